@@ -0,0 +1,211 @@
+package cli
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	client "jumpcloud_password_hash/client"
+	server "jumpcloud_password_hash/server"
+)
+
+// backupFlags are the flags shared by backup and restore.
+type backupFlags struct {
+	url string
+	adminToken string
+	timeout time.Duration
+	storePath string // Bolt file used directly, offline, when set
+	encryptionKey string
+}
+
+// register adds the shared flags to a flag set.
+func ( f *backupFlags ) register( flags *flag.FlagSet ) {
+	flags.StringVar( &f.url, "url", envOr( "HASHSVC_URL", "http://localhost:8080" ), "Server URL" )
+	flags.StringVar( &f.adminToken, "admin-token", os.Getenv( "HASHSVC_ADMIN_TOKEN" ), "Admin token of the server" )
+	flags.DurationVar( &f.timeout, "timeout", 10 * time.Minute, "Give up after this long" )
+	flags.StringVar( &f.storePath, "store-path", "", "Bolt file of a stopped server to use directly instead of --url" )
+	flags.StringVar( &f.encryptionKey, "encryption-key", os.Getenv( "ENCRYPTION_KEY" ), "Hex master key of an encrypted --store-path" )
+}
+
+// client returns a client of the server with the admin token.
+func ( f *backupFlags ) client() *client.Client {
+	c := client.New( f.url )
+	c.AdminToken = f.adminToken
+	c.Timeout = f.timeout
+	return c
+}
+
+// store returns the --store-path bolt file, decrypted with
+// --encryption-key if set.
+func ( f *backupFlags ) store() storeSpec {
+	return storeSpec{ kind: "bolt", target: f.storePath, encryptionKey: f.encryptionKey }
+}
+
+// Backup runs the backup command, writing every record of a server, or
+// of the bolt file of a stopped one, to a file along with its SHA-256
+// checksum in a .sha256 file next to it.
+func Backup( name string, args []string ) {
+	flags := flag.NewFlagSet( name, flag.ExitOnError )
+	var shared backupFlags
+	shared.register( flags )
+	out := flags.String( "out", "", "File the backup is written to, e.g. records.ndjson.gz" )
+	compress := flags.Bool( "gzip", true, "Gzip the backup" )
+	flags.Parse( args )
+	if *out == "" {
+		exit( 2, "%s needs --out\n", name )
+	}
+
+	ctx, cancel := context.WithTimeout( context.Background(), shared.timeout )
+	defer cancel()
+
+	var dump []byte
+	var err error
+	if shared.storePath != "" {
+		dump, err = dumpStore( ctx, shared.store(), *compress )
+	} else {
+		dump, err = shared.client().Export( ctx, *compress )
+	}
+	if err != nil {
+		exit( 1, "backup failed: %v\n", err )
+	}
+
+	// Read the backup back before keeping it, so a truncated download
+	// or a corrupted record is caught now rather than on restore
+	records, err := server.ReadDump( bytes.NewReader( dump ) )
+	if err != nil {
+		exit( 1, "backup failed verification: %v\n", err )
+	}
+	sum := sha256.Sum256( dump )
+	if err := writeFile( *out, dump ); err != nil {
+		exit( 1, "writing %s: %v\n", *out, err )
+	}
+	checksum := hex.EncodeToString( sum[:] ) + "  " + filepath.Base( *out ) + "\n"
+	if err := writeFile( *out + ".sha256", []byte( checksum ) ); err != nil {
+		exit( 1, "writing %s.sha256: %v\n", *out, err )
+	}
+	fmt.Printf( "backed up %d records to %s, %d bytes, sha256 %x\n", len( records ), *out, len( dump ), sum )
+}
+
+// dumpStore returns the records of a store as an export dump.
+func dumpStore( ctx context.Context, spec storeSpec, compress bool ) ( []byte, error ) {
+	store, closeStore, err := spec.open( ctx )
+	if err != nil {
+		return nil, err
+	}
+	defer closeStore()
+	records, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var dump bytes.Buffer
+	var w io.Writer = &dump
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter( &dump )
+		w = gz
+	}
+	if err := server.WriteDump( w, records ); err != nil {
+		return nil, err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return dump.Bytes(), nil
+}
+
+// Restore runs the restore command, storing the records of a backup on
+// a server, or in the bolt file of a stopped one, after checking the
+// backup against its .sha256 file and the checksum of every record.
+func Restore( name string, args []string ) {
+	flags := flag.NewFlagSet( name, flag.ExitOnError )
+	var shared backupFlags
+	shared.register( flags )
+	in := flags.String( "in", "", "Backup file to restore" )
+	onConflict := flags.String( "on-conflict", server.ImportConflictFail, "What to do with ids already taken: fail, skip or overwrite" )
+	flags.Parse( args )
+	if *in == "" {
+		exit( 2, "%s needs --in\n", name )
+	}
+
+	dump, err := os.ReadFile( *in )
+	if err != nil {
+		exit( 1, "%v\n", err )
+	}
+	if err := verifyChecksumFile( *in, dump ); err != nil {
+		exit( 1, "%v\n", err )
+	}
+	records, err := server.ReadDump( bytes.NewReader( dump ) )
+	if err != nil {
+		exit( 1, "%s failed verification: %v\n", *in, err )
+	}
+
+	ctx, cancel := context.WithTimeout( context.Background(), shared.timeout )
+	defer cancel()
+
+	var result *client.ImportResult
+	if shared.storePath != "" {
+		result, err = restoreStore( ctx, shared.store(), records, *onConflict )
+	} else {
+		result, err = shared.client().Import( ctx, dump, *onConflict )
+	}
+	if err != nil {
+		exit( 1, "restore failed: %v\n", err )
+	}
+	fmt.Printf( "restored %d records from %s: %d imported, %d unchanged, %d skipped, %d overwritten, last id %d\n",
+		len( records ), *in, result.Imported, result.Unchanged, result.Skipped, result.Overwritten, result.LastId )
+}
+
+// restoreStore stores records in a store like POST /admin/import.
+func restoreStore( ctx context.Context, spec storeSpec, records []*server.Record, onConflict string ) ( *client.ImportResult, error ) {
+	if err := checkConflict( onConflict ); err != nil {
+		return nil, err
+	}
+	store, closeStore, err := spec.open( ctx )
+	if err != nil {
+		return nil, err
+	}
+	defer closeStore()
+	return putRecords( store, records, 0, onConflict, nil )
+}
+
+// verifyChecksumFile checks a backup against the .sha256 file written
+// next to it, if there is one.
+func verifyChecksumFile( path string, data []byte ) error {
+	checksum, err := os.ReadFile( path + ".sha256" )
+	if errors.Is( err, fs.ErrNotExist ) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields( string( checksum ) )
+	sum := sha256.Sum256( data )
+	if len( fields ) == 0 || fields[ 0 ] != hex.EncodeToString( sum[:] ) {
+		return fmt.Errorf( "%s doesn't match its checksum in %s.sha256", path, path )
+	}
+	return nil
+}
+
+// writeFile writes a file through a temporary one renamed over it, so
+// an interrupted backup doesn't replace the previous one.
+func writeFile( path string, data []byte ) error {
+	temp := path + ".tmp"
+	if err := os.WriteFile( temp, data, 0600 ); err != nil {
+		os.Remove( temp )
+		return err
+	}
+	return os.Rename( temp, path )
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	client "jumpcloud_password_hash/client"
+)
+
+// Bench load tests a running server by submitting passwords from
+// concurrent workers, then reports throughput and latency.
+func Bench( name string, args []string ) {
+	flags := flag.NewFlagSet( name, flag.ExitOnError )
+	url := flags.String( "url", envOr( "HASHSVC_URL", "http://localhost:8080" ), "Server URL" )
+	requests := flags.Int( "n", 1000, "Passwords to submit" )
+	concurrency := flags.Int( "c", 10, "Concurrent workers" )
+	wait := flags.Bool( "wait", false, "Also wait for every password to be hashed" )
+	timeout := flags.Duration( "timeout", 5 * time.Minute, "Give up after this long" )
+	flags.Parse( args )
+
+	if *requests <= 0 || *concurrency <= 0 {
+		exit( 2, "-n and -c must be positive\n" )
+	}
+
+	ctx, cancel := context.WithTimeout( context.Background(), *timeout )
+	defer cancel()
+	c := client.New( *url )
+
+	// Each worker takes the next password number off the channel
+	next := make(chan int)
+	go func() {
+		defer close( next )
+		for i := 0; i < *requests; i++ {
+			select {
+			case next <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var mutex sync.Mutex
+	latencies := make([]time.Duration, 0, *requests)
+	failures := 0
+
+	var workers sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < *concurrency; w++ {
+		workers.Add( 1 )
+		go func() {
+			defer workers.Done()
+			for i := range next {
+				requestStart := time.Now()
+				submission, err := c.SubmitPassword( ctx, "bench-" + strconv.Itoa( i ) + "-" + strconv.FormatInt( start.UnixNano(), 36 ), nil )
+				if err == nil && *wait {
+					_, err = c.WaitForHash( ctx, submission.Id )
+				}
+				latency := time.Since( requestStart )
+
+				mutex.Lock()
+				if err != nil {
+					failures++
+				} else {
+					latencies = append( latencies, latency )
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	workers.Wait()
+	elapsed := time.Since( start )
+
+	fmt.Printf( "requests:    %d ok, %d failed in %s\n", len( latencies ), failures, elapsed.Round( time.Millisecond ) )
+	fmt.Printf( "throughput:  %.1f/s\n", float64( len( latencies ) ) / elapsed.Seconds() )
+	if len( latencies ) > 0 {
+		sort.Slice( latencies, func( i, j int ) bool { return latencies[ i ] < latencies[ j ] } )
+		fmt.Printf( "latency:     p50 %s, p90 %s, p99 %s, max %s\n",
+			percentile( latencies, 50 ), percentile( latencies, 90 ), percentile( latencies, 99 ), latencies[ len( latencies ) - 1 ] )
+	}
+	if failures > 0 {
+		os.Exit( 1 )
+	}
+}
+
+// percentile returns the p-th percentile of sorted latencies.
+func percentile( sorted []time.Duration, p int ) time.Duration {
+	index := ( len( sorted ) - 1 ) * p / 100
+	return sorted[ index ].Round( time.Microsecond )
+}
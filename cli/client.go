@@ -0,0 +1,186 @@
+// Package cli implements the command line client to the server.
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	client "jumpcloud_password_hash/client"
+)
+
+const usage = `Usage:
+  %[1]s submit [flags] <password|->   queue a password, "-" reads it from stdin
+  %[1]s get [flags] <id>              print the hash of a password id
+  %[1]s compact [flags]               compact the server's store and write-ahead log
+  %[1]s backfill [flags]              copy the records of the store the server migrates from
+  %[1]s cutover [flags]               switch the server to the store it migrates to
+  %[1]s backup --out <file> [flags]   back up every record to a file
+  %[1]s restore --in <file> [flags]   restore the records of a backup
+  %[1]s migrate --from <store> --to <store> [flags]
+                                    copy the records between stores, e.g. bolt:hashes.db
+`
+
+// Client runs a client command, args being the command and its flags
+// and name how the client was invoked, for usage messages.
+func Client( name string, args []string ) {
+	if len( args ) < 1 {
+		exit( 2, usage, name )
+	}
+
+	command := args[ 0 ]
+	switch command {
+	case "backup":
+		Backup( name + " backup", args[ 1: ] )
+		return
+	case "restore":
+		Restore( name + " restore", args[ 1: ] )
+		return
+	case "migrate":
+		Migrate( name + " migrate", args[ 1: ] )
+		return
+	}
+
+	flags := flag.NewFlagSet( name + " " + command, flag.ExitOnError )
+	flags.Usage = func() {
+		fmt.Fprintf( os.Stderr, usage + "\nFlags:\n", name )
+		flags.PrintDefaults()
+	}
+	url := flags.String( "url", envOr( "HASHSVC_URL", "http://localhost:8080" ), "Server URL" )
+	wait := flags.Bool( "wait", false, "Poll until the password is hashed and print its hash" )
+	timeout := flags.Duration( "timeout", time.Minute, "Give up waiting after this long" )
+	adminToken := flags.String( "admin-token", os.Getenv( "HASHSVC_ADMIN_TOKEN" ), "Admin token of the server, for compact, backfill and cutover" )
+
+	// Allow flags after the argument, e.g. "get 1 --wait"
+	args = args[ 1: ]
+	var arg string
+	if len( args ) > 0 && ( args[ 0 ] == "-" || !strings.HasPrefix( args[ 0 ], "-" ) ) {
+		arg, args = args[ 0 ], args[ 1: ]
+	}
+	flags.Parse( args )
+	if arg == "" && flags.NArg() > 0 {
+		arg = flags.Arg( 0 )
+	}
+	if arg == "" && command != "compact" && command != "backfill" && command != "cutover" {
+		flags.Usage()
+		os.Exit( 2 )
+	}
+
+	ctx, cancel := context.WithTimeout( context.Background(), *timeout )
+	defer cancel()
+	c := client.New( *url )
+	c.AdminToken = *adminToken
+
+	var id int64
+	switch command {
+	case "submit":
+		password := arg
+		if password == "-" {
+			line, err := bufio.NewReader( os.Stdin ).ReadString( '\n' )
+			if err != nil && line == "" {
+				exit( 1, "reading password: %v\n", err )
+			}
+			password = strings.TrimRight( line, "\r\n" )
+		}
+		submission, err := c.SubmitPassword( ctx, password, nil )
+		if err != nil {
+			exit( 1, "%v\n", err )
+		}
+		if !*wait {
+			fmt.Println( submission.Id )
+			return
+		}
+		id = submission.Id
+	case "get":
+		var err error
+		id, err = strconv.ParseInt( arg, 10, 64 )
+		if err != nil {
+			exit( 2, "invalid id %q\n", arg )
+		}
+	case "compact":
+		result, err := c.Compact( ctx )
+		if err != nil {
+			exit( 1, "%v\n", err )
+		}
+		printCompaction( result )
+		return
+	case "backfill":
+		result, err := c.Backfill( ctx )
+		if err != nil {
+			exit( 1, "%v\n", err )
+		}
+		fmt.Printf( "backfilled %d records in %s, %d already migrated, last id %d\n", result.Copied,
+			time.Duration( result.DurationUs ) * time.Microsecond, result.Present, result.LastId )
+		return
+	case "cutover":
+		result, err := c.Cutover( ctx )
+		if err != nil {
+			exit( 1, "%v\n", err )
+		}
+		fmt.Printf( "cut over, %d records migrated\n", result.Records )
+		return
+	default:
+		exit( 2, usage, name )
+	}
+
+	record, err := getHash( ctx, c, id, *wait )
+	if err != nil {
+		exit( 1, "%v\n", err )
+	}
+	fmt.Println( record.Hash )
+}
+
+// getHash returns the record of id, with wait polling until it is
+// hashed, sleeping for the server's Retry-After between polls.
+func getHash( ctx context.Context, c *client.Client, id int64, wait bool ) ( *client.Record, error ) {
+	for {
+		record, err := c.GetHash( ctx, id )
+		var pending *client.PendingError
+		if !wait || !errors.As( err, &pending ) {
+			return record, err
+		}
+
+		delay := pending.RetryAfter
+		if delay <= 0 {
+			delay = time.Second
+		}
+		select {
+		case <-time.After( delay ):
+		case <-ctx.Done():
+			return nil, fmt.Errorf( "timed out waiting for password %d", id )
+		}
+	}
+}
+
+// printCompaction prints the space a compaction reclaimed.
+func printCompaction( result *client.CompactResult ) {
+	fmt.Printf( "reclaimed %d bytes in %s\n", result.ReclaimedBytes, time.Duration( result.DurationUs ) * time.Microsecond )
+	if result.Store != nil {
+		fmt.Printf( "store: %d -> %d bytes\n", result.Store.BytesBefore, result.Store.BytesAfter )
+	}
+	if result.WAL != nil {
+		fmt.Printf( "wal: %d -> %d bytes, %d entries dropped\n", result.WAL.BytesBefore, result.WAL.BytesAfter, result.WAL.Dropped )
+	}
+	if result.ExpiredRecords > 0 {
+		fmt.Printf( "expired records deleted: %d\n", result.ExpiredRecords )
+	}
+}
+
+// envOr returns the environment variable key, or fallback if unset.
+func envOr( key string, fallback string ) string {
+	if value := os.Getenv( key ); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// exit prints a message to stderr and exits with code.
+func exit( code int, format string, args ...interface{} ) {
+	fmt.Fprintf( os.Stderr, format, args... )
+	os.Exit( code )
+}
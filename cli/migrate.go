@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+	server "jumpcloud_password_hash/server"
+)
+
+// Interval between progress lines of migrate
+const migrateProgressInterval = time.Second
+
+// Migrate runs the migrate command, copying every record and the id
+// sequence from one store to another while no server uses them, then
+// reading every record back from the destination to verify the copy.
+func Migrate( name string, args []string ) {
+	flags := flag.NewFlagSet( name, flag.ExitOnError )
+	from := flags.String( "from", "", "Store copied from, bolt:<file> or dynamodb:<table>" )
+	to := flags.String( "to", "", "Store copied to, bolt:<file> or dynamodb:<table>" )
+	fromKey := flags.String( "from-encryption-key", os.Getenv( "ENCRYPTION_KEY" ), "Hex master key of an encrypted --from store" )
+	toKey := flags.String( "to-encryption-key", "", "Hex master key the --to store is encrypted with, that of --from when empty" )
+	plain := flags.Bool( "to-plaintext", false, "Write the --to store in the clear even when --from is encrypted" )
+	region := flags.String( "region", "", "AWS region of DynamoDB stores, AWS_REGION when empty" )
+	fromEndpoint := flags.String( "from-endpoint", "", "DynamoDB endpoint of --from, e.g. DynamoDB Local" )
+	toEndpoint := flags.String( "to-endpoint", "", "DynamoDB endpoint of --to, e.g. DynamoDB Local" )
+	onConflict := flags.String( "on-conflict", server.ImportConflictFail, "What to do with ids already taken in --to: fail, skip or overwrite" )
+	verify := flags.Bool( "verify", true, "Read every record back from --to and compare it" )
+	timeout := flags.Duration( "timeout", time.Hour, "Give up after this long" )
+	flags.Parse( args )
+	if *from == "" || *to == "" {
+		exit( 2, "%s needs --from and --to\n", name )
+	}
+
+	source, err := parseStoreSpec( *from )
+	if err != nil {
+		exit( 2, "--from: %v\n", err )
+	}
+	destination, err := parseStoreSpec( *to )
+	if err != nil {
+		exit( 2, "--to: %v\n", err )
+	}
+	if source == destination {
+		exit( 2, "--from and --to are the same store\n" )
+	}
+	if err := checkConflict( *onConflict ); err != nil {
+		exit( 2, "%v\n", err )
+	}
+	source.region, source.endpoint, source.encryptionKey = *region, *fromEndpoint, *fromKey
+	destination.region, destination.endpoint, destination.encryptionKey = *region, *toEndpoint, *toKey
+	if destination.encryptionKey == "" && !*plain {
+		destination.encryptionKey = source.encryptionKey
+	}
+
+	ctx, cancel := context.WithTimeout( context.Background(), *timeout )
+	defer cancel()
+	if err := migrate( ctx, source, destination, *onConflict, *verify ); err != nil {
+		exit( 1, "migration failed: %v\n", err )
+	}
+}
+
+// migrate copies the records and id sequence of source to destination
+// and, with verify, compares them.
+func migrate( ctx context.Context, source storeSpec, destination storeSpec, onConflict string, verify bool ) error {
+	from, closeFrom, err := source.open( ctx )
+	if err != nil {
+		return fmt.Errorf( "opening %s: %w", source, err )
+	}
+	defer closeFrom()
+	records, err := from.List()
+	if err != nil {
+		return fmt.Errorf( "listing %s: %w", source, err )
+	}
+	sort.Slice( records, func( i, j int ) bool { return records[ i ].Id < records[ j ].Id } )
+	var lastId int64
+	if sequence, ok := from.( server.SequenceStore ); ok {
+		if lastId, err = sequence.LastId(); err != nil {
+			return err
+		}
+	}
+
+	to, closeTo, err := destination.open( ctx )
+	if err != nil {
+		return fmt.Errorf( "opening %s: %w", destination, err )
+	}
+	defer closeTo()
+	fmt.Fprintf( os.Stderr, "copying %d records from %s to %s\n", len( records ), source, destination )
+
+	start := time.Now()
+	lastProgress := start
+	result, err := putRecords( to, records, lastId, onConflict, func( done int, total int ) {
+		if time.Since( lastProgress ) >= migrateProgressInterval || done == total {
+			lastProgress = time.Now()
+			fmt.Fprintf( os.Stderr, "copied %d of %d records\n", done, total )
+		}
+	} )
+	if err != nil {
+		return err
+	}
+	fmt.Printf( "migrated %d records from %s to %s in %s: %d copied, %d unchanged, %d skipped, %d overwritten, last id %d\n",
+		len( records ), source, destination, time.Since( start ).Round( time.Millisecond ), result.Imported, result.Unchanged,
+		result.Skipped, result.Overwritten, result.LastId )
+	if !verify {
+		return nil
+	}
+
+	// Read every record back, so records lost or altered on the way
+	// are found before the source is retired
+	differ := 0
+	for i, record := range records {
+		copied, err := to.Get( record.Id )
+		if err != nil {
+			return fmt.Errorf( "verifying record %d: %w", record.Id, err )
+		}
+		same, err := sameRecord( record, copied )
+		if err != nil {
+			return err
+		}
+		if !same {
+			differ++
+			if onConflict != server.ImportConflictSkip {
+				fmt.Fprintf( os.Stderr, "record %d differs in %s\n", record.Id, destination )
+			}
+		}
+		if ( i + 1 ) % 10000 == 0 {
+			fmt.Fprintf( os.Stderr, "verified %d of %d records\n", i + 1, len( records ) )
+		}
+	}
+	if sequence, ok := to.( server.SequenceStore ); ok {
+		copiedLastId, err := sequence.LastId()
+		if err != nil {
+			return err
+		}
+		if copiedLastId < result.LastId {
+			return fmt.Errorf( "last id of %s is %d, not %d", destination, copiedLastId, result.LastId )
+		}
+	}
+
+	// Only the records left as they were by --on-conflict skip may differ
+	if differ > result.Skipped {
+		return fmt.Errorf( "%d records differ in %s", differ, destination )
+	}
+	fmt.Printf( "verified %d records in %s\n", len( records ) - differ, destination )
+	return nil
+}
+
+// sameRecord reports whether two records have the same content and
+// provenance, comparing their export encodings.
+func sameRecord( a *server.Record, b *server.Record ) ( bool, error ) {
+	var encodedA, encodedB bytes.Buffer
+	if err := server.WriteDump( &encodedA, []*server.Record{ a } ); err != nil {
+		return false, err
+	}
+	if err := server.WriteDump( &encodedB, []*server.Record{ b } ); err != nil {
+		return false, err
+	}
+	return bytes.Equal( encodedA.Bytes(), encodedB.Bytes() ), nil
+}
@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	client "jumpcloud_password_hash/client"
+	server "jumpcloud_password_hash/server"
+)
+
+// storeSpec is a store used directly rather than through a server,
+// written kind:target, e.g. bolt:hashes.db or dynamodb:hashes.
+type storeSpec struct {
+	kind string
+	target string // File of a bolt store, table of a DynamoDB one
+	region string // AWS_REGION when empty
+	endpoint string // DynamoDB endpoint, AWS when empty
+	encryptionKey string // Hex master key, the store is in the clear when empty
+}
+
+// parseStoreSpec parses a kind:target store.
+func parseStoreSpec( value string ) ( storeSpec, error ) {
+	kind, target, found := strings.Cut( value, ":" )
+	if !found || target == "" {
+		return storeSpec{}, fmt.Errorf( "invalid store %q, expected bolt:<file> or dynamodb:<table>", value )
+	}
+	switch kind {
+	case "bolt", "dynamodb":
+		return storeSpec{ kind: kind, target: target }, nil
+	}
+	return storeSpec{}, fmt.Errorf( "invalid store %q, expected bolt:<file> or dynamodb:<table>", value )
+}
+
+func ( spec storeSpec ) String() string {
+	return spec.kind + ":" + spec.target
+}
+
+// open opens the store, decrypting it with the encryption key if set
+// and refusing an encrypted one without. It returns the function
+// closing the store, a bolt file failing to open while a server has
+// it open.
+func ( spec storeSpec ) open( ctx context.Context ) ( server.Store, func(), error ) {
+	var store server.Store
+	closeStore := func() {}
+	switch spec.kind {
+	case "bolt":
+		bolt, err := server.NewBoltStore( spec.target )
+		if err != nil {
+			return nil, nil, err
+		}
+		store, closeStore = bolt, func() { bolt.Close() }
+	case "dynamodb":
+		dynamo, err := server.NewDynamoDBStore( ctx, spec.target, spec.region, spec.endpoint )
+		if err != nil {
+			return nil, nil, err
+		}
+		store = dynamo
+	default:
+		return nil, nil, fmt.Errorf( "invalid store kind %q", spec.kind )
+	}
+
+	if spec.encryptionKey == "" {
+		// Don't copy sealed records as they are, or write records in
+		// the clear next to them
+		records, err := store.List()
+		if err != nil {
+			closeStore()
+			return nil, nil, err
+		}
+		for _, record := range records {
+			if record.Algorithm == server.EncryptedAlgorithm {
+				closeStore()
+				return nil, nil, fmt.Errorf( "%s is encrypted, its encryption key is needed", spec )
+			}
+		}
+		return store, closeStore, nil
+	}
+	key, err := hex.DecodeString( spec.encryptionKey )
+	if err != nil {
+		closeStore()
+		return nil, nil, fmt.Errorf( "invalid encryption key of %s: %w", spec, err )
+	}
+	encrypted, err := server.NewEncryptedStore( store, key )
+	if err != nil {
+		closeStore()
+		return nil, nil, err
+	}
+	return encrypted, closeStore, nil
+}
+
+// checkConflict validates an on-conflict option.
+func checkConflict( onConflict string ) error {
+	switch onConflict {
+	case server.ImportConflictFail, server.ImportConflictSkip, server.ImportConflictOverwrite:
+		return nil
+	}
+	return fmt.Errorf( "invalid --on-conflict %q, expected fail, skip or overwrite", onConflict )
+}
+
+// putRecords stores records under their ids, handling ids already
+// taken like POST /admin/import, and moves the id sequence past them
+// and lastId. Every record is checked before any is stored, so a
+// conflict leaves the store as it was. progress, if not nil, is
+// called after each record stored.
+func putRecords( store server.Store, records []*server.Record, lastId int64, onConflict string, progress func( done int, total int ) ) ( *client.ImportResult, error ) {
+	if err := checkConflict( onConflict ); err != nil {
+		return nil, err
+	}
+
+	result := &client.ImportResult{ LastId: lastId }
+	var storing []*server.Record
+	var conflicts []string
+	for _, record := range records {
+		existing, err := store.Get( record.Id )
+		switch {
+		case errors.Is( err, server.ErrRecordNotFound ):
+			storing = append( storing, record )
+			result.Imported++
+		case err != nil:
+			return nil, err
+		case existing.Hash == record.Hash && existing.CompletedAt.Equal( record.CompletedAt ):
+			result.Unchanged++
+		case onConflict == server.ImportConflictSkip:
+			result.Skipped++
+		case onConflict == server.ImportConflictOverwrite:
+			storing = append( storing, record )
+			result.Overwritten++
+		default:
+			conflicts = append( conflicts, fmt.Sprint( record.Id ) )
+		}
+		if record.Id > result.LastId {
+			result.LastId = record.Id
+		}
+	}
+	if len( conflicts ) > 0 {
+		return nil, fmt.Errorf( "%d ids are stored with other records: %s", len( conflicts ), strings.Join( conflicts, ", " ) )
+	}
+
+	for i, record := range storing {
+		if err := store.Put( record ); err != nil {
+			return nil, err
+		}
+		if progress != nil {
+			progress( i + 1, len( storing ) )
+		}
+	}
+	if sequence, ok := store.( server.SequenceStore ); ok {
+		stored, err := sequence.LastId()
+		if err != nil {
+			return nil, err
+		}
+		if stored > result.LastId {
+			result.LastId = stored
+		} else if err := sequence.SetLastId( result.LastId ); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
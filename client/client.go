@@ -0,0 +1,558 @@
+// Package client is a Go client for the password hashing server.
+package client
+
+import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+var (
+    // Returned for password ids the server doesn't know
+    ErrNotFound = errors.New( "password id not found" )
+
+    // Returned for hashes deleted after their ttl
+    ErrExpired = errors.New( "hash has expired" )
+
+    // Returned by GetHash while the password is still being hashed
+    ErrPending = errors.New( "password not hashed yet" )
+)
+
+// Error response from the server
+type StatusError struct {
+    StatusCode int
+    Code string
+    Message string
+}
+
+func ( e *StatusError ) Error() string {
+    if e.Code != "" {
+        return fmt.Sprintf( "server returned %d %s: %s", e.StatusCode, e.Code, e.Message )
+    }
+    return fmt.Sprintf( "server returned %d: %s", e.StatusCode, e.Message )
+}
+
+// Returned by GetHash while the password is still being hashed,
+// matches ErrPending with errors.Is
+type PendingError struct {
+    // When the server expects the hash to be ready, from Retry-After
+    RetryAfter time.Duration
+}
+
+func ( e *PendingError ) Error() string {
+    return ErrPending.Error()
+}
+
+func ( e *PendingError ) Is( target error ) bool {
+    return target == ErrPending
+}
+
+// Options for SubmitPassword
+type SubmitOptions struct {
+    Labels map[string]string
+    CompleteBy time.Time
+    Ttl time.Duration
+    CallbackURL string
+}
+
+// Result of SubmitPassword
+type Submission struct {
+    Id int64 `json:"id"`
+    Location string `json:"location"`
+    EstimatedCompletion *time.Time `json:"estimated_completion,omitempty"`
+    Deduplicated bool `json:"deduplicated,omitempty"`
+}
+
+// Hashed password record
+type Record struct {
+    Id int64 `json:"id"`
+    Hash string `json:"hash"`
+    Algorithm string `json:"algorithm"`
+    Labels map[string]string `json:"labels,omitempty"`
+    CreatedAt time.Time `json:"created_at"`
+    CompletedAt time.Time `json:"completed_at"`
+    LatencyUs int64 `json:"latency_us"`
+    ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Hashing statistics, Average in microseconds
+type Stats struct {
+    Total int64 `json:"total"`
+    Average int64 `json:"average"`
+    SlaViolations int64 `json:"sla_violations,omitempty"`
+    Expired int64 `json:"expired,omitempty"`
+    Paused bool `json:"paused,omitempty"`
+}
+
+// Size of a file before and after it was compacted
+type CompactStat struct {
+    BytesBefore int64 `json:"bytes_before"`
+    BytesAfter int64 `json:"bytes_after"`
+    Dropped int `json:"dropped,omitempty"`
+}
+
+// Result of Compact, Store and WAL nil when not compacted
+type CompactResult struct {
+    ExpiredRecords int64 `json:"expired_records"`
+    Store *CompactStat `json:"store,omitempty"`
+    WAL *CompactStat `json:"wal,omitempty"`
+    ReclaimedBytes int64 `json:"reclaimed_bytes"`
+    DurationUs int64 `json:"duration_us"`
+}
+
+// Result of Backfill
+type BackfillResult struct {
+    Copied int `json:"copied"`
+    Present int `json:"present"`
+    LastId int64 `json:"last_id"`
+    DurationUs int64 `json:"duration_us"`
+}
+
+// Result of Cutover
+type CutoverResult struct {
+    CutOver bool `json:"cut_over"`
+    Records int `json:"records"`
+}
+
+// Result of Import
+type ImportResult struct {
+    Imported int `json:"imported"`
+    Unchanged int `json:"unchanged"`
+    Skipped int `json:"skipped,omitempty"`
+    Overwritten int `json:"overwritten,omitempty"`
+    LastId int64 `json:"last_id"`
+}
+
+// Client for a password hashing server, New returns one with the
+// default settings
+type Client struct {
+    // Server URL, e.g. http://localhost:8080
+    BaseURL string
+
+    // Bearer token for admin endpoints, needed by Shutdown, Compact,
+    // Backfill, Cutover, Export and Import
+    AdminToken string
+
+    // Time limit of each attempt at a request
+    Timeout time.Duration
+
+    // Attempts after the first for network errors and 5xx responses
+    Retries int
+
+    // Wait before the first retry, doubling for each one after
+    RetryBackoff time.Duration
+
+    HTTPClient *http.Client
+}
+
+/********************************************************************
+New()
+    Returns a client for the server at baseURL with a 10s timeout
+    and 3 retries.
+********************************************************************/
+func New( baseURL string ) *Client {
+    return &Client{
+        BaseURL: strings.TrimRight( baseURL, "/" ),
+        Timeout: 10 * time.Second,
+        Retries: 3,
+        RetryBackoff: 250 * time.Millisecond,
+        HTTPClient: http.DefaultClient,
+    }
+}
+
+/********************************************************************
+SubmitPassword()
+    Queues a password for hashing, returning its id. Retries carry
+    the same Idempotency-Key so they can't queue it twice.
+********************************************************************/
+func ( c *Client ) SubmitPassword( ctx context.Context, password string, options *SubmitOptions ) ( *Submission, error ) {
+    form := url.Values{}
+    form.Set( "password", password )
+    if options != nil {
+        for key, value := range options.Labels {
+            form.Add( "label", key + ":" + value )
+        }
+        if !options.CompleteBy.IsZero() {
+            form.Set( "complete_by", options.CompleteBy.Format( time.RFC3339Nano ) )
+        }
+        if options.Ttl > 0 {
+            form.Set( "ttl", options.Ttl.String() )
+        }
+        if options.CallbackURL != "" {
+            form.Set( "callback_url", options.CallbackURL )
+        }
+    }
+
+    header := http.Header{}
+    header.Set( "Content-Type", "application/x-www-form-urlencoded" )
+    header.Set( "Idempotency-Key", newIdempotencyKey() )
+
+    response, body, err := c.do( ctx, http.MethodPost, "/v1/hash", header, []byte( form.Encode() ), 0 )
+    if err != nil {
+        return nil, err
+    }
+    if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusAccepted {
+        return nil, statusError( response, body )
+    }
+
+    var submission Submission
+    if err := json.Unmarshal( body, &submission ); err != nil {
+        return nil, fmt.Errorf( "invalid response: %w", err )
+    }
+    return &submission, nil
+}
+
+/********************************************************************
+GetHash()
+    Returns the record of a hashed password, or a *PendingError
+    matching ErrPending while it is still being hashed.
+********************************************************************/
+func ( c *Client ) GetHash( ctx context.Context, id int64 ) ( *Record, error ) {
+    return c.getHash( ctx, id, 0 )
+}
+
+/********************************************************************
+WaitForHash()
+    Returns the record of a password once it has been hashed,
+    long-polling the server until then or until ctx is done.
+********************************************************************/
+func ( c *Client ) WaitForHash( ctx context.Context, id int64 ) ( *Record, error ) {
+    for {
+        record, err := c.getHash( ctx, id, 30 * time.Second )
+        if !errors.Is( err, ErrPending ) {
+            return record, err
+        }
+        if err := ctx.Err(); err != nil {
+            return nil, err
+        }
+    }
+}
+
+/********************************************************************
+getHash()
+    Fetches a record, asking the server to wait up to wait for it.
+********************************************************************/
+func ( c *Client ) getHash( ctx context.Context, id int64, wait time.Duration ) ( *Record, error ) {
+    path := "/v1/hash/" + strconv.FormatInt( id, 10 )
+    if wait > 0 {
+        path += "?wait=" + wait.String()
+    }
+
+    header := http.Header{}
+    header.Set( "Accept", "application/json" )
+
+    response, body, err := c.do( ctx, http.MethodGet, path, header, nil, wait )
+    if err != nil {
+        return nil, err
+    }
+
+    switch response.StatusCode {
+    case http.StatusOK:
+        var record Record
+        if err := json.Unmarshal( body, &record ); err != nil {
+            return nil, fmt.Errorf( "invalid response: %w", err )
+        }
+        return &record, nil
+    case http.StatusAccepted:
+        pending := &PendingError{}
+        if seconds, err := strconv.Atoi( response.Header.Get( "Retry-After" ) ); err == nil {
+            pending.RetryAfter = time.Duration( seconds ) * time.Second
+        }
+        return nil, pending
+    case http.StatusNotFound:
+        return nil, ErrNotFound
+    case http.StatusGone:
+        return nil, ErrExpired
+    }
+    return nil, statusError( response, body )
+}
+
+/********************************************************************
+Stats()
+    Returns the hashing statistics, all zero before any passwords
+    have been hashed. They need AdminToken if the server has one.
+********************************************************************/
+func ( c *Client ) Stats( ctx context.Context ) ( *Stats, error ) {
+    response, body, err := c.do( ctx, http.MethodGet, "/v1/stats", c.adminHeader(), nil, 0 )
+    if err != nil {
+        return nil, err
+    }
+
+    switch response.StatusCode {
+    case http.StatusOK:
+        var stats Stats
+        if err := json.Unmarshal( body, &stats ); err != nil {
+            return nil, fmt.Errorf( "invalid response: %w", err )
+        }
+        return &stats, nil
+    case http.StatusNotFound:
+        return &Stats{}, nil
+    }
+    return nil, statusError( response, body )
+}
+
+/********************************************************************
+Shutdown()
+    Gracefully shuts the server down, fetching a one-time shutdown
+    token with AdminToken first.
+********************************************************************/
+func ( c *Client ) Shutdown( ctx context.Context ) error {
+    header := http.Header{}
+    if c.AdminToken != "" {
+        header.Set( "Authorization", "Bearer " + c.AdminToken )
+    }
+
+    response, body, err := c.do( ctx, http.MethodPost, "/v1/admin/shutdown-token", header, nil, 0 )
+    if err != nil {
+        return err
+    }
+    if response.StatusCode != http.StatusOK {
+        return statusError( response, body )
+    }
+
+    var token struct {
+        Token string `json:"token"`
+    }
+    if err := json.Unmarshal( body, &token ); err != nil {
+        return fmt.Errorf( "invalid response: %w", err )
+    }
+
+    // The token is single use, so this request is never retried
+    header = http.Header{}
+    header.Set( "X-Shutdown-Token", token.Token )
+    request, err := c.newRequest( ctx, http.MethodGet, "/v1/shutdown", header, nil )
+    if err != nil {
+        return err
+    }
+    attemptCtx, cancel := context.WithTimeout( ctx, c.Timeout )
+    defer cancel()
+    response, err = c.HTTPClient.Do( request.WithContext( attemptCtx ) )
+    if err != nil {
+        return err
+    }
+    defer response.Body.Close()
+    body, _ = io.ReadAll( response.Body )
+    if response.StatusCode != http.StatusOK {
+        return statusError( response, body )
+    }
+    return nil
+}
+
+/********************************************************************
+Compact()
+    Rewrites the server's persistent store and write-ahead log without
+    deleted and expired records, with AdminToken.
+********************************************************************/
+func ( c *Client ) Compact( ctx context.Context ) ( *CompactResult, error ) {
+    response, body, err := c.do( ctx, http.MethodPost, "/v1/admin/compact", c.adminHeader(), nil, 0 )
+    if err != nil {
+        return nil, err
+    }
+    if response.StatusCode != http.StatusOK {
+        return nil, statusError( response, body )
+    }
+    var result CompactResult
+    if err := json.Unmarshal( body, &result ); err != nil {
+        return nil, fmt.Errorf( "invalid response: %w", err )
+    }
+    return &result, nil
+}
+
+/********************************************************************
+Backfill()
+    Copies the records of the store the server migrates from to its
+    new store, with AdminToken.
+********************************************************************/
+func ( c *Client ) Backfill( ctx context.Context ) ( *BackfillResult, error ) {
+    response, body, err := c.do( ctx, http.MethodPost, "/v1/admin/migration/backfill", c.adminHeader(), nil, 0 )
+    if err != nil {
+        return nil, err
+    }
+    if response.StatusCode != http.StatusOK {
+        return nil, statusError( response, body )
+    }
+    var result BackfillResult
+    if err := json.Unmarshal( body, &result ); err != nil {
+        return nil, fmt.Errorf( "invalid response: %w", err )
+    }
+    return &result, nil
+}
+
+/********************************************************************
+Cutover()
+    Switches the server to its new store only, once backfilled, with
+    AdminToken.
+********************************************************************/
+func ( c *Client ) Cutover( ctx context.Context ) ( *CutoverResult, error ) {
+    response, body, err := c.do( ctx, http.MethodPost, "/v1/admin/migration/cutover", c.adminHeader(), nil, 0 )
+    if err != nil {
+        return nil, err
+    }
+    if response.StatusCode != http.StatusOK {
+        return nil, statusError( response, body )
+    }
+    var result CutoverResult
+    if err := json.Unmarshal( body, &result ); err != nil {
+        return nil, fmt.Errorf( "invalid response: %w", err )
+    }
+    return &result, nil
+}
+
+/********************************************************************
+Export()
+    Downloads every record of the server as an export dump, gzipped
+    with compress, with AdminToken.
+********************************************************************/
+func ( c *Client ) Export( ctx context.Context, compress bool ) ( []byte, error ) {
+    response, body, err := c.do( ctx, http.MethodGet, "/v1/admin/export?gzip=" + strconv.FormatBool( compress ), c.adminHeader(), nil, 0 )
+    if err != nil {
+        return nil, err
+    }
+    if response.StatusCode != http.StatusOK {
+        return nil, statusError( response, body )
+    }
+    return body, nil
+}
+
+/********************************************************************
+Import()
+    Stores the records of an export dump on the server under their
+    original ids, with AdminToken. onConflict is "fail", "skip" or
+    "overwrite", the server's default when empty.
+********************************************************************/
+func ( c *Client ) Import( ctx context.Context, dump []byte, onConflict string ) ( *ImportResult, error ) {
+    path := "/v1/admin/import"
+    if onConflict != "" {
+        path += "?on_conflict=" + url.QueryEscape( onConflict )
+    }
+    response, body, err := c.do( ctx, http.MethodPost, path, c.adminHeader(), dump, 0 )
+    if err != nil {
+        return nil, err
+    }
+    if response.StatusCode != http.StatusOK {
+        return nil, statusError( response, body )
+    }
+    var result ImportResult
+    if err := json.Unmarshal( body, &result ); err != nil {
+        return nil, fmt.Errorf( "invalid response: %w", err )
+    }
+    return &result, nil
+}
+
+/********************************************************************
+adminHeader()
+    Returns the header authenticating admin requests with AdminToken.
+********************************************************************/
+func ( c *Client ) adminHeader() http.Header {
+    header := http.Header{}
+    if c.AdminToken != "" {
+        header.Set( "Authorization", "Bearer " + c.AdminToken )
+    }
+    return header
+}
+
+/********************************************************************
+newRequest()
+    Builds a request for a path on the server.
+********************************************************************/
+func ( c *Client ) newRequest( ctx context.Context, method string, path string, header http.Header, body []byte ) ( *http.Request, error ) {
+    var reader io.Reader
+    if body != nil {
+        reader = bytes.NewReader( body )
+    }
+    request, err := http.NewRequestWithContext( ctx, method, c.BaseURL + path, reader )
+    if err != nil {
+        return nil, err
+    }
+    for key, values := range header {
+        request.Header[ key ] = values
+    }
+    return request, nil
+}
+
+/********************************************************************
+do()
+    Sends a request, retrying network errors and 5xx responses with
+    exponential backoff, or the server's Retry-After when longer.
+    Each attempt may take Timeout plus extra, for long-polls.
+********************************************************************/
+func ( c *Client ) do( ctx context.Context, method string, path string, header http.Header, body []byte, extra time.Duration ) ( *http.Response, []byte, error ) {
+    backoff := c.RetryBackoff
+    for attempt := 0; ; attempt++ {
+        request, err := c.newRequest( ctx, method, path, header, body )
+        if err != nil {
+            return nil, nil, err
+        }
+
+        attemptCtx, cancel := context.WithTimeout( ctx, c.Timeout + extra )
+        response, err := c.HTTPClient.Do( request.WithContext( attemptCtx ) )
+        var responseBody []byte
+        if err == nil {
+            responseBody, err = io.ReadAll( response.Body )
+            response.Body.Close()
+        }
+        cancel()
+
+        retry := err != nil || response.StatusCode >= 500
+        if !retry || attempt >= c.Retries || ctx.Err() != nil {
+            return response, responseBody, err
+        }
+
+        wait := backoff
+        if response != nil {
+            if seconds, err := strconv.Atoi( response.Header.Get( "Retry-After" ) ); err == nil && time.Duration( seconds ) * time.Second > wait {
+                wait = time.Duration( seconds ) * time.Second
+            }
+        }
+        backoff *= 2
+
+        select {
+        case <-time.After( wait ):
+        case <-ctx.Done():
+            return nil, nil, ctx.Err()
+        }
+    }
+}
+
+/********************************************************************
+statusError()
+    Converts an unexpected response into a *StatusError, taking the
+    code and message from the JSON error envelope when there is one.
+********************************************************************/
+func statusError( response *http.Response, body []byte ) error {
+    var envelope struct {
+        Error struct {
+            Code string `json:"code"`
+            Message string `json:"message"`
+        } `json:"error"`
+    }
+    if json.Unmarshal( body, &envelope ) == nil && envelope.Error.Code != "" {
+        return &StatusError{ StatusCode: response.StatusCode, Code: envelope.Error.Code, Message: envelope.Error.Message }
+    }
+
+    return &StatusError{
+        StatusCode: response.StatusCode,
+        Code: response.Header.Get( "X-Error-Code" ),
+        Message: strings.TrimSpace( string( body ) ),
+    }
+}
+
+/********************************************************************
+newIdempotencyKey()
+    Returns a random 128 bit key as a hex string.
+********************************************************************/
+func newIdempotencyKey() string {
+    key := make([]byte, 16)
+    rand.Read( key )
+    return hex.EncodeToString( key )
+}
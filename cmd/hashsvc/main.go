@@ -0,0 +1,10 @@
+package main
+
+import (
+	"os"
+	cli "jumpcloud_password_hash/cli"
+)
+
+func main() {
+	cli.Client( "hashsvc", os.Args[ 1: ] )
+}
@@ -0,0 +1,247 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: hash/v1/hash.proto
+
+package hashpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	HashService_SubmitPassword_FullMethodName = "/hash.v1.HashService/SubmitPassword"
+	HashService_GetHash_FullMethodName        = "/hash.v1.HashService/GetHash"
+	HashService_GetStats_FullMethodName       = "/hash.v1.HashService/GetStats"
+	HashService_Shutdown_FullMethodName       = "/hash.v1.HashService/Shutdown"
+)
+
+// HashServiceClient is the client API for HashService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Password hashing service, the gRPC equivalent of the HTTP API
+type HashServiceClient interface {
+	// Queues a password for hashing and returns its id straight away
+	SubmitPassword(ctx context.Context, in *SubmitPasswordRequest, opts ...grpc.CallOption) (*SubmitPasswordResponse, error)
+	// Returns the state of a password id and, once done, its hash
+	GetHash(ctx context.Context, in *GetHashRequest, opts ...grpc.CallOption) (*GetHashResponse, error)
+	// Returns the number of passwords hashed and the average time taken
+	GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*GetStatsResponse, error)
+	// Gracefully shuts the server down, given a one-time shutdown token
+	Shutdown(ctx context.Context, in *ShutdownRequest, opts ...grpc.CallOption) (*ShutdownResponse, error)
+}
+
+type hashServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewHashServiceClient(cc grpc.ClientConnInterface) HashServiceClient {
+	return &hashServiceClient{cc}
+}
+
+func (c *hashServiceClient) SubmitPassword(ctx context.Context, in *SubmitPasswordRequest, opts ...grpc.CallOption) (*SubmitPasswordResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SubmitPasswordResponse)
+	err := c.cc.Invoke(ctx, HashService_SubmitPassword_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hashServiceClient) GetHash(ctx context.Context, in *GetHashRequest, opts ...grpc.CallOption) (*GetHashResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetHashResponse)
+	err := c.cc.Invoke(ctx, HashService_GetHash_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hashServiceClient) GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*GetStatsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetStatsResponse)
+	err := c.cc.Invoke(ctx, HashService_GetStats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hashServiceClient) Shutdown(ctx context.Context, in *ShutdownRequest, opts ...grpc.CallOption) (*ShutdownResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ShutdownResponse)
+	err := c.cc.Invoke(ctx, HashService_Shutdown_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// HashServiceServer is the server API for HashService service.
+// All implementations must embed UnimplementedHashServiceServer
+// for forward compatibility.
+//
+// Password hashing service, the gRPC equivalent of the HTTP API
+type HashServiceServer interface {
+	// Queues a password for hashing and returns its id straight away
+	SubmitPassword(context.Context, *SubmitPasswordRequest) (*SubmitPasswordResponse, error)
+	// Returns the state of a password id and, once done, its hash
+	GetHash(context.Context, *GetHashRequest) (*GetHashResponse, error)
+	// Returns the number of passwords hashed and the average time taken
+	GetStats(context.Context, *GetStatsRequest) (*GetStatsResponse, error)
+	// Gracefully shuts the server down, given a one-time shutdown token
+	Shutdown(context.Context, *ShutdownRequest) (*ShutdownResponse, error)
+	mustEmbedUnimplementedHashServiceServer()
+}
+
+// UnimplementedHashServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedHashServiceServer struct{}
+
+func (UnimplementedHashServiceServer) SubmitPassword(context.Context, *SubmitPasswordRequest) (*SubmitPasswordResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitPassword not implemented")
+}
+func (UnimplementedHashServiceServer) GetHash(context.Context, *GetHashRequest) (*GetHashResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetHash not implemented")
+}
+func (UnimplementedHashServiceServer) GetStats(context.Context, *GetStatsRequest) (*GetStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedHashServiceServer) Shutdown(context.Context, *ShutdownRequest) (*ShutdownResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Shutdown not implemented")
+}
+func (UnimplementedHashServiceServer) mustEmbedUnimplementedHashServiceServer() {}
+func (UnimplementedHashServiceServer) testEmbeddedByValue()                     {}
+
+// UnsafeHashServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to HashServiceServer will
+// result in compilation errors.
+type UnsafeHashServiceServer interface {
+	mustEmbedUnimplementedHashServiceServer()
+}
+
+func RegisterHashServiceServer(s grpc.ServiceRegistrar, srv HashServiceServer) {
+	// If the following call pancis, it indicates UnimplementedHashServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&HashService_ServiceDesc, srv)
+}
+
+func _HashService_SubmitPassword_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitPasswordRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HashServiceServer).SubmitPassword(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HashService_SubmitPassword_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HashServiceServer).SubmitPassword(ctx, req.(*SubmitPasswordRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HashService_GetHash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetHashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HashServiceServer).GetHash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HashService_GetHash_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HashServiceServer).GetHash(ctx, req.(*GetHashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HashService_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HashServiceServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HashService_GetStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HashServiceServer).GetStats(ctx, req.(*GetStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HashService_Shutdown_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ShutdownRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HashServiceServer).Shutdown(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HashService_Shutdown_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HashServiceServer).Shutdown(ctx, req.(*ShutdownRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// HashService_ServiceDesc is the grpc.ServiceDesc for HashService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var HashService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "hash.v1.HashService",
+	HandlerType: (*HashServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SubmitPassword",
+			Handler:    _HashService_SubmitPassword_Handler,
+		},
+		{
+			MethodName: "GetHash",
+			Handler:    _HashService_GetHash_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _HashService_GetStats_Handler,
+		},
+		{
+			MethodName: "Shutdown",
+			Handler:    _HashService_Shutdown_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "hash/v1/hash.proto",
+}
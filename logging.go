@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// logRotation says when log files are rotated and the rotated ones
+// removed.
+type logRotation struct {
+	maxSize int // Megabytes a file may grow to before it's rotated
+	maxBackups int // Rotated files kept, all when 0
+	maxAge int // Days rotated files are kept, forever when 0
+}
+
+// openLogFile returns a writer appending to a log file, which is
+// rotated once it reaches the maximum size. Rotated files are named
+// after the time of their rotation, e.g. hashsvc-2026-10-14T14-30-00.000.log.
+func openLogFile( path string, rotation logRotation ) io.Writer {
+	return &lumberjack.Logger{
+		Filename: path,
+		MaxSize: rotation.maxSize,
+		MaxBackups: rotation.maxBackups,
+		MaxAge: rotation.maxAge,
+	}
+}
+
+// newLogger creates the logger of the server, writing lines of at
+// least the level ("debug", "info", "warn" or "error") in the "text"
+// or "json" format.
+func newLogger( w io.Writer, level string, format string ) ( *slog.Logger, error ) {
+	options, err := logOptions( level )
+	if err != nil {
+		return nil, err
+	}
+	handler, err := newLogHandler( w, format, options )
+	if err != nil {
+		return nil, err
+	}
+	return slog.New( handler ), nil
+}
+
+// logOptions returns the handler options logging lines of at least
+// the level.
+func logOptions( level string ) ( *slog.HandlerOptions, error ) {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText( []byte( level ) ); err != nil {
+		return nil, fmt.Errorf( "invalid log level %q, expected debug, info, warn or error", level )
+	}
+	return &slog.HandlerOptions{ Level: logLevel }, nil
+}
+
+// newLogHandler creates a handler writing lines in the "text" or
+// "json" format.
+func newLogHandler( w io.Writer, format string, options *slog.HandlerOptions ) ( slog.Handler, error ) {
+	switch format {
+	case "text":
+		return slog.NewTextHandler( w, options ), nil
+	case "json":
+		return slog.NewJSONHandler( w, options ), nil
+	}
+	return nil, fmt.Errorf( "invalid log format %q, expected text or json", format )
+}
+
+// newAccessLogger creates the logger of the access log, appending
+// JSON records to a rotated file, or writing them to stdout for "-".
+func newAccessLogger( path string, rotation logRotation ) *slog.Logger {
+	w := io.Writer( os.Stdout )
+	if path != "-" {
+		w = openLogFile( path, rotation )
+	}
+	return slog.New( slog.NewJSONHandler( w, nil ) )
+}
+
+// fatal logs an error and exits.
+func fatal( msg string, err error ) {
+	slog.Error( msg, "error", err )
+	os.Exit( 1 )
+}
@@ -1,16 +1,59 @@
 package main
 
 import (
-	"flag"
-	"log"
+	"fmt"
+	"os"
+	"strings"
+	cli "jumpcloud_password_hash/cli"
 	server "jumpcloud_password_hash/server"
 )
 
+const usage = `Usage: %[1]s <command> [flags]
+
+Commands:
+  serve     run the password hash server (the default)
+  client    submit passwords and get hashes, see "%[1]s client"
+  bench     load test a running server
+  version   print version information
+
+Run "%[1]s <command> -h" for a command's flags.
+`
+
 func main() {
+	name := "jumpcloud_password_hash"
+	args := os.Args[ 1: ]
+
+	// Without a command, or with only flags, run the server as before
+	// subcommands were added
+	if len( args ) == 0 || strings.HasPrefix( args[ 0 ], "-" ) && args[ 0 ] != "-h" && args[ 0 ] != "--help" {
+		serve( args )
+		return
+	}
 
-	port := flag.Int( "port", 8080, "Port to listen on" )
-	flag.Parse()
+	switch args[ 0 ] {
+	case "serve":
+		serve( args[ 1: ] )
+	case "client":
+		cli.Client( name + " client", args[ 1: ] )
+	case "bench":
+		cli.Bench( name + " bench", args[ 1: ] )
+	case "version":
+		version()
+	case "help", "-h", "--help":
+		fmt.Printf( usage, name )
+	default:
+		fmt.Fprintf( os.Stderr, "unknown command %q\n\n", args[ 0 ] )
+		fmt.Fprintf( os.Stderr, usage, name )
+		os.Exit( 2 )
+	}
+}
 
-	log.Printf( "Starting server on port %d!", *port )
-	server.HandleRequests( *port )
-}
\ No newline at end of file
+// version prints the version and build details of the binary.
+func version() {
+	info := server.ReadBuildInfo()
+	fmt.Printf( "%s %s\n", info.Module, info.Version )
+	fmt.Printf( "go: %s\n", info.GoVersion )
+	if info.Revision != "" {
+		fmt.Printf( "revision: %s %s\n", info.Revision, info.BuildTime )
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	server "jumpcloud_password_hash/server"
+)
+
+// serve runs the password hash server until it is shut down or
+// interrupted.
+func serve( args []string ) {
+	config := server.DefaultConfig()
+	flags := flag.NewFlagSet( "serve", flag.ExitOnError )
+	flags.IntVar( &config.Port, "port", config.Port, "Port to listen on" )
+	flags.IntVar( &config.GrpcPort, "grpc-port", config.GrpcPort, "Port for the gRPC HashService, disabled if 0" )
+	flags.BoolVar( &config.Deduplicate, "dedup", config.Deduplicate, "Return the existing id when an already submitted password is posted again" )
+	flags.DurationVar( &config.IdempotencyWindow, "idempotency-window", config.IdempotencyWindow, "How long an Idempotency-Key is remembered for replays" )
+	signingKey := flags.String( "url-signing-key", os.Getenv( "URL_SIGNING_KEY" ), "Key for signing read-only stats URLs, random if empty" )
+	flags.StringVar( &config.ResponseTemplates, "response-templates", config.ResponseTemplates, "File of text/template definitions for text/plain responses" )
+	flags.StringVar( &config.AdminToken, "admin-token", os.Getenv( "ADMIN_TOKEN" ), "Bearer token required by /admin endpoints, no auth if empty" )
+	flags.IntVar( &config.MaxPendingJobs, "max-pending-jobs", config.MaxPendingJobs, "Most passwords waiting to be hashed at once, unlimited if 0" )
+	flags.IntVar( &config.ReadyQueueThreshold, "ready-queue-threshold", config.ReadyQueueThreshold, "Pending passwords at which /readyz fails, -max-pending-jobs if 0" )
+	flags.Float64Var( &config.SoftLimitRatio, "soft-limit-ratio", config.SoftLimitRatio, "Fraction of a limit at which responses start carrying warnings" )
+	flags.BoolVar( &config.AllowEmptyPassword, "allow-empty-password", config.AllowEmptyPassword, "Accept the empty string as a password to hash" )
+	flags.BoolVar( &config.WebhookAllowPrivate, "webhook-allow-private", config.WebhookAllowPrivate, "Let webhooks call back loopback, private and link-local addresses" )
+	flags.BoolVar( &config.LegacyAPI, "legacy-api", config.LegacyAPI, "Answer /hash and /stats exactly like the original plain text API" )
+	flags.Func( "cache-control", "Cache-Control of a route, as \"GET /stats=max-age=5\", repeatable", func( value string ) error {
+		pattern, header, ok := strings.Cut( value, "=" )
+		if !ok {
+			return fmt.Errorf( "expected <pattern>=<cache control>" )
+		}
+		if config.CacheControl == nil {
+			config.CacheControl = map[string]string{}
+		}
+		config.CacheControl[ pattern ] = header
+		return nil
+	} )
+	flags.BoolVar( &config.Expvar, "expvar", config.Expvar, "Serve expvar counters on /debug/vars, behind -admin-token" )
+	flags.StringVar( &config.StatsDAddr, "statsd", config.StatsDAddr, "StatsD agent host:port to send metrics to, disabled if empty" )
+	flags.StringVar( &config.StatsDPrefix, "statsd-prefix", config.StatsDPrefix, "Prefix of StatsD metric names" )
+	flags.Func( "statsd-tag", "DogStatsD tag added to every metric, as \"env:prod\", repeatable", func( value string ) error {
+		config.StatsDTags = append( config.StatsDTags, value )
+		return nil
+	} )
+	flags.StringVar( &config.StatsFile, "stats-file", config.StatsFile, "File the /stats counters are saved to and restored from across restarts" )
+	flags.DurationVar( &config.StatsCheckpointInterval, "stats-checkpoint-interval", config.StatsCheckpointInterval, "How often the stats are saved to -stats-file" )
+	var storeFlags storeOptions
+	flags.StringVar( &storeFlags.kind, "store", "memory", "Store of the hashed passwords: memory, bolt for a single bbolt file surviving restarts, or dynamodb" )
+	flags.StringVar( &storeFlags.path, "store-path", "", "File of -store bolt, created if missing" )
+	flags.StringVar( &storeFlags.dynamoTable, "dynamodb-table", "", "Table of -store dynamodb, with a numeric \"id\" partition key" )
+	flags.StringVar( &storeFlags.dynamoRegion, "dynamodb-region", "", "AWS region of -dynamodb-table, AWS_REGION if empty" )
+	flags.StringVar( &storeFlags.dynamoEndpoint, "dynamodb-endpoint", "", "DynamoDB endpoint URL, e.g. of DynamoDB Local, AWS if empty" )
+	migrateFrom := flags.String( "migrate-from", "", "Store migrated to -store without downtime, bolt:<file> or dynamodb:<table>, written to and read from until the cutover" )
+	var archiveFlags archiveOptions
+	flags.StringVar( &archiveFlags.bucket, "archive-bucket", "", "S3 bucket the records are periodically archived to, encrypted, and restored from on startup" )
+	flags.StringVar( &archiveFlags.prefix, "archive-prefix", "hashsvc/", "Key prefix of the archives in -archive-bucket" )
+	flags.StringVar( &archiveFlags.region, "archive-region", "", "AWS region of -archive-bucket, AWS_REGION if empty" )
+	flags.StringVar( &archiveFlags.endpoint, "archive-endpoint", "", "Endpoint URL of an S3-compatible service, e.g. MinIO, AWS if empty" )
+	archiveKey := flags.String( "archive-key", os.Getenv( "ARCHIVE_KEY" ), "Hex encoded 32 byte AES key the archives are encrypted with" )
+	flags.DurationVar( &config.ArchiveInterval, "archive-interval", time.Hour, "How often the records are archived to -archive-bucket" )
+	flags.StringVar( &config.SnapshotFile, "snapshot", config.SnapshotFile, "File the hashes, id sequence and stats are snapshotted to and restored from on startup" )
+	flags.DurationVar( &config.SnapshotInterval, "snapshot-interval", config.SnapshotInterval, "How often a -snapshot is saved" )
+	flags.DurationVar( &config.ReaperInterval, "reaper-interval", config.ReaperInterval, "How often expired hashes are deleted and internal maps compacted" )
+	flags.IntVar( &config.Workers, "workers", 0, "Workers hashing the passwords whose delay has elapsed, the number of CPUs if 0" )
+	flags.IntVar( &config.CacheRecords, "cache-records", 0, "Most hashes of a persistent -store kept in memory for reads, the most recently used, no cache if 0" )
+	flags.IntVar( &config.MaxRecords, "max-records", config.MaxRecords, "Most hashes stored at once, past it they are evicted, unlimited if 0" )
+	flags.StringVar( &config.EvictionPolicy, "eviction", server.EvictLRU, "Which hash -max-records evicts: lru, the least recently read, or oldest" )
+	flags.DurationVar( &config.DeleteGracePeriod, "delete-grace", 30 * 24 * time.Hour, "How long a DELETEd hash can be undeleted before it is purged" )
+	flags.DurationVar( &config.RetentionPeriod, "retention", 0, "How long hashes are kept once hashed whatever their ttl, e.g. 2160h for 90 days, until they expire if 0" )
+	encryptionKey := flags.String( "encryption-key", os.Getenv( "ENCRYPTION_KEY" ), "Hex encoded 32 byte master key the persisted hashes are encrypted with, in the clear if empty" )
+	previousKeys := flags.String( "encryption-previous-keys", os.Getenv( "ENCRYPTION_PREVIOUS_KEYS" ), "Comma separated hex encoded master keys hashes encrypted before a rotation are still decrypted with" )
+	var keySourceFlags keySourceOptions
+	flags.StringVar( &keySourceFlags.kmsCiphertext, "encryption-key-kms", os.Getenv( "ENCRYPTION_KEY_KMS" ), "Base64 master key encrypted with AWS KMS, fetched with kms:Decrypt instead of -encryption-key" )
+	flags.StringVar( &keySourceFlags.kmsRegion, "kms-region", "", "AWS region of -encryption-key-kms, AWS_REGION if empty" )
+	flags.StringVar( &keySourceFlags.kmsEndpoint, "kms-endpoint", "", "KMS endpoint URL, e.g. of LocalStack, AWS if empty" )
+	flags.StringVar( &keySourceFlags.vaultPath, "encryption-key-vault", "", "Path of the Vault secret holding the hex master key, e.g. secret/data/hashsvc, instead of -encryption-key" )
+	flags.StringVar( &keySourceFlags.vaultField, "vault-field", "encryption_key", "Field of the -encryption-key-vault secret" )
+	flags.StringVar( &keySourceFlags.vaultAddress, "vault-addr", os.Getenv( "VAULT_ADDR" ), "Address of the Vault server" )
+	flags.StringVar( &keySourceFlags.vaultToken, "vault-token", os.Getenv( "VAULT_TOKEN" ), "Vault token, renewed as the key is refreshed if it is renewable" )
+	flags.DurationVar( &config.KeyRefreshInterval, "key-refresh-interval", time.Hour, "How often the master key is fetched again from KMS or Vault" )
+	flags.StringVar( &config.IntegrityCheck, "integrity-check", server.IntegrityFull, "Checksums of the stored hashes verified on startup: full, sample or off" )
+	flags.IntVar( &config.IntegritySampleSize, "integrity-sample", 1000, "Hashes picked at random by -integrity-check sample" )
+	flags.StringVar( &config.IntegrityPolicy, "integrity-policy", server.IntegrityRefuse, "What is done with a corrupted hash: refuse to serve it and fail /readyz, or flag it and serve it" )
+	flags.StringVar( &config.WALFile, "wal", config.WALFile, "Write-ahead log of submissions and hashes, replayed on startup so a crash loses no work" )
+	flags.StringVar( &config.SeedFile, "seed", config.SeedFile, "Export dump whose records are stored on startup, ids already taken are skipped" )
+	flags.StringVar( &config.AuditFile, "audit-log", config.AuditFile, "File the audit log of administrative actions is appended to, memory only if empty" )
+	compress := flags.Bool( "compress", true, "Compress responses with zstd or gzip when the client accepts it" )
+	sunset := flags.String( "sunset", "", "Date (YYYY-MM-DD) the unversioned aliases will be removed, announced in their Sunset header" )
+	logLevel := flags.String( "log-level", "info", "Least level logged: debug, info, warn or error" )
+	logFormat := flags.String( "log-format", "text", "Format of the log lines: text or json" )
+	accessLog := flags.String( "access-log", "", "File JSON access log records are appended to, \"-\" for stdout, none if empty" )
+	flags.Func( "log-sample", "Fraction of a route's requests logged in full at debug level, as \"POST /hash=0.01\", repeatable", func( value string ) error {
+		pattern, fraction, ok := strings.Cut( value, "=" )
+		if !ok {
+			return fmt.Errorf( "expected <pattern>=<fraction>" )
+		}
+		rate, err := strconv.ParseFloat( fraction, 64 )
+		if err != nil || rate < 0 || rate > 1 {
+			return fmt.Errorf( "invalid fraction %q, expected 0 to 1", fraction )
+		}
+		if config.LogSampling == nil {
+			config.LogSampling = map[string]float64{}
+		}
+		config.LogSampling[ pattern ] = rate
+		return nil
+	} )
+	logFile := flags.String( "log-file", "", "File the logs are appended to instead of stderr" )
+	var rotation logRotation
+	flags.IntVar( &rotation.maxSize, "log-max-size", 100, "Megabytes -log-file and -access-log grow to before they are rotated" )
+	flags.IntVar( &rotation.maxBackups, "log-max-backups", 0, "Rotated log files kept, all if 0" )
+	flags.IntVar( &rotation.maxAge, "log-max-age", 0, "Days rotated log files are kept, forever if 0" )
+	syslogAddress := flags.String( "syslog", "", "Syslog daemon the logs are sent to instead, \"local\", udp://host:port or tcp://host:port" )
+	syslogFacility := flags.String( "syslog-facility", "daemon", "Facility of the lines sent to -syslog, e.g. local0" )
+	syslogTag := flags.String( "syslog-tag", "hashsvc", "Tag of the lines sent to -syslog" )
+	flags.Parse( args )
+
+	// Log through slog, also the lines of packages using log
+	var logger *slog.Logger
+	var err error
+	if *syslogAddress != "" {
+		logger, err = newSyslogLogger( *syslogAddress, *syslogFacility, *syslogTag, *logLevel, *logFormat )
+	} else {
+		logOutput := io.Writer( os.Stderr )
+		if *logFile != "" {
+			logOutput = openLogFile( *logFile, rotation )
+		}
+		logger, err = newLogger( logOutput, *logLevel, *logFormat )
+	}
+	if err != nil {
+		fmt.Fprintln( os.Stderr, err )
+		os.Exit( 2 )
+	}
+	slog.SetDefault( logger )
+
+	config.URLSigningKey = []byte( *signingKey )
+	if *sunset != "" {
+		if config.Sunset, err = time.Parse( time.DateOnly, *sunset ); err != nil {
+			fatal( "Invalid -sunset date", err )
+		}
+	}
+
+	// Set up tracing before the server, which takes the global tracer provider
+	shutdownTracing, err := setupTracing( context.Background() )
+	if err != nil {
+		fatal( "Unable to set up tracing", err )
+	}
+	if shutdownTracing != nil {
+		defer func() {
+			ctx, cancel := context.WithTimeout( context.Background(), 5 * time.Second )
+			defer cancel()
+			if err := shutdownTracing( ctx ); err != nil {
+				logger.Error( "Unable to flush spans", "error", err )
+			}
+		}()
+	}
+
+	if archiveFlags.bucket != "" {
+		if config.ArchiveKey, err = hex.DecodeString( *archiveKey ); err != nil {
+			fatal( "Invalid -archive-key", err )
+		}
+	}
+	if *encryptionKey != "" {
+		if config.EncryptionKey, err = hex.DecodeString( *encryptionKey ); err != nil {
+			fatal( "Invalid -encryption-key", err )
+		}
+	}
+	if *previousKeys != "" {
+		for _, previous := range strings.Split( *previousKeys, "," ) {
+			key, err := hex.DecodeString( strings.TrimSpace( previous ) )
+			if err != nil {
+				fatal( "Invalid -encryption-previous-keys", err )
+			}
+			config.PreviousEncryptionKeys = append( config.PreviousEncryptionKeys, key )
+		}
+	}
+	middleware := []server.Middleware{ server.Recover( logger ) }
+	if *compress {
+		middleware = append( middleware, server.Compress() )
+	}
+	options := []server.Option{ server.WithConfig( config ), server.WithLogger( logger ), server.WithMiddleware( middleware... ) }
+	if *accessLog != "" {
+		options = append( options, server.WithAccessLogger( newAccessLogger( *accessLog, rotation ) ) )
+	}
+	store, closer, err := newStore( context.Background(), storeFlags )
+	if err != nil {
+		fatal( "Unable to open the store", err )
+	}
+	if closer != nil {
+		defer closer.Close()
+	}
+	if store != nil {
+		options = append( options, server.WithStore( store ) )
+	}
+	if *migrateFrom != "" {
+		if store == nil {
+			fatal( "Invalid -migrate-from", fmt.Errorf( "-migrate-from needs a persistent -store to migrate to" ) )
+		}
+		source, sourceCloser, err := newMigrationSource( context.Background(), *migrateFrom, storeFlags )
+		if err != nil {
+			fatal( "Unable to open the -migrate-from store", err )
+		}
+		if sourceCloser != nil {
+			defer sourceCloser.Close()
+		}
+		options = append( options, server.WithMigration( source ) )
+	}
+	archive, err := newArchive( context.Background(), archiveFlags )
+	if err != nil {
+		fatal( "Unable to open the archive", err )
+	}
+	if archive != nil {
+		options = append( options, server.WithArchive( archive ) )
+	}
+	keySource, err := newKeySource( context.Background(), keySourceFlags )
+	if err != nil {
+		fatal( "Unable to set up the encryption key source", err )
+	}
+	if keySource != nil {
+		options = append( options, server.WithKeySource( keySource ) )
+	}
+	s, err := server.New( options... )
+	if err != nil {
+		fatal( "Unable to create the server", err )
+	}
+
+	ctx, stop := signal.NotifyContext( context.Background(), os.Interrupt, syscall.SIGTERM )
+	defer stop()
+	if err := s.ListenAndServe( ctx ); err != nil {
+		fatal( "Server failed", err )
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+    "crypto/subtle"
+    "net/http"
+    "strings"
+)
+
+/********************************************************************
+isAdmin()
+    Returns true if the request carries the admin token with the
+    Bearer scheme, or no admin token is configured.
+********************************************************************/
+func ( s *Server ) isAdmin( r *http.Request ) bool {
+    return s.isAdminAuthorization( r.Header.Get( "Authorization" ) )
+}
+
+/********************************************************************
+isAdminAuthorization()
+    Returns true if an Authorization header, or the "authorization"
+    metadata of a gRPC call, is the admin token with the Bearer
+    scheme, or no admin token is configured.
+********************************************************************/
+func ( s *Server ) isAdminAuthorization( authorization string ) bool {
+    if s.config.AdminToken == "" {
+        return true
+    }
+
+    scheme, token, found := strings.Cut( authorization, " " )
+    if !found || !strings.EqualFold( scheme, "Bearer" ) {
+        return false
+    }
+    return subtle.ConstantTimeCompare( []byte( token ), []byte( s.config.AdminToken ) ) == 1
+}
+
+/********************************************************************
+withAdmin()
+    Middleware rejecting requests without the admin bearer token with
+    401 Unauthorized.
+********************************************************************/
+func ( s *Server ) withAdmin( next http.HandlerFunc ) http.HandlerFunc {
+    return func( w http.ResponseWriter, r *http.Request ) {
+        if !s.isAdmin( r ) {
+            s.log( r ).Info( "Missing or invalid admin token!" )
+            s.auditRequest( r, AuditAuthFailure, "invalid admin token" )
+            w.Header().Set( "WWW-Authenticate", `Bearer realm="admin"` )
+            writeError( w, http.StatusUnauthorized, ErrorUnauthorized )
+            return
+        }
+        next( w, r )
+    }
+}
+
+/********************************************************************
+withRequiredAdmin()
+    Like withAdmin(), but for endpoints too sensitive to leave open:
+    when no admin token is configured they are disabled with 403.
+********************************************************************/
+func ( s *Server ) withRequiredAdmin( next http.HandlerFunc ) http.HandlerFunc {
+    return func( w http.ResponseWriter, r *http.Request ) {
+        if s.config.AdminToken == "" {
+            s.log( r ).Info( "Endpoint disabled, no admin token configured!" )
+            writeError( w, http.StatusForbidden, ErrorAdminDisabled )
+            return
+        }
+        s.withAdmin( next )( w, r )
+    }
+}
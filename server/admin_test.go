@@ -0,0 +1,70 @@
+package server
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+// adminRequest sends a request with an Authorization header, if any
+func adminRequest( handler http.Handler, method string, target string, authorization string ) *httptest.ResponseRecorder {
+    request := httptest.NewRequest( method, target, nil )
+    if authorization != "" {
+        request.Header.Set( "Authorization", authorization )
+    }
+    response := httptest.NewRecorder()
+    handler.ServeHTTP( response, request )
+    return response
+}
+
+// Shutdown tokens are only issued for the admin token, which needs the
+// Bearer scheme
+func TestShutdownTokenNeedsAdmin( t *testing.T ) {
+    _, open := newTestServer( t, 0 )
+    if response := adminRequest( open, http.MethodPost, "/v1/admin/shutdown-token", "" ); response.Code != http.StatusForbidden {
+        t.Errorf( "without -admin-token: got %d, want 403", response.Code )
+    }
+
+    config := DefaultConfig()
+    config.AdminToken = "admin"
+    _, handler := newTestServer( t, 0, WithConfig( config ) )
+    for _, test := range []struct {
+        authorization string
+        status int
+    }{
+        { "", http.StatusUnauthorized },
+        { "admin", http.StatusUnauthorized },
+        { "Basic admin", http.StatusUnauthorized },
+        { "Bearer wrong", http.StatusUnauthorized },
+        { "Bearer admin", http.StatusOK },
+        { "bearer admin", http.StatusOK },
+    } {
+        if response := adminRequest( handler, http.MethodPost, "/v1/admin/shutdown-token", test.authorization ); response.Code != test.status {
+            t.Errorf( "Authorization %q: got %d, want %d", test.authorization, response.Code, test.status )
+        }
+    }
+}
+
+// The sensitive admin endpoints are disabled rather than open without an
+// admin token
+func TestSensitiveEndpointsNeedAdminToken( t *testing.T ) {
+    _, handler := newTestServer( t, 0 )
+    for _, route := range []struct {
+        method string
+        target string
+    }{
+        { http.MethodGet, "/debug/runtime" },
+        { http.MethodGet, "/v1/admin/diagnostics" },
+        { http.MethodPost, "/v1/admin/keys/rotate" },
+        { http.MethodPost, "/v1/admin/pause" },
+        { http.MethodPost, "/v1/admin/resume" },
+        { http.MethodGet, "/v1/admin/hash/1" },
+        { http.MethodPost, "/v1/admin/signed-url" },
+        { http.MethodPost, "/v1/stats/reset" },
+        { http.MethodGet, "/v1/admin/webhooks/dead-letters" },
+    } {
+        if response := adminRequest( handler, route.method, route.target, "" ); response.Code != http.StatusForbidden {
+            t.Errorf( "%s %s without -admin-token: got %d, want 403", route.method, route.target, response.Code )
+        }
+    }
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+    "bytes"
+    "compress/gzip"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/json"
+    "fmt"
+    "io"
+    "time"
+)
+
+// Default interval between archives of the records
+const archiveDefaultInterval = time.Hour
+
+// Destination of the periodic archives of the records, set by
+// WithArchive(). Archives are encrypted before they are uploaded
+type Archive interface {
+    // Uploads an archive under a name, names sort by time
+    Upload( name string, data []byte ) error
+
+    // Returns the archive with the greatest name, nil if there is none
+    Latest() ( []byte, error )
+
+    // Returns the names of the archives, in ascending order
+    List() ( []string, error )
+}
+
+/********************************************************************
+archiveName()
+    Returns the name of an archive taken at a time, sorting in time
+    order.
+********************************************************************/
+func archiveName( at time.Time ) string {
+    return "records-" + at.UTC().Format( "20060102T150405.000000000Z" ) + ".ndjson.gz.enc"
+}
+
+/********************************************************************
+sealArchive()
+    Encrypts an archive with AES-256-GCM, returning the random nonce
+    followed by the ciphertext.
+********************************************************************/
+func sealArchive( key []byte, plaintext []byte ) ( []byte, error ) {
+    gcm, err := archiveCipher( key )
+    if err != nil {
+        return nil, err
+    }
+    nonce := make([]byte, gcm.NonceSize())
+    if _, err := rand.Read( nonce ); err != nil {
+        return nil, err
+    }
+    return gcm.Seal( nonce, nonce, plaintext, nil ), nil
+}
+
+/********************************************************************
+openArchive()
+    Decrypts an archive sealed by sealArchive(), failing if it was
+    sealed with another key or altered.
+********************************************************************/
+func openArchive( key []byte, sealed []byte ) ( []byte, error ) {
+    gcm, err := archiveCipher( key )
+    if err != nil {
+        return nil, err
+    }
+    if len( sealed ) < gcm.NonceSize() {
+        return nil, fmt.Errorf( "archive is truncated" )
+    }
+    nonce, ciphertext := sealed[ :gcm.NonceSize() ], sealed[ gcm.NonceSize(): ]
+    return gcm.Open( nil, nonce, ciphertext, nil )
+}
+
+/********************************************************************
+archiveCipher()
+    Returns the AES-256-GCM cipher of a 32 byte key.
+********************************************************************/
+func archiveCipher( key []byte ) ( cipher.AEAD, error ) {
+    if len( key ) != 32 {
+        return nil, fmt.Errorf( "archive key must be 32 bytes, not %d", len( key ) )
+    }
+    block, err := aes.NewCipher( key )
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM( block )
+}
+
+/********************************************************************
+archiveRecords()
+    Uploads every stored record to the archive, as gzipped JSON lines
+    encrypted with Config.ArchiveKey.
+********************************************************************/
+func ( s *Server ) archiveRecords() error {
+    records, err := s.store.List()
+    if err != nil {
+        return err
+    }
+
+    var body bytes.Buffer
+    gz := gzip.NewWriter( &body )
+    for _, record := range records {
+        data, err := encodeRecord( record )
+        if err != nil {
+            return err
+        }
+        gz.Write( append( data, '\n' ) )
+    }
+    if err := gz.Close(); err != nil {
+        return err
+    }
+
+    sealed, err := sealArchive( s.config.ArchiveKey, body.Bytes() )
+    if err != nil {
+        return err
+    }
+    name := archiveName( s.clock.Now() )
+    if err := s.archive.Upload( name, sealed ); err != nil {
+        return err
+    }
+    s.logger.Info( "Archived records!", "archive", name, "records", len( records ) )
+    return nil
+}
+
+/********************************************************************
+restoreArchive()
+    Rehydrates an empty store from the latest archive, if there is
+    one, leaving out the records the snapshot has as purged or
+    erased. A store that already has records is left as it is.
+********************************************************************/
+func ( s *Server ) restoreArchive() error {
+    count, err := s.store.Count()
+    if err != nil || count > 0 {
+        return err
+    }
+
+    sealed, err := s.archive.Latest()
+    if err != nil || sealed == nil {
+        return err
+    }
+    plaintext, err := openArchive( s.config.ArchiveKey, sealed )
+    if err != nil {
+        return err
+    }
+    gz, err := gzip.NewReader( bytes.NewReader( plaintext ) )
+    if err != nil {
+        return err
+    }
+
+    decoder := json.NewDecoder( gz )
+    restored := 0
+    for {
+        var data json.RawMessage
+        if err := decoder.Decode( &data ); err == io.EOF {
+            break
+        } else if err != nil {
+            return err
+        }
+        record, err := decodeRecord( data )
+        if err != nil {
+            return err
+        }
+        if s.purgedIds.has( record.Id ) {
+            continue
+        }
+        if err := s.store.Put( record ); err != nil {
+            return err
+        }
+        restored++
+    }
+
+    s.logger.Info( "Restored records from the archive!", "records", restored )
+    return nil
+}
+
+/********************************************************************
+archivePeriodically()
+    Archives the records every ArchiveInterval until the server shuts
+    down, Shutdown() archives them a last time.
+********************************************************************/
+func ( s *Server ) archivePeriodically() {
+    interval := s.config.ArchiveInterval
+    if interval <= 0 {
+        interval = archiveDefaultInterval
+    }
+    ticker := time.NewTicker( interval )
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+        case <-s.shutdownStarted:
+            return
+        }
+
+        if err := s.archiveRecords(); err != nil {
+            s.logError( "Unable to archive records: %v", err )
+        }
+    }
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+    "bufio"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+
+    "google.golang.org/grpc/peer"
+)
+
+// Administrative actions recorded in the audit log
+const (
+    AuditShutdown = "shutdown"
+    AuditShutdownToken = "shutdown_token.issue"
+    AuditPause = "pause"
+    AuditResume = "resume"
+    AuditStatsReset = "stats.reset"
+    AuditKeyRotate = "keys.rotate"
+    AuditSignedURL = "signed_url.issue"
+    AuditExport = "records.export"
+    AuditImport = "records.import"
+    AuditCompact = "storage.compact"
+    AuditBackfill = "storage.backfill"
+    AuditCutover = "storage.cutover"
+    AuditDelete = "records.delete"
+    AuditUndelete = "records.undelete"
+    AuditPurge = "records.purge"
+    AuditErase = "records.erase"
+    AuditAuthFailure = "auth.failure"
+)
+
+// Audit entries kept in memory for GET /admin/audit, older ones are
+// only in Config.AuditFile
+const auditMaxEntries = 10000
+
+// Previous hash of the first entry of an audit log
+var auditGenesisHash = strings.Repeat( "0", sha256.Size * 2 )
+
+// Entry of the audit log. Its hash is the SHA-256 of the previous
+// entry's hash followed by the entry as JSON without its hash, so
+// editing, removing or reordering entries breaks the chain
+type AuditEntry struct {
+    Seq int64 `json:"seq"`
+    Time time.Time `json:"time"`
+    Action string `json:"action"`
+
+    // Where the action came from: "http", "grpc", or "signal" when the
+    // context of ListenAndServe() is done, e.g. on SIGTERM
+    Source string `json:"source"`
+
+    // Who made it, the basic auth user name and the client's address
+    Principal string `json:"principal,omitempty"`
+    ClientIp string `json:"client_ip,omitempty"`
+    UserAgent string `json:"user_agent,omitempty"`
+    RequestId string `json:"request_id,omitempty"`
+
+    Detail string `json:"detail,omitempty"`
+    PrevHash string `json:"prev_hash"`
+    Hash string `json:"hash"`
+}
+
+// Response to GET /admin/audit
+type AuditLog struct {
+    Entries []AuditEntry `json:"entries"`
+
+    // Whether the chain of the entries is intact, and if not the
+    // first entry that doesn't match it
+    Verified bool `json:"verified"`
+    BrokenAt int64 `json:"broken_at,omitempty"`
+}
+
+/********************************************************************
+auditHash()
+    Returns the hash of an entry, chained to the previous entry's.
+********************************************************************/
+func auditHash( entry AuditEntry ) string {
+    entry.Hash = ""
+    data, _ := json.Marshal( entry )
+
+    hasher := sha256.New()
+    hasher.Write( []byte( entry.PrevHash ) )
+    hasher.Write( data )
+    return hex.EncodeToString( hasher.Sum(nil) )
+}
+
+/********************************************************************
+verifyAudit()
+    Checks that entries chain to the entry with the sequence number
+    and hash, returning the sequence number of the first one that
+    doesn't, or 0 if the chain is intact.
+********************************************************************/
+func verifyAudit( entries []AuditEntry, seq int64, prevHash string ) int64 {
+    for _, entry := range entries {
+        seq++
+        if entry.Seq != seq || entry.PrevHash != prevHash || entry.Hash != auditHash( entry ) {
+            return entry.Seq
+        }
+        prevHash = entry.Hash
+    }
+    return 0
+}
+
+/********************************************************************
+auditSnapshot()
+    Returns a copy of the entries kept in memory, and the sequence
+    number and hash the first one chains to.
+********************************************************************/
+func ( s *Server ) auditSnapshot() ( []AuditEntry, int64, string ) {
+    s.auditMutex.Lock()
+    entries := append( []AuditEntry{}, s.auditEntries... )
+    s.auditMutex.Unlock()
+
+    if len( entries ) == 0 || entries[ 0 ].Seq == 1 {
+        return entries, 0, auditGenesisHash
+    }
+    return entries, entries[ 0 ].Seq - 1, entries[ 0 ].PrevHash
+}
+
+/********************************************************************
+loadAudit()
+    Reads the entries of Config.AuditFile, if it exists, and opens it
+    for appending. A broken chain is logged as an error, the server
+    still starts and GET /admin/audit reports it.
+********************************************************************/
+func ( s *Server ) loadAudit() error {
+    file, err := os.Open( s.config.AuditFile )
+    if err != nil && !errors.Is( err, fs.ErrNotExist ) {
+        return err
+    }
+
+    var entries []AuditEntry
+    if err == nil {
+        scanner := bufio.NewScanner( file )
+        scanner.Buffer( nil, 1024 * 1024 )
+        for line := 1; scanner.Scan(); line++ {
+            if len( scanner.Bytes() ) == 0 {
+                continue
+            }
+            var entry AuditEntry
+            if err := json.Unmarshal( scanner.Bytes(), &entry ); err != nil {
+                file.Close()
+                return fmt.Errorf( "line %d: %w", line, err )
+            }
+            entries = append( entries, entry )
+        }
+        file.Close()
+        if err := scanner.Err(); err != nil {
+            return err
+        }
+    }
+
+    if brokenAt := verifyAudit( entries, 0, auditGenesisHash ); brokenAt != 0 {
+        s.logError( "Audit log chain broken at entry %d, it has been tampered with!", brokenAt )
+    }
+    if len( entries ) > auditMaxEntries {
+        entries = entries[ len( entries ) - auditMaxEntries: ]
+    }
+    s.auditEntries = entries
+    if len( entries ) > 0 {
+        last := entries[ len( entries ) - 1 ]
+        s.auditSeq, s.auditHead = last.Seq, last.Hash
+    }
+
+    s.auditFile, err = os.OpenFile( s.config.AuditFile, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0600 )
+    return err
+}
+
+/********************************************************************
+audit()
+    Appends an entry to the audit log, numbering, timing and chaining
+    it. With an AuditFile the entry is written and synced before
+    audit() returns, failing to is logged as an error.
+********************************************************************/
+func ( s *Server ) audit( entry AuditEntry ) {
+    s.auditMutex.Lock()
+    defer s.auditMutex.Unlock()
+
+    s.auditSeq++
+    entry.Seq = s.auditSeq
+    entry.Time = s.clock.Now().UTC()
+    entry.PrevHash = s.auditHead
+    entry.Hash = auditHash( entry )
+    s.auditHead = entry.Hash
+
+    s.auditEntries = append( s.auditEntries, entry )
+    if len( s.auditEntries ) > auditMaxEntries {
+        s.auditEntries = append( []AuditEntry{}, s.auditEntries[ len( s.auditEntries ) - auditMaxEntries: ]... )
+    }
+
+    if s.auditFile != nil {
+        data, _ := json.Marshal( entry )
+        if _, err := s.auditFile.Write( append( data, '\n' ) ); err != nil {
+            s.logError( "Unable to write audit entry %d: %v", entry.Seq, err )
+        } else if err := s.auditFile.Sync(); err != nil {
+            s.logError( "Unable to sync audit entry %d: %v", entry.Seq, err )
+        }
+    }
+}
+
+/********************************************************************
+auditRequest()
+    Records an action made through an HTTP request, along with who
+    made it.
+********************************************************************/
+func ( s *Server ) auditRequest( r *http.Request, action string, detail string ) {
+    principal, _, _ := r.BasicAuth()
+
+    s.audit( AuditEntry{
+        Action: action,
+        Source: "http",
+        Principal: principal,
+        ClientIp: clientIp( r ),
+        UserAgent: r.UserAgent(),
+        RequestId: requestId( r ),
+        Detail: detail,
+    } )
+}
+
+/********************************************************************
+auditGrpc()
+    Records an action made through a gRPC call, along with the
+    address of the client.
+********************************************************************/
+func ( s *Server ) auditGrpc( ctx context.Context, action string, detail string ) {
+    entry := AuditEntry{ Action: action, Source: "grpc", Detail: detail }
+    if client, ok := peer.FromContext( ctx ); ok {
+        entry.ClientIp = client.Addr.String()
+    }
+    s.audit( entry )
+}
+
+/********************************************************************
+closeAudit()
+    Closes Config.AuditFile on shutdown, later entries are only kept
+    in memory.
+********************************************************************/
+func ( s *Server ) closeAudit() {
+    s.auditMutex.Lock()
+    defer s.auditMutex.Unlock()
+
+    if s.auditFile != nil {
+        s.auditFile.Close()
+        s.auditFile = nil
+    }
+}
+
+/********************************************************************
+handleAudit()
+    Handles GET requests on /admin/audit, returning the audit log
+    entries kept in memory along with whether their chain is intact.
+********************************************************************/
+func ( s *Server ) handleAudit( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /admin/audit" )
+
+    entries, seq, prevHash := s.auditSnapshot()
+    brokenAt := verifyAudit( entries, seq, prevHash )
+    s.writeEncoded( w, r, http.StatusOK, AuditLog{ Entries: entries, Verified: brokenAt == 0, BrokenAt: brokenAt } )
+}
+
+/********************************************************************
+handleAuditExport()
+    Handles GET requests on /admin/audit/export, downloading the audit
+    log entries kept in memory as JSON lines, the format of
+    Config.AuditFile, so the chain can be verified offline.
+********************************************************************/
+func ( s *Server ) handleAuditExport( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /admin/audit/export" )
+
+    entries, _, _ := s.auditSnapshot()
+
+    w.Header().Set( "Content-Type", "application/x-ndjson" )
+    w.Header().Set( "Content-Disposition", `attachment; filename="audit.jsonl"` )
+    encoder := json.NewEncoder( w )
+    for _, entry := range entries {
+        encoder.Encode( entry )
+    }
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "os"
+    "sync"
+    "time"
+
+    bolt "go.etcd.io/bbolt"
+)
+
+var (
+    // Buckets of a bolt store, records by big endian id and the last
+    // allocated id
+    boltRecords = []byte( "records" )
+    boltMeta = []byte( "meta" )
+    boltLastId = []byte( "last_id" )
+)
+
+// Most written per transaction when compacting a bolt store
+const boltCompactTxSize = 64 * 1024 * 1024
+
+// Store keeping the records in a single bbolt database file, along
+// with the last allocated id, created by NewBoltStore()
+type BoltStore struct {
+    path string
+
+    // Guards db, replaced by the compacted database by Compact()
+    mutex sync.RWMutex
+    db *bolt.DB
+}
+
+/********************************************************************
+NewBoltStore()
+    Opens the bbolt database at path, creating it if needed. Only one
+    process can have it open, others fail after a second.
+********************************************************************/
+func NewBoltStore( path string ) ( *BoltStore, error ) {
+    db, err := openBolt( path )
+    if err != nil {
+        return nil, err
+    }
+
+    err = db.Update( func( tx *bolt.Tx ) error {
+        if _, err := tx.CreateBucketIfNotExists( boltRecords ); err != nil {
+            return err
+        }
+        _, err := tx.CreateBucketIfNotExists( boltMeta )
+        return err
+    } )
+    if err != nil {
+        db.Close()
+        return nil, err
+    }
+    return &BoltStore{ path: path, db: db }, nil
+}
+
+/********************************************************************
+openBolt()
+    Opens a bbolt database, failing after a second if another process
+    has it open.
+********************************************************************/
+func openBolt( path string ) ( *bolt.DB, error ) {
+    db, err := bolt.Open( path, 0600, &bolt.Options{ Timeout: time.Second } )
+    if errors.Is( err, bolt.ErrTimeout ) {
+        return nil, fmt.Errorf( "%s is in use by another process", path )
+    }
+    return db, err
+}
+
+/********************************************************************
+view()
+    Runs a read-only transaction on the database.
+********************************************************************/
+func ( b *BoltStore ) view( fn func( tx *bolt.Tx ) error ) error {
+    b.mutex.RLock()
+    defer b.mutex.RUnlock()
+    return b.db.View( fn )
+}
+
+/********************************************************************
+update()
+    Runs a read-write transaction on the database.
+********************************************************************/
+func ( b *BoltStore ) update( fn func( tx *bolt.Tx ) error ) error {
+    b.mutex.RLock()
+    defer b.mutex.RUnlock()
+    return b.db.Update( fn )
+}
+
+/********************************************************************
+boltKey()
+    Returns the key of an id, big endian so keys sort in id order.
+********************************************************************/
+func boltKey( id int64 ) []byte {
+    key := make([]byte, 8)
+    binary.BigEndian.PutUint64( key, uint64( id ) )
+    return key
+}
+
+func ( b *BoltStore ) Put( record *Record ) error {
+    data, err := encodeRecord( record )
+    if err != nil {
+        return err
+    }
+    return b.update( func( tx *bolt.Tx ) error {
+        return tx.Bucket( boltRecords ).Put( boltKey( record.Id ), data )
+    } )
+}
+
+func ( b *BoltStore ) Get( id int64 ) ( *Record, error ) {
+    var record *Record
+    err := b.view( func( tx *bolt.Tx ) error {
+        data := tx.Bucket( boltRecords ).Get( boltKey( id ) )
+        if data == nil {
+            return ErrRecordNotFound
+        }
+        var err error
+        record, err = decodeRecord( data )
+        return err
+    } )
+    return record, err
+}
+
+func ( b *BoltStore ) Delete( id int64 ) error {
+    return b.update( func( tx *bolt.Tx ) error {
+        return tx.Bucket( boltRecords ).Delete( boltKey( id ) )
+    } )
+}
+
+func ( b *BoltStore ) List() ( []*Record, error ) {
+    records := []*Record{}
+    err := b.view( func( tx *bolt.Tx ) error {
+        return tx.Bucket( boltRecords ).ForEach( func( key []byte, data []byte ) error {
+            record, err := decodeRecord( data )
+            if err != nil {
+                return err
+            }
+            records = append( records, record )
+            return nil
+        } )
+    } )
+    return records, err
+}
+
+func ( b *BoltStore ) Count() ( int, error ) {
+    var count int
+    err := b.view( func( tx *bolt.Tx ) error {
+        count = tx.Bucket( boltRecords ).Stats().KeyN
+        return nil
+    } )
+    return count, err
+}
+
+func ( b *BoltStore ) LastId() ( int64, error ) {
+    var id int64
+    err := b.view( func( tx *bolt.Tx ) error {
+        if data := tx.Bucket( boltMeta ).Get( boltLastId ); data != nil {
+            id = int64( binary.BigEndian.Uint64( data ) )
+        }
+        return nil
+    } )
+    return id, err
+}
+
+func ( b *BoltStore ) SetLastId( id int64 ) error {
+    return b.update( func( tx *bolt.Tx ) error {
+        return tx.Bucket( boltMeta ).Put( boltLastId, boltKey( id ) )
+    } )
+}
+
+/********************************************************************
+Ping()
+    Checks the database is open, without walking it like Count().
+********************************************************************/
+func ( b *BoltStore ) Ping() error {
+    return b.view( func( tx *bolt.Tx ) error {
+        return nil
+    } )
+}
+
+/********************************************************************
+Compact()
+    Rewrites the database into a new file holding only the live
+    records, giving back the pages of deleted ones, and swaps it in.
+    Returns the size of the file before and after.
+********************************************************************/
+func ( b *BoltStore ) Compact() ( int64, int64, error ) {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+
+    before, err := fileSize( b.path )
+    if err != nil {
+        return 0, 0, err
+    }
+    compacted := b.path + ".compact"
+    os.Remove( compacted )
+    dst, err := bolt.Open( compacted, 0600, nil )
+    if err != nil {
+        return 0, 0, err
+    }
+    if err := bolt.Compact( dst, b.db, boltCompactTxSize ); err != nil {
+        dst.Close()
+        os.Remove( compacted )
+        return 0, 0, err
+    }
+    if err := dst.Close(); err != nil {
+        os.Remove( compacted )
+        return 0, 0, err
+    }
+
+    // Swap the files with the database closed, reopening whichever
+    // is in place so the store keeps working if the rename failed
+    if err := b.db.Close(); err != nil {
+        return 0, 0, err
+    }
+    renameErr := os.Rename( compacted, b.path )
+    if b.db, err = openBolt( b.path ); err != nil {
+        return 0, 0, err
+    }
+    if renameErr != nil {
+        os.Remove( compacted )
+        return 0, 0, renameErr
+    }
+    after, err := fileSize( b.path )
+    return before, after, err
+}
+
+/********************************************************************
+Close()
+    Closes the database file.
+********************************************************************/
+func ( b *BoltStore ) Close() error {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    return b.db.Close()
+}
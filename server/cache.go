@@ -0,0 +1,107 @@
+package server
+
+import (
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// How long a browser may keep a hashed record. A record never changes
+// but can be deleted or erased, so shared caches mustn't keep it and
+// the client's copy goes stale soon after
+const recordMaxAge = time.Minute
+
+var (
+    // Cache-Control of hashed password records
+    recordCacheControl = "private, max-age=" + strconv.FormatInt( int64( recordMaxAge.Seconds() ), 10 )
+
+    // Default Cache-Control by route pattern, replaced per route by
+    // Config.CacheControl. Responses that change from one request to
+    // the next mustn't be stored by intermediary caches
+    cacheControlDefaults = map[string]string{
+        "GET /hash": "no-store",
+        "GET /hash/{id}": recordCacheControl,
+        "GET /hash/{id}/status": "no-store",
+        "GET /hash/watch": "no-store",
+        "GET /hash/find": "no-store",
+        "GET /hashes": "no-store",
+        "GET /stats": "no-store",
+        "GET /events": "no-store",
+        "GET /admin/hash/{id}": "no-store",
+        "GET /admin/webhooks/dead-letters": "no-store",
+        "GET /admin/diagnostics": "no-store",
+        "GET /admin/audit": "no-store",
+        "GET /admin/audit/export": "no-store",
+        "GET /admin/export": "no-store",
+        "GET /metrics": "no-store",
+        "GET /livez": "no-store",
+        "GET /startupz": "no-store",
+        "GET /readyz": "no-store",
+        "GET /healthz": "no-store",
+        "GET /debug/vars": "no-store",
+        "GET /debug/runtime": "no-store",
+        "GET /debug/pprof/": "no-store",
+        "GET /openapi.json": "no-cache",
+    }
+)
+
+/********************************************************************
+cacheControl()
+    Returns the Cache-Control of a route pattern, with or without
+    the API version, or "" if responses don't get one.
+********************************************************************/
+func ( s *Server ) cacheControl( pattern string ) string {
+    pattern = unversioned( pattern )
+    if value, ok := s.config.CacheControl[ pattern ]; ok {
+        return value
+    }
+    return cacheControlDefaults[ pattern ]
+}
+
+/********************************************************************
+unversioned()
+    Returns a route pattern without the API version.
+********************************************************************/
+func unversioned( pattern string ) string {
+    return strings.Replace( pattern, " " + apiVersion + "/", " /", 1 )
+}
+
+/********************************************************************
+withCacheControl()
+    Middleware setting the Cache-Control of a route pattern on its
+    responses. GET /hash/{id} is left to handleHashGet(), where the
+    policy only applies to hashed records.
+********************************************************************/
+func ( s *Server ) withCacheControl( pattern string, next http.HandlerFunc ) http.HandlerFunc {
+    value := s.cacheControl( pattern )
+    if value == "" || unversioned( pattern ) == "GET /hash/{id}" {
+        return next
+    }
+
+    return func( w http.ResponseWriter, r *http.Request ) {
+        w.Header().Set( "Cache-Control", value )
+        next( w, r )
+    }
+}
+
+/********************************************************************
+setRecordCacheControl()
+    Sets the Cache-Control of a hashed record response. With the
+    default policy a record with a ttl, or past RetentionPeriod, is
+    only cached until it expires, if that is within recordMaxAge.
+********************************************************************/
+func ( s *Server ) setRecordCacheControl( w http.ResponseWriter, record *Record ) {
+    value := s.cacheControl( "GET /hash/{id}" )
+    if expiry := s.expiryOf( record ); value == recordCacheControl && expiry != nil {
+        maxAge := s.until( *expiry )
+        if maxAge > recordMaxAge {
+            maxAge = recordMaxAge
+        }
+        if maxAge < 0 {
+            maxAge = 0
+        }
+        value = "private, max-age=" + strconv.FormatInt( int64( maxAge.Seconds() ), 10 )
+    }
+    w.Header().Set( "Cache-Control", value )
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+    "encoding/json"
+    "errors"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// Default interval between checkpoints of the stats
+const statsCheckpointDefaultInterval = time.Minute
+
+// Stats saved to Config.StatsFile, so /stats totals survive restarts
+type statsCheckpoint struct {
+    SavedAt time.Time `json:"saved_at"`
+    Hashed int64 `json:"hashed"`
+    TotalTime int64 `json:"total_time"`
+    SlaViolations int64 `json:"sla_violations"`
+    Expired int64 `json:"expired"`
+    RetentionExpired int64 `json:"retention_expired"`
+    Evicted int64 `json:"evicted"`
+    ResetAt time.Time `json:"reset_at"`
+    Since time.Time `json:"since"`
+    Labels map[string]counterCheckpoint `json:"labels"`
+    Endpoints map[string]counterCheckpoint `json:"endpoints"`
+    Windows []counterCheckpoint `json:"windows"`
+    Webhooks WebhookStat `json:"webhooks"`
+}
+
+// Count and total time of a label, an endpoint or a second of the
+// sliding window
+type counterCheckpoint struct {
+    Second int64 `json:"second,omitempty"`
+    Count int64 `json:"count"`
+    TotalTime int64 `json:"total_time"`
+    Statuses map[int]int64 `json:"statuses,omitempty"`
+}
+
+/********************************************************************
+loadStats()
+    Restores the stats from Config.StatsFile, if it exists.
+********************************************************************/
+func ( s *Server ) loadStats() error {
+    data, err := os.ReadFile( s.config.StatsFile )
+    if errors.Is( err, fs.ErrNotExist ) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+
+    var checkpoint statsCheckpoint
+    if err := json.Unmarshal( data, &checkpoint ); err != nil {
+        return err
+    }
+    s.restoreStats( checkpoint )
+
+    s.logger.Info( "Restored stats!", "hashed", checkpoint.Hashed, "saved_at", checkpoint.SavedAt )
+    return nil
+}
+
+/********************************************************************
+restoreStats()
+    Restores the stats of a checkpoint, from Config.StatsFile or a
+    snapshot.
+********************************************************************/
+func ( s *Server ) restoreStats( checkpoint statsCheckpoint ) {
+    s.hashedCount = checkpoint.Hashed
+    s.totalTime = checkpoint.TotalTime
+    s.slaViolations = checkpoint.SlaViolations
+    s.expiredCount = checkpoint.Expired
+    s.retentionExpiredCount = checkpoint.RetentionExpired
+    s.evictedCount = checkpoint.Evicted
+    s.statsResetAt = checkpoint.ResetAt
+    if !checkpoint.Since.IsZero() {
+        s.statsSince = checkpoint.Since
+    }
+    for label, counter := range checkpoint.Labels {
+        s.labelStats[ label ] = &labelStat{ count: counter.Count, totalTime: counter.TotalTime }
+    }
+    for pattern, counter := range checkpoint.Endpoints {
+        if counter.Statuses == nil {
+            counter.Statuses = map[int]int64{}
+        }
+        s.endpointCounters[ pattern ] = &endpointCounter{ count: counter.Count, totalTime: counter.TotalTime, statuses: counter.Statuses }
+    }
+    for _, counter := range checkpoint.Windows {
+        s.recentHashes.buckets[ counter.Second % windowSeconds ] = windowBucket{ second: counter.Second, count: counter.Count, totalTime: counter.TotalTime }
+    }
+    s.webhookStats = checkpoint.Webhooks
+}
+
+/********************************************************************
+saveStats()
+    Writes the stats to Config.StatsFile.
+********************************************************************/
+func ( s *Server ) saveStats() error {
+    data, err := json.Marshal( s.statsCheckpoint() )
+    if err != nil {
+        return err
+    }
+    return writeFileAtomic( s.config.StatsFile, data )
+}
+
+/********************************************************************
+statsCheckpoint()
+    Returns a checkpoint of the stats.
+********************************************************************/
+func ( s *Server ) statsCheckpoint() statsCheckpoint {
+    checkpoint := statsCheckpoint{
+        SavedAt: s.clock.Now(),
+        Labels: map[string]counterCheckpoint{},
+        Endpoints: map[string]counterCheckpoint{},
+    }
+
+    s.mapMutex.Lock()
+    checkpoint.Hashed = s.hashedCount
+    checkpoint.TotalTime = s.totalTime
+    checkpoint.SlaViolations = s.slaViolations
+    checkpoint.Expired = s.expiredCount
+    checkpoint.RetentionExpired = s.retentionExpiredCount
+    checkpoint.Evicted = s.evictedCount
+    checkpoint.ResetAt = s.statsResetAt
+    checkpoint.Since = s.statsSince
+    for label, stat := range s.labelStats {
+        checkpoint.Labels[ label ] = counterCheckpoint{ Count: stat.count, TotalTime: stat.totalTime }
+    }
+    for _, bucket := range s.recentHashes.buckets {
+        if bucket.count > 0 {
+            checkpoint.Windows = append( checkpoint.Windows, counterCheckpoint{ Second: bucket.second, Count: bucket.count, TotalTime: bucket.totalTime } )
+        }
+    }
+    s.mapMutex.Unlock()
+
+    s.endpointMutex.Lock()
+    for pattern, counter := range s.endpointCounters {
+        statuses := make(map[int]int64, len( counter.statuses ))
+        for status, count := range counter.statuses {
+            statuses[ status ] = count
+        }
+        checkpoint.Endpoints[ pattern ] = counterCheckpoint{ Count: counter.count, TotalTime: counter.totalTime, Statuses: statuses }
+    }
+    s.endpointMutex.Unlock()
+
+    s.webhookMutex.Lock()
+    checkpoint.Webhooks = s.webhookStats
+    s.webhookMutex.Unlock()
+
+    return checkpoint
+}
+
+/********************************************************************
+writeFileAtomic()
+    Replaces a file by writing a temporary file next to it, syncing
+    it and renaming it over the file, so a crash while writing leaves
+    the previous version.
+********************************************************************/
+func writeFileAtomic( path string, data []byte ) error {
+    temp, err := os.CreateTemp( filepath.Dir( path ), filepath.Base( path ) + ".*" )
+    if err != nil {
+        return err
+    }
+    defer os.Remove( temp.Name() )
+    if _, err := temp.Write( data ); err != nil {
+        temp.Close()
+        return err
+    }
+    if err := temp.Sync(); err != nil {
+        temp.Close()
+        return err
+    }
+    if err := temp.Close(); err != nil {
+        return err
+    }
+    if err := os.Rename( temp.Name(), path ); err != nil {
+        return err
+    }
+
+    // Sync the directory too, so the rename itself survives a crash
+    dir, err := os.Open( filepath.Dir( path ) )
+    if err != nil {
+        return err
+    }
+    defer dir.Close()
+    return dir.Sync()
+}
+
+/********************************************************************
+checkpointStats()
+    Saves the stats every StatsCheckpointInterval until the server
+    shuts down, Shutdown() saves them a last time.
+********************************************************************/
+func ( s *Server ) checkpointStats() {
+    interval := s.config.StatsCheckpointInterval
+    if interval <= 0 {
+        interval = statsCheckpointDefaultInterval
+    }
+    ticker := time.NewTicker( interval )
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+        case <-s.shutdownStarted:
+            return
+        }
+
+        if err := s.saveStats(); err != nil {
+            s.logError( "Unable to save stats: %v", err )
+        }
+    }
+}
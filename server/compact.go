@@ -0,0 +1,268 @@
+package server
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "os"
+    "strconv"
+    "time"
+)
+
+// Optionally implemented by a Store whose file keeps the space of
+// deleted records, rewriting it to give it back
+type CompactStore interface {
+    // Rewrites the store, returning its size in bytes before and after
+    Compact() ( before int64, after int64, err error )
+}
+
+// Size of a file before and after it was compacted
+type CompactStat struct {
+    BytesBefore int64 `json:"bytes_before"`
+    BytesAfter int64 `json:"bytes_after"`
+
+    // Entries dropped, for the write-ahead log
+    Dropped int `json:"dropped,omitempty"`
+}
+
+// Response to POST /admin/compact
+type CompactResult struct {
+    // Expired records deleted before compacting, not yet reaped
+    ExpiredRecords int64 `json:"expired_records"`
+
+    // The persistent store and the write-ahead log, when compacted
+    Store *CompactStat `json:"store,omitempty"`
+    WAL *CompactStat `json:"wal,omitempty"`
+
+    // Bytes given back by both
+    ReclaimedBytes int64 `json:"reclaimed_bytes"`
+    DurationUs int64 `json:"duration_us"`
+}
+
+/********************************************************************
+fileSize()
+    Returns the size of a file in bytes.
+********************************************************************/
+func fileSize( path string ) ( int64, error ) {
+    info, err := os.Stat( path )
+    if err != nil {
+        return 0, err
+    }
+    return info.Size(), nil
+}
+
+/********************************************************************
+compact()
+    Deletes the expired records the reaper hasn't yet, then rewrites
+    the store, if it is a CompactStore, and Config.WALFile without the
+    entries that are no longer needed. One compaction runs at a time.
+********************************************************************/
+func ( s *Server ) compact() ( *CompactResult, error ) {
+    s.compactMutex.Lock()
+    defer s.compactMutex.Unlock()
+    start := time.Now()
+    result := &CompactResult{}
+
+    s.mapMutex.Lock()
+    expired, err := s.reapExpiredRecords( s.clock.Now() )
+    s.mapMutex.Unlock()
+    if err != nil {
+        return nil, err
+    }
+    result.ExpiredRecords = expired
+
+    stat, err := s.compactStore()
+    if err != nil {
+        return nil, err
+    }
+    if stat != nil {
+        result.Store = stat
+        result.ReclaimedBytes += stat.BytesBefore - stat.BytesAfter
+    }
+
+    if s.config.WALFile != "" {
+        stat, err := s.compactWAL()
+        if err != nil {
+            return nil, err
+        }
+        result.WAL = stat
+        result.ReclaimedBytes += stat.BytesBefore - stat.BytesAfter
+    }
+    result.DurationUs = time.Since( start ).Microseconds()
+    return result, nil
+}
+
+/********************************************************************
+compactStore()
+    Rewrites the store if it is a CompactStore, returning nil if it
+    isn't. Must be called with compactMutex held.
+********************************************************************/
+func ( s *Server ) compactStore() ( *CompactStat, error ) {
+    compactable, ok := s.durableStore().( CompactStore )
+    if !ok {
+        return nil, nil
+    }
+    before, after, err := compactable.Compact()
+    if err != nil {
+        return nil, err
+    }
+    return &CompactStat{ BytesBefore: before, BytesAfter: after }, nil
+}
+
+/********************************************************************
+compactWAL()
+    Rewrites the write-ahead log keeping only what a replay needs:
+    submissions not completed yet and the last completion of each
+    record still stored. Records expired or evicted since leave a
+    marker, so their ids still answer 410 Gone after a replay, and so
+    does the last id, so ids aren't handed out again. The log is read
+    back from its file with walMutex held, so entries appended
+    meanwhile wait for the new file rather than being lost.
+********************************************************************/
+func ( s *Server ) compactWAL() ( *CompactStat, error ) {
+    s.walMutex.Lock()
+    defer s.walMutex.Unlock()
+    if s.walFile == nil {
+        return nil, errors.New( "the write-ahead log is closed" )
+    }
+
+    data, err := os.ReadFile( s.config.WALFile )
+    if err != nil {
+        return nil, err
+    }
+
+    // Find the last completion of each id
+    var entries []walEntry
+    var lastId int64
+    last := make(map[int64]int)
+    scanner := bufio.NewScanner( bytes.NewReader( data ) )
+    scanner.Buffer( nil, 1024 * 1024 )
+    for scanner.Scan() {
+        if len( scanner.Bytes() ) == 0 {
+            continue
+        }
+        var entry walEntry
+        if err := json.Unmarshal( scanner.Bytes(), &entry ); err != nil {
+            return nil, err
+        }
+        if entry.Op != walSubmit {
+            last[ entry.Id ] = len( entries )
+        }
+        if entry.Id > lastId {
+            lastId = entry.Id
+        }
+        entries = append( entries, entry )
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+
+    now := s.clock.Now()
+    var kept []walEntry
+    var keptLastId int64
+    for i, entry := range entries {
+        switch {
+        case entry.Op == walSubmit:
+            if _, done := last[ entry.Id ]; done {
+                continue
+            }
+        case last[ entry.Id ] != i:
+            continue
+        case entry.Op == walComplete:
+            if entry.Op, err = s.walRecordState( entry, now ); err != nil {
+                return nil, err
+            }
+            if entry.Op != walComplete {
+                entry = walEntry{ Op: entry.Op, Id: entry.Id }
+            }
+        }
+        if entry.Op == "" {
+            continue
+        }
+        kept = append( kept, entry )
+        if entry.Id > keptLastId {
+            keptLastId = entry.Id
+        }
+    }
+    if lastId > keptLastId {
+        kept = append( kept, walEntry{ Op: walSequence, Id: lastId } )
+    }
+
+    var compacted bytes.Buffer
+    for _, entry := range kept {
+        line, _ := json.Marshal( entry )
+        compacted.Write( append( line, '\n' ) )
+    }
+    if err := writeFileAtomic( s.config.WALFile, compacted.Bytes() ); err != nil {
+        return nil, err
+    }
+    file, err := os.OpenFile( s.config.WALFile, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0600 )
+    if err != nil {
+        return nil, err
+    }
+    s.walFile.Close()
+    s.walFile = file
+    return &CompactStat{ BytesBefore: int64( len( data ) ), BytesAfter: int64( compacted.Len() ), Dropped: len( entries ) - len( kept ) }, nil
+}
+
+/********************************************************************
+walRecordState()
+    Returns the entry replacing the completion of a record: the
+    completion while the record is stored, soft deleted or not, a
+    marker if it expired, was evicted or purged since, or none if it
+    was otherwise deleted.
+********************************************************************/
+func ( s *Server ) walRecordState( entry walEntry, now time.Time ) ( string, error ) {
+    record, err := s.decodeDurable( entry.Record )
+    if err != nil {
+        return "", err
+    }
+    if s.recordExpired( record, now ) {
+        return walExpired, nil
+    }
+
+    s.mapMutex.Lock()
+    defer s.mapMutex.Unlock()
+    switch {
+    case s.expiredIds.has( entry.Id ):
+        return walExpired, nil
+    case s.evictedIds.has( entry.Id ):
+        return walEvicted, nil
+    case s.purgedIds.has( entry.Id ):
+        return walPurged, nil
+    }
+    stored, err := s.getRecord( entry.Id )
+    if err != nil || stored == nil {
+        return "", err
+    }
+    return walComplete, nil
+}
+
+/********************************************************************
+handleCompact()
+    Handles POST requests on /admin/compact, rewriting the persistent
+    store and the write-ahead log without deleted and expired records
+    and reporting the space reclaimed.
+********************************************************************/
+func ( s *Server ) handleCompact( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /admin/compact" )
+
+    // Check shutdown
+    if s.shutDown {
+        s.log( r ).Info( "Server has been shut down!" )
+        writeError( w, http.StatusNotAcceptable, ErrorShuttingDown )
+        return
+    }
+
+    result, err := s.compact()
+    if err != nil {
+        s.writeStoreError( w, r, err )
+        return
+    }
+
+    s.auditRequest( r, AuditCompact, strconv.FormatInt( result.ReclaimedBytes, 10 ) + " bytes reclaimed" )
+    s.log( r ).Info( "Compacted storage!", "reclaimed_bytes", result.ReclaimedBytes, "expired_records", result.ExpiredRecords, "duration_us", result.DurationUs )
+    s.writeEncoded( w, r, http.StatusOK, result )
+}
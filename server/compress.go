@@ -0,0 +1,205 @@
+package server
+
+import (
+    "compress/gzip"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/klauspost/compress/zstd"
+)
+
+// Compressor of a response body, reset to each response's writer
+type compressor interface {
+    io.WriteCloser
+    Flush() error
+    Reset( w io.Writer )
+}
+
+var (
+    // Responses shorter than this are sent uncompressed
+    compressMinSize = 1024
+
+    // Content encodings by preference, each with a pool of compressors
+    compressEncodings = []struct {
+        name string
+        pool *sync.Pool
+    }{
+        { "zstd", &sync.Pool{ New: func() interface{} {
+            encoder, _ := zstd.NewWriter( nil, zstd.WithEncoderConcurrency( 1 ) )
+            return encoder
+        } } },
+        { "gzip", &sync.Pool{ New: func() interface{} { return gzip.NewWriter( nil ) } } },
+    }
+)
+
+/********************************************************************
+Compress()
+    Middleware compressing responses of at least compressMinSize
+    bytes with zstd or gzip, whichever the Accept-Encoding header
+    of the request prefers. WebSocket upgrades, images, gzip downloads
+    and responses that are already encoded pass through unchanged.
+********************************************************************/
+func Compress() Middleware {
+    return func( next http.Handler ) http.Handler {
+        return http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+            w.Header().Add( "Vary", "Accept-Encoding" )
+            name, pool := acceptedCompression( r )
+            if pool == nil || r.Header.Get( "Upgrade" ) != "" {
+                next.ServeHTTP( w, r )
+                return
+            }
+
+            // Not deferred, a panicking handler's response is left to
+            // the recovery middleware rather than finished as a 200
+            // Compressed responses have their own strong ETags, see start()
+            if ifNoneMatch := r.Header.Get( "If-None-Match" ); ifNoneMatch != "" {
+                r.Header.Set( "If-None-Match", strings.ReplaceAll( ifNoneMatch, "-" + name + `"`, `"` ) )
+            }
+
+            cw := &compressResponseWriter{ ResponseWriter: w, encoding: name, pool: pool, status: http.StatusOK }
+            next.ServeHTTP( cw, r )
+            cw.close()
+        } )
+    }
+}
+
+/********************************************************************
+acceptedCompression()
+    Returns the content encoding the Accept-Encoding header of a
+    request prefers, by q value and then compressEncodings order,
+    with its compressor pool, or nil if it accepts neither.
+********************************************************************/
+func acceptedCompression( r *http.Request ) ( string, *sync.Pool ) {
+    q := map[string]float64{}
+    for _, accepted := range strings.Split( r.Header.Get( "Accept-Encoding" ), "," ) {
+        name, params, _ := strings.Cut( strings.TrimSpace( accepted ), ";" )
+        weight := 1.0
+        if value, ok := strings.CutPrefix( strings.TrimSpace( params ), "q=" ); ok {
+            weight, _ = strconv.ParseFloat( value, 64 )
+        }
+        q[ strings.ToLower( name ) ] = weight
+    }
+
+    bestName, bestQ := "", 0.0
+    var bestPool *sync.Pool
+    for _, encoding := range compressEncodings {
+        weight, ok := q[ encoding.name ]
+        if !ok {
+            weight = q[ "*" ]
+        }
+        if weight > bestQ {
+            bestName, bestQ, bestPool = encoding.name, weight, encoding.pool
+        }
+    }
+    return bestName, bestPool
+}
+
+// Response writer buffering the start of a response until it is known
+// whether it is long enough to be worth compressing
+type compressResponseWriter struct {
+    http.ResponseWriter
+    encoding string
+    pool *sync.Pool
+    status int
+    buffer []byte
+    started bool
+    compressor compressor
+}
+
+func ( cw *compressResponseWriter ) WriteHeader( status int ) {
+    if cw.started {
+        return
+    }
+    cw.status = status
+
+    // Bodiless and informational responses are never compressed
+    if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+        cw.start( false )
+    }
+}
+
+func ( cw *compressResponseWriter ) Write( p []byte ) ( int, error ) {
+    if !cw.started {
+        cw.buffer = append( cw.buffer, p... )
+        if len( cw.buffer ) >= compressMinSize {
+            cw.start( true )
+        }
+        return len( p ), nil
+    }
+    if cw.compressor != nil {
+        return cw.compressor.Write( p )
+    }
+    return cw.ResponseWriter.Write( p )
+}
+
+/********************************************************************
+Flush()
+    Sends what has been written so far, uncompressed if the response
+    hasn't reached compressMinSize yet, so streams like /events get
+    each event right away.
+********************************************************************/
+func ( cw *compressResponseWriter ) Flush() {
+    if !cw.started {
+        cw.start( false )
+    }
+    if cw.compressor != nil {
+        cw.compressor.Flush()
+    }
+    if flusher, ok := cw.ResponseWriter.( http.Flusher ); ok {
+        flusher.Flush()
+    }
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func ( cw *compressResponseWriter ) Unwrap() http.ResponseWriter {
+    return cw.ResponseWriter
+}
+
+/********************************************************************
+start()
+    Writes the header and the buffered start of the response, and
+    sets up the compressor if compressing.
+********************************************************************/
+func ( cw *compressResponseWriter ) start( compress bool ) {
+    cw.started = true
+    header := cw.Header()
+    contentType := header.Get( "Content-Type" )
+    if compress && header.Get( "Content-Encoding" ) == "" && !strings.HasPrefix( contentType, "image/" ) && contentType != "application/gzip" {
+        header.Set( "Content-Encoding", cw.encoding )
+        header.Del( "Content-Length" )
+
+        // A strong ETag identifies the exact bytes, so the compressed
+        // response gets one of its own
+        if etag := header.Get( "ETag" ); strings.HasPrefix( etag, `"` ) {
+            header.Set( "ETag", strings.TrimSuffix( etag, `"` ) + "-" + cw.encoding + `"` )
+        }
+        cw.compressor = cw.pool.Get().( compressor )
+        cw.compressor.Reset( cw.ResponseWriter )
+    }
+
+    cw.ResponseWriter.WriteHeader( cw.status )
+    if len( cw.buffer ) > 0 {
+        buffer := cw.buffer
+        cw.buffer = nil
+        cw.Write( buffer )
+    }
+}
+
+/********************************************************************
+close()
+    Finishes the response once the handler has returned, returning
+    the compressor to its pool.
+********************************************************************/
+func ( cw *compressResponseWriter ) close() {
+    if !cw.started {
+        cw.start( false )
+    }
+    if cw.compressor != nil {
+        cw.compressor.Close()
+        cw.pool.Put( cw.compressor )
+        cw.compressor = nil
+    }
+}
@@ -0,0 +1,78 @@
+package server
+
+import "fmt"
+
+/********************************************************************
+allocateId()
+    Allocates the id for a newly submitted password. In deduplication
+    mode a password that was already submitted, and hasn't expired,
+    gets its existing id back, with deduplicated set to true. With a
+    SequenceStore the id is saved before it is handed out, and isn't
+    if it can't be, as it could be handed out again after a restart.
+********************************************************************/
+func ( s *Server ) allocateId( password string ) ( id int64, deduplicated bool, err error ) {
+    var digest string
+    if s.config.Deduplicate {
+        digest = hashPassword( password )
+    }
+
+    s.mapMutex.Lock()
+    defer s.mapMutex.Unlock()
+
+    if s.config.Deduplicate {
+        if existing, ok := s.digestIds[ digest ]; ok {
+            return existing, true, nil
+        }
+    }
+
+    s.lastId++
+    if sequence, ok := s.store.( SequenceStore ); ok {
+        if err := sequence.SetLastId( s.lastId ); err != nil {
+            s.lastId--
+            return 0, false, fmt.Errorf( "saving the last id: %w", err )
+        }
+    }
+    if s.config.Deduplicate {
+        s.digestIds[ digest ] = s.lastId
+    }
+    return s.lastId, false, nil
+}
+
+/********************************************************************
+recordDigest()
+    Returns the deduplication digest of a record. Records stored
+    before it was kept with them have none, unless hashed with SHA512,
+    whose hash is the digest.
+********************************************************************/
+func recordDigest( record *Record ) string {
+    if record.digest == "" && record.Algorithm == ( sha512Hasher{} ).Algorithm() {
+        return record.Hash
+    }
+    return record.digest
+}
+
+/********************************************************************
+rememberDigest()
+    Adds a record to the deduplication index, unless another id has
+    its digest. Must be called with mapMutex held.
+********************************************************************/
+func ( s *Server ) rememberDigest( record *Record ) {
+    digest := recordDigest( record )
+    if !s.config.Deduplicate || digest == "" {
+        return
+    }
+    if _, taken := s.digestIds[ digest ]; !taken {
+        s.digestIds[ digest ] = record.Id
+    }
+}
+
+/********************************************************************
+forgetDigest()
+    Drops an id from the deduplication index so its password can be
+    submitted again. Must be called with mapMutex held.
+********************************************************************/
+func ( s *Server ) forgetDigest( id int64, digest string ) {
+    if existing, ok := s.digestIds[ digest ]; ok && existing == id {
+        delete( s.digestIds, digest )
+    }
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "testing"
+    "time"
+)
+
+// Hasher whose hashes aren't the SHA512 deduplication digests
+type prefixHasher struct{}
+
+func ( prefixHasher ) Algorithm() string {
+    return "prefixed"
+}
+
+func ( prefixHasher ) Hash( password string ) string {
+    return "prefixed:" + hashPassword( password )
+}
+
+// Deleting a deduplicated record lets its password be submitted again,
+// whatever the hasher
+func TestDeleteForgetsDigest( t *testing.T ) {
+    config := DefaultConfig()
+    config.Deduplicate = true
+    config.AdminToken = "admin"
+    _, handler := newTestServer( t, 0, WithConfig( config ), WithHasher( prefixHasher{} ) )
+
+    id := postPassword( t, handler, "angryMonkey" )
+    waitHashed( t, handler, id, time.Second )
+    if again := postPassword( t, handler, "angryMonkey" ); again != id {
+        t.Fatalf( "resubmitted password: got id %d, want %d", again, id )
+    }
+
+    request := httptest.NewRequest( http.MethodDelete, "/v1/hash/" + strconv.FormatInt( id, 10 ), nil )
+    request.Header.Set( "Authorization", "Bearer admin" )
+    response := httptest.NewRecorder()
+    handler.ServeHTTP( response, request )
+    if response.Code != http.StatusOK && response.Code != http.StatusNoContent {
+        t.Fatalf( "DELETE /v1/hash/%d: %d %s", id, response.Code, response.Body )
+    }
+
+    if again := postPassword( t, handler, "angryMonkey" ); again == id {
+        t.Errorf( "password submitted after its record was deleted: got its old id %d", again )
+    }
+}
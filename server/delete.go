@@ -0,0 +1,311 @@
+package server
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+    "time"
+)
+
+// How long a DELETEd record is kept when Config.DeleteGracePeriod is 0
+const deleteDefaultGracePeriod = 30 * 24 * time.Hour
+
+// Response to DELETE /hash/{id}
+type DeleteResponse struct {
+    Id int64 `json:"id"`
+    DeletedAt time.Time `json:"deleted_at"`
+
+    // When the reaper purges the record, unless it is undeleted first
+    PurgeAt time.Time `json:"purge_at"`
+}
+
+// Response to POST /admin/purge
+type PurgeResponse struct {
+    Purged int `json:"purged"`
+    Ids []int64 `json:"ids"`
+}
+
+/********************************************************************
+deleteGracePeriod()
+    Returns Config.DeleteGracePeriod, deleteDefaultGracePeriod when 0.
+********************************************************************/
+func ( s *Server ) deleteGracePeriod() time.Duration {
+    if s.config.DeleteGracePeriod <= 0 {
+        return deleteDefaultGracePeriod
+    }
+    return s.config.DeleteGracePeriod
+}
+
+/********************************************************************
+setDeleted()
+    Stores a record marked as deleted at a time, or undeleted with a
+    nil time, returning the record stored. Must be called with
+    mapMutex held.
+********************************************************************/
+func ( s *Server ) setDeleted( record *Record, deletedAt *time.Time ) ( *Record, error ) {
+    updated := *record
+    updated.DeletedAt = deletedAt
+    updated.checksum = ""
+    if err := s.store.Put( &updated ); err != nil {
+        return nil, err
+    }
+    if deletedAt != nil {
+        s.deletedAt[ record.Id ] = *deletedAt
+        s.forgetDigest( record.Id, recordDigest( record ) )
+    } else {
+        delete( s.deletedAt, record.Id )
+        s.rememberDigest( record )
+    }
+    return &updated, nil
+}
+
+/********************************************************************
+dueDeletions()
+    Returns the ids of the soft deleted records whose grace period is
+    over by now. Must be called with mapMutex held.
+********************************************************************/
+func ( s *Server ) dueDeletions( now time.Time ) []int64 {
+    var ids []int64
+    for id, deletedAt := range s.deletedAt {
+        if !now.Before( deletedAt.Add( s.deleteGracePeriod() ) ) {
+            ids = append( ids, id )
+        }
+    }
+    return ids
+}
+
+/********************************************************************
+purgeRecords()
+    Removes soft deleted records from the store for good, returning
+    the ids purged. Ids that aren't soft deleted are left alone. Must
+    be called with mapMutex held, and logPurges() called once it is
+    released.
+********************************************************************/
+func ( s *Server ) purgeRecords( ids []int64 ) ( []int64, error ) {
+    var purged []int64
+    for _, id := range ids {
+        if _, deleted := s.deletedAt[ id ]; !deleted {
+            continue
+        }
+        if err := s.forgetPurged( id ); err != nil {
+            return purged, err
+        }
+        purged = append( purged, id )
+    }
+    return purged, nil
+}
+
+/********************************************************************
+forgetPurged()
+    Deletes a purged record from the store and remembers its id, so
+    GET still answers 410 Gone. Must be called with mapMutex held.
+********************************************************************/
+func ( s *Server ) forgetPurged( id int64 ) error {
+    if err := s.store.Delete( id ); err != nil {
+        return err
+    }
+    s.untrackRecord( id )
+    delete( s.expiresAt, id )
+    delete( s.deletedAt, id )
+    s.purgedIds.add( id )
+    return nil
+}
+
+/********************************************************************
+logPurges()
+    Appends a marker of each purged record to the write-ahead log, so
+    a replay doesn't bring it back. It is called with mapMutex
+    released, as compaction takes it with walMutex held.
+********************************************************************/
+func ( s *Server ) logPurges( ids []int64 ) {
+    if s.config.WALFile == "" {
+        return
+    }
+    for _, id := range ids {
+        if err := s.appendWAL( walEntry{ Op: walPurged, Id: id } ); err != nil {
+            s.logError( "%v", err )
+        }
+    }
+}
+
+/********************************************************************
+handleHashDelete()
+    Handles DELETE requests on /hash/{id}, marking the record as
+    deleted. It answers 410 Gone from then on, but is kept for
+    DeleteGracePeriod, when it can be undeleted, before the reaper
+    purges it.
+********************************************************************/
+func ( s *Server ) handleHashDelete( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /hash/{id} DELETE" )
+
+    // Check shutdown
+    if s.shutDown {
+        s.log( r ).Info( "Server has been shut down!" )
+        writeError( w, http.StatusNotAcceptable, ErrorShuttingDown )
+        return
+    }
+
+    // Lock the shutdown mutex to ensure the server doesn't
+    // shut down while processing this request
+    s.shutdownMutex.RLock()
+    defer s.shutdownMutex.RUnlock()
+
+    id := pathId( r )
+    record, job, _, gone, err := s.lookupHash( id )
+    switch {
+    case err != nil:
+        s.writeStoreError( w, r, err )
+        return
+    case gone == StatusDeleted:
+        writeError( w, http.StatusGone, ErrorDeleted )
+        return
+    case gone == StatusExpired:
+        writeError( w, http.StatusGone, ErrorExpired )
+        return
+    case gone == StatusEvicted:
+        writeError( w, http.StatusGone, ErrorEvicted )
+        return
+    case record == nil && job != nil:
+        s.log( r ).Info( "Passsword id pending, not deleted!" )
+        writeError( w, http.StatusConflict, ErrorPending )
+        return
+    case record == nil:
+        s.log( r ).Info( "Passsword id not found!" )
+        writeError( w, http.StatusNotFound, ErrorNotFound )
+        return
+    }
+
+    // Read the record again with mapMutex held, in case another
+    // request deleted it meanwhile
+    now := s.clock.Now()
+    var deleted *Record
+    s.mapMutex.Lock()
+    record, err = s.getRecord( id )
+    if err == nil && record != nil && record.DeletedAt == nil {
+        deleted, err = s.setDeleted( record, &now )
+    }
+    s.mapMutex.Unlock()
+    if err != nil {
+        s.writeStoreError( w, r, err )
+        return
+    }
+    if deleted == nil {
+        writeError( w, http.StatusGone, ErrorDeleted )
+        return
+    }
+    if s.config.WALFile != "" {
+        s.logCompletion( deleted )
+    }
+
+    s.auditRequest( r, AuditDelete, "id " + strconv.FormatInt( id, 10 ) )
+    s.log( r ).Info( "Deleted password id!", "id", id )
+    s.writeEncoded( w, r, http.StatusOK, DeleteResponse{ Id: id, DeletedAt: now, PurgeAt: now.Add( s.deleteGracePeriod() ) } )
+}
+
+/********************************************************************
+handleUndelete()
+    Handles POST requests on /admin/hash/{id}/undelete, bringing back
+    a record deleted within its grace period.
+********************************************************************/
+func ( s *Server ) handleUndelete( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /admin/hash/{id}/undelete" )
+
+    // Check shutdown
+    if s.shutDown {
+        s.log( r ).Info( "Server has been shut down!" )
+        writeError( w, http.StatusNotAcceptable, ErrorShuttingDown )
+        return
+    }
+
+    id := pathId( r )
+    s.mapMutex.Lock()
+    purged := s.purgedIds.has( id )
+    record, err := s.getRecord( id )
+    var undeleted *Record
+    if err == nil && record != nil && record.DeletedAt != nil {
+        undeleted, err = s.setDeleted( record, nil )
+    }
+    s.mapMutex.Unlock()
+    switch {
+    case err != nil:
+        s.writeStoreError( w, r, err )
+        return
+    case purged:
+        s.log( r ).Info( "Passsword id already purged!" )
+        writeError( w, http.StatusGone, ErrorDeleted )
+        return
+    case record == nil:
+        s.log( r ).Info( "Passsword id not found!" )
+        writeError( w, http.StatusNotFound, ErrorNotFound )
+        return
+    case undeleted == nil:
+        s.log( r ).Info( "Passsword id isn't deleted!" )
+        writeError( w, http.StatusConflict, ErrorNotDeleted )
+        return
+    }
+    if s.config.WALFile != "" {
+        s.logCompletion( undeleted )
+    }
+
+    s.auditRequest( r, AuditUndelete, "id " + strconv.FormatInt( id, 10 ) )
+    s.log( r ).Info( "Undeleted password id!", "id", id )
+    s.writeEncoded( w, r, http.StatusOK, adminRecord{ Record: undeleted, Provenance: undeleted.provenance } )
+}
+
+/********************************************************************
+handlePurge()
+    Handles POST requests on /admin/purge, removing soft deleted
+    records for good without waiting for their grace period: those
+    listed in "ids", each of which must be deleted, or all of them.
+********************************************************************/
+func ( s *Server ) handlePurge( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /admin/purge" )
+
+    // Check shutdown
+    if s.shutDown {
+        s.log( r ).Info( "Server has been shut down!" )
+        writeError( w, http.StatusNotAcceptable, ErrorShuttingDown )
+        return
+    }
+
+    var ids []int64
+    if value := r.FormValue( "ids" ); value != "" {
+        var err error
+        if ids, err = parseIds( value ); err != nil {
+            s.log( r ).Info( "Invalid ids", "error", err )
+            writeFieldErrors( w, invalidField( "ids", err.Error() ) )
+            return
+        }
+    }
+
+    s.mapMutex.Lock()
+    if ids == nil {
+        for id := range s.deletedAt {
+            ids = append( ids, id )
+        }
+    }
+    for _, id := range ids {
+        if _, deleted := s.deletedAt[ id ]; !deleted {
+            s.mapMutex.Unlock()
+            s.log( r ).Info( "Purging a password id that isn't deleted", "id", id )
+            writeFieldErrors( w, FieldError{ Field: "ids", Code: ErrorNotDeleted, Message: fmt.Sprintf( "id %d isn't deleted", id ) } )
+            return
+        }
+    }
+    purged, err := s.purgeRecords( ids )
+    s.mapMutex.Unlock()
+    s.logPurges( purged )
+    if err != nil {
+        s.writeStoreError( w, r, err )
+        return
+    }
+
+    s.auditRequest( r, AuditPurge, strconv.Itoa( len( purged ) ) + " records" )
+    s.log( r ).Info( "Purged deleted records!", "purged", len( purged ) )
+    if purged == nil {
+        purged = []int64{}
+    }
+    sort.Slice( purged, func( i, j int ) bool { return purged[ i ] < purged[ j ] } )
+    s.writeEncoded( w, r, http.StatusOK, PurgeResponse{ Purged: len( purged ), Ids: purged } )
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// Deprecation of a route, announced in the Deprecation, Sunset and
+// Link headers of its responses
+type deprecation struct {
+    // When the route was deprecated
+    since time.Time
+
+    // When the route will be removed, Config.Sunset when zero
+    sunset time.Time
+
+    // Path of the replacement, the request path under apiVersion
+    // when empty
+    successor string
+}
+
+var (
+    // When the unversioned aliases were deprecated in favour of /v1
+    aliasesDeprecated = time.Date( 2026, time.October, 14, 0, 0, 0, 0, time.UTC )
+
+    // Deprecated routes by pattern. Every unversioned alias must be
+    // listed. With -legacy-api the aliases of /hash and /stats also
+    // carry the original response formats, so these headers are the
+    // migration signal for those too
+    deprecations = map[string]deprecation{
+        "POST /hash": { since: aliasesDeprecated },
+        "GET /hash": { since: aliasesDeprecated },
+        "GET /hash/{id}": { since: aliasesDeprecated },
+        "GET /hash/{id}/status": { since: aliasesDeprecated },
+        "GET /hash/watch": { since: aliasesDeprecated },
+        "GET /hash/find": { since: aliasesDeprecated },
+        "GET /hashes": { since: aliasesDeprecated },
+        "GET /stats": { since: aliasesDeprecated },
+        "GET /events": { since: aliasesDeprecated },
+        "GET /ws": { since: aliasesDeprecated },
+        "POST /graphql": { since: aliasesDeprecated },
+        "GET /shutdown": { since: aliasesDeprecated },
+        "POST /admin/pause": { since: aliasesDeprecated },
+        "POST /admin/resume": { since: aliasesDeprecated },
+        "GET /admin/hash/{id}": { since: aliasesDeprecated },
+        "POST /admin/signed-url": { since: aliasesDeprecated },
+        "GET /admin/webhooks/dead-letters": { since: aliasesDeprecated },
+        "POST /admin/shutdown-token": { since: aliasesDeprecated },
+        "POST /admin/keys/rotate": { since: aliasesDeprecated },
+        "GET /admin/diagnostics": { since: aliasesDeprecated },
+    }
+)
+
+/********************************************************************
+withDeprecation()
+    Middleware adding the headers of the deprecation table entry of a
+    route pattern to its responses: Deprecation (RFC 9745) with when
+    it was deprecated, Sunset (RFC 8594) with when it will be removed,
+    if scheduled, and a successor-version Link to its replacement.
+********************************************************************/
+func ( s *Server ) withDeprecation( pattern string, next http.HandlerFunc ) http.HandlerFunc {
+    deprecated, ok := deprecations[ pattern ]
+    if !ok {
+        panic( "no deprecation table entry for " + pattern )
+    }
+    sunset := deprecated.sunset
+    if sunset.IsZero() {
+        sunset = s.config.Sunset
+    }
+
+    return func( w http.ResponseWriter, r *http.Request ) {
+        successor := deprecated.successor
+        if successor == "" {
+            successor = apiVersion + r.URL.Path
+        }
+
+        w.Header().Set( "Deprecation", "@" + strconv.FormatInt( deprecated.since.Unix(), 10 ) )
+        if !sunset.IsZero() {
+            w.Header().Set( "Sunset", sunset.UTC().Format( http.TimeFormat ) )
+        }
+        w.Header().Add( "Link", "<" + mountPrefix( r ) + successor + `>; rel="successor-version"` )
+        next( w, r )
+    }
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+    "fmt"
+    "log/slog"
+    "net/http"
+    "runtime"
+    "runtime/debug"
+    "time"
+)
+
+// Error kept for /admin/diagnostics
+type RecentError struct {
+    Time time.Time `json:"time"`
+    Message string `json:"message"`
+}
+
+// Response to GET /admin/diagnostics
+type Diagnostics struct {
+    GeneratedAt time.Time `json:"generated_at"`
+    Build BuildInfo `json:"build"`
+    Config ConfigSummary `json:"config"`
+    Health map[string]string `json:"health"`
+    Queue QueueStats `json:"queue"`
+    Webhooks WebhookStat `json:"webhooks"`
+    RecentErrors []RecentError `json:"recent_errors"`
+}
+
+// Build information of the running binary
+type BuildInfo struct {
+    GoVersion string `json:"go_version"`
+    Module string `json:"module,omitempty"`
+    Version string `json:"version,omitempty"`
+    Revision string `json:"revision,omitempty"`
+    BuildTime string `json:"build_time,omitempty"`
+}
+
+// Effective configuration, secrets are only reported as set or not
+type ConfigSummary struct {
+    Delay string `json:"delay"`
+    Deduplicate bool `json:"deduplicate"`
+    IdempotencyWindow string `json:"idempotency_window"`
+    MaxPendingJobs int `json:"max_pending_jobs"`
+    SoftLimitRatio float64 `json:"soft_limit_ratio"`
+    AdminTokenSet bool `json:"admin_token_set"`
+    URLSigningKeySet bool `json:"url_signing_key_set"`
+}
+
+// Outstanding and completed work
+type QueueStats struct {
+    Queued int `json:"queued"`
+    Processing int `json:"processing"`
+    Hashed int64 `json:"hashed"`
+    Stored int `json:"stored"`
+    Expired int64 `json:"expired"`
+    EventSubscribers int `json:"event_subscribers"`
+    Goroutines int `json:"goroutines"`
+}
+
+var (
+    // Most recent errors kept
+    recentErrorsMax = 50
+)
+
+/********************************************************************
+logError()
+    Prints an error and keeps it in the recent errors reported by
+    /admin/diagnostics.
+********************************************************************/
+func ( s *Server ) logError( format string, args ...interface{} ) {
+    s.logErrorTo( s.logger, format, args... )
+}
+
+/********************************************************************
+logErrorTo()
+    Prints an error with a request's logger, like logError().
+********************************************************************/
+func ( s *Server ) logErrorTo( logger *slog.Logger, format string, args ...interface{} ) {
+    message := fmt.Sprintf( format, args... )
+    logger.Error( message )
+
+    s.recentErrorsMutex.Lock()
+    defer s.recentErrorsMutex.Unlock()
+    s.recentErrors = append( s.recentErrors, RecentError{ Time: s.clock.Now(), Message: message } )
+    if len( s.recentErrors ) > recentErrorsMax {
+        s.recentErrors = s.recentErrors[ len( s.recentErrors ) - recentErrorsMax: ]
+    }
+}
+
+/********************************************************************
+ReadBuildInfo()
+    Returns the Go version, module version and VCS details embedded
+    in the binary.
+********************************************************************/
+func ReadBuildInfo() BuildInfo {
+    info := BuildInfo{ GoVersion: runtime.Version() }
+
+    build, ok := debug.ReadBuildInfo()
+    if !ok {
+        return info
+    }
+    info.Module = build.Main.Path
+    info.Version = build.Main.Version
+    for _, setting := range build.Settings {
+        switch setting.Key {
+        case "vcs.revision":
+            info.Revision = setting.Value
+        case "vcs.time":
+            info.BuildTime = setting.Value
+        }
+    }
+    return info
+}
+
+/********************************************************************
+handleDiagnostics()
+    Handles GET requests on /admin/diagnostics, returning a single
+    bundle of build info, configuration, subsystem health, queue
+    stats and recent errors to attach to incident tickets.
+********************************************************************/
+func ( s *Server ) handleDiagnostics( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /admin/diagnostics" )
+
+    diagnostics := Diagnostics{
+        GeneratedAt: s.clock.Now(),
+        Build: ReadBuildInfo(),
+        Config: ConfigSummary{
+            Delay: s.delay.String(),
+            Deduplicate: s.config.Deduplicate,
+            IdempotencyWindow: s.config.IdempotencyWindow.String(),
+            MaxPendingJobs: s.config.MaxPendingJobs,
+            SoftLimitRatio: s.config.SoftLimitRatio,
+            AdminTokenSet: s.config.AdminToken != "",
+            URLSigningKeySet: len( s.config.URLSigningKey ) > 0,
+        },
+        Health: map[string]string{ "server": "ok", "processing": "ok", "webhooks": "ok" },
+    }
+
+    if s.shutDown {
+        diagnostics.Health[ "server" ] = "shutting down"
+    }
+    if s.isPaused() {
+        diagnostics.Health[ "processing" ] = "paused"
+    }
+
+    s.mapMutex.Lock()
+    diagnostics.Queue.Queued, diagnostics.Queue.Processing = s.jobStates()
+    diagnostics.Queue.Hashed = s.hashedCount
+    stored, err := s.store.Count()
+    diagnostics.Queue.Stored = stored
+    diagnostics.Queue.Expired = s.expiredCount
+    s.mapMutex.Unlock()
+
+    if err != nil {
+        diagnostics.Health[ "storage" ] = err.Error()
+    }
+
+    s.eventMutex.Lock()
+    diagnostics.Queue.EventSubscribers = len( s.eventSubscribers )
+    s.eventMutex.Unlock()
+    diagnostics.Queue.Goroutines = runtime.NumGoroutine()
+
+    s.webhookMutex.Lock()
+    diagnostics.Webhooks = s.webhookStats
+    if len( s.webhookDeadLetters ) > 0 {
+        diagnostics.Health[ "webhooks" ] = fmt.Sprintf( "%d dead letters", len( s.webhookDeadLetters ) )
+    }
+    s.webhookMutex.Unlock()
+
+    s.recentErrorsMutex.Lock()
+    diagnostics.RecentErrors = append( []RecentError{}, s.recentErrors... )
+    s.recentErrorsMutex.Unlock()
+
+    s.writeEncoded( w, r, http.StatusOK, diagnostics )
+}
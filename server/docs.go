@@ -0,0 +1,48 @@
+package server
+
+import (
+    "fmt"
+    "net/http"
+
+    swaggerFiles "github.com/swaggo/files/v2"
+)
+
+// Swagger UI configuration, loading the spec from /openapi.json relative
+// to /docs/ so it is found under a mount prefix too
+const docsInitializer = `window.onload = function() {
+  window.ui = SwaggerUIBundle({
+    url: "../openapi.json",
+    dom_id: '#swagger-ui',
+    deepLinking: true,
+    presets: [
+      SwaggerUIBundle.presets.apis,
+      SwaggerUIStandalonePreset
+    ],
+    layout: "StandaloneLayout"
+  });
+};
+`
+
+var (
+    // Embedded Swagger UI assets
+    docsFiles = http.StripPrefix( "/docs/", http.FileServer( http.FS( swaggerFiles.FS ) ) )
+)
+
+/********************************************************************
+handleDocs()
+    Handles GET requests on /docs/, serving the embedded Swagger UI
+    for exercising the API described by /openapi.json from a browser.
+********************************************************************/
+func ( s *Server ) handleDocs( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /docs" )
+
+    switch r.URL.Path {
+    case "/docs":
+        http.Redirect( w, r, mountPrefix( r ) + "/docs/", http.StatusMovedPermanently )
+    case "/docs/swagger-initializer.js":
+        w.Header().Set( "Content-Type", "application/javascript" )
+        fmt.Fprint( w, docsInitializer )
+    default:
+        docsFiles.ServeHTTP( w, r )
+    }
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+    "context"
+    "sort"
+    "strconv"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    "github.com/aws/aws-sdk-go-v2/config"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// How long a DynamoDB request may take
+const dynamoTimeout = 5 * time.Second
+
+// Id of the item holding the last allocated id, which is never
+// allocated to a password
+const dynamoSequenceId = 0
+
+// Store keeping the records in a DynamoDB table with a numeric "id"
+// partition key, each item holding a record as JSON in "record". The
+// last allocated id is kept in the item with id 0. Created by
+// NewDynamoDBStore()
+type DynamoDBStore struct {
+    client *dynamodb.Client
+    table string
+}
+
+/********************************************************************
+NewDynamoDBStore()
+    Creates a store for an existing DynamoDB table, with credentials
+    from the standard AWS chain: environment, shared config files,
+    then the container or instance role. The region is taken from
+    AWS_REGION when empty, and endpoint overrides the DynamoDB
+    endpoint, e.g. for DynamoDB Local, when not empty.
+********************************************************************/
+func NewDynamoDBStore( ctx context.Context, table string, region string, endpoint string ) ( *DynamoDBStore, error ) {
+    awsConfig, err := config.LoadDefaultConfig( ctx, config.WithRegion( region ) )
+    if err != nil {
+        return nil, err
+    }
+    client := dynamodb.NewFromConfig( awsConfig, func( options *dynamodb.Options ) {
+        if endpoint != "" {
+            options.BaseEndpoint = aws.String( endpoint )
+        }
+    } )
+
+    // Fail now rather than on the first request if the table can't be reached
+    store := &DynamoDBStore{ client: client, table: table }
+    if err := store.Ping(); err != nil {
+        return nil, err
+    }
+    return store, nil
+}
+
+/********************************************************************
+Ping()
+    Checks the table can be reached, without scanning it like
+    Count() does.
+********************************************************************/
+func ( d *DynamoDBStore ) Ping() error {
+    ctx, cancel := context.WithTimeout( context.Background(), dynamoTimeout )
+    defer cancel()
+    _, err := d.client.DescribeTable( ctx, &dynamodb.DescribeTableInput{ TableName: aws.String( d.table ) } )
+    return err
+}
+
+/********************************************************************
+dynamoKey()
+    Returns the key of the item of an id.
+********************************************************************/
+func dynamoKey( id int64 ) map[string]types.AttributeValue {
+    return map[string]types.AttributeValue{ "id": &types.AttributeValueMemberN{ Value: strconv.FormatInt( id, 10 ) } }
+}
+
+/********************************************************************
+dynamoRecord()
+    Decodes the record of an item.
+********************************************************************/
+func dynamoRecord( item map[string]types.AttributeValue ) ( *Record, error ) {
+    data, _ := item[ "record" ].( *types.AttributeValueMemberS )
+    if data == nil {
+        return nil, ErrRecordNotFound
+    }
+    return decodeRecord( []byte( data.Value ) )
+}
+
+func ( d *DynamoDBStore ) Put( record *Record ) error {
+    data, err := encodeRecord( record )
+    if err != nil {
+        return err
+    }
+    item := dynamoKey( record.Id )
+    item[ "record" ] = &types.AttributeValueMemberS{ Value: string( data ) }
+
+    ctx, cancel := context.WithTimeout( context.Background(), dynamoTimeout )
+    defer cancel()
+    _, err = d.client.PutItem( ctx, &dynamodb.PutItemInput{ TableName: aws.String( d.table ), Item: item } )
+    return err
+}
+
+func ( d *DynamoDBStore ) Get( id int64 ) ( *Record, error ) {
+    ctx, cancel := context.WithTimeout( context.Background(), dynamoTimeout )
+    defer cancel()
+    output, err := d.client.GetItem( ctx, &dynamodb.GetItemInput{
+        TableName: aws.String( d.table ),
+        Key: dynamoKey( id ),
+        ConsistentRead: aws.Bool( true ),
+    } )
+    if err != nil {
+        return nil, err
+    }
+    if id == dynamoSequenceId || output.Item == nil {
+        return nil, ErrRecordNotFound
+    }
+    return dynamoRecord( output.Item )
+}
+
+func ( d *DynamoDBStore ) Delete( id int64 ) error {
+    ctx, cancel := context.WithTimeout( context.Background(), dynamoTimeout )
+    defer cancel()
+    _, err := d.client.DeleteItem( ctx, &dynamodb.DeleteItemInput{ TableName: aws.String( d.table ), Key: dynamoKey( id ) } )
+    return err
+}
+
+/********************************************************************
+scan()
+    Scans the records of the table page by page, skipping the item of
+    the last allocated id, with "select" set to COUNT to only count
+    them.
+********************************************************************/
+func ( d *DynamoDBStore ) scan( selected types.Select, page func( output *dynamodb.ScanOutput ) error ) error {
+    paginator := dynamodb.NewScanPaginator( d.client, &dynamodb.ScanInput{
+        TableName: aws.String( d.table ),
+        Select: selected,
+        FilterExpression: aws.String( "id <> :sequence" ),
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":sequence": &types.AttributeValueMemberN{ Value: strconv.Itoa( dynamoSequenceId ) },
+        },
+        ConsistentRead: aws.Bool( true ),
+    } )
+    for paginator.HasMorePages() {
+        ctx, cancel := context.WithTimeout( context.Background(), dynamoTimeout )
+        output, err := paginator.NextPage( ctx )
+        cancel()
+        if err != nil {
+            return err
+        }
+        if err := page( output ); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func ( d *DynamoDBStore ) List() ( []*Record, error ) {
+    records := []*Record{}
+    err := d.scan( types.SelectAllAttributes, func( output *dynamodb.ScanOutput ) error {
+        for _, item := range output.Items {
+            record, err := dynamoRecord( item )
+            if err != nil {
+                return err
+            }
+            records = append( records, record )
+        }
+        return nil
+    } )
+    if err != nil {
+        return nil, err
+    }
+
+    // Scans return items in hash order
+    sort.Slice( records, func( i, j int ) bool { return records[ i ].Id < records[ j ].Id } )
+    return records, nil
+}
+
+func ( d *DynamoDBStore ) Count() ( int, error ) {
+    count := 0
+    err := d.scan( types.SelectCount, func( output *dynamodb.ScanOutput ) error {
+        count += int( output.Count )
+        return nil
+    } )
+    return count, err
+}
+
+func ( d *DynamoDBStore ) LastId() ( int64, error ) {
+    ctx, cancel := context.WithTimeout( context.Background(), dynamoTimeout )
+    defer cancel()
+    output, err := d.client.GetItem( ctx, &dynamodb.GetItemInput{
+        TableName: aws.String( d.table ),
+        Key: dynamoKey( dynamoSequenceId ),
+        ConsistentRead: aws.Bool( true ),
+    } )
+    if err != nil {
+        return 0, err
+    }
+    lastId, _ := output.Item[ "last_id" ].( *types.AttributeValueMemberN )
+    if lastId == nil {
+        return 0, nil
+    }
+    return strconv.ParseInt( lastId.Value, 10, 64 )
+}
+
+func ( d *DynamoDBStore ) SetLastId( id int64 ) error {
+    item := dynamoKey( dynamoSequenceId )
+    item[ "last_id" ] = &types.AttributeValueMemberN{ Value: strconv.FormatInt( id, 10 ) }
+
+    ctx, cancel := context.WithTimeout( context.Background(), dynamoTimeout )
+    defer cancel()
+    _, err := d.client.PutItem( ctx, &dynamodb.PutItemInput{ TableName: aws.String( d.table ), Item: item } )
+    return err
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+    "bytes"
+    "encoding/json"
+    "mime"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/fxamacker/cbor/v2"
+    "github.com/vmihailenco/msgpack/v5"
+)
+
+// Response body encoding, chosen by the Accept header
+type encoding struct {
+    name string
+
+    // Media types the encoding is requested by, the first is sent
+    mediaTypes []string
+    marshal func( value interface{} ) ( []byte, error )
+}
+
+var (
+    // CBOR encodes times as RFC 3339 strings, like JSON
+    cborMode, _ = cbor.EncOptions{ Time: cbor.TimeRFC3339Nano }.EncMode()
+
+    // Supported encodings, JSON is the default
+    encodings = []*encoding{
+        { name: "json", mediaTypes: []string{ "application/json" }, marshal: marshalJSON },
+        { name: "msgpack", mediaTypes: []string{ "application/msgpack", "application/x-msgpack", "application/vnd.msgpack" }, marshal: marshalMsgpack },
+        { name: "cbor", mediaTypes: []string{ "application/cbor" }, marshal: cborMode.Marshal },
+    }
+)
+
+/********************************************************************
+marshalJSON()
+    Encodes a value as JSON, followed by a newline like
+    json.Encoder does.
+********************************************************************/
+func marshalJSON( value interface{} ) ( []byte, error ) {
+    var body bytes.Buffer
+    err := json.NewEncoder( &body ).Encode( value )
+    return body.Bytes(), err
+}
+
+/********************************************************************
+marshalMsgpack()
+    Encodes a value as MessagePack, with the field names of its JSON
+    encoding.
+********************************************************************/
+func marshalMsgpack( value interface{} ) ( []byte, error ) {
+    var body bytes.Buffer
+    encoder := msgpack.NewEncoder( &body )
+    encoder.SetCustomStructTag( "json" )
+    err := encoder.Encode( value )
+    return body.Bytes(), err
+}
+
+/********************************************************************
+acceptedEncoding()
+    Returns the supported encoding the Accept header of a request
+    prefers, by q value and then order, or nil if it doesn't name any.
+    Wildcards don't count, they get the default.
+********************************************************************/
+func acceptedEncoding( r *http.Request ) *encoding {
+    var best *encoding
+    bestQ := 0.0
+    for _, accepted := range strings.Split( r.Header.Get( "Accept" ), "," ) {
+        mediaType, params, err := mime.ParseMediaType( accepted )
+        if err != nil {
+            continue
+        }
+        q := 1.0
+        if value, ok := params[ "q" ]; ok {
+            q, _ = strconv.ParseFloat( value, 64 )
+        }
+
+        for _, candidate := range encodings {
+            for _, candidateType := range candidate.mediaTypes {
+                if mediaType == candidateType && q > bestQ {
+                    best, bestQ = candidate, q
+                }
+            }
+        }
+    }
+    return best
+}
+
+/********************************************************************
+writeEncoded()
+    Writes a response with the status and the value encoded as the
+    client asked for in its Accept header, JSON by default.
+********************************************************************/
+func ( s *Server ) writeEncoded( w http.ResponseWriter, r *http.Request, status int, value interface{} ) {
+    encoding := acceptedEncoding( r )
+    if encoding == nil {
+        encoding = encodings[ 0 ]
+    }
+
+    body, err := encoding.marshal( value )
+    if err != nil {
+        s.logError( "Unable to encode response: %v", err )
+        writeError( w, http.StatusInternalServerError, ErrorInternal )
+        return
+    }
+
+    w.Header().Set( "Content-Type", encoding.mediaTypes[ 0 ] )
+    w.Header().Add( "Vary", "Accept" )
+    w.WriteHeader( status )
+    w.Write( body )
+}
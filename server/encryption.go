@@ -0,0 +1,336 @@
+package server
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "fmt"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// Algorithm of a record sealed by recordCipher, whose hash holds the
+// encrypted record rather than a digest
+const EncryptedAlgorithm = "aes-256-gcm"
+
+// Version prefix of a sealed value, bumped with its format
+const sealedVersion = "v1"
+
+// HKDF info the data key is derived from the master key with
+const dataKeyInfo = "hashsvc record encryption " + sealedVersion
+
+// Encrypts records with AES-256-GCM under a data key derived from
+// Config.EncryptionKey, or the master key of a KeySource, binding each
+// to its id so a sealed record can't be moved to another one. Records
+// are sealed with the current key, and opened with whichever key
+// sealed them, so the keys of a rotated master key are kept
+type recordCipher struct {
+    mutex sync.RWMutex
+
+    // Data keys by a short fingerprint, telling apart the records
+    // sealed with another key from those altered
+    keys map[string]cipher.AEAD
+    keyId string
+}
+
+/********************************************************************
+deriveKey()
+    Derives a 32 byte key from a secret with HKDF-SHA256, without a
+    salt.
+********************************************************************/
+func deriveKey( secret []byte, info string ) []byte {
+    extract := hmac.New( sha256.New, make([]byte, sha256.Size) )
+    extract.Write( secret )
+    expand := hmac.New( sha256.New, extract.Sum( nil ) )
+    expand.Write( []byte( info ) )
+    expand.Write( []byte{ 1 } )
+    return expand.Sum( nil )
+}
+
+/********************************************************************
+newRecordCipher()
+    Returns the cipher of the data key derived from a 32 byte master
+    key.
+********************************************************************/
+func newRecordCipher( masterKey []byte ) ( *recordCipher, error ) {
+    c := &recordCipher{ keys: make(map[string]cipher.AEAD) }
+    if _, err := c.useKey( masterKey ); err != nil {
+        return nil, err
+    }
+    return c, nil
+}
+
+/********************************************************************
+loadKey()
+    Adds the data key derived from a 32 byte master key to those
+    records are opened with, returning its id.
+********************************************************************/
+func ( c *recordCipher ) loadKey( masterKey []byte ) ( string, error ) {
+    if len( masterKey ) != 32 {
+        return "", fmt.Errorf( "encryption key must be 32 bytes, not %d", len( masterKey ) )
+    }
+    dataKey := deriveKey( masterKey, dataKeyInfo )
+    fingerprint := sha256.Sum256( dataKey )
+    keyId := hex.EncodeToString( fingerprint[ :4 ] )
+
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    if c.keys[ keyId ] == nil {
+        block, err := aes.NewCipher( dataKey )
+        if err != nil {
+            return "", err
+        }
+        aead, err := cipher.NewGCM( block )
+        if err != nil {
+            return "", err
+        }
+        c.keys[ keyId ] = aead
+    }
+    return keyId, nil
+}
+
+/********************************************************************
+useKey()
+    Seals from now on with the data key derived from a 32 byte master
+    key, still opening the records sealed with the previous ones.
+    Returns whether the key changed.
+********************************************************************/
+func ( c *recordCipher ) useKey( masterKey []byte ) ( bool, error ) {
+    keyId, err := c.loadKey( masterKey )
+    if err != nil {
+        return false, err
+    }
+
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    changed := keyId != c.keyId
+    c.keyId = keyId
+    return changed, nil
+}
+
+/********************************************************************
+currentKeyId()
+    Returns the fingerprint of the key records are sealed with.
+********************************************************************/
+func ( c *recordCipher ) currentKeyId() string {
+    c.mutex.RLock()
+    defer c.mutex.RUnlock()
+    return c.keyId
+}
+
+/********************************************************************
+seal()
+    Encrypts a value of an id, returning the version, key id and the
+    base64 of the random nonce followed by the ciphertext.
+********************************************************************/
+func ( c *recordCipher ) seal( id int64, plaintext []byte ) ( string, error ) {
+    c.mutex.RLock()
+    keyId, aead := c.keyId, c.keys[ c.keyId ]
+    c.mutex.RUnlock()
+
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := rand.Read( nonce ); err != nil {
+        return "", err
+    }
+    sealed := aead.Seal( nonce, nonce, plaintext, []byte( strconv.FormatInt( id, 10 ) ) )
+    return sealedVersion + "." + keyId + "." + base64.RawURLEncoding.EncodeToString( sealed ), nil
+}
+
+/********************************************************************
+open()
+    Decrypts a value sealed by seal() for the same id, failing if it
+    was sealed with a key that isn't loaded, for another id or
+    altered.
+********************************************************************/
+func ( c *recordCipher ) open( id int64, value string ) ( []byte, error ) {
+    parts := strings.Split( value, "." )
+    if len( parts ) != 3 || parts[ 0 ] != sealedVersion {
+        return nil, fmt.Errorf( "record %d: not a sealed %s value", id, sealedVersion )
+    }
+    c.mutex.RLock()
+    aead, keyId := c.keys[ parts[ 1 ] ], c.keyId
+    c.mutex.RUnlock()
+    if aead == nil {
+        return nil, fmt.Errorf( "record %d was encrypted with key %s, not %s", id, parts[ 1 ], keyId )
+    }
+    sealed, err := base64.RawURLEncoding.DecodeString( parts[ 2 ] )
+    if err != nil || len( sealed ) < aead.NonceSize() {
+        return nil, fmt.Errorf( "record %d: truncated sealed value", id )
+    }
+    nonce, ciphertext := sealed[ :aead.NonceSize() ], sealed[ aead.NonceSize(): ]
+    plaintext, err := aead.Open( nil, nonce, ciphertext, []byte( strconv.FormatInt( id, 10 ) ) )
+    if err != nil {
+        return nil, fmt.Errorf( "record %d: decrypting: %w", id, err )
+    }
+    return plaintext, nil
+}
+
+/********************************************************************
+sealRecord()
+    Returns the record persisted in place of a record: its id, and as
+    its hash the whole record with its provenance, encrypted.
+********************************************************************/
+func ( c *recordCipher ) sealRecord( record *Record ) ( *Record, error ) {
+    data, err := encodeRecord( record )
+    if err != nil {
+        return nil, err
+    }
+    sealed, err := c.seal( record.Id, data )
+    if err != nil {
+        return nil, err
+    }
+    return &Record{ Id: record.Id, Hash: sealed, Algorithm: EncryptedAlgorithm }, nil
+}
+
+/********************************************************************
+openRecord()
+    Returns the record sealed by sealRecord(). Records persisted
+    before encryption was enabled are returned as they are, and
+    sealed again the next time they are stored.
+********************************************************************/
+func ( c *recordCipher ) openRecord( record *Record ) ( *Record, error ) {
+    if record.Algorithm != EncryptedAlgorithm {
+        return record, nil
+    }
+    data, err := c.open( record.Id, record.Hash )
+    if err != nil {
+        return nil, err
+    }
+    return decodeRecord( data )
+}
+
+// Store sealing every record before it reaches a persistent store,
+// set up by New() with an encryption key. Ids stay in the clear,
+// as the stores are keyed by them
+type encryptedStore struct {
+    store Store
+    cipher *recordCipher
+}
+
+/********************************************************************
+NewEncryptedStore()
+    Wraps a store so records are encrypted under the data key derived
+    from a 32 byte master key, as New() does with EncryptionKey, e.g.
+    to read an encrypted store offline. Records sealed with the
+    previous master keys are still decrypted.
+********************************************************************/
+func NewEncryptedStore( store Store, masterKey []byte, previousKeys ...[]byte ) ( Store, error ) {
+    recordCipher, err := newRecordCipher( masterKey )
+    if err != nil {
+        return nil, err
+    }
+    for _, previous := range previousKeys {
+        if _, err := recordCipher.loadKey( previous ); err != nil {
+            return nil, fmt.Errorf( "previous %w", err )
+        }
+    }
+    return &encryptedStore{ store: store, cipher: recordCipher }, nil
+}
+
+func ( e *encryptedStore ) Put( record *Record ) error {
+    sealed, err := e.cipher.sealRecord( record )
+    if err != nil {
+        return err
+    }
+    return e.store.Put( sealed )
+}
+
+func ( e *encryptedStore ) Get( id int64 ) ( *Record, error ) {
+    record, err := e.store.Get( id )
+    if err != nil {
+        return nil, err
+    }
+    return e.cipher.openRecord( record )
+}
+
+func ( e *encryptedStore ) Delete( id int64 ) error {
+    return e.store.Delete( id )
+}
+
+func ( e *encryptedStore ) List() ( []*Record, error ) {
+    records, err := e.store.List()
+    if err != nil {
+        return nil, err
+    }
+    for i, record := range records {
+        if records[ i ], err = e.cipher.openRecord( record ); err != nil {
+            return nil, err
+        }
+    }
+    return records, nil
+}
+
+func ( e *encryptedStore ) Count() ( int, error ) {
+    return e.store.Count()
+}
+
+func ( e *encryptedStore ) LastId() ( int64, error ) {
+    if sequence, ok := e.store.( SequenceStore ); ok {
+        return sequence.LastId()
+    }
+    return 0, nil
+}
+
+func ( e *encryptedStore ) SetLastId( id int64 ) error {
+    if sequence, ok := e.store.( SequenceStore ); ok {
+        return sequence.SetLastId( id )
+    }
+    return nil
+}
+
+func ( e *encryptedStore ) Ping() error {
+    if pinger, ok := e.store.( PingStore ); ok {
+        return pinger.Ping()
+    }
+    _, err := e.store.Count()
+    return err
+}
+
+/********************************************************************
+encodeDurable()
+    Encodes a record for the write-ahead log or a snapshot, sealed
+    with Config.EncryptionKey if it is set.
+********************************************************************/
+func ( s *Server ) encodeDurable( record *Record ) ( []byte, error ) {
+    if s.recordCipher != nil {
+        sealed, err := s.recordCipher.sealRecord( record )
+        if err != nil {
+            return nil, err
+        }
+        record = sealed
+    }
+    return encodeRecord( record )
+}
+
+/********************************************************************
+decodeDurable()
+    Decodes a record encoded by encodeDurable(), failing if it is
+    sealed and Config.EncryptionKey isn't set.
+********************************************************************/
+func ( s *Server ) decodeDurable( data []byte ) ( *Record, error ) {
+    record, err := decodeRecord( data )
+    if err != nil {
+        return nil, err
+    }
+    return s.openDurable( record )
+}
+
+/********************************************************************
+openDurable()
+    Returns a record read back from persistence, opened if it is
+    sealed, failing if Config.EncryptionKey isn't set to open it.
+********************************************************************/
+func ( s *Server ) openDurable( record *Record ) ( *Record, error ) {
+    if s.recordCipher == nil {
+        if record.Algorithm == EncryptedAlgorithm {
+            return nil, fmt.Errorf( "record %d is encrypted, the encryption key is needed to read it", record.Id )
+        }
+        return record, nil
+    }
+    return s.recordCipher.openRecord( record )
+}
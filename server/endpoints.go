@@ -0,0 +1,78 @@
+package server
+
+import (
+    "strconv"
+    "time"
+)
+
+// Requests handled by an endpoint, with the average handler time in
+// microseconds and the count of every status code and status class,
+// e.g. "4xx"
+type EndpointStat struct {
+    Count int64 `json:"count"`
+    Average int64 `json:"average"`
+    Statuses map[string]int64 `json:"statuses"`
+    Classes map[string]int64 `json:"classes"`
+}
+
+// Counters of one endpoint, guarded by endpointMutex
+type endpointCounter struct {
+    count int64
+    totalTime int64
+    statuses map[int]int64
+}
+
+/********************************************************************
+recordEndpoint()
+    Counts a request handled by the route pattern, "" for requests
+    matching none. A /v1 route and its unversioned alias are counted
+    as one endpoint.
+********************************************************************/
+func ( s *Server ) recordEndpoint( pattern string, status int, elapsed time.Duration ) {
+    if pattern == "" {
+        pattern = unmatchedEndpoint
+    }
+    pattern = unversioned( pattern )
+
+    s.endpointMutex.Lock()
+    defer s.endpointMutex.Unlock()
+
+    counter := s.endpointCounters[ pattern ]
+    if counter == nil {
+        counter = &endpointCounter{ statuses: map[int]int64{} }
+        s.endpointCounters[ pattern ] = counter
+    }
+    counter.count++
+    counter.totalTime += elapsed.Microseconds()
+    counter.statuses[ status ]++
+}
+
+/********************************************************************
+statusClass()
+    Returns the class of a status code, e.g. "4xx" for 404.
+********************************************************************/
+func statusClass( status int ) string {
+    return strconv.Itoa( status / 100 ) + "xx"
+}
+
+/********************************************************************
+endpointStats()
+    Returns the stats of every endpoint that handled a request, by
+    route pattern, e.g. "POST /hash".
+********************************************************************/
+func ( s *Server ) endpointStats() map[string]EndpointStat {
+    s.endpointMutex.Lock()
+    defer s.endpointMutex.Unlock()
+
+    stats := make(map[string]EndpointStat, len( s.endpointCounters ))
+    for pattern, counter := range s.endpointCounters {
+        statuses := make(map[string]int64, len( counter.statuses ))
+        classes := map[string]int64{}
+        for status, count := range counter.statuses {
+            statuses[ strconv.Itoa( status ) ] = count
+            classes[ statusClass( status ) ] += count
+        }
+        stats[ pattern ] = EndpointStat{ Count: counter.count, Average: counter.totalTime / counter.count, Statuses: statuses, Classes: classes }
+    }
+    return stats
+}
@@ -0,0 +1,316 @@
+package server
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "sort"
+    "strconv"
+    "time"
+)
+
+// Where an erasure removed records from
+const (
+    EraseMemory = "memory"
+    EraseStore = "store"
+    EraseWAL = "wal"
+    EraseSnapshot = "snapshot"
+)
+
+// A place records were erased from
+type EraseLocation struct {
+    Location string `json:"location"`
+    Detail string `json:"detail,omitempty"`
+}
+
+// Signed statement of what POST /admin/erase erased
+type EraseAttestation struct {
+    // Ids whose records were stored and are now erased
+    Erased []int64 `json:"erased"`
+
+    // Ids without a stored record, expired, evicted, purged or never
+    // handed out, still scrubbed from the write-ahead log
+    Absent []int64 `json:"absent"`
+
+    // Reference of the erasure request, e.g. its ticket
+    Reference string `json:"reference,omitempty"`
+    RequestId string `json:"request_id"`
+    ErasedAt time.Time `json:"erased_at"`
+    Locations []EraseLocation `json:"locations"`
+
+    // Names of the archives uploaded before the erasure, which still
+    // hold the records until they are removed from the archive
+    RetainedArchives []string `json:"retained_archives,omitempty"`
+}
+
+// Response to POST /admin/erase. JWS is a compact JWS signed with a key
+// of /.well-known/jwks.json whose payload is the attestation as JSON
+type EraseResponse struct {
+    Attestation EraseAttestation `json:"attestation"`
+    JWS string `json:"jws"`
+}
+
+/********************************************************************
+eraseRecords()
+    Removes the records with the ids from the store and every map
+    tracking them, returning the ids that were stored. They answer
+    410 Gone from then on, like purged ids. Must be called with
+    mapMutex held, and scrubWAL() called once it is released.
+********************************************************************/
+func ( s *Server ) eraseRecords( ids []int64 ) ( []int64, error ) {
+    var erased []int64
+    for _, id := range ids {
+        record, err := s.getRecord( id )
+        if err != nil {
+            return erased, err
+        }
+        if record == nil {
+            continue
+        }
+        s.forgetDigest( record.Id, recordDigest( record ) )
+        if err := s.forgetPurged( id ); err != nil {
+            return erased, err
+        }
+        erased = append( erased, id )
+    }
+    return erased, nil
+}
+
+/********************************************************************
+forgetIdempotencyKeys()
+    Forgets the Idempotency-Keys of submissions of the ids, so they
+    can't be used to find out their ids were submitted.
+********************************************************************/
+func ( s *Server ) forgetIdempotencyKeys( ids map[int64]bool ) {
+    s.idempotencyMutex.Lock()
+    defer s.idempotencyMutex.Unlock()
+
+    for key, entry := range s.idempotencyKeys {
+        if ids[ entry.id ] {
+            delete( s.idempotencyKeys, key )
+        }
+    }
+}
+
+/********************************************************************
+scrubWAL()
+    Rewrites the write-ahead log without the submissions and
+    completions of the ids, the only entries carrying their hashes,
+    and with a purged marker of each erased one, so a replay neither
+    brings them back nor hands their ids out again. Must be called
+    with compactMutex held. Returns the number of entries dropped.
+********************************************************************/
+func ( s *Server ) scrubWAL( ids map[int64]bool, erased []int64 ) ( int, error ) {
+    s.walMutex.Lock()
+    defer s.walMutex.Unlock()
+    if s.walFile == nil {
+        return 0, errors.New( "the write-ahead log is closed" )
+    }
+
+    data, err := os.ReadFile( s.config.WALFile )
+    if err != nil {
+        return 0, err
+    }
+
+    var kept bytes.Buffer
+    var lastId, keptLastId int64
+    dropped := 0
+    scanner := bufio.NewScanner( bytes.NewReader( data ) )
+    scanner.Buffer( nil, 1024 * 1024 )
+    for scanner.Scan() {
+        if len( scanner.Bytes() ) == 0 {
+            continue
+        }
+        var entry walEntry
+        if err := json.Unmarshal( scanner.Bytes(), &entry ); err != nil {
+            return 0, err
+        }
+        if entry.Id > lastId {
+            lastId = entry.Id
+        }
+        if ids[ entry.Id ] && ( entry.Op == walSubmit || entry.Op == walComplete ) {
+            dropped++
+            continue
+        }
+        if entry.Id > keptLastId {
+            keptLastId = entry.Id
+        }
+        kept.Write( append( scanner.Bytes(), '\n' ) )
+    }
+    if err := scanner.Err(); err != nil {
+        return 0, err
+    }
+
+    for _, id := range erased {
+        line, _ := json.Marshal( walEntry{ Op: walPurged, Id: id } )
+        kept.Write( append( line, '\n' ) )
+        if id > keptLastId {
+            keptLastId = id
+        }
+    }
+    if lastId > keptLastId {
+        line, _ := json.Marshal( walEntry{ Op: walSequence, Id: lastId } )
+        kept.Write( append( line, '\n' ) )
+    }
+
+    if err := writeFileAtomic( s.config.WALFile, kept.Bytes() ); err != nil {
+        return 0, err
+    }
+    file, err := os.OpenFile( s.config.WALFile, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0600 )
+    if err != nil {
+        return 0, err
+    }
+    s.walFile.Close()
+    s.walFile = file
+    return dropped, nil
+}
+
+/********************************************************************
+erase()
+    Erases the records with the ids from memory, the store, the
+    write-ahead log and the snapshot, returning the attestation of
+    it. The store and the log are rewritten so the bytes of the
+    records don't linger in their files. A new latest archive is
+    uploaded without them, those uploaded before are left as they
+    are and listed as retained. Ids of pending passwords must have
+    been refused with mapMutex held, by the caller.
+********************************************************************/
+func ( s *Server ) erase( ids []int64, erased []int64 ) ( *EraseAttestation, error ) {
+    s.compactMutex.Lock()
+    defer s.compactMutex.Unlock()
+
+    idSet := make(map[int64]bool, len( ids ))
+    for _, id := range ids {
+        idSet[ id ] = true
+    }
+    s.forgetIdempotencyKeys( idSet )
+
+    attestation := &EraseAttestation{ Erased: []int64{}, Absent: []int64{}, ErasedAt: s.clock.Now() }
+    isErased := make(map[int64]bool, len( erased ))
+    for _, id := range erased {
+        isErased[ id ] = true
+        attestation.Erased = append( attestation.Erased, id )
+    }
+    for _, id := range ids {
+        if !isErased[ id ] {
+            attestation.Absent = append( attestation.Absent, id )
+        }
+    }
+    sort.Slice( attestation.Erased, func( i, j int ) bool { return attestation.Erased[ i ] < attestation.Erased[ j ] } )
+    sort.Slice( attestation.Absent, func( i, j int ) bool { return attestation.Absent[ i ] < attestation.Absent[ j ] } )
+    attestation.Locations = append( attestation.Locations, EraseLocation{ Location: EraseMemory } )
+
+    stat, err := s.compactStore()
+    if err != nil {
+        return nil, err
+    }
+    location := EraseLocation{ Location: EraseStore }
+    if stat != nil {
+        location.Detail = "rewritten, " + strconv.FormatInt( stat.BytesBefore - stat.BytesAfter, 10 ) + " bytes reclaimed"
+    }
+    attestation.Locations = append( attestation.Locations, location )
+
+    if s.config.WALFile != "" {
+        dropped, err := s.scrubWAL( idSet, erased )
+        if err != nil {
+            return nil, err
+        }
+        attestation.Locations = append( attestation.Locations, EraseLocation{ Location: EraseWAL, Detail: "rewritten, " + strconv.Itoa( dropped ) + " entries dropped" } )
+    }
+    if s.config.SnapshotFile != "" {
+        if err := s.saveSnapshot(); err != nil {
+            return nil, err
+        }
+        attestation.Locations = append( attestation.Locations, EraseLocation{ Location: EraseSnapshot, Detail: "rewritten" } )
+    }
+    if s.archive != nil {
+        retained, err := s.archive.List()
+        if err != nil {
+            return nil, err
+        }
+        if err := s.archiveRecords(); err != nil {
+            return nil, err
+        }
+        attestation.RetainedArchives = retained
+    }
+    return attestation, nil
+}
+
+/********************************************************************
+handleErase()
+    Handles POST requests on /admin/erase, erasing the records with
+    the ids in "ids" everywhere the server keeps them, e.g. for an
+    erasure request of their owner, and answering a signed
+    attestation of it. "reference" is recorded in the attestation.
+    Ids of passwords still being hashed are refused.
+********************************************************************/
+func ( s *Server ) handleErase( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /admin/erase" )
+
+    // Check shutdown
+    if s.shutDown {
+        s.log( r ).Info( "Server has been shut down!" )
+        writeError( w, http.StatusNotAcceptable, ErrorShuttingDown )
+        return
+    }
+
+    // Lock the shutdown mutex to ensure the server doesn't
+    // shut down while processing this request
+    s.shutdownMutex.RLock()
+    defer s.shutdownMutex.RUnlock()
+
+    ids, err := parseIds( r.FormValue( "ids" ) )
+    if err != nil {
+        s.log( r ).Info( "Invalid ids", "error", err )
+        writeFieldErrors( w, invalidField( "ids", err.Error() ) )
+        return
+    }
+    seen := make(map[int64]bool, len( ids ))
+    unique := ids[ :0 ]
+    for _, id := range ids {
+        if !seen[ id ] {
+            seen[ id ] = true
+            unique = append( unique, id )
+        }
+    }
+    ids = unique
+
+    s.mapMutex.Lock()
+    for _, id := range ids {
+        if s.pendingJobs[ id ] != nil {
+            s.mapMutex.Unlock()
+            s.log( r ).Info( "Erasing a password id that is pending", "id", id )
+            writeFieldErrors( w, FieldError{ Field: "ids", Code: ErrorPending, Message: fmt.Sprintf( "id %d is still being hashed", id ) } )
+            return
+        }
+    }
+    erased, err := s.eraseRecords( ids )
+    s.mapMutex.Unlock()
+    if err != nil {
+        s.logPurges( erased )
+        s.writeStoreError( w, r, err )
+        return
+    }
+
+    attestation, err := s.erase( ids, erased )
+    if err != nil {
+        s.writeStoreError( w, r, err )
+        return
+    }
+    attestation.Reference = r.FormValue( "reference" )
+    attestation.RequestId = w.Header().Get( "X-Request-ID" )
+    payload, err := json.Marshal( attestation )
+    if err != nil {
+        s.logError( "Unable to encode the erasure attestation: %v", err )
+        writeError( w, http.StatusInternalServerError, ErrorInternal )
+        return
+    }
+
+    s.auditRequest( r, AuditErase, strconv.Itoa( len( erased ) ) + " records, reference " + strconv.Quote( attestation.Reference ) )
+    s.log( r ).Info( "Erased records!", "erased", len( erased ), "absent", len( attestation.Absent ) )
+    s.writeEncoded( w, r, http.StatusOK, EraseResponse{ Attestation: *attestation, JWS: s.signCompact( payload ) } )
+}
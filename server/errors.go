@@ -0,0 +1,128 @@
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+)
+
+// Error codes of the JSON error envelope. They are stable, so clients
+// can tell cases apart without matching status text
+const (
+    // Rejected passwords, also sent in the X-Error-Code header
+    ErrorEmptyBody = "EMPTY_BODY"
+    ErrorMissingPassword = "MISSING_PASSWORD"
+    ErrorEmptyPassword = "EMPTY_PASSWORD"
+
+    ErrorInvalidParameter = "INVALID_PARAMETER"
+    ErrorInvalidRequest = "INVALID_REQUEST"
+    ErrorUnsupportedMediaType = "UNSUPPORTED_MEDIA_TYPE"
+    ErrorNotFound = "NOT_FOUND"
+    ErrorExpired = "EXPIRED"
+    ErrorEvicted = "EVICTED"
+    ErrorDeleted = "DELETED"
+    ErrorFailed = "FAILED"
+    ErrorNotDeleted = "NOT_DELETED"
+    ErrorPending = "PENDING"
+    ErrorMethodNotAllowed = "METHOD_NOT_ALLOWED"
+    ErrorShuttingDown = "SHUTTING_DOWN"
+    ErrorRateLimited = "RATE_LIMITED"
+    ErrorUnauthorized = "UNAUTHORIZED"
+    ErrorAdminDisabled = "ADMIN_DISABLED"
+    ErrorInvalidToken = "INVALID_TOKEN"
+    ErrorInvalidSignature = "INVALID_SIGNATURE"
+    ErrorInternal = "INTERNAL_ERROR"
+    ErrorStoreUnavailable = "STORE_UNAVAILABLE"
+    ErrorImportConflict = "IMPORT_CONFLICT"
+    ErrorRecordCorrupted = "RECORD_CORRUPTED"
+    ErrorCutOver = "CUT_OVER"
+    ErrorNotBackfilled = "NOT_BACKFILLED"
+)
+
+// Error response body
+type ErrorResponse struct {
+    Error ErrorDetail `json:"error"`
+}
+
+// Error code and message of an error response. Validation errors
+// also list each offending field
+type ErrorDetail struct {
+    Code string `json:"code"`
+    Message string `json:"message"`
+    Fields []FieldError `json:"fields,omitempty"`
+    RequestId string `json:"request_id,omitempty"`
+}
+
+// Invalid request field, for client forms to show next to the field
+type FieldError struct {
+    Field string `json:"field"`
+    Code string `json:"code"`
+    Message string `json:"message"`
+}
+
+/********************************************************************
+writeError()
+    Writes an error response with the status and a JSON envelope
+    holding the error code and status text, and the request id of
+    the X-Request-ID response header.
+********************************************************************/
+func writeError( w http.ResponseWriter, status int, code string ) {
+    w.Header().Set( "Content-Type", "application/json" )
+    w.Header().Set( "X-Content-Type-Options", "nosniff" )
+    w.WriteHeader( status )
+    json.NewEncoder(w).Encode(ErrorResponse{ Error: ErrorDetail{ Code: code, Message: http.StatusText( status ), RequestId: w.Header().Get( "X-Request-ID" ) } })
+}
+
+/********************************************************************
+writeFieldErrors()
+    Writes a 422 Unprocessable Entity error response listing the
+    invalid fields, with the code of the first as the error code.
+********************************************************************/
+func writeFieldErrors( w http.ResponseWriter, fields ...FieldError ) {
+    w.Header().Set( "Content-Type", "application/json" )
+    w.Header().Set( "X-Content-Type-Options", "nosniff" )
+    w.WriteHeader( http.StatusUnprocessableEntity )
+    json.NewEncoder(w).Encode(ErrorResponse{ Error: ErrorDetail{
+        Code: fields[ 0 ].Code,
+        Message: http.StatusText( http.StatusUnprocessableEntity ),
+        Fields: fields,
+        RequestId: w.Header().Get( "X-Request-ID" ),
+    } })
+}
+
+/********************************************************************
+invalidField()
+    Returns the field error of an invalid parameter.
+********************************************************************/
+func invalidField( field string, message string ) FieldError {
+    return FieldError{ Field: field, Code: ErrorInvalidParameter, Message: message }
+}
+
+/********************************************************************
+route()
+    Serves a request through the routes, answering requests that
+    match none of them with an error envelope. The mux still works
+    out whether that is 404 Not Found or 405 Method Not Allowed, and
+    the Allow header. OPTIONS requests on a route are answered with
+    204 No Content and the Allow header.
+********************************************************************/
+func ( s *Server ) route( w http.ResponseWriter, r *http.Request ) {
+    if _, pattern := s.mux.Handler( r ); pattern != "" {
+        s.mux.ServeHTTP( w, r )
+        return
+    }
+
+    recorded := httptest.NewRecorder()
+    s.mux.ServeHTTP( recorded, r )
+    if recorded.Code != http.StatusMethodNotAllowed {
+        writeError( w, http.StatusNotFound, ErrorNotFound )
+        return
+    }
+
+    w.Header().Set( "Allow", recorded.Header().Get( "Allow" ) + ", " + http.MethodOptions )
+    if r.Method == http.MethodOptions {
+        w.WriteHeader( http.StatusNoContent )
+        return
+    }
+    writeError( w, http.StatusMethodNotAllowed, ErrorMethodNotAllowed )
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "strconv"
+    "strings"
+)
+
+/********************************************************************
+recordETag()
+    Returns the strong ETag of a hashed password record in one of its
+    representations, "text" or an encoding name. Records never change
+    once hashed, so the id, hash and representation identify it.
+********************************************************************/
+func recordETag( record *Record, representation string ) string {
+    digest := sha256.Sum256( []byte( record.Hash ) )
+    return `"` + strconv.FormatInt( record.Id, 10 ) + "-" + hex.EncodeToString( digest[ :8 ] ) + "-" + representation + `"`
+}
+
+/********************************************************************
+etagMatches()
+    Returns true if an If-None-Match header lists the ETag, or is
+    "*". If-None-Match uses the weak comparison, so W/ prefixes are
+    ignored.
+********************************************************************/
+func etagMatches( ifNoneMatch string, etag string ) bool {
+    for _, candidate := range strings.Split( ifNoneMatch, "," ) {
+        candidate = strings.TrimPrefix( strings.TrimSpace( candidate ), "W/" )
+        if candidate == "*" || candidate == etag {
+            return true
+        }
+    }
+    return false
+}
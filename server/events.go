@@ -0,0 +1,109 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "time"
+)
+
+// Event emitted on /events each time a password is hashed
+type CompletionEvent struct {
+    Id int64 `json:"id"`
+    Timestamp time.Time `json:"timestamp"`
+    LatencyUs int64 `json:"latency_us"`
+}
+
+var (
+    // Event stream info
+    eventBuffer = 64
+    eventHeartbeat = 15 * time.Second
+)
+
+/********************************************************************
+publishCompletion()
+    Sends a completion event to every /events subscriber. Events are
+    dropped for subscribers too slow to keep up rather than holding
+    up the hashing.
+********************************************************************/
+func ( s *Server ) publishCompletion( event CompletionEvent ) {
+    s.eventMutex.Lock()
+    defer s.eventMutex.Unlock()
+
+    for subscriber := range s.eventSubscribers {
+        select {
+        case subscriber <- event:
+        default:
+        }
+    }
+}
+
+/********************************************************************
+subscribeEvents()
+    Registers a new completion event subscriber, the returned func
+    unregisters it.
+********************************************************************/
+func ( s *Server ) subscribeEvents() ( chan CompletionEvent, func() ) {
+    events := make(chan CompletionEvent, eventBuffer)
+
+    s.eventMutex.Lock()
+    s.eventSubscribers[ events ] = struct{}{}
+    s.eventMutex.Unlock()
+
+    return events, func() {
+        s.eventMutex.Lock()
+        delete( s.eventSubscribers, events )
+        s.eventMutex.Unlock()
+    }
+}
+
+/********************************************************************
+handleEvents()
+    Handles GET requests on /events, a Server-Sent Events stream with
+    a "completed" event each time a password is hashed. The stream
+    ends when the client disconnects or the server shuts down.
+********************************************************************/
+func ( s *Server ) handleEvents( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /events" )
+
+    // Check shutdown
+    if s.shutDown {
+        s.log( r ).Info( "Server has been shut down!" )
+        writeError( w, http.StatusNotAcceptable, ErrorShuttingDown )
+        return
+    }
+
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        s.log( r ).Info( "Streaming not supported!" )
+        writeError( w, http.StatusInternalServerError, ErrorInternal )
+        return
+    }
+
+    events, unsubscribe := s.subscribeEvents()
+    defer unsubscribe()
+
+    w.Header().Set( "Content-Type", "text/event-stream" )
+    w.Header().Set( "Cache-Control", "no-cache" )
+    w.Header().Set( "Connection", "keep-alive" )
+    w.WriteHeader( http.StatusOK )
+    flusher.Flush()
+
+    heartbeat := time.NewTicker( eventHeartbeat )
+    defer heartbeat.Stop()
+
+    for {
+        select {
+        case event := <-events:
+            data, _ := json.Marshal( event )
+            fmt.Fprintf( w, "id: %d\nevent: completed\ndata: %s\n\n", event.Id, data )
+        case <-heartbeat.C:
+            fmt.Fprint( w, ": heartbeat\n\n" )
+        case <-s.shutdownStarted:
+            return
+        case <-r.Context().Done():
+            return
+        }
+        flusher.Flush()
+    }
+}
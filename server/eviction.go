@@ -0,0 +1,100 @@
+package server
+
+import "fmt"
+
+// Which record is evicted once Config.MaxRecords are stored
+const (
+    // The record read least recently
+    EvictLRU = "lru"
+
+    // The record stored first
+    EvictOldest = "oldest"
+)
+
+/********************************************************************
+checkEviction()
+    Returns an error if Config.EvictionPolicy is unknown.
+********************************************************************/
+func checkEviction( config Config ) error {
+    switch config.EvictionPolicy {
+    case "", EvictLRU, EvictOldest:
+        return nil
+    }
+    return fmt.Errorf( "unknown eviction policy %q, expected %s or %s", config.EvictionPolicy, EvictLRU, EvictOldest )
+}
+
+/********************************************************************
+trackRecord()
+    Counts a stored record towards Config.MaxRecords, as the most
+    recent, and evicts the records over it. Must be called with
+    mapMutex held.
+********************************************************************/
+func ( s *Server ) trackRecord( id int64 ) error {
+    if s.config.MaxRecords <= 0 {
+        return nil
+    }
+
+    if element := s.recordElements[ id ]; element != nil {
+        s.recordOrder.MoveToFront( element )
+    } else {
+        s.recordElements[ id ] = s.recordOrder.PushFront( id )
+    }
+    return s.evictRecords()
+}
+
+/********************************************************************
+touchRecord()
+    Marks a record as read, with the lru eviction policy. Must be
+    called with mapMutex held.
+********************************************************************/
+func ( s *Server ) touchRecord( id int64 ) {
+    if s.config.MaxRecords <= 0 || s.config.EvictionPolicy == EvictOldest {
+        return
+    }
+    if element := s.recordElements[ id ]; element != nil {
+        s.recordOrder.MoveToFront( element )
+    }
+}
+
+/********************************************************************
+untrackRecord()
+    Stops counting a record that was deleted otherwise, e.g. once it
+    expired. Must be called with mapMutex held.
+********************************************************************/
+func ( s *Server ) untrackRecord( id int64 ) {
+    if element := s.recordElements[ id ]; element != nil {
+        s.recordOrder.Remove( element )
+        delete( s.recordElements, id )
+    }
+}
+
+/********************************************************************
+evictRecords()
+    Deletes the least recently used, or oldest, records from the store
+    while there are more than Config.MaxRecords. Evicted ids are
+    remembered so GET can tell them apart from ids that never
+    existed. Must be called with mapMutex held.
+********************************************************************/
+func ( s *Server ) evictRecords() error {
+    for s.recordOrder.Len() > s.config.MaxRecords {
+        element := s.recordOrder.Back()
+        id := element.Value.( int64 )
+
+        record, err := s.getRecord( id )
+        if err != nil {
+            return err
+        }
+        if record != nil {
+            if err := s.store.Delete( id ); err != nil {
+                return err
+            }
+            s.forgetDigest( record.Id, recordDigest( record ) )
+        }
+        s.recordOrder.Remove( element )
+        delete( s.recordElements, id )
+        delete( s.expiresAt, id )
+        s.evictedIds.add( id )
+        s.evictedCount++
+    }
+    return nil
+}
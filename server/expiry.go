@@ -0,0 +1,126 @@
+package server
+
+import (
+    "fmt"
+    "strconv"
+    "time"
+)
+
+/********************************************************************
+expired()
+    Returns true if the record has a ttl that has elapsed by now.
+********************************************************************/
+func ( record *Record ) expired( now time.Time ) bool {
+    return record.ExpiresAt != nil && !now.Before( *record.ExpiresAt )
+}
+
+/********************************************************************
+parseTtl()
+    Parses the "ttl" form field, either a Go duration ("90s", "1h")
+    or a whole number of seconds. An empty value means no expiry.
+********************************************************************/
+func parseTtl( value string ) ( time.Duration, error ) {
+    if value == "" {
+        return 0, nil
+    }
+
+    ttl, err := time.ParseDuration( value )
+    if err != nil {
+        seconds, convErr := strconv.ParseInt( value, 10, 64 )
+        if convErr != nil {
+            return 0, fmt.Errorf( "invalid ttl %q", value )
+        }
+        ttl = time.Duration( seconds ) * time.Second
+    }
+
+    if ttl <= 0 {
+        return 0, fmt.Errorf( "invalid ttl %q, must be positive", value )
+    }
+
+    return ttl, nil
+}
+
+/********************************************************************
+reapExpired()
+    Background reaper, every ReaperInterval deletes expired records
+    from the store, purges the soft deleted records whose grace period
+    is over and forgets expired Idempotency-Keys, then
+    compacts the maps that shrank. Expired ids are remembered so GET
+    can tell them apart from ids that never existed. What each run
+    reclaimed and how long it took goes to the GC stats and metrics.
+********************************************************************/
+func ( s *Server ) reapExpired() {
+    interval := s.config.ReaperInterval
+    if interval <= 0 {
+        interval = reaperDefaultInterval
+    }
+    ticker := time.NewTicker( interval )
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+        case <-s.shutdownStarted:
+            return
+        }
+
+        // Expiry is on the server's clock, like the reads telling
+        // expired records apart, not the ticker's
+        now := s.clock.Now()
+        start := time.Now()
+
+        keys, keyCompactions := s.reapIdempotencyKeys( now )
+
+        s.mapMutex.Lock()
+        records, err := s.reapExpiredRecords( now )
+        if err != nil {
+            s.logError( "Unable to reap expired hashes: %v", err )
+        }
+        purged, err := s.purgeRecords( s.dueDeletions( now ) )
+        if err != nil {
+            s.logError( "Unable to purge deleted hashes: %v", err )
+        }
+        compactions := keyCompactions + s.compactRecordMaps()
+        reclaimed := map[string]int64{ gcExpiredRecords: records, gcPurgedRecords: int64( len( purged ) ), gcIdempotencyKeys: keys }
+        s.recordGC( now, time.Since( start ), reclaimed, compactions )
+        s.mapMutex.Unlock()
+        s.logPurges( purged )
+    }
+}
+
+/********************************************************************
+reapExpiredRecords()
+    Deletes the records expired by now, by their ttl or
+    RetentionPeriod, from the store, which is only asked for those,
+    returning how many were. Must be called with
+    mapMutex held.
+********************************************************************/
+func ( s *Server ) reapExpiredRecords( now time.Time ) ( int64, error ) {
+    var reaped int64
+    for id, expiresAt := range s.expiresAt {
+        if now.Before( expiresAt ) {
+            continue
+        }
+
+        record, err := s.getRecord( id )
+        if err != nil {
+            return reaped, err
+        }
+        if record != nil {
+            if err := s.store.Delete( id ); err != nil {
+                return reaped, err
+            }
+            s.forgetDigest( record.Id, recordDigest( record ) )
+            s.untrackRecord( id )
+            s.expiredIds.add( id )
+            if s.retentionExpired( record, now ) {
+                s.retentionExpiredCount++
+            } else {
+                s.expiredCount++
+            }
+            reaped++
+        }
+        delete( s.expiresAt, id )
+    }
+    return reaped, nil
+}
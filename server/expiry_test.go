@@ -0,0 +1,45 @@
+package server
+
+import (
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+)
+
+// The reaper deletes a record once its ttl elapsed on the server's
+// clock, like GET sees it, whatever the system time
+func TestReaperFollowsClock( t *testing.T ) {
+    clock := &steppedClock{}
+    config := DefaultConfig()
+    config.ReaperInterval = 10 * time.Millisecond
+    s, handler := newTestServer( t, 0, WithConfig( config ), WithClock( clock ) )
+    go s.reapExpired()
+
+    request := httptest.NewRequest( http.MethodPost, "/v1/hash", strings.NewReader( url.Values{ "password": { "angryMonkey" }, "ttl": { "1h" } }.Encode() ) )
+    request.Header.Set( "Content-Type", "application/x-www-form-urlencoded" )
+    handler.ServeHTTP( httptest.NewRecorder(), request )
+    waitHashed( t, handler, 1, 2 * time.Second )
+
+    clock.step( 2 * time.Hour )
+    deadline := time.Now().Add( 2 * time.Second )
+    for {
+        _, err := s.store.Get( 1 )
+        if errors.Is( err, ErrRecordNotFound ) {
+            break
+        }
+        if err != nil {
+            t.Fatalf( "Get( 1 ): %v", err )
+        }
+        if time.Now().After( deadline ) {
+            t.Fatal( "the reaper kept the record past its ttl on the server's clock" )
+        }
+        time.Sleep( 10 * time.Millisecond )
+    }
+    if response := getHash( handler, 1 ); response.Code != http.StatusGone {
+        t.Errorf( "GET /v1/hash/1: got %d, want 410", response.Code )
+    }
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+    "expvar"
+    "fmt"
+    "net/http"
+)
+
+/********************************************************************
+newExpvars()
+    Creates the server's expvar variables. They aren't published in
+    the process wide expvar registry, so several servers can run in
+    one process, but served next to it by handleExpvar().
+********************************************************************/
+func ( s *Server ) newExpvars() *expvar.Map {
+    vars := new( expvar.Map )
+    s.expvarRequests = new( expvar.Map )
+    vars.Set( "requests", s.expvarRequests )
+    vars.Set( "pending_jobs", expvar.Func( func() interface{} {
+        return s.pendingJobCount()
+    } ) )
+    vars.Set( "hashed", expvar.Func( func() interface{} {
+        s.mapMutex.Lock()
+        defer s.mapMutex.Unlock()
+        return s.hashedCount
+    } ) )
+    vars.Set( "hashes_per_second", expvar.Func( func() interface{} {
+        s.mapMutex.Lock()
+        hashed := s.hashedCount
+        since := s.statsSince
+        s.mapMutex.Unlock()
+        return float64( hashed ) / s.since( since ).Seconds()
+    } ) )
+    return vars
+}
+
+/********************************************************************
+handleExpvar()
+    Handles GET requests on /debug/vars, returning the process wide
+    expvar variables, like cmdline and memstats, along with the
+    server's own under "hashsvc".
+********************************************************************/
+func ( s *Server ) handleExpvar( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /debug/vars" )
+
+    w.Header().Set( "Content-Type", "application/json; charset=utf-8" )
+    fmt.Fprintf( w, "{\n" )
+    expvar.Do( func( kv expvar.KeyValue ) {
+        fmt.Fprintf( w, "%q: %s,\n", kv.Key, kv.Value )
+    } )
+    fmt.Fprintf( w, "%q: %s\n}\n", "hashsvc", s.expvars )
+}
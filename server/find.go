@@ -0,0 +1,44 @@
+package server
+
+import (
+    "crypto/subtle"
+    "net/http"
+)
+
+// Response to GET /hash/find
+type FindResponse struct {
+    Ids []int64 `json:"ids"`
+}
+
+/********************************************************************
+handleHashFind()
+    Handles GET requests on /hash/find?digest=..., returning the ids
+    whose stored hash equals the digest. Every record is compared in
+    constant time, so response timing doesn't leak how close a
+    guessed digest came to a stored one.
+********************************************************************/
+func ( s *Server ) handleHashFind( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /hash/find" )
+
+    digest := []byte( r.URL.Query().Get( "digest" ) )
+    if len( digest ) == 0 {
+        s.log( r ).Info( "Missing digest to find!" )
+        writeFieldErrors( w, invalidField( "digest", "missing digest" ) )
+        return
+    }
+
+    records, err := s.store.List()
+    if err != nil {
+        s.writeStoreError( w, r, err )
+        return
+    }
+
+    ids := []int64{}
+    for _, record := range records {
+        if record.DeletedAt == nil && subtle.ConstantTimeCompare( []byte( record.Hash ), digest ) == 1 {
+            ids = append( ids, record.Id )
+        }
+    }
+
+    s.writeEncoded( w, r, http.StatusOK, FindResponse{ Ids: ids } )
+}
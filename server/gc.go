@@ -0,0 +1,126 @@
+package server
+
+import "time"
+
+// Default interval between runs of the reaper
+const reaperDefaultInterval = time.Second
+
+// Maps are only rebuilt once they held this many entries, and only
+// once down to a quarter of the most they held
+const gcCompactMinSize = 1024
+
+// Kinds of entries the reaper reclaims, as counted in GCStat
+const (
+    gcExpiredRecords = "expired_records"
+    gcPurgedRecords = "purged_records"
+    gcIdempotencyKeys = "idempotency_keys"
+)
+
+// What the reaper has done, reported in /stats
+type GCStat struct {
+    Runs int64 `json:"runs"`
+
+    // Entries removed by kind, e.g. "expired_records"
+    Reclaimed map[string]int64 `json:"reclaimed"`
+
+    // Maps rebuilt to give back the memory of removed entries
+    Compactions int64 `json:"compactions"`
+
+    // Total and last time taken by a run, in microseconds
+    TotalUs int64 `json:"total_us"`
+    LastUs int64 `json:"last_us"`
+    LastRunAt time.Time `json:"last_run_at"`
+}
+
+// Most entries the maps compacted by the reaper held since they were
+// last rebuilt. A Go map keeps its buckets once its entries are
+// deleted, so after a burst it is copied into one sized to fit
+type gcPeaks struct {
+    pendingJobs int
+    expiresAt int
+    digestIds int
+    recordElements int
+    idempotencyKeys int
+}
+
+/********************************************************************
+compactMap()
+    Returns a copy of a map sized to its entries, and true, if it is
+    down to a quarter of its peak, or the map itself and false.
+********************************************************************/
+func compactMap[K comparable, V any]( m map[K]V, peak *int ) ( map[K]V, bool ) {
+    if len( m ) > *peak {
+        *peak = len( m )
+    }
+    if *peak < gcCompactMinSize || len( m ) > *peak / 4 {
+        return m, false
+    }
+
+    compacted := make(map[K]V, len( m ))
+    for key, value := range m {
+        compacted[ key ] = value
+    }
+    *peak = len( m )
+    return compacted, true
+}
+
+/********************************************************************
+compactRecordMaps()
+    Rebuilds the maps of records and jobs that shrank, returning how
+    many were. Must be called with mapMutex held.
+********************************************************************/
+func ( s *Server ) compactRecordMaps() int64 {
+    var compactions int64
+    var compacted bool
+    if s.pendingJobs, compacted = compactMap( s.pendingJobs, &s.gcPeaks.pendingJobs ); compacted {
+        compactions++
+    }
+    if s.expiresAt, compacted = compactMap( s.expiresAt, &s.gcPeaks.expiresAt ); compacted {
+        compactions++
+    }
+    if s.digestIds, compacted = compactMap( s.digestIds, &s.gcPeaks.digestIds ); compacted {
+        compactions++
+    }
+    if s.recordElements, compacted = compactMap( s.recordElements, &s.gcPeaks.recordElements ); compacted {
+        compactions++
+    }
+    return compactions
+}
+
+/********************************************************************
+recordGC()
+    Adds a run of the reaper to the GC stats and metrics. Must be
+    called with mapMutex held.
+********************************************************************/
+func ( s *Server ) recordGC( at time.Time, elapsed time.Duration, reclaimed map[string]int64, compactions int64 ) {
+    if s.gcStats.Reclaimed == nil {
+        s.gcStats.Reclaimed = map[string]int64{ gcExpiredRecords: 0, gcPurgedRecords: 0, gcIdempotencyKeys: 0 }
+    }
+    s.gcStats.Runs++
+    for kind, count := range reclaimed {
+        s.gcStats.Reclaimed[ kind ] += count
+        s.metrics.gcReclaimed.WithLabelValues( kind ).Add( float64( count ) )
+    }
+    s.gcStats.Compactions += compactions
+    s.gcStats.TotalUs += elapsed.Microseconds()
+    s.gcStats.LastUs = elapsed.Microseconds()
+    s.gcStats.LastRunAt = at
+    s.metrics.gcDuration.WithLabelValues().Observe( elapsed.Seconds() )
+}
+
+/********************************************************************
+gcSnapshot()
+    Returns a copy of the GC stats, nil before the reaper first ran.
+    Must be called with mapMutex held.
+********************************************************************/
+func ( s *Server ) gcSnapshot() *GCStat {
+    if s.gcStats.Runs == 0 {
+        return nil
+    }
+    stat := s.gcStats
+    stat.Reclaimed = make(map[string]int64, len( s.gcStats.Reclaimed ))
+    for kind, count := range s.gcStats.Reclaimed {
+        stat.Reclaimed[ kind ] = count
+    }
+    return &stat
+}
@@ -0,0 +1,545 @@
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/graphql-go/graphql"
+    "github.com/graphql-go/graphql/language/lexer"
+    "github.com/graphql-go/graphql/language/source"
+)
+
+var (
+    // Page size of the hashes query when "first" is not given
+    graphqlDefaultPage = 100
+)
+
+// Schema in SDL, returned by _service for GraphQL federation gateways
+const graphqlSDL = `type Label {
+  key: String!
+  value: String!
+}
+
+type HashRecord {
+  id: ID!
+  status: String!
+  hash: String
+  algorithm: String
+  labels: [Label!]!
+  createdAt: String
+  completedAt: String
+  latencyUs: Int
+  expiresAt: String
+}
+
+type HashPage {
+  records: [HashRecord!]!
+  endCursor: ID
+  hasNextPage: Boolean!
+}
+
+type Stats {
+  total: Int!
+  average: Int!
+  slaViolations: Int!
+  expired: Int!
+  paused: Boolean!
+}
+
+type SubmitResult {
+  id: ID!
+  deduplicated: Boolean!
+  estimatedCompletion: String
+}
+
+input LabelInput {
+  key: String!
+  value: String!
+}
+
+type Query {
+  hash(id: ID!): HashRecord
+  hashes(labels: [LabelInput!], first: Int, after: ID): HashPage!
+  stats: Stats!
+}
+
+type Mutation {
+  submitPassword(password: String!, labels: [LabelInput!], ttl: String): SubmitResult!
+}
+`
+
+// Body of a POST /graphql request
+type graphqlRequest struct {
+    Query string `json:"query"`
+    OperationName string `json:"operationName"`
+    Variables map[string]interface{} `json:"variables"`
+}
+
+/********************************************************************
+newGraphqlSchema()
+    Builds the schema served on /graphql, see graphqlSDL.
+********************************************************************/
+func ( s *Server ) newGraphqlSchema() graphql.Schema {
+    label := graphql.NewObject( graphql.ObjectConfig{
+        Name: "Label",
+        Fields: graphql.Fields{
+            "key": &graphql.Field{ Type: graphql.NewNonNull( graphql.String ) },
+            "value": &graphql.Field{ Type: graphql.NewNonNull( graphql.String ) },
+        },
+    } )
+    labelInput := graphql.NewInputObject( graphql.InputObjectConfig{
+        Name: "LabelInput",
+        Fields: graphql.InputObjectConfigFieldMap{
+            "key": &graphql.InputObjectFieldConfig{ Type: graphql.NewNonNull( graphql.String ) },
+            "value": &graphql.InputObjectFieldConfig{ Type: graphql.NewNonNull( graphql.String ) },
+        },
+    } )
+    record := graphql.NewObject( graphql.ObjectConfig{
+        Name: "HashRecord",
+        Fields: graphql.Fields{
+            "id": &graphql.Field{ Type: graphql.NewNonNull( graphql.ID ) },
+            "status": &graphql.Field{ Type: graphql.NewNonNull( graphql.String ) },
+            "hash": &graphql.Field{ Type: graphql.String },
+            "algorithm": &graphql.Field{ Type: graphql.String },
+            "labels": &graphql.Field{ Type: graphql.NewNonNull( graphql.NewList( graphql.NewNonNull( label ) ) ) },
+            "createdAt": &graphql.Field{ Type: graphql.String },
+            "completedAt": &graphql.Field{ Type: graphql.String },
+            "latencyUs": &graphql.Field{ Type: graphql.Int },
+            "expiresAt": &graphql.Field{ Type: graphql.String },
+        },
+    } )
+    page := graphql.NewObject( graphql.ObjectConfig{
+        Name: "HashPage",
+        Fields: graphql.Fields{
+            "records": &graphql.Field{ Type: graphql.NewNonNull( graphql.NewList( graphql.NewNonNull( record ) ) ) },
+            "endCursor": &graphql.Field{ Type: graphql.ID },
+            "hasNextPage": &graphql.Field{ Type: graphql.NewNonNull( graphql.Boolean ) },
+        },
+    } )
+    stats := graphql.NewObject( graphql.ObjectConfig{
+        Name: "Stats",
+        Fields: graphql.Fields{
+            "total": &graphql.Field{ Type: graphql.NewNonNull( graphql.Int ) },
+            "average": &graphql.Field{ Type: graphql.NewNonNull( graphql.Int ) },
+            "slaViolations": &graphql.Field{ Type: graphql.NewNonNull( graphql.Int ) },
+            "expired": &graphql.Field{ Type: graphql.NewNonNull( graphql.Int ) },
+            "paused": &graphql.Field{ Type: graphql.NewNonNull( graphql.Boolean ) },
+        },
+    } )
+    submitResult := graphql.NewObject( graphql.ObjectConfig{
+        Name: "SubmitResult",
+        Fields: graphql.Fields{
+            "id": &graphql.Field{ Type: graphql.NewNonNull( graphql.ID ) },
+            "deduplicated": &graphql.Field{ Type: graphql.NewNonNull( graphql.Boolean ) },
+            "estimatedCompletion": &graphql.Field{ Type: graphql.String },
+        },
+    } )
+    service := graphql.NewObject( graphql.ObjectConfig{
+        Name: "_Service",
+        Fields: graphql.Fields{
+            "sdl": &graphql.Field{ Type: graphql.String },
+        },
+    } )
+    labelsArg := &graphql.ArgumentConfig{ Type: graphql.NewList( graphql.NewNonNull( labelInput ) ) }
+
+    query := graphql.NewObject( graphql.ObjectConfig{
+        Name: "Query",
+        Fields: graphql.Fields{
+            "hash": &graphql.Field{
+                Type: record,
+                Args: graphql.FieldConfigArgument{
+                    "id": &graphql.ArgumentConfig{ Type: graphql.NewNonNull( graphql.ID ) },
+                },
+                Resolve: s.resolveHash,
+            },
+            "hashes": &graphql.Field{
+                Type: graphql.NewNonNull( page ),
+                Args: graphql.FieldConfigArgument{
+                    "labels": labelsArg,
+                    "first": &graphql.ArgumentConfig{ Type: graphql.Int },
+                    "after": &graphql.ArgumentConfig{ Type: graphql.ID },
+                },
+                Resolve: s.resolveHashes,
+            },
+            "stats": &graphql.Field{
+                Type: graphql.NewNonNull( stats ),
+                Resolve: s.resolveStats,
+            },
+            "_service": &graphql.Field{
+                Type: graphql.NewNonNull( service ),
+                Resolve: func( p graphql.ResolveParams ) ( interface{}, error ) {
+                    return map[string]interface{}{ "sdl": graphqlSDL }, nil
+                },
+            },
+        },
+    } )
+    mutation := graphql.NewObject( graphql.ObjectConfig{
+        Name: "Mutation",
+        Fields: graphql.Fields{
+            "submitPassword": &graphql.Field{
+                Type: graphql.NewNonNull( submitResult ),
+                Args: graphql.FieldConfigArgument{
+                    "password": &graphql.ArgumentConfig{ Type: graphql.NewNonNull( graphql.String ) },
+                    "labels": labelsArg,
+                    "ttl": &graphql.ArgumentConfig{ Type: graphql.String },
+                },
+                Resolve: s.resolveSubmitPassword,
+            },
+        },
+    } )
+
+    schema, err := graphql.NewSchema( graphql.SchemaConfig{ Query: query, Mutation: mutation } )
+    if err != nil {
+        panic( err )
+    }
+    return schema
+}
+
+/********************************************************************
+handleGraphql()
+    Handles GraphQL requests on /graphql, POSTed as JSON
+    {"query":"...","variables":{...}}.
+********************************************************************/
+func ( s *Server ) handleGraphql( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /graphql" )
+
+    // Check shutdown
+    if s.shutDown {
+        s.log( r ).Info( "Server has been shut down!" )
+        writeError( w, http.StatusNotAcceptable, ErrorShuttingDown )
+        return
+    }
+
+    var request graphqlRequest
+    if err := json.NewDecoder( r.Body ).Decode( &request ); err != nil {
+        s.log( r ).Info( "Invalid GraphQL request", "error", jsonError( err ) )
+        writeError( w, http.StatusBadRequest, ErrorInvalidRequest )
+        return
+    }
+
+    // Lock the shutdown mutex to ensure the server doesn't
+    // shut down while processing this request
+    s.shutdownMutex.RLock()
+    defer s.shutdownMutex.RUnlock()
+
+    result := graphql.Do( graphql.Params{
+        Schema: s.graphqlSchema,
+        RequestString: request.Query,
+        OperationName: request.OperationName,
+        VariableValues: request.Variables,
+        Context: r.Context(),
+        RootObject: map[string]interface{}{ "request": r },
+    } )
+    redactGraphqlErrors( r, request, result )
+
+    w.Header().Set( "Content-Type", "application/json" )
+    json.NewEncoder(w).Encode(result)
+}
+
+/********************************************************************
+graphqlLiterals()
+    Returns the string literals and variables of a GraphQL request,
+    any of which can be a password.
+********************************************************************/
+func graphqlLiterals( request graphqlRequest ) *secrets {
+    literals := &secrets{}
+    lex := lexer.Lex( source.NewSource( &source.Source{ Body: []byte( request.Query ) } ) )
+    for {
+        token, err := lex( 0 )
+        if err != nil || token.Kind == lexer.EOF {
+            break
+        }
+        if token.Kind == lexer.STRING || token.Kind == lexer.BLOCK_STRING {
+            literals.add( token.Value )
+        }
+    }
+
+    var addValue func( value interface{} )
+    addValue = func( value interface{} ) {
+        switch value := value.( type ) {
+        case string:
+            literals.add( value )
+        case map[string]interface{}:
+            for _, member := range value {
+                addValue( member )
+            }
+        case []interface{}:
+            for _, member := range value {
+                addValue( member )
+            }
+        }
+    }
+    addValue( request.Variables )
+    return literals
+}
+
+/********************************************************************
+redactGraphqlErrors()
+    Scrubs the submitted passwords from the errors of a GraphQL
+    result. Errors without a path are from parsing and validating the
+    query, which can quote any of its values, so those are scrubbed
+    of every literal, and syntax errors lose the excerpt of the query
+    they end with, which can quote a password the lexer couldn't read.
+********************************************************************/
+func redactGraphqlErrors( r *http.Request, request graphqlRequest, result *graphql.Result ) {
+    passwords := requestSecrets( r.Context() )
+    var literals *secrets
+    for i, err := range result.Errors {
+        message := passwords.scrub( err.Message )
+        if len( err.Path ) == 0 {
+            if literals == nil {
+                literals = graphqlLiterals( request )
+            }
+            message, _, _ = strings.Cut( literals.scrub( message ), "\n\n" )
+        }
+        result.Errors[ i ].Message = message
+    }
+}
+
+/********************************************************************
+graphqlRecord()
+    Converts a password id into a HashRecord, nil if it was never
+    submitted.
+********************************************************************/
+func ( s *Server ) graphqlRecord( ctx context.Context, id int64 ) ( map[string]interface{}, error ) {
+    record, job, state, gone, err := s.lookupHash( id )
+    if err != nil {
+        return nil, s.storeFailed( ctx, err )
+    }
+    result := map[string]interface{}{ "id": strconv.FormatInt( id, 10 ), "labels": []interface{}{} }
+    switch {
+    case gone != "":
+        result[ "status" ] = gone
+    case record != nil:
+        result[ "status" ] = StatusDone
+        result[ "hash" ] = record.Hash
+        result[ "algorithm" ] = record.Algorithm
+        result[ "labels" ] = graphqlLabels( record.Labels )
+        result[ "createdAt" ] = record.CreatedAt.Format( time.RFC3339Nano )
+        result[ "completedAt" ] = record.CompletedAt.Format( time.RFC3339Nano )
+        result[ "latencyUs" ] = record.LatencyUs
+        if record.ExpiresAt != nil {
+            result[ "expiresAt" ] = record.ExpiresAt.Format( time.RFC3339Nano )
+        }
+    case job != nil:
+        result[ "status" ] = state
+        result[ "labels" ] = graphqlLabels( job.labels )
+        result[ "createdAt" ] = job.startTime.Format( time.RFC3339Nano )
+    default:
+        return nil, nil
+    }
+    return result, nil
+}
+
+/********************************************************************
+graphqlLabels()
+    Converts labels into a list of Label objects sorted by key.
+********************************************************************/
+func graphqlLabels( labels map[string]string ) []interface{} {
+    keys := make([]string, 0, len( labels ))
+    for key := range labels {
+        keys = append( keys, key )
+    }
+    sort.Strings( keys )
+
+    result := make([]interface{}, 0, len( keys ))
+    for _, key := range keys {
+        result = append( result, map[string]interface{}{ "key": key, "value": labels[ key ] } )
+    }
+    return result
+}
+
+/********************************************************************
+graphqlLabelArg()
+    Converts a [LabelInput!] argument into a labels map.
+********************************************************************/
+func graphqlLabelArg( arg interface{} ) ( map[string]string, error ) {
+    list, _ := arg.([]interface{})
+    if len( list ) == 0 {
+        return nil, nil
+    }
+
+    labels := make(map[string]string, len( list ))
+    for _, item := range list {
+        input, _ := item.(map[string]interface{})
+        key, _ := input[ "key" ].(string)
+        value, _ := input[ "value" ].(string)
+        if key == "" {
+            return nil, fmt.Errorf( "invalid label, empty key" )
+        }
+        labels[ key ] = value
+    }
+    return labels, nil
+}
+
+/********************************************************************
+graphqlIdArg()
+    Parses an ID argument as a password id.
+********************************************************************/
+func graphqlIdArg( arg interface{} ) ( int64, error ) {
+    text, _ := arg.(string)
+    id, err := strconv.ParseInt( text, 10, 64 )
+    if err != nil || id <= 0 {
+        return 0, fmt.Errorf( "invalid id %q", text )
+    }
+    return id, nil
+}
+
+/********************************************************************
+resolveHash()
+    Resolves the hash(id) query.
+********************************************************************/
+func ( s *Server ) resolveHash( p graphql.ResolveParams ) ( interface{}, error ) {
+    id, err := graphqlIdArg( p.Args[ "id" ] )
+    if err != nil {
+        return nil, err
+    }
+    record, err := s.graphqlRecord( p.Context, id )
+    if record == nil {
+        return nil, err
+    }
+    return record, nil
+}
+
+/********************************************************************
+resolveHashes()
+    Resolves the hashes(labels, first, after) query, a page of the
+    hashed passwords matching every label, in id order. "after" is
+    the endCursor of the previous page.
+********************************************************************/
+func ( s *Server ) resolveHashes( p graphql.ResolveParams ) ( interface{}, error ) {
+    filter, err := graphqlLabelArg( p.Args[ "labels" ] )
+    if err != nil {
+        return nil, err
+    }
+
+    first := graphqlDefaultPage
+    if value, ok := p.Args[ "first" ].(int); ok {
+        first = value
+    }
+    if first <= 0 || first > bulkMaxIds {
+        return nil, fmt.Errorf( "first must be between 1 and %d", bulkMaxIds )
+    }
+
+    var after int64
+    if arg, ok := p.Args[ "after" ]; ok && arg != nil {
+        if after, err = graphqlIdArg( arg ); err != nil {
+            return nil, err
+        }
+    }
+
+    // Collect the ids matching every label in the filter
+    stored, err := s.store.List()
+    if err != nil {
+        return nil, s.storeFailed( p.Context, err )
+    }
+    ids := []int64{}
+    for _, record := range stored {
+        if record.Id > after && record.DeletedAt == nil && matchLabels( record.Labels, filter ) {
+            ids = append( ids, record.Id )
+        }
+    }
+
+    hasNextPage := len( ids ) > first
+    if hasNextPage {
+        ids = ids[ :first ]
+    }
+
+    records := make([]interface{}, 0, len( ids ))
+    for _, id := range ids {
+        record, err := s.graphqlRecord( p.Context, id )
+        if err != nil {
+            return nil, err
+        }
+        if record != nil {
+            records = append( records, record )
+        }
+    }
+
+    result := map[string]interface{}{ "records": records, "hasNextPage": hasNextPage }
+    if len( ids ) > 0 {
+        result[ "endCursor" ] = strconv.FormatInt( ids[ len( ids ) - 1 ], 10 )
+    }
+    return result, nil
+}
+
+/********************************************************************
+resolveStats()
+    Resolves the stats query, all zero before any are hashed. Like
+    /stats it needs the admin token once one is configured, signed
+    URLs only being issued for /stats.
+********************************************************************/
+func ( s *Server ) resolveStats( p graphql.ResolveParams ) ( interface{}, error ) {
+    r := p.Info.RootValue.(map[string]interface{})[ "request" ].(*http.Request)
+    if !s.isAdmin( r ) {
+        s.log( r ).Info( "Missing or invalid admin token!" )
+        s.auditRequest( r, AuditAuthFailure, "invalid admin token" )
+        return nil, fmt.Errorf( "stats need the admin token" )
+    }
+
+    stats, _ := s.collectStats()
+    return map[string]interface{}{
+        "total": stats.Total,
+        "average": stats.Average,
+        "slaViolations": stats.SlaViolations,
+        "expired": stats.Expired,
+        "paused": s.isPaused(),
+    }, nil
+}
+
+/********************************************************************
+resolveSubmitPassword()
+    Resolves the submitPassword mutation, queuing a password for
+    hashing like POST /hash.
+********************************************************************/
+func ( s *Server ) resolveSubmitPassword( p graphql.ResolveParams ) ( interface{}, error ) {
+    r := p.Info.RootValue.(map[string]interface{})[ "request" ].(*http.Request)
+    password, _ := p.Args[ "password" ].(string)
+    redactPassword( r, password )
+    if password == "" && !s.config.AllowEmptyPassword {
+        return nil, fmt.Errorf( "missing password" )
+    }
+    labels, err := graphqlLabelArg( p.Args[ "labels" ] )
+    if err != nil {
+        return nil, err
+    }
+    ttlArg, _ := p.Args[ "ttl" ].(string)
+    ttl, err := parseTtl( ttlArg )
+    if err != nil {
+        return nil, err
+    }
+    if s.config.MaxPendingJobs > 0 && s.pendingJobCount() >= s.config.MaxPendingJobs {
+        return nil, fmt.Errorf( "too many pending passwords" )
+    }
+
+    startTime := s.clock.Now()
+    id, deduplicated, err := s.allocateId( password )
+    if err != nil {
+        return nil, s.storeFailed( r.Context(), err )
+    }
+    if !deduplicated {
+        err := s.queueJob( r.Context(), &hashJob{
+            id: id,
+            password: secret( password ),
+            labels: labels,
+            startTime: startTime,
+            ttl: ttl,
+            provenance: newProvenance( r, startTime ),
+        } )
+        if err != nil {
+            return nil, s.storeFailed( r.Context(), err )
+        }
+    }
+
+    result := map[string]interface{}{ "id": strconv.FormatInt( id, 10 ), "deduplicated": deduplicated }
+    if dueAt := s.estimatedCompletion( id ); !dueAt.IsZero() {
+        result[ "estimatedCompletion" ] = dueAt.Format( time.RFC3339Nano )
+    }
+    return result, nil
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+    "context"
+    "net"
+    "os"
+    "strconv"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/peer"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/timestamppb"
+
+    "jumpcloud_password_hash/hashpb"
+)
+
+// gRPC HashService implementation over the same state as the HTTP API
+type hashService struct {
+    hashpb.UnimplementedHashServiceServer
+    server *Server
+}
+
+/********************************************************************
+serveGrpc()
+    Runs the gRPC HashService on the given port.
+********************************************************************/
+func ( s *Server ) serveGrpc( port int ) {
+    listener, err := net.Listen( "tcp", ":" + strconv.Itoa( port ) )
+    if err != nil {
+        s.logger.Error( "Unable to listen for gRPC!", "error", err )
+        os.Exit( 1 )
+    }
+
+    s.grpcMutex.Lock()
+    s.grpcServer = grpc.NewServer()
+    hashpb.RegisterHashServiceServer( s.grpcServer, &hashService{ server: s } )
+    server := s.grpcServer
+    s.grpcMutex.Unlock()
+
+    s.logger.Info( "Starting gRPC server!", "port", port )
+    if err := server.Serve( listener ); err != nil {
+        s.logError( "gRPC server stopped: %v", err )
+    }
+}
+
+/********************************************************************
+stopGrpc()
+    Gracefully stops the gRPC server, if it is running.
+********************************************************************/
+func ( s *Server ) stopGrpc() {
+    s.grpcMutex.Lock()
+    server := s.grpcServer
+    s.grpcMutex.Unlock()
+
+    if server != nil {
+        server.GracefulStop()
+    }
+}
+
+/********************************************************************
+SubmitPassword()
+    Queues a password for hashing, like POST /hash.
+********************************************************************/
+func ( h *hashService ) SubmitPassword( ctx context.Context, request *hashpb.SubmitPasswordRequest ) ( *hashpb.SubmitPasswordResponse, error ) {
+    h.server.logger.Debug( "gRPC: SubmitPassword" )
+
+    h.server.shutdownMutex.RLock()
+    defer h.server.shutdownMutex.RUnlock()
+    if h.server.shutDown {
+        return nil, status.Error( codes.Unavailable, "server is shutting down" )
+    }
+
+    if request.Password == "" && !h.server.config.AllowEmptyPassword {
+        return nil, status.Error( codes.InvalidArgument, "missing password" )
+    }
+    for key := range request.Labels {
+        if key == "" {
+            return nil, status.Error( codes.InvalidArgument, "invalid label, empty key" )
+        }
+    }
+    ttl, err := parseTtl( request.Ttl )
+    if err != nil {
+        return nil, status.Error( codes.InvalidArgument, err.Error() )
+    }
+    if h.server.config.MaxPendingJobs > 0 && h.server.pendingJobCount() >= h.server.config.MaxPendingJobs {
+        return nil, status.Error( codes.ResourceExhausted, "too many pending passwords" )
+    }
+
+    startTime := h.server.clock.Now()
+    id, deduplicated, err := h.server.allocateId( request.Password )
+    if err != nil {
+        return nil, status.Error( codes.Unavailable, h.server.storeFailed( ctx, err ).Error() )
+    }
+    if !deduplicated {
+        provenance := &Provenance{ RequestId: newRequestId(), SubmittedAt: startTime, UserAgent: "grpc" }
+        if client, ok := peer.FromContext( ctx ); ok {
+            provenance.ClientIp, _, _ = net.SplitHostPort( client.Addr.String() )
+        }
+        err := h.server.queueJob( ctx, &hashJob{
+            id: id,
+            password: secret( request.Password ),
+            labels: request.Labels,
+            startTime: startTime,
+            ttl: ttl,
+            provenance: provenance,
+        } )
+        if err != nil {
+            return nil, status.Error( codes.Unavailable, h.server.storeFailed( ctx, err ).Error() )
+        }
+    }
+
+    response := &hashpb.SubmitPasswordResponse{ Id: id, Deduplicated: deduplicated }
+    if dueAt := h.server.estimatedCompletion( id ); !dueAt.IsZero() {
+        response.EstimatedCompletion = timestamppb.New( dueAt )
+    }
+    return response, nil
+}
+
+/********************************************************************
+GetHash()
+    Returns the state of a password id and, once done, its hash.
+********************************************************************/
+func ( h *hashService ) GetHash( ctx context.Context, request *hashpb.GetHashRequest ) ( *hashpb.GetHashResponse, error ) {
+    h.server.logger.Debug( "gRPC: GetHash" )
+
+    entry, err := h.server.hashStatus( request.Id )
+    if err != nil {
+        return nil, status.Error( codes.Unavailable, h.server.storeFailed( ctx, err ).Error() )
+    }
+    if entry.Status == "not_found" {
+        return nil, status.Error( codes.NotFound, "password id not found" )
+    }
+    return &hashpb.GetHashResponse{ Id: request.Id, Status: entry.Status, Hash: entry.Hash }, nil
+}
+
+/********************************************************************
+GetStats()
+    Returns the total number of passwords hashed and the average
+    time taken in microseconds, zero before any are hashed. Like
+    /stats it needs the admin token, as "authorization" metadata,
+    once one is configured.
+********************************************************************/
+func ( h *hashService ) GetStats( ctx context.Context, request *hashpb.GetStatsRequest ) ( *hashpb.GetStatsResponse, error ) {
+    h.server.logger.Debug( "gRPC: GetStats" )
+
+    var authorization string
+    if md, ok := metadata.FromIncomingContext( ctx ); ok && len( md.Get( "authorization" ) ) > 0 {
+        authorization = md.Get( "authorization" )[ 0 ]
+    }
+    if !h.server.isAdminAuthorization( authorization ) {
+        h.server.auditGrpc( ctx, AuditAuthFailure, "invalid admin token" )
+        return nil, status.Error( codes.Unauthenticated, "missing or invalid admin token" )
+    }
+
+    stats, _ := h.server.collectStats()
+    return &hashpb.GetStatsResponse{ Total: stats.Total, Average: stats.Average }, nil
+}
+
+/********************************************************************
+Shutdown()
+    Gracefully shuts the server down, like /shutdown this needs a
+    one-time token from /admin/shutdown-token.
+********************************************************************/
+func ( h *hashService ) Shutdown( ctx context.Context, request *hashpb.ShutdownRequest ) ( *hashpb.ShutdownResponse, error ) {
+    h.server.logger.Debug( "gRPC: Shutdown" )
+
+    if !h.server.redeemShutdownToken( request.Token ) {
+        h.server.auditGrpc( ctx, AuditAuthFailure, "invalid shutdown token" )
+        return nil, status.Error( codes.PermissionDenied, "missing, expired or already used shutdown token" )
+    }
+
+    h.server.shutdownMutex.Lock()
+    defer h.server.shutdownMutex.Unlock()
+    h.server.auditGrpc( ctx, AuditShutdown, "" )
+    h.server.startShutdown()
+
+    return &hashpb.ShutdownResponse{}, nil
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+    "fmt"
+    "net/http"
+)
+
+// Response to the health probes, with the result of every check,
+// "ok" or why it failed
+type HealthResponse struct {
+    Status string `json:"status"`
+    Checks map[string]string `json:"checks,omitempty"`
+
+    // Checks that failed without failing the probe
+    Warnings map[string]string `json:"warnings,omitempty"`
+}
+
+// Health check, returning why the server is unhealthy or "" if it
+// isn't. When warnOnly returns true a failure is only a warning
+type healthCheck struct {
+    name string
+    check func( s *Server ) string
+    warnOnly func( s *Server ) bool
+}
+
+var (
+    // Checks of the startup probe
+    startupChecks = []healthCheck{
+        { "started", checkStarted, nil },
+    }
+
+    // Checks of the readiness probe, failing while the server
+    // shouldn't be sent new passwords
+    readinessChecks = []healthCheck{
+        { "started", checkStarted, nil },
+        { "shutdown", checkShutdown, nil },
+        { "storage", checkStorage, nil },
+        { "integrity", checkIntegrity, integrityWarnOnly },
+        { "queue", checkQueue, nil },
+    }
+)
+
+/********************************************************************
+checkStarted()
+    Fails until ListenAndServe() has started the background workers,
+    like the reaper of expired hashes.
+********************************************************************/
+func checkStarted( s *Server ) string {
+    select {
+    case <-s.serving:
+        return ""
+    default:
+        return "starting"
+    }
+}
+
+/********************************************************************
+checkShutdown()
+    Fails once the server is shutting down, so traffic moves to other
+    instances while pending passwords are hashed.
+********************************************************************/
+func checkShutdown( s *Server ) string {
+    if s.shutDown {
+        return "shutting down"
+    }
+    return ""
+}
+
+/********************************************************************
+checkStorage()
+    Fails when the store of the hashed passwords can't be reached.
+********************************************************************/
+func checkStorage( s *Server ) string {
+    var err error
+    if pinger, ok := s.store.( PingStore ); ok {
+        err = pinger.Ping()
+    } else {
+        _, err = s.store.Count()
+    }
+    if err != nil {
+        return err.Error()
+    }
+    return ""
+}
+
+/********************************************************************
+checkQueue()
+    Fails while the pending passwords reach ReadyQueueThreshold, or
+    MaxPendingJobs when no threshold is set, so new passwords go to
+    instances that will accept them.
+********************************************************************/
+func checkQueue( s *Server ) string {
+    threshold := s.config.ReadyQueueThreshold
+    if threshold <= 0 {
+        threshold = s.config.MaxPendingJobs
+    }
+    if threshold <= 0 {
+        return ""
+    }
+    if pending := s.pendingJobCount(); pending >= threshold {
+        return fmt.Sprintf( "%d pending passwords, threshold %d", pending, threshold )
+    }
+    return ""
+}
+
+/********************************************************************
+writeHealth()
+    Runs the checks and responds 200 OK if all pass, otherwise 503
+    Service Unavailable. Probes aren't logged, they would drown out
+    the other requests.
+********************************************************************/
+func ( s *Server ) writeHealth( w http.ResponseWriter, r *http.Request, checks []healthCheck ) {
+    response := HealthResponse{ Status: "ok", Checks: make(map[string]string, len( checks )) }
+    status := http.StatusOK
+    for _, check := range checks {
+        result := check.check( s )
+        if result == "" {
+            response.Checks[ check.name ] = "ok"
+            continue
+        }
+        if check.warnOnly != nil && check.warnOnly( s ) {
+            response.Checks[ check.name ] = "ok"
+            if response.Warnings == nil {
+                response.Warnings = make(map[string]string)
+            }
+            response.Warnings[ check.name ] = result
+            continue
+        }
+        response.Checks[ check.name ] = result
+        response.Status = "unavailable"
+        status = http.StatusServiceUnavailable
+    }
+
+    s.writeEncoded( w, r, status, response )
+}
+
+/********************************************************************
+handleLivez()
+    Handles GET requests on /livez, the liveness probe. Answers 200 OK
+    as long as the process serves requests, even while shutting down,
+    so the orchestrator doesn't kill it before pending passwords are
+    hashed.
+********************************************************************/
+func ( s *Server ) handleLivez( w http.ResponseWriter, r *http.Request ) {
+    s.writeHealth( w, r, nil )
+}
+
+/********************************************************************
+handleStartupz()
+    Handles GET requests on /startupz, the startup probe.
+********************************************************************/
+func ( s *Server ) handleStartupz( w http.ResponseWriter, r *http.Request ) {
+    s.writeHealth( w, r, startupChecks )
+}
+
+/********************************************************************
+handleReadyz()
+    Handles GET requests on /readyz and /healthz, the readiness probe.
+********************************************************************/
+func ( s *Server ) handleReadyz( w http.ResponseWriter, r *http.Request ) {
+    s.writeHealth( w, r, readinessChecks )
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+    "errors"
+    "time"
+)
+
+var (
+    errIdempotencyMismatch = errors.New( "Idempotency-Key reused with a different password" )
+)
+
+// Original outcome of a POST /hash made with an Idempotency-Key
+type idempotencyEntry struct {
+    id int64
+    deduplicated bool
+    fingerprint string
+    expiresAt time.Time
+}
+
+/********************************************************************
+allocateIdempotent()
+    Allocates the id for a submission carrying an Idempotency-Key.
+    A replay of the key within the window returns the original id
+    with replayed set, so the job isn't queued again. Reusing a key
+    for a different password is an error.
+********************************************************************/
+func ( s *Server ) allocateIdempotent( key string, password string ) ( id int64, deduplicated bool, replayed bool, err error ) {
+    fingerprint := hashPassword( password )
+
+    s.idempotencyMutex.Lock()
+    defer s.idempotencyMutex.Unlock()
+
+    if entry := s.idempotencyKeys[ key ]; entry != nil && s.clock.Now().Before( entry.expiresAt ) {
+        if entry.fingerprint != fingerprint {
+            return 0, false, false, errIdempotencyMismatch
+        }
+        return entry.id, entry.deduplicated, true, nil
+    }
+
+    if id, deduplicated, err = s.allocateId( password ); err != nil {
+        return 0, false, false, err
+    }
+    s.idempotencyKeys[ key ] = &idempotencyEntry{
+        id: id,
+        deduplicated: deduplicated,
+        fingerprint: fingerprint,
+        expiresAt: s.clock.Now().Add( s.config.IdempotencyWindow ),
+    }
+    return id, deduplicated, false, nil
+}
+
+/********************************************************************
+reapIdempotencyKeys()
+    Forgets Idempotency-Keys whose replay window has passed, returning
+    how many were, and compacts the map of the keys once it shrank,
+    returning 1 if it was.
+********************************************************************/
+func ( s *Server ) reapIdempotencyKeys( now time.Time ) ( reaped int64, compactions int64 ) {
+    s.idempotencyMutex.Lock()
+    defer s.idempotencyMutex.Unlock()
+
+    for key, entry := range s.idempotencyKeys {
+        if !now.Before( entry.expiresAt ) {
+            delete( s.idempotencyKeys, key )
+            reaped++
+        }
+    }
+
+    var compacted bool
+    if s.idempotencyKeys, compacted = compactMap( s.idempotencyKeys, &s.gcPeaks.idempotencyKeys ); compacted {
+        compactions++
+    }
+    return reaped, compactions
+}
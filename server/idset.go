@@ -0,0 +1,129 @@
+package server
+
+import "sort"
+
+// Most ranges kept of the expired, evicted and failed ids, about 16
+// bytes each. Beyond, the lowest are forgotten and answer 404 Not
+// Found rather than 410 Gone
+const tombstoneMaxRanges = 100000
+
+// Consecutive ids from First to Last, saved as [first, last]
+type idRange [2]int64
+
+// Set of ids kept as sorted, disjoint ranges, so a run of consecutive
+// ids, like those evicted or expired in submission order, takes the
+// memory of one. With a max, only the ranges of the highest ids are
+// kept, 0 keeps them all. It is guarded by the mutex of its owner
+type idSet struct {
+    ranges []idRange
+    max int
+}
+
+/********************************************************************
+newIdSet()
+    Creates an empty set keeping at most max ranges, all with 0.
+********************************************************************/
+func newIdSet( max int ) *idSet {
+    return &idSet{ max: max }
+}
+
+/********************************************************************
+search()
+    Returns the index of the first range ending at or after id.
+********************************************************************/
+func ( s *idSet ) search( id int64 ) int {
+    return sort.Search( len( s.ranges ), func( i int ) bool { return s.ranges[ i ][ 1 ] >= id } )
+}
+
+/********************************************************************
+has()
+    Returns true if the set holds id.
+********************************************************************/
+func ( s *idSet ) has( id int64 ) bool {
+    i := s.search( id )
+    return i < len( s.ranges ) && s.ranges[ i ][ 0 ] <= id
+}
+
+/********************************************************************
+add()
+    Adds id to the set, joining it to the ranges next to it, then
+    forgets the lowest ranges over the max.
+********************************************************************/
+func ( s *idSet ) add( id int64 ) {
+    i := s.search( id )
+    if i < len( s.ranges ) && s.ranges[ i ][ 0 ] <= id {
+        return
+    }
+
+    joinsBelow := i > 0 && s.ranges[ i - 1 ][ 1 ] == id - 1
+    joinsAbove := i < len( s.ranges ) && s.ranges[ i ][ 0 ] == id + 1
+    switch {
+    case joinsBelow && joinsAbove:
+        s.ranges[ i - 1 ][ 1 ] = s.ranges[ i ][ 1 ]
+        s.ranges = append( s.ranges[ :i ], s.ranges[ i + 1: ]... )
+    case joinsBelow:
+        s.ranges[ i - 1 ][ 1 ] = id
+    case joinsAbove:
+        s.ranges[ i ][ 0 ] = id
+    default:
+        s.ranges = append( s.ranges, idRange{} )
+        copy( s.ranges[ i + 1: ], s.ranges[ i: ] )
+        s.ranges[ i ] = idRange{ id, id }
+    }
+
+    if s.max > 0 && len( s.ranges ) > s.max {
+        s.ranges = append( s.ranges[ :0 ], s.ranges[ len( s.ranges ) - s.max: ]... )
+    }
+}
+
+/********************************************************************
+remove()
+    Removes id from the set, splitting its range if need be.
+********************************************************************/
+func ( s *idSet ) remove( id int64 ) {
+    i := s.search( id )
+    if i == len( s.ranges ) || s.ranges[ i ][ 0 ] > id {
+        return
+    }
+
+    first, last := s.ranges[ i ][ 0 ], s.ranges[ i ][ 1 ]
+    switch {
+    case first == last:
+        s.ranges = append( s.ranges[ :i ], s.ranges[ i + 1: ]... )
+    case id == first:
+        s.ranges[ i ][ 0 ] = id + 1
+    case id == last:
+        s.ranges[ i ][ 1 ] = id - 1
+    default:
+        s.ranges[ i ][ 1 ] = id - 1
+        s.ranges = append( s.ranges, idRange{} )
+        copy( s.ranges[ i + 2: ], s.ranges[ i + 1: ] )
+        s.ranges[ i + 1 ] = idRange{ id + 1, last }
+    }
+}
+
+/********************************************************************
+addRange()
+    Adds the ids of a saved range to the set. Ranges above those of
+    the set, as saved, are appended whole.
+********************************************************************/
+func ( s *idSet ) addRange( r idRange ) {
+    if last := len( s.ranges ) - 1; last < 0 || s.ranges[ last ][ 1 ] < r[ 0 ] - 1 {
+        s.ranges = append( s.ranges, r )
+        if s.max > 0 && len( s.ranges ) > s.max {
+            s.ranges = append( s.ranges[ :0 ], s.ranges[ len( s.ranges ) - s.max: ]... )
+        }
+        return
+    }
+    for id := r[ 0 ]; id <= r[ 1 ]; id++ {
+        s.add( id )
+    }
+}
+
+/********************************************************************
+savedRanges()
+    Returns a copy of the ranges, to be saved.
+********************************************************************/
+func ( s *idSet ) savedRanges() []idRange {
+    return append( []idRange( nil ), s.ranges... )
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+    "net/http"
+    "reflect"
+    "testing"
+    "time"
+)
+
+// Ids join the ranges next to them, split them when removed, and only
+// the highest ranges are kept over the max
+func TestIdSet( t *testing.T ) {
+    set := newIdSet( 3 )
+    for _, id := range []int64{ 1, 2, 4, 3, 7, 9, 12 } {
+        set.add( id )
+    }
+    if want := []idRange{ { 7, 7 }, { 9, 9 }, { 12, 12 } }; !reflect.DeepEqual( set.ranges, want ) {
+        t.Errorf( "after adding: got %v, want %v", set.ranges, want )
+    }
+    if set.has( 1 ) || !set.has( 9 ) || set.has( 10 ) {
+        t.Errorf( "has: got 1 %t, 9 %t, 10 %t, want false, true, false", set.has( 1 ), set.has( 9 ), set.has( 10 ) )
+    }
+
+    set = newIdSet( 0 )
+    for id := int64( 1 ); id <= 5; id++ {
+        set.add( id )
+    }
+    set.remove( 3 )
+    set.remove( 5 )
+    set.remove( 8 )
+    if want := []idRange{ { 1, 2 }, { 4, 4 } }; !reflect.DeepEqual( set.ranges, want ) {
+        t.Errorf( "after removing: got %v, want %v", set.ranges, want )
+    }
+    set.add( 3 )
+    if want := []idRange{ { 1, 4 } }; !reflect.DeepEqual( set.ranges, want ) {
+        t.Errorf( "after adding back: got %v, want %v", set.ranges, want )
+    }
+}
+
+// Records evicted in order with -max-records take one range, not an
+// entry each, and still answer 410 Gone
+func TestEvictedIdsBounded( t *testing.T ) {
+    config := DefaultConfig()
+    config.MaxRecords = 2
+    config.EvictionPolicy = EvictOldest
+    s, handler := newTestServer( t, 0, WithConfig( config ) )
+
+    var last int64
+    for i := 0; i < 20; i++ {
+        last = postPassword( t, handler, "angryMonkey" )
+        waitHashed( t, handler, last, 2 * time.Second )
+    }
+
+    s.mapMutex.Lock()
+    ranges := s.evictedIds.savedRanges()
+    s.mapMutex.Unlock()
+    if want := []idRange{ { 1, last - 2 } }; !reflect.DeepEqual( ranges, want ) {
+        t.Errorf( "evicted ranges: got %v, want %v", ranges, want )
+    }
+    if response := getHash( handler, 1 ); response.Code != http.StatusGone {
+        t.Errorf( "GET /v1/hash/1: got %d, want 410", response.Code )
+    }
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+    "bufio"
+    "bytes"
+    "compress/gzip"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "strconv"
+)
+
+// What POST /admin/import does with a record whose id is taken
+const (
+    ImportConflictFail = "fail"
+    ImportConflictSkip = "skip"
+    ImportConflictOverwrite = "overwrite"
+)
+
+// Most conflicting ids listed in a 409 Conflict response
+const importMaxConflicts = 100
+
+// Response to POST /admin/import
+type ImportResult struct {
+    // Records stored, and those already stored exactly as in the dump
+    Imported int `json:"imported"`
+    Unchanged int `json:"unchanged"`
+
+    // Conflicting records left as they were with on_conflict=skip, or
+    // pending, and those replaced with on_conflict=overwrite
+    Skipped int `json:"skipped,omitempty"`
+    Overwritten int `json:"overwritten,omitempty"`
+
+    // Last allocated id once imported, new passwords get later ones
+    LastId int64 `json:"last_id"`
+}
+
+/********************************************************************
+ReadDump()
+    Reads the records of an export dump, JSON lines optionally
+    gzipped, which is detected from its first bytes, failing on a
+    record that doesn't match its checksum.
+********************************************************************/
+func ReadDump( body io.Reader ) ( []*Record, error ) {
+    reader := bufio.NewReader( body )
+    if magic, _ := reader.Peek( 2 ); bytes.Equal( magic, []byte{ 0x1f, 0x8b } ) {
+        gz, err := gzip.NewReader( reader )
+        if err != nil {
+            return nil, err
+        }
+        defer gz.Close()
+        reader = bufio.NewReader( gz )
+    }
+
+    var records []*Record
+    scanner := bufio.NewScanner( reader )
+    scanner.Buffer( nil, 1024 * 1024 )
+    for line := 1; scanner.Scan(); line++ {
+        if len( bytes.TrimSpace( scanner.Bytes() ) ) == 0 {
+            continue
+        }
+        record, err := decodeRecord( scanner.Bytes() )
+        if err != nil {
+            return nil, fmt.Errorf( "line %d: %w", line, err )
+        }
+        if record.Id <= 0 || record.Hash == "" {
+            return nil, fmt.Errorf( "line %d: record without an id or hash", line )
+        }
+        if err := verifyRecord( record ); err != nil {
+            return nil, fmt.Errorf( "line %d: %w", line, err )
+        }
+        records = append( records, record )
+    }
+    return records, scanner.Err()
+}
+
+/********************************************************************
+importConflict()
+    Returns why a record can't be imported under its id, empty if it
+    can, and whether the same record is already stored. Must be called
+    with mapMutex held.
+********************************************************************/
+func ( s *Server ) importConflict( record *Record ) ( conflict string, unchanged bool, err error ) {
+    if s.pendingJobs[ record.Id ] != nil {
+        return fmt.Sprintf( "id %d is waiting to be hashed", record.Id ), false, nil
+    }
+    if s.expiredIds.has( record.Id ) {
+        return fmt.Sprintf( "id %d has expired", record.Id ), false, nil
+    }
+    if s.evictedIds.has( record.Id ) {
+        return fmt.Sprintf( "id %d was evicted", record.Id ), false, nil
+    }
+    if s.purgedIds.has( record.Id ) {
+        return fmt.Sprintf( "id %d was purged", record.Id ), false, nil
+    }
+
+    existing, err := s.getRecord( record.Id )
+    if err != nil || existing == nil {
+        return "", false, err
+    }
+    existingData, err := encodeRecord( existing )
+    if err != nil {
+        return "", false, err
+    }
+    data, err := encodeRecord( record )
+    if err != nil {
+        return "", false, err
+    }
+    if bytes.Equal( existingData, data ) {
+        return "", true, nil
+    }
+    return fmt.Sprintf( "id %d is stored with another record", record.Id ), false, nil
+}
+
+/********************************************************************
+handleImport()
+    Handles POST requests on /admin/import, storing the records of a
+    GET /admin/export dump under their original ids and moving the id
+    sequence past them. A record whose id is taken by another record,
+    a pending password, an expired, evicted or purged one is a
+    conflict: by default nothing is imported and the conflicts are
+    listed in a 409, with "on_conflict=skip" they are left as they
+    are, with "on_conflict=overwrite" replaced, except pending
+    passwords, which are skipped. Records identical to the stored
+    ones are left alone, so a dump can be imported again.
+********************************************************************/
+func ( s *Server ) handleImport( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /admin/import" )
+
+    onConflict := r.URL.Query().Get( "on_conflict" )
+    switch onConflict {
+    case "":
+        onConflict = ImportConflictFail
+    case ImportConflictFail, ImportConflictSkip, ImportConflictOverwrite:
+    default:
+        writeFieldErrors( w, invalidField( "on_conflict", fmt.Sprintf( "invalid on_conflict %q, expected fail, skip or overwrite", onConflict ) ) )
+        return
+    }
+
+    records, err := ReadDump( r.Body )
+    if err != nil {
+        s.log( r ).Info( "Invalid import dump", "error", err )
+        writeFieldErrors( w, invalidField( "body", err.Error() ) )
+        return
+    }
+
+    s.mapMutex.Lock()
+
+    // Check every record before storing any, so a conflict leaves the
+    // store as it was
+    var result ImportResult
+    var conflicts []FieldError
+    importing := make([]*Record, 0, len( records ))
+    for _, record := range records {
+        conflict, unchanged, err := s.importConflict( record )
+        if err != nil {
+            s.mapMutex.Unlock()
+            s.writeStoreError( w, r, err )
+            return
+        }
+        switch {
+        case unchanged:
+            result.Unchanged++
+        case conflict == "":
+            importing = append( importing, record )
+        case onConflict == ImportConflictSkip, s.pendingJobs[ record.Id ] != nil && onConflict == ImportConflictOverwrite:
+            result.Skipped++
+        case onConflict == ImportConflictOverwrite:
+            s.expiredIds.remove( record.Id )
+            importing = append( importing, record )
+            result.Overwritten++
+        case len( conflicts ) < importMaxConflicts:
+            conflicts = append( conflicts, FieldError{ Field: "id", Code: ErrorImportConflict, Message: conflict } )
+        }
+    }
+    if len( conflicts ) > 0 {
+        s.mapMutex.Unlock()
+        s.log( r ).Info( "Import conflicts with the stored records", "conflicts", len( conflicts ) )
+        writeConflicts( w, conflicts )
+        return
+    }
+
+    for _, record := range importing {
+        if existing, _ := s.getRecord( record.Id ); existing != nil {
+            s.forgetDigest( existing.Id, recordDigest( existing ) )
+        }
+        if err := s.restoreRecord( record ); err != nil {
+            s.mapMutex.Unlock()
+            s.writeStoreError( w, r, err )
+            return
+        }
+        if record.Id > s.lastId {
+            s.lastId = record.Id
+        }
+    }
+    result.Imported = len( importing ) - result.Overwritten
+    if sequence, ok := s.store.( SequenceStore ); ok {
+        if err := sequence.SetLastId( s.lastId ); err != nil {
+            s.logErrorTo( s.log( r ), "Unable to save the last id %d: %v", s.lastId, err )
+        }
+    }
+    result.LastId = s.lastId
+    s.mapMutex.Unlock()
+
+    if s.config.WALFile != "" {
+        for _, record := range importing {
+            s.logCompletion( record )
+        }
+    }
+
+    s.auditRequest( r, AuditImport, strconv.Itoa( len( importing ) ) + " records" )
+    s.log( r ).Info( "Imported records!", "imported", result.Imported, "overwritten", result.Overwritten, "last_id", result.LastId )
+    s.writeEncoded( w, r, http.StatusOK, result )
+}
+
+/********************************************************************
+loadSeed()
+    Stores the records of the export dump Config.SeedFile before the
+    server starts serving. Records whose id is taken are left as they
+    are, so a server restarted with a persistent store or a WALFile
+    skips the records it already has.
+********************************************************************/
+func ( s *Server ) loadSeed() error {
+    file, err := os.Open( s.config.SeedFile )
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    records, err := ReadDump( file )
+    if err != nil {
+        return err
+    }
+
+    seeded, skipped := 0, 0
+    for _, record := range records {
+        conflict, unchanged, err := s.importConflict( record )
+        if err != nil {
+            return err
+        }
+        if unchanged || conflict != "" {
+            skipped++
+            continue
+        }
+        if err := s.restoreRecord( record ); err != nil {
+            return err
+        }
+        if record.Id > s.lastId {
+            s.lastId = record.Id
+        }
+        if s.config.WALFile != "" {
+            s.logCompletion( record )
+        }
+        seeded++
+    }
+    if sequence, ok := s.store.( SequenceStore ); ok && seeded > 0 {
+        if err := sequence.SetLastId( s.lastId ); err != nil {
+            return err
+        }
+    }
+
+    s.logger.Info( "Seeded records!", "seeded", seeded, "skipped", skipped, "last_id", s.lastId )
+    return nil
+}
+
+/********************************************************************
+writeConflicts()
+    Writes a 409 Conflict error response listing the records that
+    conflict with the stored ones.
+********************************************************************/
+func writeConflicts( w http.ResponseWriter, conflicts []FieldError ) {
+    w.Header().Set( "Content-Type", "application/json" )
+    w.Header().Set( "X-Content-Type-Options", "nosniff" )
+    w.WriteHeader( http.StatusConflict )
+    json.NewEncoder(w).Encode(ErrorResponse{ Error: ErrorDetail{
+        Code: ErrorImportConflict,
+        Message: http.StatusText( http.StatusConflict ),
+        Fields: conflicts,
+        RequestId: w.Header().Get( "X-Request-ID" ),
+    } })
+}
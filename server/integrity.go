@@ -0,0 +1,201 @@
+package server
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math/rand"
+    "strconv"
+    "strings"
+)
+
+// How much of a persistent store New() verifies the checksums of
+const (
+    // Every record
+    IntegrityFull = "full"
+
+    // IntegritySampleSize records picked at random
+    IntegritySample = "sample"
+
+    // None, records are still verified as they are read
+    IntegrityOff = "off"
+)
+
+// What the server does with a record whose checksum doesn't match
+const (
+    // Fail readiness and answer lookups of the record with an error
+    IntegrityRefuse = "refuse"
+
+    // Log it, report it as a warning of the readiness probe, and
+    // serve the record as it is
+    IntegrityFlag = "flag"
+)
+
+// Records verified by IntegritySample when Config.IntegritySampleSize
+// is 0
+const integrityDefaultSampleSize = 1000
+
+// Most corrupted ids the readiness probe lists
+const integrityMaxListed = 10
+
+// Returned, wrapped, for a record whose checksum doesn't match with
+// the refuse policy
+var errRecordCorrupted = errors.New( "record corrupted" )
+
+// Result of the verification of a persistent store by New()
+type integrityReport struct {
+    checked int
+    corruptedIds []int64
+}
+
+/********************************************************************
+checkIntegrityConfig()
+    Returns an error if Config.IntegrityCheck or IntegrityPolicy is
+    unknown.
+********************************************************************/
+func checkIntegrityConfig( config Config ) error {
+    switch config.IntegrityCheck {
+    case "", IntegrityFull, IntegritySample, IntegrityOff:
+    default:
+        return fmt.Errorf( "unknown integrity check %q, expected %s, %s or %s", config.IntegrityCheck, IntegrityFull, IntegritySample, IntegrityOff )
+    }
+    switch config.IntegrityPolicy {
+    case "", IntegrityRefuse, IntegrityFlag:
+        return nil
+    }
+    return fmt.Errorf( "unknown integrity policy %q, expected %s or %s", config.IntegrityPolicy, IntegrityRefuse, IntegrityFlag )
+}
+
+/********************************************************************
+recordChecksum()
+    Returns the SHA-256 checksum of a record, its provenance and its
+    deduplication digest as encoded by encodeRecord().
+********************************************************************/
+func recordChecksum( record *Record ) ( string, error ) {
+    data, err := json.Marshal( storedRecord{ Record: record, Provenance: record.provenance, Digest: record.digest } )
+    if err != nil {
+        return "", err
+    }
+    sum := sha256.Sum256( data )
+    return "sha256:" + hex.EncodeToString( sum[:] ), nil
+}
+
+/********************************************************************
+verifyRecord()
+    Returns an error if a record read back from persistence doesn't
+    match the checksum it was persisted with. Records persisted
+    before checksums were added, or never persisted, pass.
+********************************************************************/
+func verifyRecord( record *Record ) error {
+    if record.checksum == "" {
+        return nil
+    }
+    checksum, err := recordChecksum( record )
+    if err != nil {
+        return err
+    }
+    if checksum != record.checksum {
+        return fmt.Errorf( "record %d: checksum %s doesn't match %s", record.Id, checksum, record.checksum )
+    }
+    return nil
+}
+
+/********************************************************************
+checkRecord()
+    Applies Config.IntegrityPolicy to a record read from the store:
+    returns an error wrapping errRecordCorrupted if it doesn't match
+    its checksum, or with the flag policy logs it and returns nil.
+********************************************************************/
+func ( s *Server ) checkRecord( record *Record ) error {
+    err := verifyRecord( record )
+    if err == nil {
+        return nil
+    }
+    if s.config.IntegrityPolicy == IntegrityFlag {
+        s.logger.Warn( "Serving corrupted record", "id", record.Id, "error", err )
+        return nil
+    }
+    return fmt.Errorf( "%w: %v", errRecordCorrupted, err )
+}
+
+/********************************************************************
+verifyRecords()
+    Verifies the checksums of the records listed from the store on
+    startup, all of them or a sample as set by Config.IntegrityCheck,
+    logging every corrupted one.
+********************************************************************/
+func ( s *Server ) verifyRecords( records []*Record ) {
+    report := &integrityReport{}
+    switch s.config.IntegrityCheck {
+    case IntegrityOff:
+        return
+    case IntegritySample:
+        size := s.config.IntegritySampleSize
+        if size <= 0 {
+            size = integrityDefaultSampleSize
+        }
+        if size < len( records ) {
+            sample := make([]*Record, size)
+            for i, index := range rand.Perm( len( records ) )[ :size ] {
+                sample[ i ] = records[ index ]
+            }
+            records = sample
+        }
+    }
+
+    for _, record := range records {
+        if err := verifyRecord( record ); err != nil {
+            s.logger.Error( "Corrupted record!", "id", record.Id, "error", err )
+            report.corruptedIds = append( report.corruptedIds, record.Id )
+        }
+    }
+    report.checked = len( records )
+    s.integrity = report
+
+    if len( report.corruptedIds ) > 0 {
+        s.logger.Error( "Store integrity check failed!", "checked", report.checked, "corrupted", len( report.corruptedIds ), "policy", s.integrityPolicy() )
+    } else if report.checked > 0 {
+        s.logger.Info( "Verified store integrity!", "checked", report.checked )
+    }
+}
+
+/********************************************************************
+integrityPolicy()
+    Returns Config.IntegrityPolicy, refuse by default.
+********************************************************************/
+func ( s *Server ) integrityPolicy() string {
+    if s.config.IntegrityPolicy == "" {
+        return IntegrityRefuse
+    }
+    return s.config.IntegrityPolicy
+}
+
+/********************************************************************
+checkIntegrity()
+    Fails when the startup verification of the store found corrupted
+    records, a warning only with the flag policy.
+********************************************************************/
+func checkIntegrity( s *Server ) string {
+    if s.integrity == nil || len( s.integrity.corruptedIds ) == 0 {
+        return ""
+    }
+    ids := make([]string, 0, integrityMaxListed)
+    for _, id := range s.integrity.corruptedIds {
+        if len( ids ) == integrityMaxListed {
+            ids = append( ids, "..." )
+            break
+        }
+        ids = append( ids, strconv.FormatInt( id, 10 ) )
+    }
+    return fmt.Sprintf( "%d of %d records checked corrupted: %s", len( s.integrity.corruptedIds ), s.integrity.checked, strings.Join( ids, ", " ) )
+}
+
+/********************************************************************
+integrityWarnOnly()
+    Reports checkIntegrity() as a warning with the flag policy.
+********************************************************************/
+func integrityWarnOnly( s *Server ) bool {
+    return s.integrityPolicy() == IntegrityFlag
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+    "crypto/ed25519"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "net/http"
+    "time"
+)
+
+// Ed25519 key used to sign responses and callbacks
+type signingKeyPair struct {
+    kid string
+    private ed25519.PrivateKey
+    public ed25519.PublicKey
+    createdAt time.Time
+    retiredAt time.Time
+}
+
+// JSON Web Key, RFC 7517 / RFC 8037
+type JWK struct {
+    Kty string `json:"kty"`
+    Crv string `json:"crv"`
+    X string `json:"x"`
+    Kid string `json:"kid"`
+    Use string `json:"use"`
+    Alg string `json:"alg"`
+}
+
+// JSON Web Key Set served on /.well-known/jwks.json
+type JWKS struct {
+    Keys []JWK `json:"keys"`
+}
+
+var (
+    // How long rotated out signing keys are still published
+    keyRetention = 7 * 24 * time.Hour
+)
+
+/********************************************************************
+newSigningKey()
+    Generates a new Ed25519 signing key with a random key id.
+********************************************************************/
+func ( s *Server ) newSigningKey() *signingKeyPair {
+    public, private, _ := ed25519.GenerateKey( rand.Reader )
+    kid := make([]byte, 8)
+    rand.Read( kid )
+    return &signingKeyPair{ kid: hex.EncodeToString( kid ), private: private, public: public, createdAt: s.clock.Now() }
+}
+
+/********************************************************************
+activeSigningKey()
+    Returns the key new signatures are made with, generating the
+    first one on demand.
+********************************************************************/
+func ( s *Server ) activeSigningKey() *signingKeyPair {
+    s.keyMutex.Lock()
+    defer s.keyMutex.Unlock()
+
+    if len( s.signingKeys ) == 0 {
+        s.signingKeys = append( s.signingKeys, s.newSigningKey() )
+    }
+    return s.signingKeys[ len( s.signingKeys ) - 1 ]
+}
+
+/********************************************************************
+rotateSigningKey()
+    Makes a fresh key active. The previous key stays published so
+    signatures made with it can still be verified, and keys retired
+    for longer than keyRetention are dropped.
+********************************************************************/
+func ( s *Server ) rotateSigningKey() *signingKeyPair {
+    s.activeSigningKey()
+
+    s.keyMutex.Lock()
+    defer s.keyMutex.Unlock()
+
+    now := s.clock.Now()
+    s.signingKeys[ len( s.signingKeys ) - 1 ].retiredAt = now
+    kept := []*signingKeyPair{}
+    for _, key := range s.signingKeys {
+        if now.Sub( key.retiredAt ) < keyRetention {
+            kept = append( kept, key )
+        }
+    }
+    key := s.newSigningKey()
+    s.signingKeys = append( kept, key )
+    return key
+}
+
+/********************************************************************
+signDetached()
+    Signs a payload with the active key, returning a compact JWS with
+    a detached payload (RFC 7515 appendix F): "header..signature".
+********************************************************************/
+func ( s *Server ) signDetached( payload []byte ) string {
+    protected, _, signature := s.signJWS( payload )
+    return protected + ".." + signature
+}
+
+/********************************************************************
+signCompact()
+    Signs a payload with the active key, returning a compact JWS
+    carrying the payload: "header.payload.signature".
+********************************************************************/
+func ( s *Server ) signCompact( payload []byte ) string {
+    protected, encodedPayload, signature := s.signJWS( payload )
+    return protected + "." + encodedPayload + "." + signature
+}
+
+/********************************************************************
+signJWS()
+    Returns the base64url encoded protected header, payload and
+    signature of a JWS of the payload signed with the active key.
+********************************************************************/
+func ( s *Server ) signJWS( payload []byte ) ( protected string, encodedPayload string, signature string ) {
+    key := s.activeSigningKey()
+
+    header, _ := json.Marshal( map[string]string{ "alg": "EdDSA", "kid": key.kid } )
+    protected = base64.RawURLEncoding.EncodeToString( header )
+    encodedPayload = base64.RawURLEncoding.EncodeToString( payload )
+    signed := ed25519.Sign( key.private, []byte( protected + "." + encodedPayload ) )
+
+    return protected, encodedPayload, base64.RawURLEncoding.EncodeToString( signed )
+}
+
+/********************************************************************
+handleJWKS()
+    Handles GET requests on /.well-known/jwks.json, publishing the
+    public signing keys.
+********************************************************************/
+func ( s *Server ) handleJWKS( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /.well-known/jwks.json" )
+
+    s.activeSigningKey()
+
+    s.keyMutex.Lock()
+    jwks := JWKS{ Keys: []JWK{} }
+    for i := len( s.signingKeys ) - 1; i >= 0; i-- {
+        jwks.Keys = append( jwks.Keys, JWK{
+            Kty: "OKP",
+            Crv: "Ed25519",
+            X: base64.RawURLEncoding.EncodeToString( s.signingKeys[ i ].public ),
+            Kid: s.signingKeys[ i ].kid,
+            Use: "sig",
+            Alg: "EdDSA",
+        } )
+    }
+    s.keyMutex.Unlock()
+
+    w.Header().Set( "Content-Type", "application/jwk-set+json" )
+    json.NewEncoder(w).Encode(jwks)
+}
+
+/********************************************************************
+handleKeyRotate()
+    Handles POST requests on /admin/keys/rotate, making a new signing
+    key active.
+********************************************************************/
+func ( s *Server ) handleKeyRotate( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /admin/keys/rotate" )
+
+    key := s.rotateSigningKey()
+    s.auditRequest( r, AuditKeyRotate, "kid " + key.kid )
+
+    s.writeEncoded( w, r, http.StatusOK, map[string]string{ "kid": key.kid } )
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+)
+
+// How long fetching the master key may take
+const keySourceTimeout = 30 * time.Second
+
+// Default interval between fetches of the master key from a KeySource
+const keyRefreshDefaultInterval = time.Hour
+
+// Source of the 32 byte master key the records are encrypted with,
+// e.g. a secret manager, set by WithKeySource() in place of
+// Config.EncryptionKey. The key is fetched by New(), cached in memory
+// and fetched again every Config.KeyRefreshInterval
+type KeySource interface {
+    FetchKey( ctx context.Context ) ( []byte, error )
+}
+
+/********************************************************************
+fetchKey()
+    Fetches the master key from the key source.
+********************************************************************/
+func ( s *Server ) fetchKey() ( []byte, error ) {
+    ctx, cancel := context.WithTimeout( context.Background(), keySourceTimeout )
+    defer cancel()
+    return s.keySource.FetchKey( ctx )
+}
+
+/********************************************************************
+loadEncryptionKeys()
+    Sets up the cipher of the records from Config.EncryptionKey, or
+    the key fetched from the key source, along with the previous keys
+    records sealed before a rotation are still opened with.
+********************************************************************/
+func ( s *Server ) loadEncryptionKeys() error {
+    masterKey := s.config.EncryptionKey
+    if s.keySource != nil {
+        if len( masterKey ) > 0 {
+            return errors.New( "an encryption key and a key source are exclusive" )
+        }
+        var err error
+        if masterKey, err = s.fetchKey(); err != nil {
+            return fmt.Errorf( "fetching the encryption key: %w", err )
+        }
+    }
+    if len( masterKey ) == 0 {
+        return nil
+    }
+
+    recordCipher, err := newRecordCipher( masterKey )
+    if err != nil {
+        return err
+    }
+    for _, previous := range s.config.PreviousEncryptionKeys {
+        if _, err := recordCipher.loadKey( previous ); err != nil {
+            return fmt.Errorf( "previous %w", err )
+        }
+    }
+    s.recordCipher = recordCipher
+    if _, inMemory := s.store.( *memoryStore ); !inMemory {
+        s.store = &encryptedStore{ store: s.store, cipher: recordCipher }
+    }
+    return nil
+}
+
+/********************************************************************
+refreshKeyPeriodically()
+    Fetches the master key from the key source every
+    KeyRefreshInterval until the server shuts down. A rotated key
+    seals the records from then on, those sealed before are still
+    opened with the previous one. Failing to fetch it keeps the cached
+    key.
+********************************************************************/
+func ( s *Server ) refreshKeyPeriodically() {
+    interval := s.config.KeyRefreshInterval
+    if interval <= 0 {
+        interval = keyRefreshDefaultInterval
+    }
+    ticker := time.NewTicker( interval )
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+        case <-s.shutdownStarted:
+            return
+        }
+
+        masterKey, err := s.fetchKey()
+        if err != nil {
+            s.logError( "Unable to refresh the encryption key, still using the cached one: %v", err )
+            continue
+        }
+        changed, err := s.recordCipher.useKey( masterKey )
+        if err != nil {
+            s.logError( "Invalid encryption key from the key source, still using the cached one: %v", err )
+            continue
+        }
+        if changed {
+            s.logger.Info( "Rotated the encryption key!", "key_id", s.recordCipher.currentKeyId() )
+        }
+    }
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+    "context"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    "github.com/aws/aws-sdk-go-v2/config"
+    "github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// Key source decrypting a master key encrypted under an AWS KMS key,
+// e.g. the CiphertextBlob of GenerateDataKey, created by
+// NewKMSKeySource()
+type KMSKeySource struct {
+    client *kms.Client
+    ciphertext []byte
+}
+
+/********************************************************************
+NewKMSKeySource()
+    Creates a key source decrypting a master key encrypted with KMS,
+    with credentials from the standard AWS chain like
+    NewDynamoDBStore(). The region is taken from AWS_REGION when
+    empty, and endpoint, when not empty, points at a KMS-compatible
+    service like LocalStack.
+********************************************************************/
+func NewKMSKeySource( ctx context.Context, ciphertext []byte, region string, endpoint string ) ( *KMSKeySource, error ) {
+    awsConfig, err := config.LoadDefaultConfig( ctx, config.WithRegion( region ) )
+    if err != nil {
+        return nil, err
+    }
+    client := kms.NewFromConfig( awsConfig, func( options *kms.Options ) {
+        if endpoint != "" {
+            options.BaseEndpoint = aws.String( endpoint )
+        }
+    } )
+    return &KMSKeySource{ client: client, ciphertext: ciphertext }, nil
+}
+
+func ( k *KMSKeySource ) FetchKey( ctx context.Context ) ( []byte, error ) {
+    output, err := k.client.Decrypt( ctx, &kms.DecryptInput{ CiphertextBlob: k.ciphertext } )
+    if err != nil {
+        return nil, err
+    }
+    return output.Plaintext, nil
+}
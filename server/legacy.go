@@ -0,0 +1,104 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+)
+
+/********************************************************************
+withLegacyAPI()
+    Middleware translating the JSON responses of next back into the
+    original API's when LegacyAPI is set, on the unversioned paths
+    only. The request is handled as a JSON client's and translate
+    rewrites the recorded response.
+********************************************************************/
+func ( s *Server ) withLegacyAPI( next http.HandlerFunc, translate func( w http.ResponseWriter, r *http.Request, recorded *httptest.ResponseRecorder ) ) http.HandlerFunc {
+    return func( w http.ResponseWriter, r *http.Request ) {
+        if !s.config.LegacyAPI || requestVersion( r ) != "" {
+            next( w, r )
+            return
+        }
+
+        r = r.Clone( r.Context() )
+        r.Header.Set( "Accept", "application/json" )
+        recorded := httptest.NewRecorder()
+        next( recorded, r )
+        translate( w, r, recorded )
+    }
+}
+
+/********************************************************************
+copyRecorded()
+    Writes a recorded response out unchanged, except that errors are
+    plain text status text like in the original API, rather than the
+    JSON error envelope.
+********************************************************************/
+func copyRecorded( w http.ResponseWriter, recorded *httptest.ResponseRecorder ) {
+    for key, values := range recorded.Header() {
+        w.Header()[ key ] = values
+    }
+
+    var envelope ErrorResponse
+    if recorded.Code >= http.StatusBadRequest && json.Unmarshal( recorded.Body.Bytes(), &envelope ) == nil && envelope.Error.Code != "" {
+        http.Error( w, http.StatusText(recorded.Code), recorded.Code )
+        return
+    }
+
+    w.WriteHeader( recorded.Code )
+    w.Write( recorded.Body.Bytes() )
+}
+
+/********************************************************************
+legacyHashPost()
+    Translates a POST /hash response to the original bare id, with
+    200 OK whether or not the password has been hashed yet.
+********************************************************************/
+func legacyHashPost( w http.ResponseWriter, r *http.Request, recorded *httptest.ResponseRecorder ) {
+    var response HashResponse
+    if ( recorded.Code != http.StatusOK && recorded.Code != http.StatusAccepted ) ||
+        json.Unmarshal( recorded.Body.Bytes(), &response ) != nil {
+        copyRecorded( w, recorded )
+        return
+    }
+
+    fmt.Fprintf( w, "%d", response.Id )
+}
+
+/********************************************************************
+legacyHashGet()
+    Translates a GET /hash/{id} response to the original plain text
+    hash. Passwords that are still pending or have expired are 404
+    Not Found, like unknown ids.
+********************************************************************/
+func legacyHashGet( w http.ResponseWriter, r *http.Request, recorded *httptest.ResponseRecorder ) {
+    var record Record
+    switch recorded.Code {
+    case http.StatusOK:
+        if json.Unmarshal( recorded.Body.Bytes(), &record ) != nil {
+            copyRecorded( w, recorded )
+            return
+        }
+        fmt.Fprint( w, record.Hash )
+    case http.StatusAccepted, http.StatusGone:
+        http.Error( w, http.StatusText(http.StatusNotFound), http.StatusNotFound )
+    default:
+        copyRecorded( w, recorded )
+    }
+}
+
+/********************************************************************
+legacyStats()
+    Translates a GET /stats response to the original, with only the
+    total and average.
+********************************************************************/
+func legacyStats( w http.ResponseWriter, r *http.Request, recorded *httptest.ResponseRecorder ) {
+    var stats Stat
+    if recorded.Code != http.StatusOK || json.Unmarshal( recorded.Body.Bytes(), &stats ) != nil {
+        copyRecorded( w, recorded )
+        return
+    }
+
+    json.NewEncoder(w).Encode(Stat{ Total: stats.Total, Average: stats.Average })
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+)
+
+/********************************************************************
+pendingJobCount()
+    Returns the number of passwords waiting to be hashed.
+********************************************************************/
+func ( s *Server ) pendingJobCount() int {
+    s.mapMutex.Lock()
+    defer s.mapMutex.Unlock()
+    return len( s.pendingJobs )
+}
+
+/********************************************************************
+jobStates()
+    Returns the number of pending passwords still waiting out their
+    delay, and of those being hashed. Must be called with mapMutex
+    held.
+********************************************************************/
+func ( s *Server ) jobStates() ( queued int, processing int ) {
+    for _, job := range s.pendingJobs {
+        if job.state == StatusProcessing {
+            processing++
+        } else {
+            queued++
+        }
+    }
+    return queued, processing
+}
+
+/********************************************************************
+checkSoftLimit()
+    Compares usage of a limit against its soft threshold. Returns a
+    warning message once usage reaches SoftLimitRatio of the limit,
+    logging when the threshold is crossed in either direction.
+********************************************************************/
+func ( s *Server ) checkSoftLimit( name string, used int64, limit int64 ) string {
+    if limit <= 0 {
+        return ""
+    }
+
+    over := float64( used ) >= s.config.SoftLimitRatio * float64( limit )
+
+    s.softLimitMutex.Lock()
+    if over != s.softLimitsCrossed[ name ] {
+        s.softLimitsCrossed[ name ] = over
+        if over {
+            s.logger.Warn( "Soft limit reached!", "limit_name", name, "used", used, "limit", limit )
+        } else {
+            s.logger.Info( "Soft limit cleared!", "limit_name", name, "used", used, "limit", limit )
+        }
+    }
+    s.softLimitMutex.Unlock()
+
+    if !over {
+        return ""
+    }
+    return fmt.Sprintf( "%s at %d of %d, requests will be rejected at the limit", name, used, limit )
+}
+
+/********************************************************************
+checkPendingLimit()
+    Enforces MaxPendingJobs on POST /hash. Responds 503 with a
+    Retry-After header and returns false once the limit is reached.
+    Below it, sets X-Pending-Limit / X-Pending-Remaining headers and
+    a Warning header once the soft threshold is reached.
+********************************************************************/
+func ( s *Server ) checkPendingLimit( w http.ResponseWriter ) bool {
+    if s.config.MaxPendingJobs <= 0 {
+        return true
+    }
+
+    pending := s.pendingJobCount()
+    if pending >= s.config.MaxPendingJobs {
+        s.logger.Warn( "Too many pending passwords!" )
+        w.Header().Set( "Retry-After", strconv.Itoa( int( s.delay.Seconds() ) ) )
+        writeError( w, http.StatusServiceUnavailable, ErrorRateLimited )
+        return false
+    }
+
+    w.Header().Set( "X-Pending-Limit", strconv.Itoa( s.config.MaxPendingJobs ) )
+    w.Header().Set( "X-Pending-Remaining", strconv.Itoa( s.config.MaxPendingJobs - pending - 1 ) )
+    if warning := s.checkSoftLimit( "pending jobs", int64( pending + 1 ), int64( s.config.MaxPendingJobs ) ); warning != "" {
+        w.Header().Set( "Warning", `299 - ` + strconv.Quote( warning ) )
+    }
+    return true
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "math/rand/v2"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+
+    "go.opentelemetry.io/otel/trace"
+)
+
+// Context keys of a request's logger and id
+type loggerKey struct{}
+type requestIdKey struct{}
+
+/********************************************************************
+withLogging()
+    Middleware giving every request a logger that adds its method,
+    path, request id and trace id to each line, and logging the
+    status and duration at debug level once it is handled, along with
+    its access log record. The request id is taken from the
+    X-Request-ID header or generated, and echoed in the response's.
+    Passwords registered by redactPassword() are scrubbed from the
+    lines and from panics. Requests sampled by Config.LogSampling are
+    logged at debug level whatever the log level, with their headers
+    and query.
+********************************************************************/
+func ( s *Server ) withLogging( next http.HandlerFunc ) http.HandlerFunc {
+    return func( w http.ResponseWriter, r *http.Request ) {
+        start := time.Now()
+
+        id := r.Header.Get( "X-Request-ID" )
+        if id == "" {
+            id = newRequestId()
+        }
+        traceId := trace.SpanContextFromContext( r.Context() ).TraceID().String()
+        passwords := &secrets{}
+        handler := newRedactHandler( s.logger.Handler(), passwords )
+        sampled := s.sampled( r )
+        if sampled {
+            handler = debugHandler{ next: handler }
+        }
+        logger := slog.New( handler ).With(
+            "method", r.Method,
+            "path", r.URL.Path,
+            "request_id", id,
+            "trace_id", traceId,
+        )
+        if sampled {
+            logger = logger.With( "sampled", true )
+            logger.Debug( "Sampled request", requestDetail( r )... )
+        }
+        ctx := context.WithValue( r.Context(), loggerKey{}, logger )
+        ctx = context.WithValue( ctx, requestIdKey{}, id )
+        ctx = context.WithValue( ctx, secretsKey{}, passwords )
+        w.Header().Set( "X-Request-ID", id )
+
+        recorder := &statusRecorder{ ResponseWriter: w }
+        defer func() {
+            // Scrub the passwords from a panic before it is logged
+            if err := recover(); err != nil {
+                if err == http.ErrAbortHandler {
+                    panic( err )
+                }
+                panic( passwords.scrub( fmt.Sprint( err ) ) )
+            }
+        }()
+        next( recorder, r.WithContext( ctx ) )
+
+        if recorder.status == 0 {
+            recorder.status = http.StatusOK
+        }
+        elapsed := time.Since( start )
+        if sampled {
+            logger.Debug( "Request handled", "status", recorder.status, "duration", elapsed, "bytes", recorder.bytes, headersAttr( "response_headers", w.Header() ) )
+        } else {
+            logger.Debug( "Request handled", "status", recorder.status, "duration", elapsed )
+        }
+
+        if s.accessLogger != nil {
+            accessLogger := slog.New( newRedactHandler( s.accessLogger.Handler(), passwords ) )
+            accessLogger.LogAttrs( r.Context(), slog.LevelInfo, "request",
+                slog.String( "method", r.Method ),
+                slog.String( "path", r.URL.Path ),
+                slog.Int( "status", recorder.status ),
+                slog.Int64( "bytes", recorder.bytes ),
+                slog.Int64( "latency_us", elapsed.Microseconds() ),
+                slog.String( "client_ip", clientIp( r ) ),
+                slog.String( "user_agent", r.UserAgent() ),
+                slog.String( "request_id", id ),
+                slog.String( "trace_id", traceId ),
+            )
+        }
+    }
+}
+
+/********************************************************************
+log()
+    Returns the logger of a request, which adds its method, path,
+    request id and trace id to every line.
+********************************************************************/
+func ( s *Server ) log( r *http.Request ) *slog.Logger {
+    return s.contextLogger( r.Context() )
+}
+
+/********************************************************************
+contextLogger()
+    Returns the logger of the request a context belongs to, or the
+    server's.
+********************************************************************/
+func ( s *Server ) contextLogger( ctx context.Context ) *slog.Logger {
+    if logger, ok := ctx.Value( loggerKey{} ).( *slog.Logger ); ok {
+        return logger
+    }
+    return s.logger
+}
+
+/********************************************************************
+requestId()
+    Returns the id withLogging() gave a request, from its X-Request-ID
+    header or generated.
+********************************************************************/
+func requestId( r *http.Request ) string {
+    if id, ok := r.Context().Value( requestIdKey{} ).( string ); ok {
+        return id
+    }
+    if id := r.Header.Get( "X-Request-ID" ); id != "" {
+        return id
+    }
+    return newRequestId()
+}
+
+/********************************************************************
+sampled()
+    Returns true if a request is to be logged in full, for the
+    fraction Config.LogSampling sets of the requests of its route.
+********************************************************************/
+func ( s *Server ) sampled( r *http.Request ) bool {
+    if len( s.config.LogSampling ) == 0 {
+        return false
+    }
+
+    _, pattern := s.mux.Handler( r )
+    rate := s.config.LogSampling[ unversioned( pattern ) ]
+    return rate > 0 && rand.Float64() < rate
+}
+
+/********************************************************************
+requestDetail()
+    Returns the attributes describing a sampled request in full. The
+    values of sensitive headers and query parameters are redacted by
+    the logger, and the body, which holds the password, isn't logged.
+********************************************************************/
+func requestDetail( r *http.Request ) []interface{} {
+    query := r.URL.Query()
+    keys := make([]string, 0, len( query ))
+    for key := range query {
+        keys = append( keys, key )
+    }
+    sort.Strings( keys )
+    queryAttrs := make([]interface{}, len( keys ))
+    for i, key := range keys {
+        queryAttrs[ i ] = slog.String( key, strings.Join( query[ key ], "," ) )
+    }
+
+    return []interface{}{
+        "proto", r.Proto,
+        "host", r.Host,
+        "client_ip", clientIp( r ),
+        "content_length", r.ContentLength,
+        slog.Group( "query", queryAttrs... ),
+        headersAttr( "headers", r.Header ),
+    }
+}
+
+/********************************************************************
+headersAttr()
+    Returns headers as a group of attributes keyed by lower case name,
+    in order.
+********************************************************************/
+func headersAttr( key string, header http.Header ) slog.Attr {
+    names := make([]string, 0, len( header ))
+    for name := range header {
+        names = append( names, name )
+    }
+    sort.Strings( names )
+    attrs := make([]interface{}, len( names ))
+    for i, name := range names {
+        attrs[ i ] = slog.String( strings.ToLower( name ), strings.Join( header[ name ], "," ) )
+    }
+    return slog.Group( key, attrs... )
+}
+
+// Handler logging every level, for sampled requests. The handlers
+// behind it leave checking the level to their logger
+type debugHandler struct {
+    next slog.Handler
+}
+
+func ( h debugHandler ) Enabled( ctx context.Context, level slog.Level ) bool {
+    return true
+}
+
+func ( h debugHandler ) Handle( ctx context.Context, record slog.Record ) error {
+    return h.next.Handle( ctx, record )
+}
+
+func ( h debugHandler ) WithAttrs( attrs []slog.Attr ) slog.Handler {
+    return debugHandler{ next: h.next.WithAttrs( attrs ) }
+}
+
+func ( h debugHandler ) WithGroup( name string ) slog.Handler {
+    return debugHandler{ next: h.next.WithGroup( name ) }
+}
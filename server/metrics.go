@@ -0,0 +1,252 @@
+package server
+
+import (
+    "bufio"
+    "fmt"
+    "log/slog"
+    "net"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/collectors"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Endpoint label of requests matching no route
+const unmatchedEndpoint = "unmatched"
+
+// Hash latency buckets in seconds, around the 5 second delay window
+var hashLatencyBuckets = []float64{ 0.5, 1, 2.5, 5, 5.5, 6, 7.5, 10, 30, 60 }
+
+// Prometheus metrics of a server, in its own registry so several
+// servers can run in one process
+type metrics struct {
+    registry *prometheus.Registry
+    requests *prometheus.CounterVec
+    responses *prometheus.CounterVec
+    handlerLatency *prometheus.HistogramVec
+    // Without labels, a vec only so that it can be reset
+    hashLatency *prometheus.HistogramVec
+    gcReclaimed *prometheus.CounterVec
+    gcDuration *prometheus.HistogramVec
+}
+
+/********************************************************************
+newMetrics()
+    Creates and registers the Prometheus metrics of a server, along
+    with the Go runtime and process collectors.
+********************************************************************/
+func ( s *Server ) newMetrics() *metrics {
+    m := &metrics{
+        registry: prometheus.NewRegistry(),
+        requests: prometheus.NewCounterVec( prometheus.CounterOpts{
+            Name: "hashsvc_http_requests_total",
+            Help: "HTTP requests by endpoint, method and status.",
+        }, []string{ "endpoint", "method", "status" } ),
+        responses: prometheus.NewCounterVec( prometheus.CounterOpts{
+            Name: "hashsvc_http_responses_total",
+            Help: "HTTP responses by endpoint and status class, e.g. 5xx, for error rate alerts.",
+        }, []string{ "endpoint", "class" } ),
+        handlerLatency: prometheus.NewHistogramVec( prometheus.HistogramOpts{
+            Name: "hashsvc_http_request_duration_seconds",
+            Help: "Time taken to handle HTTP requests by endpoint and method.",
+            Buckets: prometheus.DefBuckets,
+        }, []string{ "endpoint", "method" } ),
+        hashLatency: prometheus.NewHistogramVec( prometheus.HistogramOpts{
+            Name: "hashsvc_hash_duration_seconds",
+            Help: "Time from submitting a password to it being hashed, not counting time paused.",
+            Buckets: hashLatencyBuckets,
+        }, nil ),
+        gcReclaimed: prometheus.NewCounterVec( prometheus.CounterOpts{
+            Name: "hashsvc_gc_reclaimed_total",
+            Help: "Entries removed by the reaper by kind, e.g. expired_records.",
+        }, []string{ "kind" } ),
+        gcDuration: prometheus.NewHistogramVec( prometheus.HistogramOpts{
+            Name: "hashsvc_gc_duration_seconds",
+            Help: "Time taken by a run of the reaper.",
+            Buckets: prometheus.ExponentialBuckets( 0.00001, 10, 6 ),
+        }, nil ),
+    }
+
+    // Export the histograms before the first hash and run too
+    m.hashLatency.WithLabelValues()
+    m.gcDuration.WithLabelValues()
+
+    m.registry.MustRegister(
+        m.requests,
+        m.responses,
+        m.handlerLatency,
+        m.hashLatency,
+        m.gcReclaimed,
+        m.gcDuration,
+        prometheus.NewGaugeFunc( prometheus.GaugeOpts{
+            Name: "hashsvc_pending_jobs",
+            Help: "Passwords waiting to be hashed.",
+        }, func() float64 { return float64( s.pendingJobCount() ) } ),
+        prometheus.NewGaugeFunc( prometheus.GaugeOpts{
+            Name: "hashsvc_queued_jobs",
+            Help: "Passwords waiting out their delay before being hashed.",
+        }, func() float64 {
+            s.mapMutex.Lock()
+            defer s.mapMutex.Unlock()
+            queued, _ := s.jobStates()
+            return float64( queued )
+        } ),
+        prometheus.NewGaugeFunc( prometheus.GaugeOpts{
+            Name: "hashsvc_processing_jobs",
+            Help: "Passwords being hashed.",
+        }, func() float64 {
+            s.mapMutex.Lock()
+            defer s.mapMutex.Unlock()
+            _, processing := s.jobStates()
+            return float64( processing )
+        } ),
+        prometheus.NewGaugeFunc( prometheus.GaugeOpts{
+            Name: "hashsvc_workers",
+            Help: "Size of the pool of workers hashing passwords.",
+        }, func() float64 { return float64( s.workerCount() ) } ),
+        prometheus.NewGaugeFunc( prometheus.GaugeOpts{
+            Name: "hashsvc_busy_workers",
+            Help: "Workers hashing a password.",
+        }, func() float64 { return float64( s.busyWorkers.Load() ) } ),
+        collectors.NewGoCollector(),
+        collectors.NewProcessCollector( collectors.ProcessCollectorOpts{} ),
+    )
+    if cache := s.recordCache; cache != nil {
+        m.registry.MustRegister(
+            prometheus.NewCounterFunc( prometheus.CounterOpts{
+                Name: "hashsvc_store_cache_hits_total",
+                Help: "Reads of the store answered by the record cache.",
+            }, func() float64 { return float64( cache.hits.Load() ) } ),
+            prometheus.NewCounterFunc( prometheus.CounterOpts{
+                Name: "hashsvc_store_cache_misses_total",
+                Help: "Reads of the store the record cache passed on to the persistent store.",
+            }, func() float64 { return float64( cache.misses.Load() ) } ),
+            prometheus.NewCounterFunc( prometheus.CounterOpts{
+                Name: "hashsvc_store_cache_evictions_total",
+                Help: "Records dropped from the record cache to stay within -cache-records.",
+            }, func() float64 { return float64( cache.evictions.Load() ) } ),
+            prometheus.NewGaugeFunc( prometheus.GaugeOpts{
+                Name: "hashsvc_store_cache_records",
+                Help: "Records held by the record cache.",
+            }, func() float64 { return float64( cache.stats().Records ) } ),
+        )
+    }
+    return m
+}
+
+/********************************************************************
+observeRequest()
+    Counts a handled request and records how long it took. Endpoint
+    is the path of the route it matched.
+********************************************************************/
+func ( m *metrics ) observeRequest( endpoint string, method string, status int, elapsed time.Duration ) {
+    m.requests.WithLabelValues( endpoint, method, strconv.Itoa( status ) ).Inc()
+    m.responses.WithLabelValues( endpoint, statusClass( status ) ).Inc()
+    m.handlerLatency.WithLabelValues( endpoint, method ).Observe( elapsed.Seconds() )
+}
+
+/********************************************************************
+reset()
+    Zeroes the request counters and latency histograms.
+********************************************************************/
+func ( m *metrics ) reset() {
+    m.requests.Reset()
+    m.responses.Reset()
+    m.handlerLatency.Reset()
+    m.hashLatency.Reset()
+    m.hashLatency.WithLabelValues()
+    m.gcReclaimed.Reset()
+    m.gcDuration.Reset()
+    m.gcDuration.WithLabelValues()
+}
+
+/********************************************************************
+withMetrics()
+    Middleware counting requests and timing them, by the route they
+    match, for /stats, /metrics, /debug/vars and StatsD.
+********************************************************************/
+func ( s *Server ) withMetrics( next http.HandlerFunc ) http.HandlerFunc {
+    return func( w http.ResponseWriter, r *http.Request ) {
+        _, pattern := s.mux.Handler( r )
+        start := time.Now()
+        recorder := &statusRecorder{ ResponseWriter: w }
+        next( recorder, r )
+
+        if recorder.status == 0 {
+            recorder.status = http.StatusOK
+        }
+        elapsed := time.Since( start )
+        s.recordEndpoint( pattern, recorder.status, elapsed )
+        endpoint := unmatchedEndpoint
+        if pattern != "" {
+            _, endpoint, _ = strings.Cut( pattern, " " )
+        } else {
+            pattern = unmatchedEndpoint
+        }
+        s.metrics.observeRequest( endpoint, r.Method, recorder.status, elapsed )
+        s.expvarRequests.Add( pattern, 1 )
+        s.statsd.timing( "http.request", elapsed, "endpoint:" + endpoint, "method:" + r.Method, "status:" + strconv.Itoa( recorder.status ) )
+    }
+}
+
+/********************************************************************
+handleMetrics()
+    Handles GET requests on /metrics, returning the metrics in the
+    Prometheus text format.
+********************************************************************/
+func ( s *Server ) handleMetrics( w http.ResponseWriter, r *http.Request ) {
+    promhttp.HandlerFor( s.metrics.registry, promhttp.HandlerOpts{ ErrorLog: slog.NewLogLogger( s.log( r ).Handler(), slog.LevelError ) } ).ServeHTTP( w, r )
+}
+
+// Response writer remembering the status and body bytes written, for
+// the request metrics and logs. It still lets handlers flush and
+// hijack the connection
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+    bytes int64
+}
+
+func ( sr *statusRecorder ) WriteHeader( status int ) {
+    if sr.status == 0 {
+        sr.status = status
+    }
+    sr.ResponseWriter.WriteHeader( status )
+}
+
+func ( sr *statusRecorder ) Write( data []byte ) ( int, error ) {
+    if sr.status == 0 {
+        sr.status = http.StatusOK
+    }
+    written, err := sr.ResponseWriter.Write( data )
+    sr.bytes += int64( written )
+    return written, err
+}
+
+func ( sr *statusRecorder ) Flush() {
+    if sr.status == 0 {
+        sr.status = http.StatusOK
+    }
+    if flusher, ok := sr.ResponseWriter.( http.Flusher ); ok {
+        flusher.Flush()
+    }
+}
+
+// Hijack hands the connection over, to WebSocket upgrades
+func ( sr *statusRecorder ) Hijack() ( net.Conn, *bufio.ReadWriter, error ) {
+    hijacker, ok := sr.ResponseWriter.( http.Hijacker )
+    if !ok {
+        return nil, nil, fmt.Errorf( "response writer does not support hijacking" )
+    }
+    sr.status = http.StatusSwitchingProtocols
+    return hijacker.Hijack()
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func ( sr *statusRecorder ) Unwrap() http.ResponseWriter {
+    return sr.ResponseWriter
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+    "log/slog"
+    "net/http"
+)
+
+// Middleware wraps a handler, e.g. to log, authenticate, rate limit
+// or recover every request
+type Middleware func( next http.Handler ) http.Handler
+
+/********************************************************************
+Chain()
+    Wraps a handler in middleware, the first one outermost so that it
+    sees each request first.
+********************************************************************/
+func Chain( handler http.Handler, middleware ...Middleware ) http.Handler {
+    for i := len( middleware ) - 1; i >= 0; i-- {
+        handler = middleware[ i ]( handler )
+    }
+    return handler
+}
+
+/********************************************************************
+WithMiddleware()
+    Adds middleware applied to every route, after any added before.
+********************************************************************/
+func WithMiddleware( middleware ...Middleware ) Option {
+    return func( s *Server ) {
+        s.middleware = append( s.middleware, middleware... )
+    }
+}
+
+/********************************************************************
+Recover()
+    Middleware answering 500 Internal Server Error when a handler
+    panics, rather than dropping the connection, and logging the
+    panic.
+********************************************************************/
+func Recover( logger *slog.Logger ) Middleware {
+    return func( next http.Handler ) http.Handler {
+        return http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+            defer func() {
+                err := recover()
+                if err == nil {
+                    return
+                }
+                // Aborted responses are meant to drop the connection
+                if err == http.ErrAbortHandler {
+                    panic( err )
+                }
+                logger.Error( "Panic serving request!", "method", r.Method, "path", r.URL.Path, "panic", err )
+                writeError( w, http.StatusInternalServerError, ErrorInternal )
+            }()
+            next.ServeHTTP( w, r )
+        } )
+    }
+}
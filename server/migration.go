@@ -0,0 +1,345 @@
+package server
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+var (
+    // Returned by the migration endpoints once cut over
+    errCutOver = errors.New( "the migration is cut over" )
+
+    // Returned by cutover() while records are only in the old store
+    errNotBackfilled = errors.New( "records not backfilled yet" )
+)
+
+// Response to POST /admin/migration/backfill
+type BackfillResult struct {
+    // Records copied to the new store, and those already in it or
+    // deleted since they were listed
+    Copied int `json:"copied"`
+    Present int `json:"present"`
+
+    // Last id of the new store's sequence, 0 without one
+    LastId int64 `json:"last_id"`
+    DurationUs int64 `json:"duration_us"`
+}
+
+// Response to POST /admin/migration/cutover
+type CutoverResult struct {
+    CutOver bool `json:"cut_over"`
+
+    // Records of the old store found in the new one
+    Records int `json:"records"`
+}
+
+// Store migrating the records of one store to another without
+// downtime, set up by New() with WithMigration(). Writes go to both,
+// the new one first, and reads to the new one, falling back to the
+// old for records not backfilled yet. Once cut over the old store is
+// left alone
+type migratingStore struct {
+    from Store
+    to Store
+    cutOver atomic.Bool
+
+    // Held shared by writes and exclusively while a record is
+    // backfilled, so a backfill doesn't bring back a record deleted
+    // or replace one written meanwhile
+    mutex sync.RWMutex
+}
+
+/********************************************************************
+newMigratingStore()
+    Double-writes the records of from to to.
+********************************************************************/
+func newMigratingStore( from Store, to Store ) *migratingStore {
+    return &migratingStore{ from: from, to: to }
+}
+
+func ( m *migratingStore ) Put( record *Record ) error {
+    m.mutex.RLock()
+    defer m.mutex.RUnlock()
+
+    if err := m.to.Put( record ); err != nil {
+        return err
+    }
+    if m.cutOver.Load() {
+        return nil
+    }
+    return m.from.Put( record )
+}
+
+func ( m *migratingStore ) Get( id int64 ) ( *Record, error ) {
+    record, err := m.to.Get( id )
+    if !errors.Is( err, ErrRecordNotFound ) || m.cutOver.Load() {
+        return record, err
+    }
+    return m.from.Get( id )
+}
+
+func ( m *migratingStore ) Delete( id int64 ) error {
+    m.mutex.RLock()
+    defer m.mutex.RUnlock()
+
+    if err := m.to.Delete( id ); err != nil {
+        return err
+    }
+    if m.cutOver.Load() {
+        return nil
+    }
+    return m.from.Delete( id )
+}
+
+func ( m *migratingStore ) List() ( []*Record, error ) {
+    records, err := m.to.List()
+    if err != nil || m.cutOver.Load() {
+        return records, err
+    }
+    old, err := m.from.List()
+    if err != nil {
+        return nil, err
+    }
+
+    // Add the records not backfilled yet
+    listed := make(map[int64]bool, len( records ))
+    for _, record := range records {
+        listed[ record.Id ] = true
+    }
+    merged := len( records )
+    for _, record := range old {
+        if !listed[ record.Id ] {
+            records = append( records, record )
+        }
+    }
+    if len( records ) > merged {
+        sort.Slice( records, func( i, j int ) bool { return records[ i ].Id < records[ j ].Id } )
+    }
+    return records, nil
+}
+
+func ( m *migratingStore ) Count() ( int, error ) {
+    if m.cutOver.Load() {
+        return m.to.Count()
+    }
+    records, err := m.List()
+    return len( records ), err
+}
+
+func ( m *migratingStore ) LastId() ( int64, error ) {
+    var lastId int64
+    for _, store := range []Store{ m.to, m.from } {
+        if sequence, ok := store.( SequenceStore ); ok {
+            id, err := sequence.LastId()
+            if err != nil {
+                return 0, err
+            }
+            lastId = max( lastId, id )
+        }
+    }
+    return lastId, nil
+}
+
+func ( m *migratingStore ) SetLastId( id int64 ) error {
+    if sequence, ok := m.to.( SequenceStore ); ok {
+        if err := sequence.SetLastId( id ); err != nil {
+            return err
+        }
+    }
+    if sequence, ok := m.from.( SequenceStore ); ok && !m.cutOver.Load() {
+        return sequence.SetLastId( id )
+    }
+    return nil
+}
+
+func ( m *migratingStore ) Ping() error {
+    stores := []Store{ m.to }
+    if !m.cutOver.Load() {
+        stores = append( stores, m.from )
+    }
+    for _, store := range stores {
+        var err error
+        if pinger, ok := store.( PingStore ); ok {
+            err = pinger.Ping()
+        } else {
+            _, err = store.Count()
+        }
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+/********************************************************************
+backfill()
+    Copies the records of the old store missing from the new one, and
+    moves the id sequence of the new one past that of the old. Each
+    record is read again from the old store once writes are held off,
+    so one deleted or replaced since the listing isn't copied stale.
+********************************************************************/
+func ( m *migratingStore ) backfill() ( *BackfillResult, error ) {
+    if m.cutOver.Load() {
+        return nil, errCutOver
+    }
+    start := time.Now()
+    result := &BackfillResult{}
+
+    records, err := m.from.List()
+    if err != nil {
+        return nil, err
+    }
+    for _, record := range records {
+        copied, err := m.backfillRecord( record.Id )
+        if err != nil {
+            return nil, fmt.Errorf( "backfilling record %d: %w", record.Id, err )
+        }
+        if copied {
+            result.Copied++
+        } else {
+            result.Present++
+        }
+    }
+
+    result.LastId, err = m.LastId()
+    if err != nil {
+        return nil, err
+    }
+    if sequence, ok := m.to.( SequenceStore ); ok {
+        if err := sequence.SetLastId( result.LastId ); err != nil {
+            return nil, err
+        }
+    }
+    result.DurationUs = time.Since( start ).Microseconds()
+    return result, nil
+}
+
+/********************************************************************
+backfillRecord()
+    Copies the record of an id from the old store to the new one,
+    unless the new one has it or the old one no longer does. Returns
+    whether it was copied.
+********************************************************************/
+func ( m *migratingStore ) backfillRecord( id int64 ) ( bool, error ) {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+
+    _, err := m.to.Get( id )
+    if !errors.Is( err, ErrRecordNotFound ) {
+        return false, err
+    }
+    record, err := m.from.Get( id )
+    if errors.Is( err, ErrRecordNotFound ) {
+        return false, nil
+    }
+    if err != nil {
+        return false, err
+    }
+    return true, m.to.Put( record )
+}
+
+/********************************************************************
+cutover()
+    Stops using the old store once every record of it is in the new
+    one, returning how many there are. Returns errNotBackfilled and
+    how many records are missing if they aren't.
+********************************************************************/
+func ( m *migratingStore ) cutover() ( int, error ) {
+    if m.cutOver.Load() {
+        return 0, errCutOver
+    }
+
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+
+    records, err := m.from.List()
+    if err != nil {
+        return 0, err
+    }
+    missing := 0
+    for _, record := range records {
+        _, err := m.to.Get( record.Id )
+        if errors.Is( err, ErrRecordNotFound ) {
+            missing++
+        } else if err != nil {
+            return 0, err
+        }
+    }
+    if missing > 0 {
+        return missing, errNotBackfilled
+    }
+    m.cutOver.Store( true )
+    return len( records ), nil
+}
+
+/********************************************************************
+handleBackfill()
+    Handles POST requests on /admin/migration/backfill, copying the
+    records of the store migrated from to the new one.
+********************************************************************/
+func ( s *Server ) handleBackfill( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /admin/migration/backfill" )
+
+    // Check shutdown
+    if s.shutDown {
+        s.log( r ).Info( "Server has been shut down!" )
+        writeError( w, http.StatusNotAcceptable, ErrorShuttingDown )
+        return
+    }
+
+    result, err := s.migration.backfill()
+    if errors.Is( err, errCutOver ) {
+        s.log( r ).Info( "Backfill after the cutover!" )
+        writeError( w, http.StatusConflict, ErrorCutOver )
+        return
+    }
+    if err != nil {
+        s.writeStoreError( w, r, err )
+        return
+    }
+
+    s.auditRequest( r, AuditBackfill, strconv.Itoa( result.Copied ) + " records copied" )
+    s.log( r ).Info( "Backfilled the new store!", "copied", result.Copied, "present", result.Present, "duration_us", result.DurationUs )
+    s.writeEncoded( w, r, http.StatusOK, result )
+}
+
+/********************************************************************
+handleCutover()
+    Handles POST requests on /admin/migration/cutover, switching reads
+    and writes to the new store only once it holds every record.
+********************************************************************/
+func ( s *Server ) handleCutover( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /admin/migration/cutover" )
+
+    // Check shutdown
+    if s.shutDown {
+        s.log( r ).Info( "Server has been shut down!" )
+        writeError( w, http.StatusNotAcceptable, ErrorShuttingDown )
+        return
+    }
+
+    records, err := s.migration.cutover()
+    switch {
+    case errors.Is( err, errCutOver ):
+        s.log( r ).Info( "Already cut over!" )
+        writeError( w, http.StatusConflict, ErrorCutOver )
+        return
+    case errors.Is( err, errNotBackfilled ):
+        s.log( r ).Info( "Cutover before the backfill!", "missing", records )
+        writeError( w, http.StatusConflict, ErrorNotBackfilled )
+        return
+    case err != nil:
+        s.writeStoreError( w, r, err )
+        return
+    }
+
+    s.auditRequest( r, AuditCutover, strconv.Itoa( records ) + " records migrated" )
+    s.log( r ).Info( "Cut over to the new store!", "records", records )
+    s.writeEncoded( w, r, http.StatusOK, CutoverResult{ CutOver: true, Records: records } )
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "testing"
+    "time"
+)
+
+// Records of the store migrated from are read until backfilled, new
+// ones are written to both stores until the cutover, which waits for
+// the backfill, and to the new one only after it
+func TestMigrationDoubleWritesUntilCutover( t *testing.T ) {
+    from, to := NewMemoryStore(), NewMemoryStore()
+    _, handler := newTestServer( t, 0, WithStore( from ) )
+    old := postPassword( t, handler, "angryMonkey" )
+    waitHashed( t, handler, old, 2 * time.Second )
+
+    config := DefaultConfig()
+    config.AdminToken = "admin"
+    _, handler = newTestServer( t, 0, WithConfig( config ), WithStore( to ), WithMigration( from ) )
+    if response := getHash( handler, old ); response.Code != http.StatusOK {
+        t.Fatalf( "GET of a record not backfilled: got %d %q, want 200", response.Code, response.Body )
+    }
+
+    migrated := postPassword( t, handler, "angryMonkey2" )
+    waitHashed( t, handler, migrated, 2 * time.Second )
+    for name, store := range map[string]Store{ "old": from, "new": to } {
+        if _, err := store.Get( migrated ); err != nil {
+            t.Errorf( "record %d in the %s store: %v", migrated, name, err )
+        }
+    }
+
+    if response := adminRequest( handler, http.MethodPost, "/v1/admin/migration/cutover", "Bearer admin" ); response.Code != http.StatusConflict {
+        t.Errorf( "cutover before the backfill: got %d %q, want 409", response.Code, response.Body )
+    }
+    response := adminRequest( handler, http.MethodPost, "/v1/admin/migration/backfill", "Bearer admin" )
+    var backfill BackfillResult
+    if err := json.Unmarshal( response.Body.Bytes(), &backfill ); err != nil || response.Code != http.StatusOK {
+        t.Fatalf( "backfill: got %d %q (%v), want 200", response.Code, response.Body, err )
+    }
+    if backfill.Copied != 1 || backfill.Present != 1 {
+        t.Errorf( "backfill: got %+v, want 1 copied, 1 present", backfill )
+    }
+    if response := adminRequest( handler, http.MethodPost, "/v1/admin/migration/cutover", "Bearer admin" ); response.Code != http.StatusOK {
+        t.Fatalf( "cutover: got %d %q, want 200", response.Code, response.Body )
+    }
+
+    cutOver := postPassword( t, handler, "angryMonkey3" )
+    waitHashed( t, handler, cutOver, 2 * time.Second )
+    if _, err := from.Get( cutOver ); !errors.Is( err, ErrRecordNotFound ) {
+        t.Errorf( "record %d stored in the old store after the cutover: %v", cutOver, err )
+    }
+    if response := getHash( handler, old ); response.Code != http.StatusOK {
+        t.Errorf( "GET of a backfilled record: got %d %q, want 200", response.Code, response.Body )
+    }
+    if response := adminRequest( handler, http.MethodPost, "/v1/admin/migration/backfill", "Bearer admin" ); response.Code != http.StatusConflict {
+        t.Errorf( "backfill after the cutover: got %d %q, want 409", response.Code, response.Body )
+    }
+}
+
+// The migration endpoints need the admin token, and only exist while
+// migrating
+func TestMigrationEndpointsNeedAdminToken( t *testing.T ) {
+    _, handler := newTestServer( t, 0, WithStore( NewMemoryStore() ), WithMigration( NewMemoryStore() ) )
+    for _, target := range []string{ "/v1/admin/migration/backfill", "/v1/admin/migration/cutover" } {
+        if response := adminRequest( handler, http.MethodPost, target, "" ); response.Code != http.StatusForbidden {
+            t.Errorf( "POST %s without -admin-token: got %d, want 403", target, response.Code )
+        }
+    }
+
+    _, handler = newTestServer( t, 0 )
+    if response := adminRequest( handler, http.MethodPost, "/v1/admin/migration/backfill", "" ); response.Code != http.StatusNotFound {
+        t.Errorf( "POST /v1/admin/migration/backfill without a migration: got %d, want 404", response.Code )
+    }
+}
@@ -0,0 +1,315 @@
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Parameter of an API operation. In is "query", "path", "header" or
+// "form", form parameters make up the request body.
+type apiParam struct {
+    Name string
+    In string
+    Type string
+    Required bool
+    Description string
+}
+
+// Response of an API operation. Body is a value of the type encoded
+// in the response, nil for none.
+type apiResponse struct {
+    Status int
+    Description string
+    ContentType string
+    Body interface{}
+}
+
+// API operation described in /openapi.json
+type apiOperation struct {
+    Path string
+    Method string
+    Summary string
+    Admin bool
+    Deprecated bool
+    Params []apiParam
+    JSONBody interface{}
+    Responses []apiResponse
+}
+
+/********************************************************************
+handle()
+    Registers a handler for a "METHOD /path" pattern together with
+    the API operation it serves, which makes up /openapi.json. GET
+    patterns also match HEAD requests.
+********************************************************************/
+func ( s *Server ) handle( pattern string, handler http.HandlerFunc, operations ...apiOperation ) {
+    s.mux.HandleFunc( pattern, s.withCacheControl( pattern, handler ) )
+
+    method, path, _ := strings.Cut( pattern, " " )
+    for _, operation := range operations {
+        operation.Method = method
+        operation.Path = strings.TrimSuffix( path, "{$}" )
+        s.apiOperations = append( s.apiOperations, operation )
+    }
+}
+
+/********************************************************************
+handleAPI()
+    Registers an API route under apiVersion, along with its
+    unversioned path as a deprecated alias served by the same
+    handler, announced as listed in the deprecation table.
+********************************************************************/
+func ( s *Server ) handleAPI( pattern string, handler http.HandlerFunc, operations ...apiOperation ) {
+    method, path, _ := strings.Cut( pattern, " " )
+    s.handle( method + " " + apiVersion + path, handler, operations... )
+
+    aliases := make([]apiOperation, len( operations ))
+    for i, operation := range operations {
+        operation.Deprecated = true
+        operation.Summary += ", deprecated alias of " + apiVersion + path
+        aliases[ i ] = operation
+    }
+    s.handle( pattern, s.withDeprecation( pattern, handler ), aliases... )
+}
+
+/********************************************************************
+handleOpenAPI()
+    Handles GET requests on /openapi.json, returning the OpenAPI 3
+    document for the registered routes.
+********************************************************************/
+func ( s *Server ) handleOpenAPI( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /openapi.json" )
+
+    document := openAPIDocument( s.apiOperations )
+
+    w.Header().Set( "Content-Type", "application/json" )
+    json.NewEncoder(w).Encode(document)
+}
+
+/********************************************************************
+openAPIDocument()
+    Builds an OpenAPI 3 document from API operations, with a schema
+    in components for every named type in a request or response.
+********************************************************************/
+func openAPIDocument( operations []apiOperation ) map[string]interface{} {
+    schemas := map[string]interface{}{}
+    paths := map[string]map[string]interface{}{}
+
+    for _, operation := range operations {
+        item := paths[ operation.Path ]
+        if item == nil {
+            item = map[string]interface{}{}
+            paths[ operation.Path ] = item
+        }
+
+        description := map[string]interface{}{
+            "summary": operation.Summary,
+            "operationId": operationId( operation ),
+        }
+        if operation.Deprecated {
+            description[ "deprecated" ] = true
+        }
+        if operation.Admin {
+            description[ "security" ] = []interface{}{ map[string]interface{}{ "adminToken": []string{} } }
+        }
+
+        parameters := []interface{}{}
+        form := map[string]interface{}{}
+        formRequired := []string{}
+        for _, param := range operation.Params {
+            schema := map[string]interface{}{ "type": param.Type }
+            if param.In == "form" {
+                schema[ "description" ] = param.Description
+                form[ param.Name ] = schema
+                if param.Required {
+                    formRequired = append( formRequired, param.Name )
+                }
+                continue
+            }
+            parameters = append( parameters, map[string]interface{}{
+                "name": param.Name,
+                "in": param.In,
+                "required": param.Required || param.In == "path",
+                "description": param.Description,
+                "schema": schema,
+            } )
+        }
+        if len( parameters ) > 0 {
+            description[ "parameters" ] = parameters
+        }
+
+        // Operations taking a form may also take the same fields as JSON
+        content := map[string]interface{}{}
+        if len( form ) > 0 {
+            schema := map[string]interface{}{ "type": "object", "properties": form }
+            if len( formRequired ) > 0 {
+                schema[ "required" ] = formRequired
+            }
+            content[ "application/x-www-form-urlencoded" ] = map[string]interface{}{ "schema": schema }
+        }
+        if operation.JSONBody != nil {
+            content[ "application/json" ] = map[string]interface{}{ "schema": schemaOf( reflect.TypeOf( operation.JSONBody ), schemas ) }
+        }
+        if len( content ) > 0 {
+            requestBody := map[string]interface{}{ "content": content }
+            if len( form ) == 0 {
+                requestBody[ "required" ] = true
+            }
+            description[ "requestBody" ] = requestBody
+        }
+
+        responses := map[string]interface{}{}
+        for _, response := range operation.Responses {
+            description := map[string]interface{}{ "description": response.Description }
+            if response.Body == nil && response.Status >= http.StatusBadRequest {
+                response.Body = ErrorResponse{}
+            }
+            if response.Body != nil {
+                contentType := response.ContentType
+                if contentType == "" {
+                    contentType = "application/json"
+                }
+                description[ "content" ] = map[string]interface{}{
+                    contentType: map[string]interface{}{ "schema": schemaOf( reflect.TypeOf( response.Body ), schemas ) },
+                }
+            }
+            responses[ strconv.Itoa( response.Status ) ] = description
+        }
+        description[ "responses" ] = responses
+
+        item[ strings.ToLower( operation.Method ) ] = description
+    }
+
+    return map[string]interface{}{
+        "openapi": "3.0.3",
+        "info": map[string]interface{}{
+            "title": "Password Hashing Server",
+            "version": "1.0.0",
+        },
+        "paths": paths,
+        "components": map[string]interface{}{
+            "schemas": schemas,
+            "securitySchemes": map[string]interface{}{
+                "adminToken": map[string]interface{}{ "type": "http", "scheme": "bearer" },
+            },
+        },
+    }
+}
+
+/********************************************************************
+operationId()
+    Returns a unique name for an operation from its method and path,
+    e.g. getHashIdStatus for GET /hash/{id}/status.
+********************************************************************/
+func operationId( operation apiOperation ) string {
+    name := strings.ToLower( operation.Method )
+    for _, part := range strings.FieldsFunc( operation.Path, func( c rune ) bool {
+        return c == '/' || c == '{' || c == '}' || c == '.' || c == '-'
+    } ) {
+        name += strings.ToUpper( part[ :1 ] ) + part[ 1: ]
+    }
+    return name
+}
+
+/********************************************************************
+schemaOf()
+    Returns the JSON schema of a type as encoded by encoding/json.
+    Exported struct types are added to schemas and referenced.
+********************************************************************/
+func schemaOf( t reflect.Type, schemas map[string]interface{} ) map[string]interface{} {
+    for t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+
+    switch {
+    case t == reflect.TypeOf( time.Time{} ):
+        return map[string]interface{}{ "type": "string", "format": "date-time" }
+    case t == reflect.TypeOf( json.RawMessage{} ):
+        return map[string]interface{}{}
+    }
+
+    switch t.Kind() {
+    case reflect.Bool:
+        return map[string]interface{}{ "type": "boolean" }
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+        return map[string]interface{}{ "type": "integer", "format": "int32" }
+    case reflect.Int64, reflect.Uint64:
+        return map[string]interface{}{ "type": "integer", "format": "int64" }
+    case reflect.Float32, reflect.Float64:
+        return map[string]interface{}{ "type": "number" }
+    case reflect.String:
+        return map[string]interface{}{ "type": "string" }
+    case reflect.Slice, reflect.Array:
+        return map[string]interface{}{ "type": "array", "items": schemaOf( t.Elem(), schemas ) }
+    case reflect.Map:
+        return map[string]interface{}{ "type": "object", "additionalProperties": schemaOf( t.Elem(), schemas ) }
+    case reflect.Struct:
+        name := t.Name()
+        if name == "" || !( name[ 0 ] >= 'A' && name[ 0 ] <= 'Z' ) {
+            return structSchema( t, schemas )
+        }
+        if _, ok := schemas[ name ]; !ok {
+            // Reserve the name first so recursive types terminate
+            schemas[ name ] = nil
+            schemas[ name ] = structSchema( t, schemas )
+        }
+        return map[string]interface{}{ "$ref": "#/components/schemas/" + name }
+    }
+    return map[string]interface{}{}
+}
+
+/********************************************************************
+structSchema()
+    Returns the object schema of a struct's JSON encoded fields.
+    Fields without omitempty are required.
+********************************************************************/
+func structSchema( t reflect.Type, schemas map[string]interface{} ) map[string]interface{} {
+    properties := map[string]interface{}{}
+    required := []string{}
+
+    var addFields func( t reflect.Type )
+    addFields = func( t reflect.Type ) {
+        for i := 0; i < t.NumField(); i++ {
+            field := t.Field( i )
+            tag := field.Tag.Get( "json" )
+            if tag == "-" {
+                continue
+            }
+
+            // Embedded structs are flattened, like encoding/json does
+            if field.Anonymous && tag == "" {
+                embedded := field.Type
+                for embedded.Kind() == reflect.Ptr {
+                    embedded = embedded.Elem()
+                }
+                addFields( embedded )
+                continue
+            }
+            if !field.IsExported() {
+                continue
+            }
+
+            name, options, _ := strings.Cut( tag, "," )
+            if name == "" {
+                name = field.Name
+            }
+            properties[ name ] = schemaOf( field.Type, schemas )
+            if !strings.Contains( options, "omitempty" ) {
+                required = append( required, name )
+            }
+        }
+    }
+    addFields( t )
+
+    schema := map[string]interface{}{ "type": "object", "properties": properties }
+    if len( required ) > 0 {
+        sort.Strings( required )
+        schema[ "required" ] = required
+    }
+    return schema
+}
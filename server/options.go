@@ -0,0 +1,200 @@
+package server
+
+import (
+    "log/slog"
+    "time"
+)
+
+// Option customizes a Server created by New()
+type Option func( s *Server )
+
+// Password hashing algorithm used for new records
+type Hasher interface {
+    // Name recorded as each record's algorithm
+    Algorithm() string
+
+    // Returns the encoded hash of a password
+    Hash( password string ) string
+}
+
+// Source of the current time for records, deadlines and expiry.
+// Delays follow it too, but elapse on real timers at the latest.
+type Clock interface {
+    Now() time.Time
+}
+
+// Default hasher, base64 encoded SHA512
+type sha512Hasher struct{}
+
+func ( sha512Hasher ) Algorithm() string {
+    return "sha512"
+}
+
+func ( sha512Hasher ) Hash( password string ) string {
+    return hashPassword( password )
+}
+
+// Default clock, the system time
+type systemClock struct{}
+
+func ( systemClock ) Now() time.Time {
+    return time.Now()
+}
+
+/********************************************************************
+WithConfig()
+    Sets the configuration, replacing DefaultConfig().
+********************************************************************/
+func WithConfig( config Config ) Option {
+    return func( s *Server ) {
+        s.config = config
+    }
+}
+
+/********************************************************************
+WithDelay()
+    Sets how long passwords wait before being hashed, 5 seconds by
+    default.
+********************************************************************/
+func WithDelay( delay time.Duration ) Option {
+    return func( s *Server ) {
+        s.delay = delay
+    }
+}
+
+/********************************************************************
+WithHasher()
+    Sets the algorithm new passwords are hashed with, SHA512 by
+    default. Deduplication and idempotency still compare SHA512
+    digests, so the hasher doesn't need to be deterministic.
+********************************************************************/
+func WithHasher( hasher Hasher ) Option {
+    return func( s *Server ) {
+        s.hasher = hasher
+    }
+}
+
+/********************************************************************
+WithLogger()
+    Sets the logger for requests and server events, slog.Default()
+    by default.
+********************************************************************/
+func WithLogger( logger *slog.Logger ) Option {
+    return func( s *Server ) {
+        s.logger = logger
+    }
+}
+
+/********************************************************************
+WithAccessLogger()
+    Sets the logger writing one access log record per request, not
+    recorded by default. The query isn't logged, as it can carry
+    shutdown tokens and URL signatures.
+********************************************************************/
+func WithAccessLogger( logger *slog.Logger ) Option {
+    return func( s *Server ) {
+        s.accessLogger = logger
+    }
+}
+
+/********************************************************************
+WithClock()
+    Sets the clock, the system time by default.
+********************************************************************/
+func WithClock( clock Clock ) Option {
+    return func( s *Server ) {
+        s.clock = clock
+    }
+}
+
+/********************************************************************
+WithStore()
+    Sets the store of the hashed password records, NewMemoryStore()
+    by default.
+********************************************************************/
+func WithStore( store Store ) Option {
+    return func( s *Server ) {
+        s.store = store
+    }
+}
+
+/********************************************************************
+WithMigration()
+    Migrates the records of from, the store used so far, to the one
+    set with WithStore() without downtime: records are written to
+    both and read from the new one, falling back to from, until POST
+    /admin/migration/cutover once POST /admin/migration/backfill has
+    copied the rest.
+********************************************************************/
+func WithMigration( from Store ) Option {
+    return func( s *Server ) {
+        s.migrationSource = from
+    }
+}
+
+/********************************************************************
+WithArchive()
+    Archives the records every Config.ArchiveInterval and on shutdown,
+    encrypted with Config.ArchiveKey, and restores an empty store from
+    the latest archive on startup.
+********************************************************************/
+func WithArchive( archive Archive ) Option {
+    return func( s *Server ) {
+        s.archive = archive
+    }
+}
+
+/********************************************************************
+WithKeySource()
+    Encrypts the persisted records with the master key fetched from a
+    key source, e.g. NewKMSKeySource() or NewVaultKeySource(), rather
+    than Config.EncryptionKey, fetching it again every
+    Config.KeyRefreshInterval.
+********************************************************************/
+func WithKeySource( source KeySource ) Option {
+    return func( s *Server ) {
+        s.keySource = source
+    }
+}
+
+/********************************************************************
+since()
+    Returns the time elapsed since t on the server's clock.
+********************************************************************/
+func ( s *Server ) since( t time.Time ) time.Duration {
+    return s.clock.Now().Sub( t )
+}
+
+/********************************************************************
+until()
+    Returns the time until t on the server's clock.
+********************************************************************/
+func ( s *Server ) until( t time.Time ) time.Duration {
+    return t.Sub( s.clock.Now() )
+}
+
+/********************************************************************
+sleep()
+    Waits for d to elapse on the server's clock, reading a clock
+    other than the system's every dispatchPollInterval so it sees the
+    clock moved. Returns false if stop is closed first.
+********************************************************************/
+func ( s *Server ) sleep( d time.Duration, stop <-chan struct{} ) bool {
+    wakeAt := s.clock.Now().Add( d )
+    for {
+        remaining := s.until( wakeAt )
+        if remaining <= 0 {
+            return true
+        }
+        if _, system := s.clock.( systemClock ); !system && remaining > dispatchPollInterval {
+            remaining = dispatchPollInterval
+        }
+        timer := time.NewTimer( remaining )
+        select {
+        case <-timer.C:
+        case <-stop:
+            timer.Stop()
+            return false
+        }
+    }
+}
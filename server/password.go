@@ -0,0 +1,118 @@
+package server
+
+import (
+    "encoding/json"
+    "fmt"
+    "mime"
+    "net/http"
+    "net/url"
+    "strconv"
+)
+
+// Content types of POST /hash bodies, listed in Accept-Post when a
+// request is rejected with 415 Unsupported Media Type
+const hashPostContentTypes = "application/x-www-form-urlencoded, multipart/form-data, application/json"
+
+// JSON body of POST /hash, with the same fields as the form
+type HashRequest struct {
+    Password *string `json:"password"`
+    Labels map[string]string `json:"labels,omitempty"`
+    CompleteBy string `json:"complete_by,omitempty"`
+    Ttl string `json:"ttl,omitempty"`
+    CallbackURL string `json:"callback_url,omitempty"`
+    Sync bool `json:"sync,omitempty"`
+}
+
+/********************************************************************
+parseHashBody()
+    Checks the Content-Type of a POST /hash request body, answering
+    415 Unsupported Media Type unless it is form encoded or JSON. A
+    JSON body is decoded into the request's form, so it is handled
+    like the form fields. A password in the query string would end
+    up in URL logs, it is rejected with 400 Bad Request. Returns
+    false once an error was written.
+********************************************************************/
+func ( s *Server ) parseHashBody( w http.ResponseWriter, r *http.Request ) bool {
+    if r.URL.Query().Has( "password" ) {
+        s.log( r ).Info( "Password in the query string" )
+        writeError( w, http.StatusBadRequest, ErrorInvalidRequest )
+        return false
+    }
+    if r.ContentLength == 0 {
+        return true
+    }
+
+    mediaType, _, _ := mime.ParseMediaType( r.Header.Get( "Content-Type" ) )
+    switch mediaType {
+    case "application/x-www-form-urlencoded", "multipart/form-data":
+        return true
+    case "application/json":
+    default:
+        s.log( r ).Info( "Unsupported Content-Type", "content_type", r.Header.Get( "Content-Type" ) )
+        w.Header().Set( "Accept-Post", hashPostContentTypes )
+        writeError( w, http.StatusUnsupportedMediaType, ErrorUnsupportedMediaType )
+        return false
+    }
+
+    var request HashRequest
+    if err := json.NewDecoder( http.MaxBytesReader( w, r.Body, 32 << 20 ) ).Decode( &request ); err != nil {
+        s.log( r ).Info( "Invalid JSON body", "error", jsonError( err ) )
+        writeError( w, http.StatusBadRequest, ErrorInvalidRequest )
+        return false
+    }
+
+    form := url.Values{}
+    if request.Password != nil {
+        form.Set( "password", *request.Password )
+    }
+    for key, value := range request.Labels {
+        form.Add( "label", key + ":" + value )
+    }
+    for name, value := range map[string]string{ "complete_by": request.CompleteBy, "ttl": request.Ttl, "callback_url": request.CallbackURL } {
+        if value != "" {
+            form.Set( name, value )
+        }
+    }
+    if request.Sync {
+        form.Set( "sync", strconv.FormatBool( request.Sync ) )
+    }
+
+    // Query parameters still apply, after the body's like for forms
+    r.PostForm = form
+    r.Form = url.Values{}
+    for name, values := range form {
+        r.Form[ name ] = append( r.Form[ name ], values... )
+    }
+    for name, values := range r.URL.Query() {
+        r.Form[ name ] = append( r.Form[ name ], values... )
+    }
+    return true
+}
+
+/********************************************************************
+passwordFormValue()
+    Returns the "password" field of a POST /hash request body, never
+    the query string's. On failure returns an error code telling
+    apart an empty request body, a missing password field and an
+    empty password, the last only being an error unless
+    AllowEmptyPassword is set.
+********************************************************************/
+func ( s *Server ) passwordFormValue( r *http.Request ) ( password string, code string, err error ) {
+    if r.ContentLength == 0 {
+        return "", ErrorEmptyBody, fmt.Errorf( "empty request body" )
+    }
+
+    r.ParseMultipartForm( 32 << 20 )
+    values, present := r.PostForm[ "password" ]
+    if !present {
+        return "", ErrorMissingPassword, fmt.Errorf( "missing password field" )
+    }
+
+    password = values[ 0 ]
+    redactPassword( r, password )
+    if password == "" && !s.config.AllowEmptyPassword {
+        return "", ErrorEmptyPassword, fmt.Errorf( "empty password" )
+    }
+
+    return password, "", nil
+}
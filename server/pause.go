@@ -0,0 +1,96 @@
+package server
+
+import (
+    "fmt"
+    "net/http"
+    "time"
+)
+
+/********************************************************************
+pausedTime()
+    Returns the total time job processing has spent paused so far,
+    including the current pause if there is one.
+********************************************************************/
+func ( s *Server ) pausedTime() time.Duration {
+    s.pauseMutex.Lock()
+    defer s.pauseMutex.Unlock()
+
+    total := s.pausedTotal
+    if s.paused {
+        total += s.since( s.pausedSince )
+    }
+    return total
+}
+
+/********************************************************************
+waitWhilePaused()
+    Blocks until job processing is not paused, returning false if
+    stop is closed first.
+********************************************************************/
+func ( s *Server ) waitWhilePaused( stop <-chan struct{} ) bool {
+    for {
+        s.pauseMutex.Lock()
+        isPaused := s.paused
+        resumed := s.pauseResumed
+        s.pauseMutex.Unlock()
+
+        if !isPaused {
+            return true
+        }
+        select {
+        case <-resumed:
+        case <-stop:
+            return false
+        }
+    }
+}
+
+/********************************************************************
+handlePause()
+    Handles POST requests on /admin/pause. Stops dispatching queued
+    jobs for hashing while new submissions are still accepted.
+********************************************************************/
+func ( s *Server ) handlePause( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /admin/pause" )
+
+    s.pauseMutex.Lock()
+    if !s.paused {
+        s.paused = true
+        s.pausedSince = s.clock.Now()
+    }
+    s.pauseMutex.Unlock()
+
+    s.auditRequest( r, AuditPause, "" )
+    fmt.Fprintf( w, "Processing Paused!" )
+}
+
+/********************************************************************
+handleResume()
+    Handles POST requests on /admin/resume. Resumes dispatching of
+    queued jobs, releasing any that came due while paused.
+********************************************************************/
+func ( s *Server ) handleResume( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /admin/resume" )
+
+    s.pauseMutex.Lock()
+    if s.paused {
+        s.paused = false
+        s.pausedTotal += s.since( s.pausedSince )
+        close( s.pauseResumed )
+        s.pauseResumed = make(chan struct{})
+    }
+    s.pauseMutex.Unlock()
+
+    s.auditRequest( r, AuditResume, "" )
+    fmt.Fprintf( w, "Processing Resumed!" )
+}
+
+/********************************************************************
+isPaused()
+    Returns true if job processing is currently paused.
+********************************************************************/
+func ( s *Server ) isPaused() bool {
+    s.pauseMutex.Lock()
+    defer s.pauseMutex.Unlock()
+    return s.paused
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "net"
+    "net/http"
+    "time"
+)
+
+// Who submitted a password and from where, only exposed to admins
+type Provenance struct {
+    Principal string `json:"principal,omitempty"`
+    ClientIp string `json:"client_ip"`
+    UserAgent string `json:"user_agent,omitempty"`
+    RequestId string `json:"request_id"`
+    SubmittedAt time.Time `json:"submitted_at"`
+}
+
+// Hashed password record as returned to admins
+type adminRecord struct {
+    *Record
+    Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+/********************************************************************
+newProvenance()
+    Captures the provenance of a submission. The principal is the
+    basic auth user name, if any, and the request id the one its log
+    lines carry.
+********************************************************************/
+func newProvenance( r *http.Request, submittedAt time.Time ) *Provenance {
+    principal, _, _ := r.BasicAuth()
+
+    return &Provenance{
+        Principal: principal,
+        ClientIp: clientIp( r ),
+        UserAgent: r.UserAgent(),
+        RequestId: requestId( r ),
+        SubmittedAt: submittedAt,
+    }
+}
+
+/********************************************************************
+clientIp()
+    Returns the IP address a request came from.
+********************************************************************/
+func clientIp( r *http.Request ) string {
+    ip, _, err := net.SplitHostPort( r.RemoteAddr )
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return ip
+}
+
+/********************************************************************
+newRequestId()
+    Returns a random 128 bit request id as a hex string.
+********************************************************************/
+func newRequestId() string {
+    id := make([]byte, 16)
+    rand.Read( id )
+    return hex.EncodeToString( id )
+}
+
+/********************************************************************
+handleAdminHashGet()
+    Handles GET requests on /admin/hash/{id}, returning the record
+    along with its provenance as JSON.
+********************************************************************/
+func ( s *Server ) handleAdminHashGet( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /admin/hash/{id} GET" )
+
+    id := pathId( r )
+    record, err := s.getRecord( id )
+    if err != nil {
+        s.writeStoreError( w, r, err )
+        return
+    }
+    if record == nil {
+        s.log( r ).Info( "Passsword id not found!" )
+        writeError( w, http.StatusNotFound, ErrorNotFound )
+        return
+    }
+
+    s.writeEncoded( w, r, http.StatusOK, adminRecord{ Record: record, Provenance: record.provenance } )
+}
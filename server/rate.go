@@ -0,0 +1,96 @@
+package server
+
+import (
+    "math"
+    "time"
+)
+
+// Interval at which the request rates decay, as in the Unix load
+// average
+const rateTickInterval = 5 * time.Second
+
+// Ticks after which an idle rate is treated as zero, instead of
+// decaying it tick by tick
+const rateMaxTicks = 10000
+
+// Exponentially weighted rates reported by /stats, shortest first
+var statRates = []struct {
+    name string
+    length time.Duration
+}{
+    { "1m", time.Minute },
+    { "5m", 5 * time.Minute },
+    { "15m", 15 * time.Minute },
+}
+
+// 1, 5 and 15 minute exponentially weighted moving averages of a
+// request rate. Requests are counted as they come and folded into the
+// averages every rateTickInterval, catching up on the ticks missed
+// when the rates are next marked or read. Guarded by mapMutex
+type ewmaRates struct {
+    uncounted int64
+    lastTick time.Time
+    initialized bool
+    rates [ 3 ]float64 // Requests per second, by statRates
+}
+
+/********************************************************************
+mark()
+    Counts a request made at now.
+********************************************************************/
+func ( e *ewmaRates ) mark( now time.Time ) {
+    e.tick( now )
+    e.uncounted++
+}
+
+/********************************************************************
+tick()
+    Folds the requests counted since the last tick into the averages,
+    once for every rateTickInterval elapsed up to now.
+********************************************************************/
+func ( e *ewmaRates ) tick( now time.Time ) {
+    if e.lastTick.IsZero() {
+        e.lastTick = now
+        return
+    }
+
+    ticks := int64( now.Sub( e.lastTick ) / rateTickInterval )
+    if ticks <= 0 {
+        return
+    }
+    e.lastTick = e.lastTick.Add( time.Duration( ticks ) * rateTickInterval )
+
+    for i := int64( 0 ); i < ticks; i++ {
+        if i >= rateMaxTicks {
+            e.rates = [ 3 ]float64{}
+            break
+        }
+
+        instant := float64( e.uncounted ) / rateTickInterval.Seconds()
+        e.uncounted = 0
+        for j, rate := range statRates {
+            if !e.initialized {
+                e.rates[ j ] = instant
+                continue
+            }
+            alpha := 1 - math.Exp( -rateTickInterval.Seconds() / rate.length.Seconds() )
+            e.rates[ j ] += alpha * ( instant - e.rates[ j ] )
+        }
+        e.initialized = true
+    }
+}
+
+/********************************************************************
+snapshot()
+    Returns the rates at now in requests per second, by statRates
+    name.
+********************************************************************/
+func ( e *ewmaRates ) snapshot( now time.Time ) map[string]float64 {
+    e.tick( now )
+
+    rates := make(map[string]float64, len( statRates ))
+    for i, rate := range statRates {
+        rates[ rate.name ] = e.rates[ i ]
+    }
+    return rates
+}
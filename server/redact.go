@@ -0,0 +1,204 @@
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// Written in place of a password, or of any other secret
+const redacted = "[REDACTED]"
+
+// Log attributes whose values are always redacted, by lower case key
+var sensitiveKeys = map[string]bool{
+    "password": true,
+    "token": true,
+    "secret": true,
+    "authorization": true,
+    "sig": true,
+    "proxy-authorization": true,
+    "cookie": true,
+    "set-cookie": true,
+    "x-shutdown-token": true,
+    "idempotency-key": true,
+}
+
+// Plaintext password, which is never formatted or logged as itself
+type secret string
+
+func ( secret ) String() string {
+    return redacted
+}
+
+func ( secret ) GoString() string {
+    return redacted
+}
+
+func ( secret ) LogValue() slog.Value {
+    return slog.StringValue( redacted )
+}
+
+func ( secret ) MarshalJSON() ( []byte, error ) {
+    return json.Marshal( redacted )
+}
+
+// Context key of a request's secrets
+type secretsKey struct{}
+
+// Passwords a request carries, scrubbed from every line its logger
+// writes. A nil secrets scrubs nothing
+type secrets struct {
+    mutex sync.Mutex
+    values []string
+}
+
+/********************************************************************
+add()
+    Adds a password to scrub.
+********************************************************************/
+func ( ss *secrets ) add( value string ) {
+    if ss == nil || value == "" {
+        return
+    }
+
+    ss.mutex.Lock()
+    defer ss.mutex.Unlock()
+    ss.values = append( ss.values, value )
+
+    // Longest first, so a password containing another is scrubbed whole
+    sort.Slice( ss.values, func( i, j int ) bool {
+        return len( ss.values[ i ] ) > len( ss.values[ j ] )
+    } )
+}
+
+/********************************************************************
+scrub()
+    Returns the text with every password replaced by [REDACTED].
+********************************************************************/
+func ( ss *secrets ) scrub( text string ) string {
+    if ss == nil {
+        return text
+    }
+
+    ss.mutex.Lock()
+    defer ss.mutex.Unlock()
+    for _, value := range ss.values {
+        text = strings.ReplaceAll( text, value, redacted )
+    }
+    return text
+}
+
+/********************************************************************
+requestSecrets()
+    Returns the secrets of the request a context belongs to, nil
+    outside of requests.
+********************************************************************/
+func requestSecrets( ctx context.Context ) *secrets {
+    ss, _ := ctx.Value( secretsKey{} ).( *secrets )
+    return ss
+}
+
+/********************************************************************
+redactPassword()
+    Registers the password of a request, so that no line logged for
+    it, even at debug level, and no GraphQL error it gets contains
+    the password.
+********************************************************************/
+func redactPassword( r *http.Request, password string ) {
+    requestSecrets( r.Context() ).add( password )
+}
+
+/********************************************************************
+jsonError()
+    Describes why a request body isn't valid JSON without quoting
+    it, as syntax errors quote the character at fault, which can be
+    part of a password.
+********************************************************************/
+func jsonError( err error ) string {
+    var syntaxError *json.SyntaxError
+    if errors.As( err, &syntaxError ) {
+        return fmt.Sprintf( "invalid JSON at offset %d", syntaxError.Offset )
+    }
+    return err.Error()
+}
+
+// Handler redacting the values of sensitiveKeys attributes, and the
+// passwords of a request from the message and every attribute
+type redactHandler struct {
+    next slog.Handler
+    secrets *secrets
+}
+
+/********************************************************************
+newRedactHandler()
+    Wraps a handler so it redacts what it is handed. Wrapping a
+    redactHandler replaces the secrets it scrubs.
+********************************************************************/
+func newRedactHandler( next slog.Handler, ss *secrets ) slog.Handler {
+    if handler, ok := next.( *redactHandler ); ok {
+        next = handler.next
+    }
+    return &redactHandler{ next: next, secrets: ss }
+}
+
+func ( h *redactHandler ) Enabled( ctx context.Context, level slog.Level ) bool {
+    return h.next.Enabled( ctx, level )
+}
+
+func ( h *redactHandler ) Handle( ctx context.Context, record slog.Record ) error {
+    redactedRecord := slog.NewRecord( record.Time, record.Level, h.secrets.scrub( record.Message ), record.PC )
+    record.Attrs( func( attr slog.Attr ) bool {
+        redactedRecord.AddAttrs( h.redactAttr( attr ) )
+        return true
+    } )
+    return h.next.Handle( ctx, redactedRecord )
+}
+
+func ( h *redactHandler ) WithAttrs( attrs []slog.Attr ) slog.Handler {
+    redactedAttrs := make([]slog.Attr, len( attrs ))
+    for i, attr := range attrs {
+        redactedAttrs[ i ] = h.redactAttr( attr )
+    }
+    return &redactHandler{ next: h.next.WithAttrs( redactedAttrs ), secrets: h.secrets }
+}
+
+func ( h *redactHandler ) WithGroup( name string ) slog.Handler {
+    return &redactHandler{ next: h.next.WithGroup( name ), secrets: h.secrets }
+}
+
+/********************************************************************
+redactAttr()
+    Returns the attribute with its value redacted if its key is
+    sensitive, and with the passwords scrubbed from it otherwise.
+    Values that aren't strings are scrubbed as formatted.
+********************************************************************/
+func ( h *redactHandler ) redactAttr( attr slog.Attr ) slog.Attr {
+    if sensitiveKeys[ strings.ToLower( attr.Key ) ] {
+        return slog.String( attr.Key, redacted )
+    }
+
+    value := attr.Value.Resolve()
+    switch value.Kind() {
+    case slog.KindString:
+        return slog.String( attr.Key, h.secrets.scrub( value.String() ) )
+    case slog.KindGroup:
+        group := value.Group()
+        redactedGroup := make([]interface{}, len( group ))
+        for i, member := range group {
+            redactedGroup[ i ] = h.redactAttr( member )
+        }
+        return slog.Group( attr.Key, redactedGroup... )
+    case slog.KindAny:
+        formatted := fmt.Sprint( value.Any() )
+        if scrubbed := h.secrets.scrub( formatted ); scrubbed != formatted {
+            return slog.String( attr.Key, scrubbed )
+        }
+    }
+    return slog.Attr{ Key: attr.Key, Value: value }
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+    "bytes"
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// Buffer the loggers of a test server write to, from any goroutine
+type logBuffer struct {
+    mutex sync.Mutex
+    buffer bytes.Buffer
+}
+
+func ( b *logBuffer ) Write( p []byte ) ( int, error ) {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    return b.buffer.Write( p )
+}
+
+func ( b *logBuffer ) String() string {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    return b.buffer.String()
+}
+
+// Hasher panicking with the password it was handed when it is panicPassword
+type panickingHasher struct {
+    sha512Hasher
+}
+
+const panicPassword = "pw-panic-8d1f"
+
+func ( h panickingHasher ) Hash( password string ) string {
+    if password == panicPassword {
+        panic( "unable to hash " + password )
+    }
+    return h.sha512Hasher.Hash( password )
+}
+
+// No password makes it into the debug log or a response, whichever
+// way it is submitted and however its request fails
+func TestPasswordsRedacted( t *testing.T ) {
+    logs := &logBuffer{}
+    logger := slog.New( slog.NewTextHandler( logs, &slog.HandlerOptions{ Level: slog.LevelDebug } ) )
+    config := DefaultConfig()
+    config.WALFile = filepath.Join( t.TempDir(), "wal.log" )
+    _, handler := newTestServer( t, 0,
+        WithConfig( config ),
+        WithLogger( logger ),
+        WithHasher( panickingHasher{} ),
+        WithMiddleware( Recover( logger ) ) )
+
+    requests := []struct {
+        name string
+        password string
+        method string
+        target string
+        contentType string
+        body string
+    }{
+        { "form", "pw-form-3a9c", http.MethodPost, "/v1/hash", "application/x-www-form-urlencoded",
+            url.Values{ "password": { "pw-form-3a9c" }, "ttl": { "pw-form-3a9c" } }.Encode() },
+        { "json", "pw-json-5b2e", http.MethodPost, "/v1/hash", "application/json",
+            `{"password":"pw-json-5b2e","ttl":"pw-json-5b2e"}` },
+        { "malformed json", "pw-badjson-7c4d", http.MethodPost, "/v1/hash", "application/json",
+            `{"password":"pw-badjson-7c4d" "pw-badjson-7c4d"}` },
+        { "graphql error", "pw-graphql-1e6f", http.MethodPost, "/v1/graphql", "application/json",
+            `{"query":"mutation { submitPassword(password: \"pw-graphql-1e6f\", ttl: \"pw-graphql-1e6f\") { id } }"}` },
+        { "graphql syntax error", "pw-gqlsyntax-9a0b", http.MethodPost, "/v1/graphql", "application/json",
+            `{"query":"mutation { submitPassword(password: \"pw-gqlsyntax-9a0b\" ttl: pw-gqlsyntax-9a0b) { id }"}` },
+        { "panic", panicPassword, http.MethodPost, "/v1/hash", "application/x-www-form-urlencoded",
+            url.Values{ "password": { panicPassword } }.Encode() },
+        { "query string", "pw-query-2d8e", http.MethodPost, "/v1/hash?password=pw-query-2d8e", "application/x-www-form-urlencoded",
+            "" },
+    }
+    for _, test := range requests {
+        request := httptest.NewRequest( test.method, test.target, strings.NewReader( test.body ) )
+        request.Header.Set( "Content-Type", test.contentType )
+        response := httptest.NewRecorder()
+        handler.ServeHTTP( response, request )
+
+        if strings.Contains( response.Body.String(), test.password ) {
+            t.Errorf( "%s: response %d %q contains the password", test.name, response.Code, response.Body )
+        }
+        for name, values := range response.Header() {
+            if strings.Contains( strings.Join( values, " " ), test.password ) {
+                t.Errorf( "%s: response header %s contains the password", test.name, name )
+            }
+        }
+    }
+
+    if !strings.Contains( logs.String(), "Panic serving request!" ) {
+        t.Error( "the panic was not logged" )
+    }
+    for _, test := range requests {
+        if strings.Contains( logs.String(), test.password ) {
+            t.Errorf( "%s: the log contains the password:\n%s", test.name, logs )
+        }
+    }
+}
+
+// A password in the query string is rejected, even alongside one in
+// the body, rather than hashed
+func TestQueryPasswordRejected( t *testing.T ) {
+    _, handler := newTestServer( t, 0 )
+
+    for _, body := range []string{ "", url.Values{ "password": { "angryMonkey" } }.Encode() } {
+        request := httptest.NewRequest( http.MethodPost, "/v1/hash?password=angryMonkey", strings.NewReader( body ) )
+        request.Header.Set( "Content-Type", "application/x-www-form-urlencoded" )
+        response := httptest.NewRecorder()
+        handler.ServeHTTP( response, request )
+
+        if response.Code != http.StatusBadRequest {
+            t.Errorf( "body %q: got %d %q, want 400", body, response.Code, response.Body )
+        }
+    }
+}
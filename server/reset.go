@@ -0,0 +1,64 @@
+package server
+
+import (
+    "net/http"
+    "time"
+)
+
+// Response to POST /stats/reset
+type StatsResetResponse struct {
+    ResetAt time.Time `json:"reset_at"`
+}
+
+/********************************************************************
+handleStatsReset()
+    Handles POST requests on /stats/reset, zeroing the /stats
+    counters, the request counters and latency histograms of
+    /metrics, and the /debug/vars request counts, e.g. between
+    benchmark runs. Records, pending jobs and ids are kept.
+********************************************************************/
+func ( s *Server ) handleStatsReset( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /stats/reset" )
+
+    // Check shutdown
+    if s.shutDown {
+        s.log( r ).Info( "Server has been shut down!" )
+        writeError( w, http.StatusNotAcceptable, ErrorShuttingDown )
+        return
+    }
+
+    resetAt := s.clock.Now()
+
+    s.mapMutex.Lock()
+    s.hashedCount = 0
+    s.totalTime = 0
+    s.slaViolations = 0
+    s.expiredCount = 0
+    s.retentionExpiredCount = 0
+    s.evictedCount = 0
+    s.gcStats = GCStat{}
+    s.labelStats = make(map[string]*labelStat)
+    s.recentHashes = slidingWindow{}
+    s.submissionRates = ewmaRates{}
+    s.statsResetAt = resetAt
+    s.statsSince = resetAt
+    s.mapMutex.Unlock()
+
+    s.endpointMutex.Lock()
+    s.endpointCounters = make(map[string]*endpointCounter)
+    s.endpointMutex.Unlock()
+
+    s.webhookMutex.Lock()
+    s.webhookStats = WebhookStat{}
+    s.webhookMutex.Unlock()
+
+    s.metrics.reset()
+    if s.recordCache != nil {
+        s.recordCache.resetStats()
+    }
+    s.expvarRequests.Init()
+
+    s.log( r ).Info( "Statistics reset!" )
+    s.auditRequest( r, AuditStatsReset, "" )
+    s.writeEncoded( w, r, http.StatusOK, StatsResetResponse{ ResetAt: resetAt } )
+}
@@ -0,0 +1,48 @@
+package server
+
+import "time"
+
+/********************************************************************
+retentionDeadline()
+    Returns when a record falls out of Config.RetentionPeriod, the
+    zero time without one.
+********************************************************************/
+func ( s *Server ) retentionDeadline( record *Record ) time.Time {
+    if s.config.RetentionPeriod <= 0 {
+        return time.Time{}
+    }
+    return record.CompletedAt.Add( s.config.RetentionPeriod )
+}
+
+/********************************************************************
+expiryOf()
+    Returns when a record expires: at the end of its ttl or of the
+    retention period, whichever comes first, nil if neither is set.
+********************************************************************/
+func ( s *Server ) expiryOf( record *Record ) *time.Time {
+    deadline := s.retentionDeadline( record )
+    if deadline.IsZero() || ( record.ExpiresAt != nil && record.ExpiresAt.Before( deadline ) ) {
+        return record.ExpiresAt
+    }
+    return &deadline
+}
+
+/********************************************************************
+recordExpired()
+    Returns true if the record's ttl or the retention period has
+    elapsed by now.
+********************************************************************/
+func ( s *Server ) recordExpired( record *Record, now time.Time ) bool {
+    expiry := s.expiryOf( record )
+    return expiry != nil && !now.Before( *expiry )
+}
+
+/********************************************************************
+retentionExpired()
+    Returns true if the record expires by now because of the
+    retention period rather than its ttl.
+********************************************************************/
+func ( s *Server ) retentionExpired( record *Record, now time.Time ) bool {
+    deadline := s.retentionDeadline( record )
+    return !deadline.IsZero() && !now.Before( deadline ) && !record.expired( deadline )
+}
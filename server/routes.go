@@ -0,0 +1,468 @@
+package server
+
+import (
+    "net/http"
+    "net/http/pprof"
+)
+
+// Version prefix of the API routes
+const apiVersion = "/v1"
+
+var (
+    // Responses shared by many operations
+    apiNotAcceptable = apiResponse{ Status: http.StatusNotAcceptable, Description: "The server is shutting down" }
+    apiUnauthorized = apiResponse{ Status: http.StatusUnauthorized, Description: "Missing or invalid admin bearer token" }
+    apiUnprocessable = apiResponse{ Status: http.StatusUnprocessableEntity, Description: "Invalid parameters" }
+    apiNotFound = apiResponse{ Status: http.StatusNotFound, Description: "Unknown password id" }
+    apiIdParam = apiParam{ Name: "id", In: "path", Type: "integer", Description: "Password id" }
+)
+
+/********************************************************************
+registerRoutes()
+    Registers the handler of every endpoint along with its operation
+    for /openapi.json. API endpoints are served under /v1, and their
+    unversioned paths are deprecated aliases. The operations describe
+    the default responses, not those of -legacy-api. Requests for
+    other paths are 404 Not Found and for other methods 405 Method
+    Not Allowed.
+********************************************************************/
+func ( s *Server ) registerRoutes() {
+    s.handle( "GET /{$}", s.home,
+        apiOperation{ Summary: "Banner", Responses: []apiResponse{
+            { Status: http.StatusOK, Description: "Server banner", ContentType: "text/plain", Body: "" },
+        } },
+    )
+    s.handleAPI( "POST /hash", s.withLegacyAPI( s.handleHashPost, legacyHashPost ),
+        apiOperation{ Summary: "Queue a password for hashing",
+            Params: []apiParam{
+                { Name: "password", In: "form", Type: "string", Required: true, Description: "Password to hash" },
+                { Name: "label", In: "form", Type: "string", Description: "Repeatable key:value label" },
+                { Name: "complete_by", In: "form", Type: "string", Description: "RFC 3339 deadline for the hash" },
+                { Name: "ttl", In: "form", Type: "string", Description: "How long to keep the hash, e.g. 1h" },
+                { Name: "callback_url", In: "form", Type: "string", Description: "URL POSTed the hash once it is ready" },
+                { Name: "sync", In: "form", Type: "boolean", Description: "Wait for the hash and include it in the response" },
+                { Name: "Idempotency-Key", In: "header", Type: "string", Description: "Key making retries return the original id" },
+                { Name: "X-Request-ID", In: "header", Type: "string", Description: "Request id recorded in the provenance" },
+            },
+            JSONBody: HashRequest{},
+            Responses: []apiResponse{
+                { Status: http.StatusAccepted, Description: "Password queued", Body: HashResponse{} },
+                { Status: http.StatusOK, Description: "Password already hashed, or hashed synchronously", Body: HashResponse{} },
+                apiNotAcceptable,
+                { Status: http.StatusBadRequest, Description: "Invalid JSON body, or a password in the query string" },
+                { Status: http.StatusUnsupportedMediaType, Description: "Body neither form encoded nor JSON, Accept-Post lists the supported types" },
+                { Status: http.StatusUnprocessableEntity, Description: "Invalid parameters, X-Error-Code tells missing and empty passwords apart" },
+                { Status: http.StatusServiceUnavailable, Description: "Too many pending passwords" },
+            } },
+    )
+    s.handleAPI( "GET /hash", s.handleHashBulkGet,
+        apiOperation{ Summary: "Look up several password ids",
+            Params: []apiParam{
+                { Name: "ids", In: "query", Type: "string", Required: true, Description: "Comma separated ids, at most 1000" },
+            },
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Status and hash by id", Body: map[string]BulkEntry{} },
+                apiNotAcceptable,
+                apiUnprocessable,
+            } },
+    )
+    s.handleAPI( "GET /hash/{id}", s.withLegacyAPI( s.handleHashGet, legacyHashGet ),
+        apiOperation{ Summary: "Get a hashed password",
+            Params: []apiParam{
+                apiIdParam,
+                { Name: "wait", In: "query", Type: "string", Description: "Long-poll up to this duration, e.g. 10s, for the hash" },
+            },
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "The hash, or the full record with Accept: application/json", Body: Record{} },
+                { Status: http.StatusAccepted, Description: "Password not hashed yet" },
+                apiNotFound,
+                { Status: http.StatusGone, Description: "Hash has been deleted, has expired or been evicted" },
+            } },
+    )
+    s.handleAPI( "GET /hash/{id}/status", s.handleHashStatus,
+        apiOperation{ Summary: "Get the state of a password",
+            Params: []apiParam{ apiIdParam },
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Job state", Body: StatusResponse{} },
+                apiNotAcceptable,
+                apiNotFound,
+            } },
+    )
+    s.handleAPI( "GET /hash/watch", s.handleHashWatch,
+        apiOperation{ Summary: "Wait for any of several passwords to be hashed",
+            Params: []apiParam{
+                { Name: "ids", In: "query", Type: "string", Required: true, Description: "Comma separated ids, at most 1000" },
+                { Name: "timeout", In: "query", Type: "string", Description: "How long to wait, default 30s, max 5m" },
+            },
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Completed records", Body: []Record{} },
+                { Status: http.StatusNoContent, Description: "None completed before the timeout" },
+                { Status: http.StatusBadRequest, Description: "Too many ids" },
+                apiUnprocessable,
+            } },
+    )
+    s.handleAPI( "GET /hash/find", s.withRequiredAdmin( s.handleHashFind ),
+        apiOperation{ Summary: "Find the ids with a given hash", Admin: true,
+            Params: []apiParam{
+                { Name: "digest", In: "query", Type: "string", Required: true, Description: "Hash to look for" },
+            },
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Matching ids", Body: FindResponse{} },
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "No admin token is configured" },
+            } },
+    )
+    s.handleAPI( "GET /hashes", s.handleHashesList,
+        apiOperation{ Summary: "List hashed passwords",
+            Params: []apiParam{
+                { Name: "label", In: "query", Type: "string", Description: "Repeatable key:value filter" },
+            },
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Records in id order", Body: []Record{} },
+                apiNotAcceptable,
+                apiUnprocessable,
+            } },
+    )
+    s.handleAPI( "GET /stats", s.withSignedURL( s.withLegacyAPI( s.handleStats, legacyStats ) ),
+        apiOperation{ Summary: "Get hashing statistics, with the admin token or a signed URL", Admin: true,
+            Params: []apiParam{
+                { Name: "expires", In: "query", Type: "integer", Description: "Expiry of a signed URL" },
+                { Name: "sig", In: "query", Type: "string", Description: "Signature of a signed URL" },
+            },
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Statistics", Body: Stat{} },
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "Invalid or expired signed URL" },
+            } },
+    )
+    // Added after versioning, so without an unversioned alias
+    s.handle( "POST " + apiVersion + "/stats/reset", s.withRequiredAdmin( s.handleStatsReset ),
+        apiOperation{ Summary: "Reset the statistics", Admin: true,
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "When the statistics were reset", Body: StatsResetResponse{} },
+                apiNotAcceptable,
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "No admin token configured" },
+            } },
+    )
+    s.handleAPI( "GET /events", s.handleEvents,
+        apiOperation{ Summary: "Stream completion events",
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Server-Sent Events, each a completed event", ContentType: "text/event-stream", Body: CompletionEvent{} },
+            } },
+    )
+    s.handleAPI( "GET /ws", s.handleWebSocket,
+        apiOperation{ Summary: "Submit passwords and receive hashes over a WebSocket",
+            Responses: []apiResponse{
+                { Status: http.StatusSwitchingProtocols, Description: "WebSocket connection" },
+            } },
+    )
+    // GET is not supported so that a link can't submit passwords
+    // through a mutation
+    s.handleAPI( "POST /graphql", s.handleGraphql,
+        apiOperation{ Summary: "GraphQL queries and mutations", JSONBody: graphqlRequest{},
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "GraphQL result", Body: map[string]interface{}{} },
+                { Status: http.StatusBadRequest, Description: "Invalid request body" },
+            } },
+    )
+    s.handleAPI( "GET /shutdown", s.handleShutDown,
+        apiOperation{ Summary: "Shut the server down gracefully",
+            Params: []apiParam{
+                { Name: "token", In: "query", Type: "string", Description: "One-time token from /admin/shutdown-token" },
+                { Name: "X-Shutdown-Token", In: "header", Type: "string", Description: "One-time token from /admin/shutdown-token" },
+            },
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Shutting down" },
+                { Status: http.StatusForbidden, Description: "Missing, expired or already used token" },
+            } },
+    )
+    s.handleAPI( "POST /admin/pause", s.withRequiredAdmin( s.handlePause ),
+        apiOperation{ Summary: "Stop hashing queued passwords", Admin: true,
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Paused", ContentType: "text/plain", Body: "" },
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "No admin token configured" },
+            } },
+    )
+    s.handleAPI( "POST /admin/resume", s.withRequiredAdmin( s.handleResume ),
+        apiOperation{ Summary: "Resume hashing queued passwords", Admin: true,
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Resumed", ContentType: "text/plain", Body: "" },
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "No admin token configured" },
+            } },
+    )
+    s.handleAPI( "GET /admin/hash/{id}", s.withRequiredAdmin( s.handleAdminHashGet ),
+        apiOperation{ Summary: "Get a record with its provenance", Admin: true,
+            Params: []apiParam{ apiIdParam },
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Record and provenance", Body: adminRecord{} },
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "No admin token configured" },
+                apiNotFound,
+            } },
+    )
+    s.handleAPI( "POST /admin/signed-url", s.withRequiredAdmin( s.handleSignedURL ),
+        apiOperation{ Summary: "Issue a signed /stats URL", Admin: true,
+            Params: []apiParam{
+                { Name: "ttl", In: "form", Type: "string", Description: "How long the URL stays valid, default 24h, max 30 days" },
+            },
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Signed URL", Body: SignedURLResponse{} },
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "No admin token configured" },
+                apiUnprocessable,
+            } },
+    )
+    s.handleAPI( "GET /admin/webhooks/dead-letters", s.withRequiredAdmin( s.handleDeadLetters ),
+        apiOperation{ Summary: "List undelivered webhook callbacks", Admin: true,
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Dead letters", Body: []DeadLetter{} },
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "No admin token configured" },
+            } },
+    )
+    s.handleAPI( "POST /admin/shutdown-token", s.withRequiredAdmin( s.handleShutdownToken ),
+        apiOperation{ Summary: "Issue a one-time /shutdown token", Admin: true,
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Token, valid for 5 minutes", Body: ShutdownTokenResponse{} },
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "No admin token configured" },
+            } },
+    )
+    s.handleAPI( "POST /admin/keys/rotate", s.withRequiredAdmin( s.handleKeyRotate ),
+        apiOperation{ Summary: "Rotate the webhook signing key", Admin: true,
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Key id of the new active key", Body: map[string]string{} },
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "No admin token configured" },
+            } },
+    )
+    s.handleAPI( "GET /admin/diagnostics", s.withRequiredAdmin( s.handleDiagnostics ),
+        apiOperation{ Summary: "Get a diagnostics bundle", Admin: true,
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Diagnostics", Body: Diagnostics{} },
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "No admin token configured" },
+            } },
+    )
+    s.handle( "GET " + apiVersion + "/admin/audit", s.withRequiredAdmin( s.handleAudit ),
+        apiOperation{ Summary: "Get the audit log with its chain verified", Admin: true,
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Audit log", Body: AuditLog{} },
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "No admin token configured" },
+            } },
+    )
+    s.handle( "GET " + apiVersion + "/admin/audit/export", s.withRequiredAdmin( s.handleAuditExport ),
+        apiOperation{ Summary: "Download the audit log as JSON lines", Admin: true,
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Audit log entries, one per line", ContentType: "application/x-ndjson", Body: "" },
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "No admin token configured" },
+            } },
+    )
+    s.handle( "GET " + apiVersion + "/admin/export", s.withRequiredAdmin( s.handleExport ),
+        apiOperation{ Summary: "Download every record as JSON lines", Admin: true,
+            Params: []apiParam{
+                { Name: "gzip", In: "query", Type: "boolean", Description: "Gzip the download" },
+            },
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Records with their provenance, one per line", ContentType: "application/x-ndjson", Body: "" },
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "No admin token configured" },
+                { Status: http.StatusServiceUnavailable, Description: "Store unavailable" },
+            } },
+    )
+    s.handle( "POST " + apiVersion + "/admin/import", s.withRequiredAdmin( s.handleImport ),
+        apiOperation{ Summary: "Import the records of an export", Admin: true,
+            Params: []apiParam{
+                { Name: "on_conflict", In: "query", Type: "string", Description: "fail (default), skip or overwrite records whose id is taken" },
+            },
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Import counts and the last allocated id", Body: ImportResult{} },
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "No admin token configured" },
+                { Status: http.StatusConflict, Description: "Records conflicting with the stored ones, nothing imported" },
+                apiUnprocessable,
+                { Status: http.StatusServiceUnavailable, Description: "Store unavailable" },
+            } },
+    )
+    s.handle( "DELETE " + apiVersion + "/hash/{id}", s.withRequiredAdmin( s.handleHashDelete ),
+        apiOperation{ Summary: "Delete a hashed password, purged after the grace period", Admin: true,
+            Params: []apiParam{ apiIdParam },
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Deleted, and when it will be purged", Body: DeleteResponse{} },
+                apiNotAcceptable,
+                apiUnauthorized,
+                apiNotFound,
+                { Status: http.StatusConflict, Description: "Password not hashed yet" },
+                { Status: http.StatusGone, Description: "Hash already deleted, expired or evicted" },
+            } },
+    )
+    s.handle( "POST " + apiVersion + "/admin/hash/{id}/undelete", s.withRequiredAdmin( s.handleUndelete ),
+        apiOperation{ Summary: "Bring back a deleted password before it is purged", Admin: true,
+            Params: []apiParam{ apiIdParam },
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Record and provenance", Body: adminRecord{} },
+                apiNotAcceptable,
+                apiUnauthorized,
+                apiNotFound,
+                { Status: http.StatusConflict, Description: "Password isn't deleted" },
+                { Status: http.StatusGone, Description: "Password already purged" },
+            } },
+    )
+    s.handle( "POST " + apiVersion + "/admin/purge", s.withRequiredAdmin( s.handlePurge ),
+        apiOperation{ Summary: "Purge deleted passwords without waiting for the grace period", Admin: true,
+            Params: []apiParam{
+                { Name: "ids", In: "form", Type: "string", Description: "Comma separated deleted ids, all of them when empty" },
+            },
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Purged ids", Body: PurgeResponse{} },
+                apiNotAcceptable,
+                apiUnauthorized,
+                { Status: http.StatusUnprocessableEntity, Description: "Invalid ids, or ids that aren't deleted" },
+            } },
+    )
+    s.handle( "POST " + apiVersion + "/admin/erase", s.withRequiredAdmin( s.handleErase ),
+        apiOperation{ Summary: "Erase passwords everywhere they are kept, with a signed attestation", Admin: true,
+            Params: []apiParam{
+                { Name: "ids", In: "form", Type: "string", Description: "Comma separated ids to erase" },
+                { Name: "reference", In: "form", Type: "string", Description: "Reference of the erasure request, recorded in the attestation" },
+            },
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Attestation of the erasure and its JWS", Body: EraseResponse{} },
+                apiNotAcceptable,
+                apiUnauthorized,
+                { Status: http.StatusUnprocessableEntity, Description: "Invalid ids, or ids still being hashed" },
+                { Status: http.StatusServiceUnavailable, Description: "Store unavailable" },
+            } },
+    )
+    s.handle( "POST " + apiVersion + "/admin/compact", s.withRequiredAdmin( s.handleCompact ),
+        apiOperation{ Summary: "Compact the persistent store and the write-ahead log", Admin: true,
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Bytes reclaimed from the store and the write-ahead log", Body: CompactResult{} },
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "No admin token configured" },
+                apiNotAcceptable,
+                { Status: http.StatusServiceUnavailable, Description: "Store unavailable" },
+            } },
+    )
+    if s.migration != nil {
+        s.handle( "POST " + apiVersion + "/admin/migration/backfill", s.withRequiredAdmin( s.handleBackfill ),
+            apiOperation{ Summary: "Copy the records of the store migrated from to the new one", Admin: true,
+                Responses: []apiResponse{
+                    { Status: http.StatusOK, Description: "Records copied and already migrated", Body: BackfillResult{} },
+                    apiUnauthorized,
+                    { Status: http.StatusForbidden, Description: "No admin token configured" },
+                    apiNotAcceptable,
+                    { Status: http.StatusConflict, Description: "Already cut over" },
+                    { Status: http.StatusServiceUnavailable, Description: "Store unavailable" },
+                } },
+        )
+        s.handle( "POST " + apiVersion + "/admin/migration/cutover", s.withRequiredAdmin( s.handleCutover ),
+            apiOperation{ Summary: "Stop using the store migrated from once backfilled", Admin: true,
+                Responses: []apiResponse{
+                    { Status: http.StatusOK, Description: "Records migrated", Body: CutoverResult{} },
+                    apiUnauthorized,
+                    { Status: http.StatusForbidden, Description: "No admin token configured" },
+                    apiNotAcceptable,
+                    { Status: http.StatusConflict, Description: "Already cut over, or records not backfilled yet" },
+                    { Status: http.StatusServiceUnavailable, Description: "Store unavailable" },
+                } },
+        )
+    }
+    if s.config.Expvar {
+        s.handle( "GET /debug/vars", s.withAdmin( s.handleExpvar ),
+            apiOperation{ Summary: "Get expvar counters", Admin: true,
+                Responses: []apiResponse{
+                    { Status: http.StatusOK, Description: "expvar variables, the server's under hashsvc", Body: map[string]interface{}{} },
+                    apiUnauthorized,
+                } },
+        )
+    }
+    s.handle( "GET /debug/runtime", s.withRequiredAdmin( s.handleRuntime ),
+        apiOperation{ Summary: "Get runtime and memory stats", Admin: true,
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Goroutines, heap, GC and stored records", Body: RuntimeStats{} },
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "No admin token configured" },
+            } },
+    )
+    s.handle( "GET /debug/pprof/", s.withRequiredAdmin( pprof.Index ),
+        apiOperation{ Summary: "List the runtime profiles, /debug/pprof/{name} gets one", Admin: true,
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Profiles, e.g. heap, goroutine and allocs", ContentType: "text/html", Body: "" },
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "No admin token configured" },
+            } },
+    )
+    s.handle( "GET /debug/pprof/cmdline", s.withRequiredAdmin( pprof.Cmdline ) )
+    s.handle( "GET /debug/pprof/profile", s.withRequiredAdmin( pprof.Profile ),
+        apiOperation{ Summary: "Capture a CPU profile", Admin: true,
+            Params: []apiParam{
+                { Name: "seconds", In: "query", Type: "integer", Description: "How long to profile for, default 30" },
+            },
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "CPU profile for go tool pprof", ContentType: "application/octet-stream", Body: "" },
+                apiUnauthorized,
+                { Status: http.StatusForbidden, Description: "No admin token configured" },
+            } },
+    )
+    s.handle( "GET /debug/pprof/symbol", s.withRequiredAdmin( pprof.Symbol ) )
+    s.handle( "POST /debug/pprof/symbol", s.withRequiredAdmin( pprof.Symbol ) )
+    s.handle( "GET /debug/pprof/trace", s.withRequiredAdmin( pprof.Trace ) )
+    s.handle( "GET /livez", s.handleLivez,
+        apiOperation{ Summary: "Liveness probe",
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "The process is up", Body: HealthResponse{} },
+            } },
+    )
+    s.handle( "GET /startupz", s.handleStartupz,
+        apiOperation{ Summary: "Startup probe",
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Started", Body: HealthResponse{} },
+                { Status: http.StatusServiceUnavailable, Description: "Still starting", Body: HealthResponse{} },
+            } },
+    )
+    s.handle( "GET /readyz", s.handleReadyz,
+        apiOperation{ Summary: "Readiness probe",
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Ready for new passwords", Body: HealthResponse{} },
+                { Status: http.StatusServiceUnavailable, Description: "Starting, shutting down, storage unreachable or queue full, checks tells which", Body: HealthResponse{} },
+            } },
+    )
+    s.handle( "GET /healthz", s.handleReadyz,
+        apiOperation{ Summary: "Readiness probe, for checks that expect /healthz",
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Ready for new passwords", Body: HealthResponse{} },
+                { Status: http.StatusServiceUnavailable, Description: "Not ready, checks tells why", Body: HealthResponse{} },
+            } },
+    )
+    s.handle( "GET /.well-known/jwks.json", s.handleJWKS,
+        apiOperation{ Summary: "Get the public signing keys",
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "JSON Web Key Set", Body: JWKS{} },
+            } },
+    )
+    s.handle( "GET /docs", s.handleDocs,
+        apiOperation{ Summary: "Browse and try out the API",
+            Responses: []apiResponse{
+                { Status: http.StatusMovedPermanently, Description: "Redirect to the Swagger UI at /docs/" },
+            } },
+    )
+    s.handle( "GET /docs/", s.handleDocs )
+    s.handle( "GET /openapi.json", s.handleOpenAPI,
+        apiOperation{ Summary: "Get this OpenAPI document",
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "OpenAPI 3 document", Body: map[string]interface{}{} },
+            } },
+    )
+    s.handle( "GET /metrics", s.handleMetrics,
+        apiOperation{ Summary: "Get metrics for Prometheus",
+            Responses: []apiResponse{
+                { Status: http.StatusOK, Description: "Metrics in the Prometheus text format", ContentType: "text/plain", Body: "" },
+            } },
+    )
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+    "net/http"
+    "runtime"
+    "time"
+    "unsafe"
+)
+
+// Estimated overhead of a map entry on top of its key and value,
+// from the bucket's tophash, overflow pointer and load factor
+const mapEntryOverhead = 16
+
+// Response to GET /debug/runtime
+type RuntimeStats struct {
+    Goroutines int `json:"goroutines"`
+    HeapInUse uint64 `json:"heap_in_use"`
+    HeapObjects uint64 `json:"heap_objects"`
+    Sys uint64 `json:"sys"`
+    GC GCStats `json:"gc"`
+    Records int `json:"records"`
+    PendingJobs int `json:"pending_jobs"`
+    RecordsBytes int64 `json:"records_bytes"`
+}
+
+// Garbage collector stats, pauses in microseconds
+type GCStats struct {
+    Cycles uint32 `json:"cycles"`
+    LastAt *time.Time `json:"last_at,omitempty"`
+    LastPause int64 `json:"last_pause"`
+    TotalPause int64 `json:"total_pause"`
+    NextAt uint64 `json:"next_at"`
+    CPUFraction float64 `json:"cpu_fraction"`
+}
+
+/********************************************************************
+recordSize()
+    Estimates the bytes a stored record takes, along with its map
+    entry, labels and provenance.
+********************************************************************/
+func recordSize( record *Record ) int64 {
+    size := int64( unsafe.Sizeof( int64( 0 ) ) + unsafe.Sizeof( record ) + mapEntryOverhead )
+    size += int64( unsafe.Sizeof( *record ) ) + int64( len( record.Hash ) + len( record.Algorithm ) )
+    for key, value := range record.Labels {
+        size += int64( 2 * unsafe.Sizeof( "" ) + mapEntryOverhead ) + int64( len( key ) + len( value ) )
+    }
+    if record.CompleteBy != nil {
+        size += int64( unsafe.Sizeof( time.Time{} ) )
+    }
+    if record.ExpiresAt != nil {
+        size += int64( unsafe.Sizeof( time.Time{} ) )
+    }
+    if provenance := record.provenance; provenance != nil {
+        size += int64( unsafe.Sizeof( *provenance ) ) + int64( len( provenance.Principal ) + len( provenance.ClientIp ) + len( provenance.UserAgent ) + len( provenance.RequestId ) )
+    }
+    return size
+}
+
+/********************************************************************
+handleRuntime()
+    Handles GET requests on /debug/runtime, returning goroutine, heap
+    and GC stats with the number of stored records and an estimate of
+    the memory they take, for quick capacity checks. The estimate
+    walks every record, with the records locked.
+********************************************************************/
+func ( s *Server ) handleRuntime( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /debug/runtime" )
+
+    var memStats runtime.MemStats
+    runtime.ReadMemStats( &memStats )
+
+    stats := RuntimeStats{
+        Goroutines: runtime.NumGoroutine(),
+        HeapInUse: memStats.HeapInuse,
+        HeapObjects: memStats.HeapObjects,
+        Sys: memStats.Sys,
+        GC: GCStats{
+            Cycles: memStats.NumGC,
+            LastPause: int64( memStats.PauseNs[ ( memStats.NumGC + 255 ) % 256 ] / 1000 ),
+            TotalPause: int64( memStats.PauseTotalNs / 1000 ),
+            NextAt: memStats.NextGC,
+            CPUFraction: memStats.GCCPUFraction,
+        },
+    }
+    if memStats.LastGC > 0 {
+        lastAt := time.Unix( 0, int64( memStats.LastGC ) )
+        stats.GC.LastAt = &lastAt
+    }
+
+    records, err := s.store.List()
+    if err != nil {
+        s.writeStoreError( w, r, err )
+        return
+    }
+    stats.Records = len( records )
+    for _, record := range records {
+        stats.RecordsBytes += recordSize( record )
+    }
+    s.mapMutex.Lock()
+    stats.PendingJobs = len( s.pendingJobs )
+    s.mapMutex.Unlock()
+
+    s.writeEncoded( w, r, http.StatusOK, stats )
+}
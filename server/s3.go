@@ -0,0 +1,120 @@
+package server
+
+import (
+    "bytes"
+    "context"
+    "io"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    "github.com/aws/aws-sdk-go-v2/config"
+    "github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// How long an S3 request may take, archives can be large
+const s3Timeout = 5 * time.Minute
+
+// Archive keeping archives as objects of an S3 or S3-compatible
+// bucket under a key prefix, created by NewS3Archive()
+type S3Archive struct {
+    client *s3.Client
+    bucket string
+    prefix string
+}
+
+/********************************************************************
+NewS3Archive()
+    Creates an archive in an existing bucket, with credentials from
+    the standard AWS chain like NewDynamoDBStore(). The region is
+    taken from AWS_REGION when empty, and endpoint, when not empty,
+    points at an S3-compatible service like MinIO, addressed with
+    path-style URLs.
+********************************************************************/
+func NewS3Archive( ctx context.Context, bucket string, prefix string, region string, endpoint string ) ( *S3Archive, error ) {
+    awsConfig, err := config.LoadDefaultConfig( ctx, config.WithRegion( region ) )
+    if err != nil {
+        return nil, err
+    }
+    client := s3.NewFromConfig( awsConfig, func( options *s3.Options ) {
+        if endpoint != "" {
+            options.BaseEndpoint = aws.String( endpoint )
+            options.UsePathStyle = true
+        }
+    } )
+
+    // Fail now rather than at the first archive if the bucket can't be reached
+    ctx, cancel := context.WithTimeout( ctx, s3Timeout )
+    defer cancel()
+    if _, err := client.HeadBucket( ctx, &s3.HeadBucketInput{ Bucket: aws.String( bucket ) } ); err != nil {
+        return nil, err
+    }
+    return &S3Archive{ client: client, bucket: bucket, prefix: prefix }, nil
+}
+
+func ( a *S3Archive ) Upload( name string, data []byte ) error {
+    ctx, cancel := context.WithTimeout( context.Background(), s3Timeout )
+    defer cancel()
+    _, err := a.client.PutObject( ctx, &s3.PutObjectInput{
+        Bucket: aws.String( a.bucket ),
+        Key: aws.String( a.prefix + name ),
+        Body: bytes.NewReader( data ),
+        ContentType: aws.String( "application/octet-stream" ),
+    } )
+    return err
+}
+
+func ( a *S3Archive ) Latest() ( []byte, error ) {
+    ctx, cancel := context.WithTimeout( context.Background(), s3Timeout )
+    defer cancel()
+
+    // Keys are listed in ascending order, the last is the latest
+    keys, err := a.keys( ctx )
+    if err != nil || len( keys ) == 0 {
+        return nil, err
+    }
+
+    object, err := a.client.GetObject( ctx, &s3.GetObjectInput{ Bucket: aws.String( a.bucket ), Key: aws.String( keys[ len( keys ) - 1 ] ) } )
+    if err != nil {
+        return nil, err
+    }
+    defer object.Body.Close()
+    return io.ReadAll( object.Body )
+}
+
+func ( a *S3Archive ) List() ( []string, error ) {
+    ctx, cancel := context.WithTimeout( context.Background(), s3Timeout )
+    defer cancel()
+
+    keys, err := a.keys( ctx )
+    if err != nil {
+        return nil, err
+    }
+    names := make([]string, len( keys ))
+    for i, key := range keys {
+        names[ i ] = strings.TrimPrefix( key, a.prefix )
+    }
+    return names, nil
+}
+
+/********************************************************************
+keys()
+    Returns the keys of the objects under the prefix, in ascending
+    order.
+********************************************************************/
+func ( a *S3Archive ) keys( ctx context.Context ) ( []string, error ) {
+    var keys []string
+    paginator := s3.NewListObjectsV2Paginator( a.client, &s3.ListObjectsV2Input{ Bucket: aws.String( a.bucket ), Prefix: aws.String( a.prefix ) } )
+    for paginator.HasMorePages() {
+        page, err := paginator.NextPage( ctx )
+        if err != nil {
+            return nil, err
+        }
+        for _, object := range page.Contents {
+            keys = append( keys, aws.ToString( object.Key ) )
+        }
+    }
+    sort.Strings( keys )
+    return keys, nil
+}
@@ -1,64 +1,813 @@
 package server
 
 import (
+    "container/list"
     "context"
+    "crypto/rand"
     "crypto/sha512"
     "encoding/base64"
-    "encoding/json"
+    "errors"
+    "expvar"
     "fmt"
-    "log"
+    "log/slog"
+    "math"
     "net/http"
-    "path"
+    "net/url"
+    "os"
     "strconv"
+    "strings"
     "sync"
+    "sync/atomic"
+    "text/template"
     "time"
+
+    "github.com/graphql-go/graphql"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/trace"
+    "google.golang.org/grpc"
 )
 
 // Statistics struct
 type Stat struct {
     Total int64 `json:"total"`
     Average int64 `json:"average"`
+    SlaViolations int64 `json:"sla_violations,omitempty"`
+    Expired int64 `json:"expired,omitempty"`
+    RetentionExpired int64 `json:"retention_expired,omitempty"`
+    Evicted int64 `json:"evicted,omitempty"`
+    Paused bool `json:"paused,omitempty"`
+    Webhooks *WebhookStat `json:"webhooks,omitempty"`
+    Labels map[string]Stat `json:"labels,omitempty"`
+    Windows map[string]WindowStat `json:"windows,omitempty"`
+    Rates map[string]float64 `json:"rates,omitempty"`
+    Endpoints map[string]EndpointStat `json:"endpoints,omitempty"`
+    Queue *QueueStat `json:"queue,omitempty"`
+    Server *ServerInfo `json:"server,omitempty"`
+    GC *GCStat `json:"gc,omitempty"`
+    Cache *CacheStat `json:"cache,omitempty"`
+    ResetAt *time.Time `json:"reset_at,omitempty"`
+}
+
+// What is running and for how long, reported in /stats
+type ServerInfo struct {
+    StartedAt time.Time `json:"started_at"`
+    Uptime string `json:"uptime"`
+    Delay string `json:"delay"`
+    Build BuildInfo `json:"build"`
+}
+
+// Outstanding work: passwords waiting out their delay, and passwords
+// being hashed
+type QueueStat struct {
+    Queued int `json:"queued"`
+    Processing int `json:"processing"`
+
+    // Size of the worker pool, and workers hashing a password
+    Workers int `json:"workers"`
+    BusyWorkers int64 `json:"busy_workers"`
+}
+
+// Hashed password record
+type Record struct {
+    Id int64 `json:"id"`
+    Hash string `json:"hash"`
+    Algorithm string `json:"algorithm"`
+    Labels map[string]string `json:"labels,omitempty"`
+    CreatedAt time.Time `json:"created_at"`
+    CompletedAt time.Time `json:"completed_at"`
+    LatencyUs int64 `json:"latency_us"`
+    CompleteBy *time.Time `json:"complete_by,omitempty"`
+    SlaViolated bool `json:"sla_violated,omitempty"`
+    ExpiresAt *time.Time `json:"expires_at,omitempty"`
+    DeletedAt *time.Time `json:"deleted_at,omitempty"`
+    provenance *Provenance
+
+    // SHA512 digest of the password keying the deduplication index,
+    // only kept in deduplication mode
+    digest string
+
+    // Checksum the record was persisted with, empty if it never was
+    checksum string
+}
+
+// Response to POST /hash
+type HashResponse struct {
+    Id int64 `json:"id"`
+    Location string `json:"location"`
+    EstimatedCompletion *time.Time `json:"estimated_completion,omitempty"`
+    Deduplicated bool `json:"deduplicated,omitempty"`
+    Hash string `json:"hash,omitempty"`
+}
+
+// Pending hash job, waiting for its delay to elapse
+type hashJob struct {
+    id int64
+    password secret
+    labels map[string]string
+    startTime time.Time
+    startPaused time.Duration
+    completeBy time.Time
+    deadline time.Time
+    ttl time.Duration
+    provenance *Provenance
+    callbackURL string
+    dueAt time.Time
+
+    // SHA512 digest of the password in deduplication mode, kept on its
+    // record
+    digest string
+
+    // When the delay elapses on the system's monotonic clock, the
+    // latest the job is dispatched even if the server's clock was
+    // stepped backwards
+    realDueAt time.Time
+
+    // Hash of the password computed on submission with a WALFile, or
+    // replayed from it, hashed after the delay when empty
+    hash string
+    algorithm string
+    state string
+    span trace.Span
+
+    // Span context of the submitting request, passed on to the webhook
+    traceContext trace.SpanContext
+
+    // Logger of the submitting request, so lines logged once it has
+    // been answered carry its request id
+    logger *slog.Logger
+}
+
+// Per label counters, keyed by "key:value"
+type labelStat struct {
+    count int64
+    totalTime int64
+}
+
+// Server configuration, start from DefaultConfig()
+type Config struct {
+    // Port to listen on
+    Port int
+
+    // Port for the gRPC HashService, disabled when 0
+    GrpcPort int
+
+    // Deduplication mode, when enabled submitting a password that was
+    // already submitted returns the existing id instead of a new one
+    Deduplicate bool
+
+    // How long an Idempotency-Key is remembered for replays
+    IdempotencyWindow time.Duration
+
+    // Key for signing read-only URLs, random per server unless set
+    URLSigningKey []byte
+
+    // File of text/template definitions for text/plain responses
+    ResponseTemplates string
+
+    // Bearer token required by /admin endpoints, no auth when empty
+    AdminToken string
+
+    // How often the reaper deletes expired records and compacts the
+    // maps that shrank, reaperDefaultInterval when 0
+    ReaperInterval time.Duration
+
+    // Most records stored at once, unlimited when 0. Past it records
+    // are evicted by EvictionPolicy, EvictLRU when empty
+    MaxRecords int
+    EvictionPolicy string
+
+    // How long records are kept once hashed, whatever their ttl, e.g.
+    // for compliance, until they expire when 0. The reaper deletes
+    // the older ones as if they had expired
+    RetentionPeriod time.Duration
+
+    // How long a DELETEd record is kept, and can be undeleted, before
+    // the reaper purges it, deleteDefaultGracePeriod when 0
+    DeleteGracePeriod time.Duration
+
+    // Most records kept in memory in front of a persistent store, the
+    // ones read or written most recently, none when 0. Unused with the
+    // in-memory store
+    CacheRecords int
+
+    // Most passwords waiting to be hashed at once, unlimited when 0
+    MaxPendingJobs int
+
+    // Workers hashing the passwords whose delay has elapsed, the
+    // number of CPUs when 0
+    Workers int
+
+    // Pending passwords at which /readyz reports the server not ready,
+    // MaxPendingJobs when 0
+    ReadyQueueThreshold int
+
+    // Fraction of a limit at which clients start getting warnings
+    SoftLimitRatio float64
+
+    // Whether the empty string is a valid password to hash
+    AllowEmptyPassword bool
+
+    // Whether webhooks may call back loopback, private and link-local
+    // addresses, e.g. a sidecar. Refused as they are dialed otherwise,
+    // so a callback_url can't reach the services next to the server
+    WebhookAllowPrivate bool
+
+    // Whether /hash and /stats answer exactly like the original API,
+    // for scripted clients that haven't moved to the JSON responses
+    LegacyAPI bool
+
+    // When the unversioned aliases will be removed, announced in their
+    // Sunset header, not announced when zero
+    Sunset time.Time
+
+    // Cache-Control by route pattern, e.g. "GET /stats", replacing the
+    // defaults. For GET /hash/{id} it applies to hashed records, other
+    // responses of it are never stored
+    CacheControl map[string]string
+
+    // Fraction of the requests by route pattern, e.g. "POST /hash",
+    // logged at debug level whatever the log level, with their headers
+    // and query. Patterns are unversioned like for CacheControl
+    LogSampling map[string]float64
+
+    // Whether /debug/vars serves expvar counters, behind the admin
+    // token when one is set
+    Expvar bool
+
+    // StatsD agent host:port request latencies and hash completions
+    // are sent to, with the name prefix and "key:value" tags added to
+    // every metric. Not sent when empty
+    StatsDAddr string
+    StatsDPrefix string
+    StatsDTags []string
+
+    // File the /stats counters are checkpointed to every
+    // StatsCheckpointInterval and on shutdown, and restored from by
+    // New(). Not saved when empty
+    StatsFile string
+    StatsCheckpointInterval time.Duration
+
+    // File the records, id sequence and stats are snapshotted to every
+    // SnapshotInterval and on shutdown, and restored from by New().
+    // Not saved when empty
+    SnapshotFile string
+    SnapshotInterval time.Duration
+
+    // AES-256 key the archives of WithArchive() are encrypted with, and
+    // how often the records are archived, archiveDefaultInterval when 0
+    ArchiveKey []byte
+    ArchiveInterval time.Duration
+
+    // 32 byte master key the records are encrypted with, under a data
+    // key derived from it, before they reach a persistent store, the
+    // write-ahead log or a snapshot. Stored in the clear when empty
+    EncryptionKey []byte
+
+    // Master keys records were encrypted with before the key was
+    // rotated, still decrypted with, e.g. until they are all rewritten
+    PreviousEncryptionKeys [][]byte
+
+    // How often the master key is fetched from the KeySource of
+    // WithKeySource(), keyRefreshDefaultInterval when 0
+    KeyRefreshInterval time.Duration
+
+    // How much of a persistent store New() verifies the checksums of,
+    // IntegrityFull by default, the records IntegritySample picks,
+    // integrityDefaultSampleSize when 0, and what is done with a
+    // corrupted record, IntegrityRefuse by default
+    IntegrityCheck string
+    IntegritySampleSize int
+    IntegrityPolicy string
+
+    // Write-ahead log every submission and completed record is
+    // appended to, and replayed from by New() so a crash doesn't lose
+    // them. Not written when empty
+    WALFile string
+
+    // Export dump whose records New() stores before the server starts,
+    // e.g. test fixtures, skipping ids already taken. None when empty
+    SeedFile string
+
+    // File audit log entries are appended to as JSON lines, and read
+    // back from by New(). Only kept in memory when empty
+    AuditFile string
+}
+
+// Password hash server, created by New()
+type Server struct {
+    config Config
+    delay time.Duration
+    hasher Hasher
+    logger *slog.Logger
+    accessLogger *slog.Logger
+    clock Clock
+    tracer trace.Tracer
+    propagator propagation.TextMapPropagator
+    mux *http.ServeMux
+    middleware []Middleware
+    handler http.Handler
+    httpServer http.Server
+
+    // Jobs waiting out their delay, by when they are due, guarded by
+    // jobQueueMutex, and the worker pool the due ones are sent to,
+    // stopped by closing workersStopped
+    jobQueue jobQueue
+    jobQueueMutex sync.Mutex
+    jobQueued chan struct{}
+    dueJobs chan *hashJob
+    workers int
+    busyWorkers atomic.Int64
+    workersStopped chan struct{}
+    workerGroup sync.WaitGroup
+
+    // Hashed passwords and pending jobs, guarded by mapMutex so a job
+    // leaves pendingJobs as its record is stored
+    mapMutex sync.Mutex
+    store Store
+    pendingJobs map[int64]*hashJob
+    hashedCount int64
+    lastId int64
+    totalTime int64
+    slaViolations int64
+    labelStats map[string]*labelStat
+    recentHashes slidingWindow
+    submissionRates ewmaRates
+    statsResetAt time.Time
+
+    // Since when the stats have been counted, across restarts with a
+    // StatsFile
+    statsSince time.Time
+
+    // Closed and replaced each time a password is hashed
+    completed chan struct{}
+
+    // Ids by hashed password, only maintained in deduplication mode
+    digestIds map[string]int64
+
+    // Expired ids, and when the stored records with a ttl expire so
+    // the reaper doesn't list the store, guarded by mapMutex
+    expiredIds *idSet
+    expiresAt map[int64]time.Time
+    expiredCount int64
+    retentionExpiredCount int64
+
+    // Records by eviction order, most recent first, only maintained
+    // with MaxRecords, and the evicted ids, guarded by mapMutex
+    recordOrder *list.List
+    recordElements map[int64]*list.Element
+    evictedIds *idSet
+    evictedCount int64
+
+    // Ids whose job failed as the store kept failing, guarded by
+    // mapMutex. Not persisted, a write-ahead log queues them again
+    failedIds *idSet
+
+    // When the soft deleted records were deleted, and the ids of those
+    // purged since, guarded by mapMutex. Purged ids are all kept, they
+    // keep erased records out of a restore from an archive
+    deletedAt map[int64]time.Time
+    purgedIds *idSet
+
+    // Cache in front of a persistent store with Config.CacheRecords,
+    // also s.store then, nil without
+    recordCache *cachedStore
+
+    // Store migrated from with WithMigration(), and the store double-
+    // writing to it and s.store, under the encryption, nil without
+    migrationSource Store
+    migration *migratingStore
+
+    // What the reaper reclaimed, guarded by mapMutex, and the peak
+    // sizes of the maps it compacts, guarded by the maps' mutexes
+    gcStats GCStat
+    gcPeaks gcPeaks
+
+    // POST /hash ids by Idempotency-Key
+    idempotencyKeys map[string]*idempotencyEntry
+    idempotencyMutex sync.Mutex
+
+    // Closed once ListenAndServe() has started the background workers
+    serving chan struct{}
+
+    // Shutdown info, shutdownStarted is closed once shutting down and
+    // stopped once shut down
+    shutDown bool
+    shutdownMutex sync.RWMutex
+    shutdownStarted chan struct{}
+    stopped chan struct{}
+    stopOnce sync.Once
+    shutdownTokens map[string]time.Time
+    shutdownTokenMutex sync.Mutex
+
+    // gRPC HashService, while running
+    grpcServer *grpc.Server
+    grpcMutex sync.Mutex
+
+    // Pause info, pauseResumed is closed and replaced on resume
+    paused bool
+    pausedSince time.Time
+    pausedTotal time.Duration
+    pauseResumed chan struct{}
+    pauseMutex sync.Mutex
+
+    // Event stream subscribers
+    eventSubscribers map[chan CompletionEvent]struct{}
+    eventMutex sync.Mutex
+
+    // Signing keys, the last one is the active key and older ones are
+    // still published until keyRetention after they were rotated out
+    signingKeys []*signingKeyPair
+    keyMutex sync.Mutex
+
+    // Limits currently above their soft threshold, so each crossing
+    // is only logged once
+    softLimitsCrossed map[string]bool
+    softLimitMutex sync.Mutex
+
+    // Recent errors, oldest first
+    recentErrors []RecentError
+    recentErrorsMutex sync.Mutex
+
+    // Audit log, the newest auditMaxEntries entries and the sequence
+    // number and hash of the last one
+    auditEntries []AuditEntry
+    auditSeq int64
+    auditHead string
+    auditFile *os.File
+    auditMutex sync.Mutex
+
+    // Cipher of the records persisted, nil without an EncryptionKey,
+    // and the source of its master key, nil unless WithKeySource()
+    // sets one
+    recordCipher *recordCipher
+    keySource KeySource
+
+    // Result of the verification of the store on startup, nil if
+    // nothing was verified
+    integrity *integrityReport
+
+    // Write-ahead log, while open
+    walFile *os.File
+    walMutex sync.Mutex
+
+    // Held by POST /admin/compact, so one compaction runs at a time
+    compactMutex sync.Mutex
+
+    // Client delivering the webhooks, its delivery counters, the
+    // undeliverable callbacks and the deliveries in progress
+    webhookClient *http.Client
+    webhookStats WebhookStat
+    webhookDeadLetters []DeadLetter
+    webhookMutex sync.Mutex
+    webhookGroup sync.WaitGroup
+
+    // Configured URL signing key, or a random one
+    urlSigningKey []byte
+
+    responseTemplates *template.Template
+    graphqlSchema graphql.Schema
+
+    // Operations of every registered route, in registration order
+    apiOperations []apiOperation
+
+    // Prometheus metrics served on /metrics
+    metrics *metrics
+
+    // Variables served on /debug/vars, and the request counts in them
+    expvars *expvar.Map
+    expvarRequests *expvar.Map
+
+    // When New() created the server, and the binary it runs
+    startedAt time.Time
+    buildInfo BuildInfo
+
+    // Destination of the periodic archives of the records, nil unless
+    // WithArchive() sets one
+    archive Archive
+
+    // StatsD client, nil unless StatsDAddr is set
+    statsd *statsdClient
+
+    // Request counters by route pattern, reported in /stats
+    endpointCounters map[string]*endpointCounter
+    endpointMutex sync.Mutex
+}
+
+/********************************************************************
+DefaultConfig()
+    Returns the default configuration.
+********************************************************************/
+func DefaultConfig() Config {
+    return Config{
+        Port: 8080,
+        IdempotencyWindow: 24 * time.Hour,
+        SoftLimitRatio: 0.8,
+        StatsDPrefix: statsdDefaultPrefix,
+        StatsCheckpointInterval: statsCheckpointDefaultInterval,
+        ReaperInterval: reaperDefaultInterval,
+        SnapshotInterval: snapshotDefaultInterval,
+    }
 }
 
 var (
     // Password info
     pwdDelay = 5 * time.Second
-    pwdHashedMap = make(map[int64]string)
-    pwdHashedCount int64 = 0
-    pwdTotalTime int64 = 0
-    pwdMutexMap sync.Mutex
-    pwdServer http.Server
+    slaLeadTime = 100 * time.Millisecond
 
     // Shutdown info
-    shutDown bool = false
-    shutdownMutex sync.RWMutex
     shutdownDelay = 1 * time.Second
 )
 
 /********************************************************************
-HandleRequests()
-    Runs the password hash server
+New()
+    Creates a password hash server customized by options, loading
+    the response templates if configured. Without WithConfig() the
+    server has DefaultConfig().
     Endpoints:
-        /hash  - POST requests to hash a password
+        /hash  - POST requests to hash a password, GET ?ids= for several
         /hash/ - GET requests to retrieve a hashed password by id
+        /hash/watch - GET requests to wait for any of a set of ids to complete
+        /hash/find - GET requests for the ids with a given hash, admin only
+        /hashes - GET requests to list hashed passwords, filtered by label
         /stats - GET requests for total number of passwords and average time
+        /events - GET requests for a Server-Sent Events stream of completions
+        /ws - WebSocket to submit passwords and await their hashes
+        /graphql - POST requests for GraphQL queries and mutations
         /shutdown - GET request to shut the sever down
+        /admin/pause - POST request to stop hashing queued passwords
+        /admin/resume - POST request to resume hashing queued passwords
+        /admin/hash/ - GET requests to retrieve a record with its provenance
+        /admin/signed-url - POST request to issue a signed /stats URL
+        /admin/webhooks/dead-letters - GET request for undelivered callbacks
+        /admin/shutdown-token - POST request for a one-time /shutdown token
+        /admin/keys/rotate - POST request to rotate the signing key
+        /admin/diagnostics - GET request for a diagnostics bundle
+        /.well-known/jwks.json - GET request for the public signing keys
+        /openapi.json - GET request for the OpenAPI document
+        /docs - Swagger UI for the OpenAPI document
+    Routes and their OpenAPI operations are registered in routes.go.
+********************************************************************/
+func New( options ...Option ) ( *Server, error ) {
+    s := &Server{
+        config: DefaultConfig(),
+        delay: pwdDelay,
+        hasher: sha512Hasher{},
+        logger: slog.Default(),
+        clock: systemClock{},
+        tracer: otel.GetTracerProvider().Tracer( tracerName ),
+        propagator: propagation.NewCompositeTextMapPropagator( propagation.TraceContext{}, propagation.Baggage{} ),
+        mux: http.NewServeMux(),
+        store: NewMemoryStore(),
+        pendingJobs: make(map[int64]*hashJob),
+        labelStats: make(map[string]*labelStat),
+        endpointCounters: make(map[string]*endpointCounter),
+        completed: make(chan struct{}),
+        digestIds: make(map[string]int64),
+        expiredIds: newIdSet( tombstoneMaxRanges ),
+        expiresAt: make(map[int64]time.Time),
+        recordOrder: list.New(),
+        recordElements: make(map[int64]*list.Element),
+        evictedIds: newIdSet( tombstoneMaxRanges ),
+        failedIds: newIdSet( tombstoneMaxRanges ),
+        deletedAt: make(map[int64]time.Time),
+        purgedIds: newIdSet( 0 ),
+        idempotencyKeys: make(map[string]*idempotencyEntry),
+        serving: make(chan struct{}),
+        shutdownStarted: make(chan struct{}),
+        stopped: make(chan struct{}),
+        shutdownTokens: make(map[string]time.Time),
+        pauseResumed: make(chan struct{}),
+        eventSubscribers: make(map[chan CompletionEvent]struct{}),
+        softLimitsCrossed: make(map[string]bool),
+        recentErrors: []RecentError{},
+        webhookDeadLetters: []DeadLetter{},
+        auditEntries: []AuditEntry{},
+        auditHead: auditGenesisHash,
+    }
+    for _, option := range options {
+        option( s )
+    }
+    s.logger = slog.New( newRedactHandler( s.logger.Handler(), nil ) )
+    config := s.config
+    s.responseTemplates = s.defaultResponseTemplates()
+
+    s.webhookClient = newWebhookClient( config.WebhookAllowPrivate )
+    s.urlSigningKey = config.URLSigningKey
+    if len( s.urlSigningKey ) == 0 {
+        s.urlSigningKey = make([]byte, 32)
+        rand.Read( s.urlSigningKey )
+    }
+    if err := checkEviction( config ); err != nil {
+        return nil, err
+    }
+    if err := checkIntegrityConfig( config ); err != nil {
+        return nil, err
+    }
+    if s.migrationSource != nil {
+        s.migration = newMigratingStore( s.migrationSource, s.store )
+        s.store = s.migration
+    }
+    if err := s.loadEncryptionKeys(); err != nil {
+        return nil, err
+    }
+    if _, inMemory := s.store.( *memoryStore ); config.CacheRecords > 0 && !inMemory {
+        s.recordCache = newCachedStore( s.store, config.CacheRecords )
+        s.store = s.recordCache
+    }
+    if config.ResponseTemplates != "" {
+        if err := s.loadResponseTemplates( config.ResponseTemplates ); err != nil {
+            return nil, err
+        }
+    }
+    if config.StatsDAddr != "" {
+        statsd, err := newStatsdClient( config.StatsDAddr, config.StatsDPrefix, config.StatsDTags )
+        if err != nil {
+            return nil, err
+        }
+        s.statsd = statsd
+    }
+    s.graphqlSchema = s.newGraphqlSchema()
+    s.metrics = s.newMetrics()
+    s.startWorkers()
+    s.expvars = s.newExpvars()
+    s.startedAt = s.clock.Now()
+    s.buildInfo = ReadBuildInfo()
+    s.statsSince = s.startedAt
+    if config.StatsFile != "" {
+        if err := s.loadStats(); err != nil {
+            return nil, fmt.Errorf( "loading stats: %w", err )
+        }
+    }
+    if config.SnapshotFile != "" {
+        if err := s.loadSnapshot(); err != nil {
+            return nil, fmt.Errorf( "loading snapshot: %w", err )
+        }
+    }
+    if s.archive != nil {
+        if _, err := archiveCipher( config.ArchiveKey ); err != nil {
+            return nil, err
+        }
+        if err := s.restoreArchive(); err != nil {
+            return nil, fmt.Errorf( "restoring the archive: %w", err )
+        }
+    }
+    if err := s.loadStore(); err != nil {
+        return nil, fmt.Errorf( "loading store: %w", err )
+    }
+    if config.WALFile != "" {
+        if err := s.loadWAL(); err != nil {
+            return nil, fmt.Errorf( "replaying the write-ahead log: %w", err )
+        }
+    }
+    if config.SeedFile != "" {
+        if err := s.loadSeed(); err != nil {
+            return nil, fmt.Errorf( "loading seed: %w", err )
+        }
+    }
+    if config.AuditFile != "" {
+        if err := s.loadAudit(); err != nil {
+            return nil, fmt.Errorf( "loading audit log: %w", err )
+        }
+    }
+    s.registerRoutes()
+    s.handler = Chain( s.withTracing( s.withLogging( s.withMetrics( s.route ) ) ), s.middleware... )
+    s.httpServer = http.Server{ Addr: ":" + strconv.Itoa( config.Port ), Handler: s.handler }
+    return s, nil
+}
+
+/********************************************************************
+Handler()
+    Returns the handler serving every route through the middleware,
+    for mounting the server in another application or driving it with
+    httptest. Mounted under a prefix with http.StripPrefix, links in
+    responses keep it.
+********************************************************************/
+func ( s *Server ) Handler() http.Handler {
+    return s.handler
+}
+
+/********************************************************************
+mountPrefix()
+    Returns the path prefix http.StripPrefix removed from a request,
+    empty when the handler is mounted at the root.
 ********************************************************************/
-func HandleRequests( port int ) {
-    http.HandleFunc( "/", home )
-    http.HandleFunc( "/hash", handleHashPost )
-    http.HandleFunc( "/hash/", handleHashGet )
-    http.HandleFunc( "/stats", handleStats )
-    http.HandleFunc( "/shutdown", handleShutDown )
-    pwdServer = http.Server{Addr: ":" + strconv.Itoa(port)}
-    log.Fatal( pwdServer.ListenAndServe(), nil )
+func mountPrefix( r *http.Request ) string {
+    requestURL, err := url.ParseRequestURI( r.RequestURI )
+    if err != nil || !strings.HasSuffix( requestURL.EscapedPath(), r.URL.EscapedPath() ) {
+        return ""
+    }
+    return strings.TrimSuffix( requestURL.EscapedPath(), r.URL.EscapedPath() )
+}
+
+/********************************************************************
+requestVersion()
+    Returns the API version prefix of a request, empty if it came
+    through an unversioned alias, so links in the response use the
+    same form.
+********************************************************************/
+func requestVersion( r *http.Request ) string {
+    if strings.HasPrefix( r.URL.Path, apiVersion + "/" ) {
+        return apiVersion
+    }
+    return ""
+}
+
+/********************************************************************
+ListenAndServe()
+    Runs the server until ctx is done or it is shut down, through
+    Shutdown() or the /shutdown endpoint, then returns once the
+    shutdown has completed.
+********************************************************************/
+func ( s *Server ) ListenAndServe( ctx context.Context ) error {
+    go s.reapExpired()
+    if s.config.StatsFile != "" {
+        go s.checkpointStats()
+    }
+    if s.config.SnapshotFile != "" {
+        go s.snapshotPeriodically()
+    }
+    if s.archive != nil {
+        go s.archivePeriodically()
+    }
+    if s.keySource != nil {
+        go s.refreshKeyPeriodically()
+    }
+    if s.config.GrpcPort > 0 {
+        go s.serveGrpc( s.config.GrpcPort )
+    }
+    go func() {
+        select {
+        case <-ctx.Done():
+            s.audit( AuditEntry{ Action: AuditShutdown, Source: "signal" } )
+            s.Shutdown( context.Background() )
+        case <-s.shutdownStarted:
+        }
+    }()
+
+    s.logger.Info( "Starting server!", "port", s.config.Port )
+    close( s.serving )
+    err := s.httpServer.ListenAndServe()
+    if err != http.ErrServerClosed {
+        return err
+    }
+    <-s.stopped
+    return nil
+}
+
+/********************************************************************
+Shutdown()
+    Gracefully shuts the server down, waiting for requests being
+    processed to complete or ctx to be done.
+********************************************************************/
+func ( s *Server ) Shutdown( ctx context.Context ) error {
+    s.shutdownMutex.Lock()
+    s.beginShutdown()
+    s.shutdownMutex.Unlock()
+
+    s.stopGrpc()
+    err := s.httpServer.Shutdown( ctx )
+    s.stopOnce.Do( func() {
+        s.stopWorkers()
+        s.webhookGroup.Wait()
+        if s.config.StatsFile != "" {
+            if err := s.saveStats(); err != nil {
+                s.logError( "Unable to save stats: %v", err )
+            }
+        }
+        if s.config.SnapshotFile != "" {
+            if err := s.saveSnapshot(); err != nil {
+                s.logError( "Unable to save snapshot: %v", err )
+            }
+        }
+        if s.archive != nil {
+            if err := s.archiveRecords(); err != nil {
+                s.logError( "Unable to archive records: %v", err )
+            }
+        }
+        s.statsd.close()
+        s.closeWAL()
+        s.closeAudit()
+        close( s.stopped )
+    } )
+    return err
+}
+
+/********************************************************************
+pathId()
+    Returns the {id} path parameter of a request, or 0, which is never
+    allocated, if it isn't a number.
+********************************************************************/
+func pathId( r *http.Request ) int64 {
+    id, _ := strconv.ParseInt( r.PathValue( "id" ), 0, 64 )
+    return id
 }
 
 /********************************************************************
 home()
 ********************************************************************/
-func home( w http.ResponseWriter, r *http.Request ) {
-    fmt.Println( "Endpoint: home" )
+func ( s *Server ) home( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: home" )
     fmt.Fprintf( w, "JumpCloud Takehome Assignment - Password Hashing Server!" )
 }
 
@@ -82,120 +831,672 @@ func hashPassword( password string ) string {
 }
 
 /********************************************************************
-delayAndAdd()
-    Delays for the specified delay time, hash the password and
-    add it to the hashed passwords map.
+hashAndStore()
+    Hashes the password of a job whose delay has elapsed and adds it
+    to the store, on a worker. Jobs with a complete_by deadline
+    earlier than the delay are dispatched for the deadline instead,
+    and flagged as an SLA violation if they still miss it.
 ********************************************************************/
-func delayAndAdd( id int64, password string, startTime time.Time ) {
+func ( s *Server ) hashAndStore( job *hashJob ) {
+    s.mapMutex.Lock()
+    job.state = StatusProcessing
+    s.mapMutex.Unlock()
 
-    // Delay the hashing
-    time.Sleep( pwdDelay )
+    // Hash the password, unless it was on submission, time spent
+    // paused doesn't count towards the stats
+    hashedPassword, algorithm := job.hash, job.algorithm
+    if hashedPassword == "" {
+        hashedPassword, algorithm = s.hasher.Hash( string( job.password ) ), s.hasher.Algorithm()
+    }
+    activeTime := s.activeTime( job )
+    elapsed := activeTime.Microseconds()
+    s.metrics.hashLatency.WithLabelValues().Observe( activeTime.Seconds() )
+    s.statsd.increment( "hash.completed" )
+    s.statsd.timing( "hash.duration", activeTime )
+    record := &Record{
+        Id: job.id,
+        Hash: hashedPassword,
+        Algorithm: algorithm,
+        Labels: job.labels,
+        CreatedAt: job.startTime,
+        CompletedAt: s.clock.Now(),
+        LatencyUs: elapsed,
+        provenance: job.provenance,
+        digest: job.digest,
+    }
+    if !job.completeBy.IsZero() {
+        completeBy := job.completeBy
+        record.CompleteBy = &completeBy
+        record.SlaViolated = s.clock.Now().After( job.deadline )
+    }
+    if job.ttl > 0 {
+        expiresAt := s.clock.Now().Add( job.ttl )
+        record.ExpiresAt = &expiresAt
+    }
 
-    // Hash the password
-    hashedPassword := hashPassword( password )
-    pwdMutexMap.Lock()
-    // Store the password in a map by its id and update the count and total time
-    pwdHashedCount++
-    pwdHashedMap[ id ] = hashedPassword
-    pwdTotalTime += time.Since(startTime).Microseconds()
-    pwdMutexMap.Unlock()
+    // Store the record, retrying a few times while the store fails,
+    // then failing the job so a broken store doesn't hold every worker.
+    // This is done without mapMutex, a remote store can take a while,
+    // the job stays pending meanwhile so erasures and imports leave
+    // its id alone
+    for attempt := 1; ; attempt++ {
+        err := s.store.Put( record )
+        if err == nil {
+            break
+        }
+        if attempt == storeMaxAttempts {
+            s.failJob( job, err )
+            return
+        }
+        s.logErrorTo( job.logger, "Unable to store hash %d, retrying in %s: %v", job.id, storeRetryInterval, err )
+        select {
+        case <-time.After( storeRetryInterval ):
+        case <-s.workersStopped:
+            // Left pending, a write-ahead log replays it on restart
+            return
+        }
+    }
+
+    s.mapMutex.Lock()
+    if expiry := s.expiryOf( record ); expiry != nil {
+        s.expiresAt[ record.Id ] = *expiry
+    }
+    if err := s.trackRecord( record.Id ); err != nil {
+        s.logErrorTo( job.logger, "Unable to evict records: %v", err )
+    }
+
+    // Update the count and total time
+    s.hashedCount++
+    delete( s.pendingJobs, job.id )
+    s.totalTime += elapsed
+    s.recentHashes.add( record.CompletedAt, elapsed )
+    if record.SlaViolated {
+        s.slaViolations++
+    }
+
+    // Update the per label counters
+    for key, value := range job.labels {
+        stat := s.labelStats[ key + ":" + value ]
+        if stat == nil {
+            stat = &labelStat{}
+            s.labelStats[ key + ":" + value ] = stat
+        }
+        stat.count++
+        stat.totalTime += elapsed
+    }
+
+    // Wake up anyone waiting on a completion
+    s.notifyCompleted()
+    s.mapMutex.Unlock()
+
+    if s.config.WALFile != "" {
+        s.logCompletion( record )
+    }
+    s.publishCompletion( CompletionEvent{ Id: job.id, Timestamp: record.CompletedAt, LatencyUs: elapsed } )
+    job.span.End()
+    job.logger.Debug( "Password hashed", "id", job.id, "latency_us", elapsed )
+
+    if job.callbackURL != "" {
+        s.webhookGroup.Add( 1 )
+        go s.deliverWebhook( job, record )
+    }
+}
+
+/********************************************************************
+activeTime()
+    Returns how long a job took, excluding time spent paused. Only
+    monotonic clock readings are used, so wall clock jumps (NTP
+    steps, VM migration) can't skew it, and it is never negative.
+********************************************************************/
+func ( s *Server ) activeTime( job *hashJob ) time.Duration {
+    active := s.since( job.startTime ) - ( s.pausedTime() - job.startPaused )
+    if active < 0 {
+        active = 0
+    }
+    return active
+}
+
+/********************************************************************
+jobDelay()
+    Returns how long to wait before hashing a job: the configured
+    delay, or less if the job's complete_by deadline comes sooner.
+    Deadline jobs are started slaLeadTime early so hashing itself
+    doesn't push them past the deadline.
+********************************************************************/
+func ( s *Server ) jobDelay( job *hashJob ) time.Duration {
+    delay := s.delay - s.since( job.startTime )
+    if !job.deadline.IsZero() {
+        if untilDeadline := s.until( job.deadline ) - slaLeadTime; untilDeadline < delay {
+            delay = untilDeadline
+        }
+    }
+    if delay < 0 {
+        delay = 0
+    }
+    return delay
+}
+
+/********************************************************************
+monotonicDeadline()
+    Converts a wall clock deadline into one carrying a monotonic clock
+    reading, relative to now. Scheduling and SLA checks against it are
+    unaffected by wall clock changes after submission.
+********************************************************************/
+func monotonicDeadline( now time.Time, wallDeadline time.Time ) time.Time {
+    if wallDeadline.IsZero() {
+        return time.Time{}
+    }
+    return now.Add( wallDeadline.Sub( now.Round( 0 ) ) )
+}
+
+/********************************************************************
+parseLabels()
+    Parses "key:value" label strings into a map. Returns an error if
+    a label is not in the "key:value" form or has an empty key.
+********************************************************************/
+func parseLabels( values []string ) ( map[string]string, error ) {
+    if len( values ) == 0 {
+        return nil, nil
+    }
+
+    labels := make(map[string]string)
+    for _, value := range values {
+        parts := strings.SplitN( value, ":", 2 )
+        if len( parts ) != 2 || parts[ 0 ] == "" {
+            return nil, fmt.Errorf( "invalid label %q, expected key:value", value )
+        }
+        labels[ parts[ 0 ] ] = parts[ 1 ]
+    }
+
+    return labels, nil
 }
 
 /********************************************************************
 handleHashPost()
     Handles POST requests on the /hash endpoint with a form field
     "password" provding the value to hash. Returns an incrementing
-    identifier immediately but the password is not hashed for 5 secs.
+    identifier immediately, as a 202 Accepted JSON body with a Location
+    header, but the password is not hashed for 5 secs.
+    An optional "complete_by" (RFC 3339) deadline brings the hashing
+    forward when it falls inside the delay window, and an optional
+    "ttl" deletes the hash again once it has elapsed, and an optional
+    "callback_url" is POSTed the hash once it is ready.
 ********************************************************************/
-func handleHashPost( w http.ResponseWriter, r *http.Request ) {
-    fmt.Println( "Endpoint: /hash POST" )
+func ( s *Server ) handleHashPost( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /hash POST" )
 
     // Check shutdown
-    if shutDown {
-        fmt.Println( "Server has been shut down!" )
-        http.Error( w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable )
+    if s.shutDown {
+        s.log( r ).Info( "Server has been shut down!" )
+        writeError( w, http.StatusNotAcceptable, ErrorShuttingDown )
         return
     }
 
-    // Check for POST method
-    if r.Method != http.MethodPost {
-        fmt.Println( "Only POST requests supported!" )
-        http.Error( w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed )
+    id, deduplicated, ok := s.submitHashPost( w, r )
+    if !ok {
         return
     }
+    s.finishHashPost( w, r, id, deduplicated )
+}
+
+/********************************************************************
+submitHashPost()
+    Checks the fields of a POST /hash request and queues its password,
+    holding the shutdown mutex. Returns the id of the password, and
+    false once an error was written.
+********************************************************************/
+func ( s *Server ) submitHashPost( w http.ResponseWriter, r *http.Request ) ( id int64, deduplicated bool, ok bool ) {
 
     // Lock the shutdown mutex to ensure the server doesn't
     // shut down while processing this request
-    shutdownMutex.RLock()
-    defer shutdownMutex.RUnlock()
+    s.shutdownMutex.RLock()
+    defer s.shutdownMutex.RUnlock()
 
     // Time the request
-    startTime := time.Now()
+    startTime := s.clock.Now()
+
+    // Count the submission in the request rates
+    s.mapMutex.Lock()
+    s.submissionRates.mark( startTime )
+    s.mapMutex.Unlock()
+
+    // Check the body is form encoded or JSON
+    if !s.parseHashBody( w, r ) {
+        return 0, false, false
+    }
+
+    // Check every field, so that all invalid ones are reported at once
+    var invalid []FieldError
 
     // Check for the "password" form field
-    password := r.FormValue( "password" )
-    if password == "" {
-        fmt.Println( "Missing password to hash!" )
-        http.Error( w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity )
-        return
+    password, code, err := s.passwordFormValue( r )
+    if err != nil {
+        s.log( r ).Info( "Missing password to hash", "error", err )
+        w.Header().Set( "X-Error-Code", code )
+        invalid = append( invalid, FieldError{ Field: "password", Code: code, Message: err.Error() } )
+    }
+
+    // Check for the optional, repeatable "label" form field
+    labels, err := parseLabels( r.Form[ "label" ] )
+    if err != nil {
+        s.log( r ).Info( "Invalid label", "error", err )
+        invalid = append( invalid, invalidField( "label", err.Error() ) )
+    }
+
+    // Check for the optional "complete_by" form field, an RFC 3339 deadline
+    var completeBy time.Time
+    if value := r.FormValue( "complete_by" ); value != "" {
+        completeBy, err = time.Parse( time.RFC3339, value )
+        if err != nil {
+            s.log( r ).Info( "Invalid complete_by deadline!" )
+            invalid = append( invalid, invalidField( "complete_by", fmt.Sprintf( "invalid complete_by %q, expected an RFC 3339 time", value ) ) )
+        }
+    }
+
+    // Check for the optional "ttl" form field, how long to keep the hash
+    ttl, err := parseTtl( r.FormValue( "ttl" ) )
+    if err != nil {
+        s.log( r ).Info( "Invalid ttl", "error", err )
+        invalid = append( invalid, invalidField( "ttl", err.Error() ) )
+    }
+
+    // Check for the optional "callback_url" form field, notified once hashed
+    callbackURL, err := parseCallbackURL( r.FormValue( "callback_url" ) )
+    if err != nil {
+        s.log( r ).Info( "Invalid callback_url", "error", err )
+        invalid = append( invalid, invalidField( "callback_url", err.Error() ) )
+    }
+
+    if len( invalid ) > 0 {
+        // The messages quote the invalid values, which could be the password
+        for i := range invalid {
+            invalid[ i ].Message = requestSecrets( r.Context() ).scrub( invalid[ i ].Message )
+        }
+        writeFieldErrors( w, invalid... )
+        return 0, false, false
     }
 
-    // Get the incremented count here, but don't actually increment it yet
+    // Reject the submission if too many passwords are already waiting
+    if !s.checkPendingLimit( w ) {
+        return 0, false, false
+    }
+
+    // Allocate the id here, but don't increment the hashed count yet
     // It'll be incremented when the password is hashed, after the delay
     // This is done so the stats endpoint has accurate average time
-    pwdMutexMap.Lock()
-    id := pwdHashedCount + 1
-    pwdMutexMap.Unlock()
+    // With an Idempotency-Key header, a retried request gets its original id
+    var replayed bool
+    if key := r.Header.Get( "Idempotency-Key" ); key != "" {
+        id, deduplicated, replayed, err = s.allocateIdempotent( key, password )
+        if errors.Is( err, errIdempotencyMismatch ) {
+            s.log( r ).Info( "Invalid Idempotency-Key", "error", err )
+            writeFieldErrors( w, invalidField( "Idempotency-Key", err.Error() ) )
+            return 0, false, false
+        }
+    } else {
+        id, deduplicated, err = s.allocateId( password )
+    }
+    if err != nil {
+        s.writeStoreError( w, r, err )
+        return 0, false, false
+    }
+
+    // Nothing to queue for a replay or an already submitted password
+    if replayed || deduplicated {
+        if replayed {
+            s.log( r ).Info( "Idempotent replay, returning original id!" )
+            w.Header().Set( "Idempotent-Replayed", "true" )
+        } else {
+            s.log( r ).Info( "Password already submitted, returning existing id!" )
+        }
+        return id, deduplicated, true
+    }
 
     // Start a go routine to do the wait and add the hashed password
     // to the map, this is done so that the id can be returned right
     // away without the delay
-    go delayAndAdd( id, password, startTime )
+    provenance := newProvenance( r, startTime )
+    err = s.queueJob( r.Context(), &hashJob{
+        id: id,
+        password: secret( password ),
+        labels: labels,
+        startTime: startTime,
+        completeBy: completeBy,
+        ttl: ttl,
+        provenance: provenance,
+        callbackURL: callbackURL,
+    } )
+    if err != nil {
+        s.writeStoreError( w, r, err )
+        return 0, false, false
+    }
+
+    return id, false, true
+}
+
+/********************************************************************
+queueJob()
+    Queues a submitted job, logging it to the write-ahead log first
+    if there is one. A submission the log failed to take isn't
+    queued, as the crash it must survive would lose it, and the
+    error is returned. The id stays allocated but is no longer
+    handed out for the password or its Idempotency-Key.
+********************************************************************/
+func ( s *Server ) queueJob( ctx context.Context, job *hashJob ) error {
+    if s.config.Deduplicate {
+        job.digest = hashPassword( string( job.password ) )
+    }
+    if s.config.WALFile != "" {
+        if err := s.logSubmission( job ); err != nil {
+            s.mapMutex.Lock()
+            s.forgetDigest( job.id, job.digest )
+            s.mapMutex.Unlock()
+            s.forgetIdempotencyKeys( map[int64]bool{ job.id: true } )
+            return err
+        }
+    }
+    s.scheduleJob( ctx, job )
+    return nil
+}
 
-    // Return the hashed password id
-    fmt.Fprintf( w, "%d", id )
+/********************************************************************
+scheduleJob()
+    Registers a job as pending and queues it for a worker to hash
+    once its delay has elapsed. The job logs with the logger of
+    the submitting request, from ctx, or one adding the request id
+    of its provenance.
+********************************************************************/
+func ( s *Server ) scheduleJob( ctx context.Context, job *hashJob ) {
+    s.startJobSpan( ctx, job )
+    if logger, ok := ctx.Value( loggerKey{} ).( *slog.Logger ); ok {
+        job.logger = logger
+    } else {
+        job.logger = s.logger.With( "request_id", job.provenance.RequestId )
+    }
+    job.startPaused = s.pausedTime()
+    job.deadline = monotonicDeadline( job.startTime, job.completeBy )
+    job.state = StatusQueued
+    delay := s.jobDelay( job )
+    job.dueAt = s.clock.Now().Add( delay )
+    job.realDueAt = time.Now().Add( delay )
+
+    // Register the pending job before responding, so a GET issued right
+    // after this POST observes the job (202) rather than a 404
+    s.mapMutex.Lock()
+    s.pendingJobs[ job.id ] = job
+    s.mapMutex.Unlock()
+
+    s.enqueueJob( job )
+}
+
+/********************************************************************
+finishHashPost()
+    Writes the POST /hash response. With "sync=true" it first waits
+    for the password to be hashed and includes the hash itself. The
+    wait is done before taking the shutdown mutex, like the ?wait=
+    of GET /hash/{id}, so a shutdown releases waiting requests rather
+    than waiting for them.
+********************************************************************/
+func ( s *Server ) finishHashPost( w http.ResponseWriter, r *http.Request, id int64, deduplicated bool ) {
+    sync, _ := strconv.ParseBool( r.FormValue( "sync" ) )
+    if sync && !s.waitForHash( r.Context(), id, watchMaxTimeout ) {
+        return
+    }
+
+    s.shutdownMutex.RLock()
+    defer s.shutdownMutex.RUnlock()
+
+    s.writeHashResponse( w, r, id, deduplicated, sync )
+}
+
+/********************************************************************
+estimatedCompletion()
+    Returns when the password with the given id is expected to be
+    hashed, or the zero time if it has been hashed already.
+********************************************************************/
+func ( s *Server ) estimatedCompletion( id int64 ) time.Time {
+    s.mapMutex.Lock()
+    if job := s.pendingJobs[ id ]; job != nil {
+        s.mapMutex.Unlock()
+        return job.dueAt
+    }
+    s.mapMutex.Unlock()
+
+    // Looked up without mapMutex, a job is stored before it stops
+    // being pending so a hashed password is found either way. A store
+    // failure is reported by the lookup that follows
+    if record, _ := s.getRecord( id ); record != nil {
+        return time.Time{}
+    }
+
+    // Allocated but not queued yet
+    return s.clock.Now().Add( s.delay )
+}
+
+/********************************************************************
+s.setRetryAfter()
+    Sets the Retry-After header to the whole seconds remaining until
+    a pending password is due to be hashed, at least 1.
+********************************************************************/
+func ( s *Server ) setRetryAfter( w http.ResponseWriter, dueAt time.Time ) {
+    seconds := int64( math.Ceil( s.until( dueAt ).Seconds() ) )
+    if seconds < 1 {
+        seconds = 1
+    }
+    w.Header().Set( "Retry-After", strconv.FormatInt( seconds, 10 ) )
+}
+
+/********************************************************************
+writeHashResponse()
+    Writes a 202 Accepted response with a Location header and a JSON
+    body holding the id and estimated completion time, or 200 OK if
+    the password has been hashed already, including the hash when
+    includeHash is set. Clients accepting only text/plain get the
+    hash_post response template instead.
+********************************************************************/
+func ( s *Server ) writeHashResponse( w http.ResponseWriter, r *http.Request, id int64, deduplicated bool, includeHash bool ) {
+    location := mountPrefix( r ) + requestVersion( r ) + "/hash/" + strconv.FormatInt( id, 10 )
+    response := HashResponse{ Id: id, Location: location, Deduplicated: deduplicated }
+
+    status := http.StatusOK
+    if dueAt := s.estimatedCompletion( id ); !dueAt.IsZero() {
+        response.EstimatedCompletion = &dueAt
+        status = http.StatusAccepted
+        s.setRetryAfter( w, dueAt )
+    } else if includeHash {
+        record, _, _, _, err := s.lookupHash( id )
+        if err != nil {
+            s.writeStoreError( w, r, err )
+            return
+        }
+        if record != nil {
+            response.Hash = record.Hash
+        }
+    }
+
+    w.Header().Set( "Location", location )
+
+    // Legacy text/plain clients get the configurable template instead
+    if wantsText( r ) {
+        data := templateData{ Id: id, Location: location, Hash: response.Hash }
+        if response.EstimatedCompletion != nil {
+            data.EstimatedCompletion = *response.EstimatedCompletion
+        }
+        s.writeTemplate( w, status, templateHashPost, data )
+        return
+    }
+
+    s.writeEncoded( w, r, status, response )
 }
 
 /********************************************************************
 handleHashGet()
     Handles GET requests to retrieve a hashed password by its id.
+    Clients sending Accept: application/json get the full record as
+    JSON instead of the bare hash, or as MessagePack or CBOR.
+    Responds 202 Accepted while the password is still pending, unless
+    a "wait" duration is given to long-poll for it. Hashed records
+    have a strong ETag, and a matching If-None-Match gets 304 Not
+    Modified.
 ********************************************************************/
-func handleHashGet( w http.ResponseWriter, r *http.Request ) {
-    fmt.Println( "Endpoint: /hash/ GET" )
+func ( s *Server ) handleHashGet( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /hash/{id} GET" )
 
     // Check shutdown
-    if shutDown {
-        fmt.Println( "Server has been shut down!" )
-        http.Error( w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable )
+    if s.shutDown {
+        s.log( r ).Info( "Server has been shut down!" )
+        writeError( w, http.StatusNotAcceptable, ErrorShuttingDown )
         return
     }
 
-    // Check for GET method
-    if r.Method != http.MethodGet {
-        fmt.Println( "Only GET requests supported!" )
-        http.Error( w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed )
-        return
+    // Pending, expired and unknown ids can change any moment
+    w.Header().Set( "Cache-Control", "no-store" )
+
+    id := pathId( r )
+
+    // With ?wait=10s, block until the password is hashed or the wait
+    // elapses. This is done before taking the shutdown mutex so that
+    // waiting clients don't hold up a shutdown
+    if value := r.URL.Query().Get( "wait" ); value != "" {
+        wait, err := time.ParseDuration( value )
+        if err != nil || wait <= 0 || wait > watchMaxTimeout {
+            s.log( r ).Info( "Invalid wait duration!" )
+            writeFieldErrors( w, invalidField( "wait", fmt.Sprintf( "invalid wait %q, must be between 0 and %s", value, watchMaxTimeout ) ) )
+            return
+        }
+        if !s.waitForHash( r.Context(), id, wait ) {
+            return
+        }
     }
 
     // Lock the shutdown mutex to ensure the server doesn't
     // shut down while processing this request
-    shutdownMutex.RLock()
-    defer shutdownMutex.RUnlock()
+    s.shutdownMutex.RLock()
+    defer s.shutdownMutex.RUnlock()
 
     // Get the hashed password, if the provided id exists
-    id, _ := strconv.ParseInt( path.Base( r.URL.Path ), 0, 64 )
-    pwdMutexMap.Lock()
-    hashedPassword := pwdHashedMap[ id ]
-    pwdMutexMap.Unlock()
+    record, job, _, gone, err := s.lookupHash( id )
+    if err != nil {
+        s.writeStoreError( w, r, err )
+        return
+    }
 
-    if hashedPassword == "" {
-        fmt.Println( "Passsword id not found!" )
-        http.Error( w, http.StatusText(http.StatusNotFound), http.StatusNotFound )
+    if gone == StatusDeleted {
+        s.log( r ).Info( "Passsword id deleted!" )
+        writeError( w, http.StatusGone, ErrorDeleted )
+        return
+    }
+    if gone == StatusEvicted {
+        s.log( r ).Info( "Passsword id evicted!" )
+        writeError( w, http.StatusGone, ErrorEvicted )
+        return
+    }
+    if gone == StatusExpired {
+        s.log( r ).Info( "Passsword id expired!" )
+        writeError( w, http.StatusGone, ErrorExpired )
+        return
+    }
+    if gone == StatusFailed {
+        s.log( r ).Info( "Passsword id failed!" )
+        writeError( w, http.StatusInternalServerError, ErrorFailed )
+        return
+    }
+
+    // Still within the delay window, tell the client it is coming
+    if record == nil && job != nil {
+        s.log( r ).Info( "Passsword id pending!" )
+        s.setRetryAfter( w, job.dueAt )
+        http.Error( w, http.StatusText(http.StatusAccepted), http.StatusAccepted )
+        return
+    }
+
+    if record == nil {
+        s.log( r ).Info( "Passsword id not found!" )
+        writeError( w, http.StatusNotFound, ErrorNotFound )
+        return
+    }
+
+    // Hashes never change once computed, so polling clients can
+    // revalidate their copy instead of downloading it again
+    s.setRecordCacheControl( w, record )
+    encoding := acceptedEncoding( r )
+    representation := "text"
+    if encoding != nil {
+        representation = encoding.name
+    }
+    etag := recordETag( record, representation )
+    w.Header().Set( "ETag", etag )
+    if etagMatches( r.Header.Get( "If-None-Match" ), etag ) {
+        w.WriteHeader( http.StatusNotModified )
+        return
+    }
+
+    // Return the full record to JSON, MessagePack and CBOR clients
+    if encoding != nil {
+        s.writeEncoded( w, r, http.StatusOK, record )
         return
     }
 
     // Return the hashed password
-    fmt.Fprintf( w, hashedPassword )
+    s.writeTemplate( w, http.StatusOK, templateHashGet, templateData{ Id: id, Location: mountPrefix( r ) + r.URL.Path, Hash: record.Hash } )
+}
+
+/********************************************************************
+handleHashesList()
+    Handles GET requests to list hashed passwords. The optional,
+    repeatable "label" query parameter (key:value) filters the list
+    to records carrying all of the given labels.
+********************************************************************/
+func ( s *Server ) handleHashesList( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /hashes" )
+
+    // Check shutdown
+    if s.shutDown {
+        s.log( r ).Info( "Server has been shut down!" )
+        writeError( w, http.StatusNotAcceptable, ErrorShuttingDown )
+        return
+    }
+
+    // Lock the shutdown mutex to ensure the server doesn't
+    // shut down while processing this request
+    s.shutdownMutex.RLock()
+    defer s.shutdownMutex.RUnlock()
+
+    filter, err := parseLabels( r.URL.Query()[ "label" ] )
+    if err != nil {
+        s.log( r ).Info( "Invalid label filter", "error", err )
+        writeFieldErrors( w, invalidField( "label", err.Error() ) )
+        return
+    }
+
+    // Collect the records matching every label in the filter
+    stored, err := s.store.List()
+    if err != nil {
+        s.writeStoreError( w, r, err )
+        return
+    }
+    records := []*Record{}
+    for _, record := range stored {
+        if record.DeletedAt == nil && matchLabels( record.Labels, filter ) {
+            records = append( records, record )
+        }
+    }
+
+    // Serialize and return the records
+    s.writeEncoded( w, r, http.StatusOK, records )
+}
+
+/********************************************************************
+matchLabels()
+    Returns true if labels contains every key:value pair in filter.
+********************************************************************/
+func matchLabels( labels map[string]string, filter map[string]string ) bool {
+    for key, value := range filter {
+        if got, ok := labels[ key ]; !ok || got != value {
+            return false
+        }
+    }
+    return true
 }
 
 /********************************************************************
@@ -205,79 +1506,150 @@ handleStats()
         Total number of passwords hashed (count of POST requests to the /hash endpoint).
         Average time for processing password hashing requests (in microseconds).
 ********************************************************************/
-func handleStats( w http.ResponseWriter, r *http.Request ) {
-    fmt.Println( "Endpoint: /stats" )
+func ( s *Server ) handleStats( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /stats" )
 
     // Check shutdown
-    if shutDown {
-        fmt.Println( "Server has been shut down!" )
-        http.Error( w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable )
-        return
-    }
-
-    // Check for GET method
-    if r.Method != http.MethodGet {
-        fmt.Println( "Only GET requests supported!" )
-        http.Error( w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed )
+    if s.shutDown {
+        s.log( r ).Info( "Server has been shut down!" )
+        writeError( w, http.StatusNotAcceptable, ErrorShuttingDown )
         return
     }
 
     // Lock the shutdown mutex to ensure the server doesn't
     // shut down while processing this request
-    shutdownMutex.RLock()
-    defer shutdownMutex.RUnlock()
-
-    // Get the current statistics - total number of requests and average processing time
-    pwdMutexMap.Lock()
-    total := pwdTotalTime
-    count := pwdHashedCount
-    pwdMutexMap.Unlock()
+    s.shutdownMutex.RLock()
+    defer s.shutdownMutex.RUnlock()
 
     // Don't panic if we get a /stats request before we have any passwords hashed
-    if count == 0 {
-        fmt.Println( "No hashed passwords yet!" )
-        http.Error( w, http.StatusText(http.StatusNotFound), http.StatusNotFound )
+    Stats, ok := s.collectStats()
+    if !ok {
+        s.log( r ).Info( "No hashed passwords yet!" )
+        writeError( w, http.StatusNotFound, ErrorNotFound )
         return
     }
 
-    average := total / count
-    Stats := Stat{ Total: count, Average: average }
-
     // Serialize and return the stats
-    json.NewEncoder(w).Encode(Stats)
+    s.writeEncoded( w, r, http.StatusOK, Stats )
+}
+
+/********************************************************************
+collectStats()
+    Returns the current statistics - total number of requests and
+    average processing time, plus the extended counters, the stats
+    of the recent windows and of every endpoint, the queue and what
+    is running. Returns false if no passwords have been hashed yet
+    and the stats were never reset.
+********************************************************************/
+func ( s *Server ) collectStats() ( Stat, bool ) {
+    s.mapMutex.Lock()
+    total := s.totalTime
+    count := s.hashedCount
+    slaViolations := s.slaViolations
+    expired := s.expiredCount
+    retentionExpired := s.retentionExpiredCount
+    evicted := s.evictedCount
+    gc := s.gcSnapshot()
+    labels := make(map[string]Stat, len( s.labelStats ))
+    for label, stat := range s.labelStats {
+        labels[ label ] = Stat{ Total: stat.count, Average: stat.totalTime / stat.count }
+    }
+    windows := s.windowStats()
+    rates := s.submissionRates.snapshot( s.clock.Now() )
+    queued, processing := s.jobStates()
+    resetAt := s.statsResetAt
+    s.mapMutex.Unlock()
+
+    // After a reset the zeroed stats are still reported, with when
+    // that was
+    if count == 0 && resetAt.IsZero() {
+        return Stat{}, false
+    }
+
+    var average int64
+    if count > 0 {
+        average = total / count
+    }
+    stats := Stat{ Total: count, Average: average, SlaViolations: slaViolations, Expired: expired, RetentionExpired: retentionExpired, Evicted: evicted, Paused: s.isPaused(), Webhooks: s.webhookStatsSnapshot(), Labels: labels, Windows: windows, Rates: rates, Endpoints: s.endpointStats(), GC: gc,
+        Queue: &QueueStat{ Queued: queued, Processing: processing, Workers: s.workers, BusyWorkers: s.busyWorkers.Load() },
+        Server: &ServerInfo{
+            StartedAt: s.startedAt,
+            Uptime: s.since( s.startedAt ).Round( time.Second ).String(),
+            Delay: s.delay.String(),
+            Build: s.buildInfo,
+        } }
+    if s.recordCache != nil {
+        stats.Cache = s.recordCache.stats()
+    }
+    if !resetAt.IsZero() {
+        stats.ResetAt = &resetAt
+    }
+    return stats, true
 }
 
 /********************************************************************
 handleShutDown()
-    Handles GET “graceful shutdown request”.
+    Handles GET “graceful shutdown request”. Requires a one-time token
+    from /admin/shutdown-token in the "token" query parameter or the
+    X-Shutdown-Token header.
 ********************************************************************/
-func handleShutDown( w http.ResponseWriter, r *http.Request ) {
-    fmt.Println( "Endpoint: /shutdown" )
+func ( s *Server ) handleShutDown( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /shutdown" )
 
-    // Check for GET method
-    if r.Method != http.MethodGet {
-        fmt.Println( "Only GET requests supported!" )
-        http.Error( w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed )
+    // Require a one-time token, so probes and crawlers hitting the URL
+    // can't shut the server down
+    token := r.URL.Query().Get( "token" )
+    if header := r.Header.Get( "X-Shutdown-Token" ); header != "" {
+        token = header
+    }
+    if !s.redeemShutdownToken( token ) {
+        s.log( r ).Info( "Missing, expired or already used shutdown token!" )
+        s.auditRequest( r, AuditAuthFailure, "invalid shutdown token" )
+        writeError( w, http.StatusForbidden, ErrorInvalidToken )
         return
     }
 
     // Ensure there are no requests currently being processed
     // This is done via a RW mutex
-    shutdownMutex.Lock()
-    defer shutdownMutex.Unlock()
+    s.shutdownMutex.Lock()
+    defer s.shutdownMutex.Unlock()
 
-    shutDown = true
+    s.auditRequest( r, AuditShutdown, "" )
+    s.startShutdown()
 
-    // Send a shutdown message and delay for a bit
-    // so the server can send the message before shutting down
+    // Send a shutdown message, the server is shut down after a delay
+    // so it can send the message before shutting down
     fmt.Fprintf( w, "Server Shutting Down!" )
+}
 
-	go func() {
-		time.Sleep( shutdownDelay )
-		err := pwdServer.Shutdown( context.Background() )
-        if err != nil {
-            fmt.Println( "Server unable to shut down!" )
-            http.Error( w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError )
+/********************************************************************
+startShutdown()
+    Stops accepting work and shuts the HTTP and gRPC servers down
+    after shutdownDelay, so the caller can still respond. Must be
+    called with shutdownMutex held.
+********************************************************************/
+func ( s *Server ) startShutdown() {
+    s.beginShutdown()
+
+    go func() {
+        time.Sleep( shutdownDelay )
+        if err := s.Shutdown( context.Background() ); err != nil {
+            s.logError( "Server unable to shut down: %v", err )
         }
-	}()
-}
\ No newline at end of file
+    }()
+}
+
+/********************************************************************
+beginShutdown()
+    Marks the server as shutting down. Must be called with
+    shutdownMutex held.
+********************************************************************/
+func ( s *Server ) beginShutdown() {
+
+    // Release long lived requests (event streams and long-polls),
+    // which would otherwise keep the server from shutting down
+    if !s.shutDown {
+        close( s.shutdownStarted )
+    }
+    s.shutDown = true
+}
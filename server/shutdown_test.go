@@ -0,0 +1,63 @@
+package server
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+)
+
+// A POST /hash waiting with sync=true doesn't hold up a shutdown, which
+// releases it rather than waiting out the delay
+func TestShutdownReleasesSyncPost( t *testing.T ) {
+    s, handler := newTestServer( t, time.Hour )
+
+    responded := make(chan *httptest.ResponseRecorder, 1)
+    go func() {
+        request := httptest.NewRequest( http.MethodPost, "/v1/hash", strings.NewReader( url.Values{ "password": { "angryMonkey" }, "sync": { "true" } }.Encode() ) )
+        request.Header.Set( "Content-Type", "application/x-www-form-urlencoded" )
+        response := httptest.NewRecorder()
+        handler.ServeHTTP( response, request )
+        responded <- response
+    }()
+
+    // Wait for the submission to be queued, the request then waits for it
+    deadline := time.Now().Add( 2 * time.Second )
+    for {
+        s.mapMutex.Lock()
+        pending := len( s.pendingJobs )
+        s.mapMutex.Unlock()
+        if pending > 0 {
+            break
+        }
+        if time.Now().After( deadline ) {
+            t.Fatal( "the sync POST was never queued" )
+        }
+        time.Sleep( 10 * time.Millisecond )
+    }
+
+    ctx, cancel := context.WithTimeout( context.Background(), 5 * time.Second )
+    defer cancel()
+    shutDown := make(chan error, 1)
+    go func() { shutDown <- s.Shutdown( ctx ) }()
+
+    select {
+    case err := <-shutDown:
+        if err != nil {
+            t.Errorf( "Shutdown: %v", err )
+        }
+    case <-time.After( 2 * time.Second ):
+        t.Fatal( "Shutdown waited for the sync POST" )
+    }
+    select {
+    case response := <-responded:
+        if response.Code != http.StatusAccepted {
+            t.Errorf( "sync POST: got %d %q, want 202", response.Code, response.Body )
+        }
+    case <-time.After( 2 * time.Second ):
+        t.Fatal( "the sync POST was not released by the shutdown" )
+    }
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "net/http"
+    "time"
+)
+
+// Response to POST /admin/shutdown-token
+type ShutdownTokenResponse struct {
+    Token string `json:"token"`
+    ExpiresAt time.Time `json:"expires_at"`
+}
+
+var (
+    // Shutdown token info
+    shutdownTokenTtl = 5 * time.Minute
+)
+
+/********************************************************************
+issueShutdownToken()
+    Creates a new one-time shutdown token. Returns an error rather
+    than a guessable token if the system's random source fails.
+********************************************************************/
+func ( s *Server ) issueShutdownToken() ( string, time.Time, error ) {
+    raw := make([]byte, 32)
+    if _, err := rand.Read( raw ); err != nil {
+        return "", time.Time{}, err
+    }
+    token := hex.EncodeToString( raw )
+    expiresAt := s.clock.Now().Add( shutdownTokenTtl )
+
+    s.shutdownTokenMutex.Lock()
+    defer s.shutdownTokenMutex.Unlock()
+
+    // Drop tokens that expired unused
+    for issued, issuedExpiry := range s.shutdownTokens {
+        if !s.clock.Now().Before( issuedExpiry ) {
+            delete( s.shutdownTokens, issued )
+        }
+    }
+    s.shutdownTokens[ token ] = expiresAt
+
+    return token, expiresAt, nil
+}
+
+/********************************************************************
+redeemShutdownToken()
+    Returns true if the token was issued and hasn't expired, and
+    invalidates it so it can't be replayed.
+********************************************************************/
+func ( s *Server ) redeemShutdownToken( token string ) bool {
+    if token == "" {
+        return false
+    }
+
+    s.shutdownTokenMutex.Lock()
+    defer s.shutdownTokenMutex.Unlock()
+
+    expiresAt, ok := s.shutdownTokens[ token ]
+    delete( s.shutdownTokens, token )
+    return ok && s.clock.Now().Before( expiresAt )
+}
+
+/********************************************************************
+handleShutdownToken()
+    Handles POST requests on /admin/shutdown-token, issuing a one-time
+    token that /shutdown requires. Tokens expire after 5 minutes.
+********************************************************************/
+func ( s *Server ) handleShutdownToken( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /admin/shutdown-token" )
+
+    token, expiresAt, err := s.issueShutdownToken()
+    if err != nil {
+        s.logErrorTo( s.log( r ), "Unable to issue a shutdown token: %v", err )
+        writeError( w, http.StatusInternalServerError, ErrorInternal )
+        return
+    }
+    s.auditRequest( r, AuditShutdownToken, "expires " + expiresAt.Format( time.RFC3339 ) )
+
+    s.writeEncoded( w, r, http.StatusOK, ShutdownTokenResponse{ Token: token, ExpiresAt: expiresAt } )
+}
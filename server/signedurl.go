@@ -0,0 +1,126 @@
+package server
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strconv"
+    "time"
+)
+
+var (
+    // Longest lifetime of a signed URL
+    signedURLMaxTtl = 30 * 24 * time.Hour
+    signedURLDefaultTtl = 24 * time.Hour
+)
+
+// Response to POST /admin/signed-url
+type SignedURLResponse struct {
+    URL string `json:"url"`
+    ExpiresAt time.Time `json:"expires_at"`
+}
+
+/********************************************************************
+urlSignature()
+    Returns the hex HMAC-SHA256 of a path and its expiry time.
+********************************************************************/
+func ( s *Server ) urlSignature( urlPath string, expires int64 ) string {
+    mac := hmac.New( sha256.New, s.urlSigningKey )
+    fmt.Fprintf( mac, "%s?expires=%d", urlPath, expires )
+    return hex.EncodeToString( mac.Sum(nil) )
+}
+
+/********************************************************************
+SignURL()
+    Returns urlPath with "expires" and "sig" query parameters that
+    grant read-only access to it until expiresAt.
+********************************************************************/
+func ( s *Server ) SignURL( urlPath string, expiresAt time.Time ) string {
+    expires := expiresAt.Unix()
+    query := url.Values{}
+    query.Set( "expires", strconv.FormatInt( expires, 10 ) )
+    query.Set( "sig", s.urlSignature( urlPath, expires ) )
+    return urlPath + "?" + query.Encode()
+}
+
+/********************************************************************
+verifySignedURL()
+    Checks the "expires" and "sig" query parameters of a request.
+********************************************************************/
+func ( s *Server ) verifySignedURL( r *http.Request ) error {
+    query := r.URL.Query()
+    expires, err := strconv.ParseInt( query.Get( "expires" ), 10, 64 )
+    if err != nil {
+        return fmt.Errorf( "signed URL has an invalid expiry" )
+    }
+    if s.clock.Now().Unix() >= expires {
+        return fmt.Errorf( "signed URL has expired" )
+    }
+
+    sig, err := hex.DecodeString( query.Get( "sig" ) )
+    want, _ := hex.DecodeString( s.urlSignature( r.URL.Path, expires ) )
+    if err != nil || !hmac.Equal( sig, want ) {
+        return fmt.Errorf( "signed URL has an invalid signature" )
+    }
+
+    return nil
+}
+
+/********************************************************************
+withSignedURL()
+    Middleware letting in admins, as withAdmin() does, and valid,
+    unexpired, read-only (GET) signed URLs for this path. Requests
+    carrying a "sig" parameter are rejected with 403 Forbidden unless
+    they are such a URL, others without the admin bearer token with
+    401 Unauthorized.
+********************************************************************/
+func ( s *Server ) withSignedURL( next http.HandlerFunc ) http.HandlerFunc {
+    return func( w http.ResponseWriter, r *http.Request ) {
+        if r.URL.Query().Get( "sig" ) == "" {
+            s.withAdmin( next )( w, r )
+            return
+        }
+
+        err := s.verifySignedURL( r )
+        if err == nil && r.Method != http.MethodGet {
+            err = fmt.Errorf( "signed URLs are read-only" )
+        }
+        if err != nil {
+            s.log( r ).Info( "Invalid signed URL", "error", err )
+            s.auditRequest( r, AuditAuthFailure, err.Error() )
+            writeError( w, http.StatusForbidden, ErrorInvalidSignature )
+            return
+        }
+        next( w, r )
+    }
+}
+
+/********************************************************************
+handleSignedURL()
+    Handles POST requests on /admin/signed-url, issuing a time limited
+    signed URL for the stats endpoint. The optional "ttl" form field
+    sets how long it stays valid, 24h by default.
+********************************************************************/
+func ( s *Server ) handleSignedURL( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /admin/signed-url" )
+
+    ttl := signedURLDefaultTtl
+    if value := r.FormValue( "ttl" ); value != "" {
+        var err error
+        ttl, err = time.ParseDuration( value )
+        if err != nil || ttl <= 0 || ttl > signedURLMaxTtl {
+            s.log( r ).Info( "Invalid signed URL ttl!" )
+            writeFieldErrors( w, invalidField( "ttl", fmt.Sprintf( "invalid ttl %q, must be between 0 and %s", value, signedURLMaxTtl ) ) )
+            return
+        }
+    }
+
+    expiresAt := s.clock.Now().Add( ttl ).Truncate( time.Second )
+    response := SignedURLResponse{ URL: mountPrefix( r ) + s.SignURL( requestVersion( r ) + "/stats", expiresAt ), ExpiresAt: expiresAt }
+
+    s.auditRequest( r, AuditSignedURL, "expires " + expiresAt.Format( time.RFC3339 ) )
+    s.writeEncoded( w, r, http.StatusOK, response )
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+
+    "jumpcloud_password_hash/hashpb"
+)
+
+// With an admin token /stats answers the token and valid signed URLs
+// only
+func TestStatsNeedsAdminOrSignature( t *testing.T ) {
+    config := DefaultConfig()
+    config.AdminToken = "admin"
+    s, handler := newTestServer( t, 0, WithConfig( config ) )
+    waitHashed( t, handler, postPassword( t, handler, "angryMonkey" ), time.Second )
+
+    valid := s.SignURL( "/v1/stats", time.Now().Add( time.Hour ) )
+    expired := s.SignURL( "/v1/stats", time.Now().Add( -time.Second ) )
+    for _, test := range []struct {
+        name string
+        target string
+        authorization string
+        status int
+    }{
+        { "anonymous", "/v1/stats", "", http.StatusUnauthorized },
+        { "admin", "/v1/stats", "Bearer admin", http.StatusOK },
+        { "signed", valid, "", http.StatusOK },
+        { "expired", expired, "", http.StatusForbidden },
+        { "tampered", strings.Replace( valid, "sig=", "sig=00", 1 ), "", http.StatusForbidden },
+        { "other path", strings.Replace( valid, "/v1/stats", "/stats", 1 ), "", http.StatusForbidden },
+        { "unsigned expiry", "/v1/stats?expires=9999999999", "", http.StatusUnauthorized },
+    } {
+        response := adminRequest( handler, http.MethodGet, test.target, test.authorization )
+        if response.Code != test.status {
+            t.Errorf( "%s: got %d %q, want %d", test.name, response.Code, response.Body, test.status )
+        }
+    }
+}
+
+// The GraphQL stats query and gRPC GetStats need the admin token like
+// /stats, so they don't bypass it
+func TestStatsFrontEndsNeedAdmin( t *testing.T ) {
+    config := DefaultConfig()
+    config.AdminToken = "admin"
+    s, handler := newTestServer( t, 0, WithConfig( config ) )
+
+    for authorization, allowed := range map[string]bool{ "": false, "admin": false, "Bearer wrong": false, "Bearer admin": true } {
+        request := httptest.NewRequest( http.MethodPost, "/v1/graphql", strings.NewReader( `{"query":"{ stats { total } }"}` ) )
+        request.Header.Set( "Content-Type", "application/json" )
+        if authorization != "" {
+            request.Header.Set( "Authorization", authorization )
+        }
+        response := httptest.NewRecorder()
+        handler.ServeHTTP( response, request )
+        if got := !strings.Contains( response.Body.String(), `"errors"` ); got != allowed {
+            t.Errorf( "GraphQL stats with %q: got %q, want allowed %t", authorization, response.Body, allowed )
+        }
+
+        ctx := context.Background()
+        if authorization != "" {
+            ctx = metadata.NewIncomingContext( ctx, metadata.Pairs( "authorization", authorization ) )
+        }
+        _, err := ( &hashService{ server: s } ).GetStats( ctx, &hashpb.GetStatsRequest{} )
+        if allowed && err != nil {
+            t.Errorf( "gRPC GetStats with %q: %v", authorization, err )
+        }
+        if !allowed && status.Code( err ) != codes.Unauthenticated {
+            t.Errorf( "gRPC GetStats with %q: got %v, want Unauthenticated", authorization, err )
+        }
+    }
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "time"
+)
+
+// Default interval between snapshots
+const snapshotDefaultInterval = 5 * time.Minute
+
+// State saved to Config.SnapshotFile: the records, sealed with
+// Config.EncryptionKey if it is set, the id sequence, the ranges of
+// expired, evicted and purged ids and the stats. Snapshots saved
+// before the ranges list the ids one by one
+type snapshot struct {
+    SavedAt time.Time `json:"saved_at"`
+    LastId int64 `json:"last_id"`
+    Records []json.RawMessage `json:"records"`
+    ExpiredIds []int64 `json:"expired_ids,omitempty"`
+    EvictedIds []int64 `json:"evicted_ids,omitempty"`
+    PurgedIds []int64 `json:"purged_ids,omitempty"`
+    ExpiredRanges []idRange `json:"expired_ranges,omitempty"`
+    EvictedRanges []idRange `json:"evicted_ranges,omitempty"`
+    PurgedRanges []idRange `json:"purged_ranges,omitempty"`
+    Stats statsCheckpoint `json:"stats"`
+}
+
+/********************************************************************
+loadSnapshot()
+    Restores the state saved in Config.SnapshotFile, if it exists.
+    Records are only put in an empty store, a persistent one already
+    has them, and the stats are only restored without a StatsFile,
+    which is checkpointed on its own.
+********************************************************************/
+func ( s *Server ) loadSnapshot() error {
+    data, err := os.ReadFile( s.config.SnapshotFile )
+    if errors.Is( err, fs.ErrNotExist ) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+
+    var saved snapshot
+    if err := json.Unmarshal( data, &saved ); err != nil {
+        return err
+    }
+
+    if saved.LastId > s.lastId {
+        s.lastId = saved.LastId
+    }
+    for _, id := range saved.ExpiredIds {
+        s.expiredIds.add( id )
+    }
+    for _, id := range saved.EvictedIds {
+        s.evictedIds.add( id )
+    }
+    for _, id := range saved.PurgedIds {
+        s.purgedIds.add( id )
+    }
+    for _, ranges := range []struct {
+        saved []idRange
+        set *idSet
+    }{
+        { saved.ExpiredRanges, s.expiredIds },
+        { saved.EvictedRanges, s.evictedIds },
+        { saved.PurgedRanges, s.purgedIds },
+    } {
+        for _, r := range ranges.saved {
+            if r[ 0 ] < 1 || r[ 0 ] > r[ 1 ] || r[ 1 ] > s.lastId {
+                return fmt.Errorf( "invalid id range %v in the snapshot", r )
+            }
+            ranges.set.addRange( r )
+        }
+    }
+    if s.config.StatsFile == "" {
+        s.restoreStats( saved.Stats )
+    }
+
+    count, err := s.store.Count()
+    if err != nil {
+        return err
+    }
+    if count == 0 {
+        for _, data := range saved.Records {
+            if err := s.replayRecord( data ); err != nil {
+                return err
+            }
+        }
+    }
+
+    s.logger.Info( "Restored snapshot!", "records", len( saved.Records ), "last_id", saved.LastId, "saved_at", saved.SavedAt )
+    return nil
+}
+
+/********************************************************************
+saveSnapshot()
+    Writes the records, id sequence, expired, evicted and purged ids
+    and stats to Config.SnapshotFile. The records are listed with
+    mapMutex held, so they match the id sequence and those ids.
+********************************************************************/
+func ( s *Server ) saveSnapshot() error {
+    saved := snapshot{ SavedAt: s.clock.Now() }
+
+    s.mapMutex.Lock()
+    records, err := s.store.List()
+    saved.LastId = s.lastId
+    saved.ExpiredRanges = s.expiredIds.savedRanges()
+    saved.EvictedRanges = s.evictedIds.savedRanges()
+    saved.PurgedRanges = s.purgedIds.savedRanges()
+    s.mapMutex.Unlock()
+    if err != nil {
+        return err
+    }
+
+    saved.Records = make([]json.RawMessage, len( records ))
+    for i, record := range records {
+        if saved.Records[ i ], err = s.encodeDurable( record ); err != nil {
+            return err
+        }
+    }
+    saved.Stats = s.statsCheckpoint()
+
+    data, err := json.Marshal( saved )
+    if err != nil {
+        return err
+    }
+    if err := writeFileAtomic( s.config.SnapshotFile, data ); err != nil {
+        return err
+    }
+    s.logger.Debug( "Saved snapshot", "records", len( records ), "last_id", saved.LastId )
+    return nil
+}
+
+/********************************************************************
+snapshotPeriodically()
+    Saves a snapshot every SnapshotInterval until the server shuts
+    down, Shutdown() saves one a last time.
+********************************************************************/
+func ( s *Server ) snapshotPeriodically() {
+    interval := s.config.SnapshotInterval
+    if interval <= 0 {
+        interval = snapshotDefaultInterval
+    }
+    ticker := time.NewTicker( interval )
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+        case <-s.shutdownStarted:
+            return
+        }
+
+        if err := s.saveSnapshot(); err != nil {
+            s.logError( "Unable to save snapshot: %v", err )
+        }
+    }
+}
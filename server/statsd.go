@@ -0,0 +1,77 @@
+package server
+
+import (
+    "net"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Default prefix of StatsD metric names
+const statsdDefaultPrefix = "hashsvc."
+
+// StatsD client sending DogStatsD metrics over UDP. Sends are fire
+// and forget, lost packets and a missing agent don't affect requests.
+// A nil client sends nothing
+type statsdClient struct {
+    conn net.Conn
+    prefix string
+    tags []string
+}
+
+/********************************************************************
+newStatsdClient()
+    Creates a StatsD client sending to a host:port, prefixing metric
+    names and adding the tags to every metric.
+********************************************************************/
+func newStatsdClient( addr string, prefix string, tags []string ) ( *statsdClient, error ) {
+    conn, err := net.Dial( "udp", addr )
+    if err != nil {
+        return nil, err
+    }
+    return &statsdClient{ conn: conn, prefix: prefix, tags: tags }, nil
+}
+
+/********************************************************************
+timing()
+    Sends a timing in milliseconds.
+********************************************************************/
+func ( c *statsdClient ) timing( name string, elapsed time.Duration, tags ...string ) {
+    c.send( name, strconv.FormatFloat( float64( elapsed ) / float64( time.Millisecond ), 'f', 3, 64 ), "ms", tags )
+}
+
+/********************************************************************
+increment()
+    Adds one to a counter.
+********************************************************************/
+func ( c *statsdClient ) increment( name string, tags ...string ) {
+    c.send( name, "1", "c", tags )
+}
+
+/********************************************************************
+send()
+    Writes a metric in the DogStatsD format,
+    <prefix><name>:<value>|<type>|#<tag>,<tag>
+********************************************************************/
+func ( c *statsdClient ) send( name string, value string, metricType string, tags []string ) {
+    if c == nil {
+        return
+    }
+
+    var line strings.Builder
+    line.WriteString( c.prefix + name + ":" + value + "|" + metricType )
+    if all := append( append( []string{}, c.tags... ), tags... ); len( all ) > 0 {
+        line.WriteString( "|#" + strings.Join( all, "," ) )
+    }
+    c.conn.Write( []byte( line.String() ) )
+}
+
+/********************************************************************
+close()
+    Closes the client's socket.
+********************************************************************/
+func ( c *statsdClient ) close() {
+    if c != nil {
+        c.conn.Close()
+    }
+}
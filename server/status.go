@@ -0,0 +1,186 @@
+package server
+
+import (
+    "fmt"
+    "net/http"
+    "time"
+)
+
+// Job states reported by /hash/{id}/status
+const (
+    StatusQueued = "queued"
+    StatusProcessing = "processing"
+    StatusDone = "done"
+    StatusFailed = "failed"
+    StatusExpired = "expired"
+    StatusEvicted = "evicted"
+    StatusDeleted = "deleted"
+)
+
+// Response to GET /hash/{id}/status
+type StatusResponse struct {
+    Id int64 `json:"id"`
+    Status string `json:"status"`
+    EstimatedCompletion *time.Time `json:"estimated_completion,omitempty"`
+}
+
+/********************************************************************
+lookupHash()
+    Looks up a password id, returning its record once hashed, or its
+    pending job while it is still waiting to be hashed, and if its
+    record has been deleted, has expired or been evicted, or its job
+    failed, StatusDeleted, StatusExpired, StatusEvicted or
+    StatusFailed. Looking up a record counts as reading it for the
+    lru eviction policy. The store is read without mapMutex, a remote
+    one can take a while, pending jobs are checked first as a job is
+    stored before it stops being pending.
+********************************************************************/
+func ( s *Server ) lookupHash( id int64 ) ( record *Record, job *hashJob, state string, gone string, err error ) {
+    s.mapMutex.Lock()
+    if job = s.pendingJobs[ id ]; job != nil {
+        state = job.state
+        s.mapMutex.Unlock()
+        return nil, job, state, "", nil
+    }
+    s.mapMutex.Unlock()
+
+    record, err = s.getRecord( id )
+    if err != nil {
+        return nil, nil, "", "", err
+    }
+
+    s.mapMutex.Lock()
+    defer s.mapMutex.Unlock()
+    switch {
+    case s.purgedIds.has( id ) || ( record != nil && record.DeletedAt != nil ):
+        gone = StatusDeleted
+    case s.expiredIds.has( id ) || ( record != nil && s.recordExpired( record, s.clock.Now() ) ):
+        gone = StatusExpired
+    case s.evictedIds.has( id ):
+        gone = StatusEvicted
+    case s.failedIds.has( id ):
+        gone = StatusFailed
+    case record != nil:
+        s.touchRecord( id )
+    }
+    return record, nil, "", gone, nil
+}
+
+/********************************************************************
+handleHashStatus()
+    Handles GET requests on /hash/{id}/status, reporting whether the
+    password is queued, processing, done, failed, deleted, expired or
+    evicted.
+********************************************************************/
+func ( s *Server ) handleHashStatus( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /hash/{id}/status GET" )
+
+    // Check shutdown
+    if s.shutDown {
+        s.log( r ).Info( "Server has been shut down!" )
+        writeError( w, http.StatusNotAcceptable, ErrorShuttingDown )
+        return
+    }
+
+    // Lock the shutdown mutex to ensure the server doesn't
+    // shut down while processing this request
+    s.shutdownMutex.RLock()
+    defer s.shutdownMutex.RUnlock()
+
+    id := pathId( r )
+    record, job, state, gone, err := s.lookupHash( id )
+    if err != nil {
+        s.writeStoreError( w, r, err )
+        return
+    }
+    response := StatusResponse{ Id: id }
+    switch {
+    case gone != "":
+        response.Status = gone
+    case record != nil:
+        response.Status = StatusDone
+    case job != nil:
+        response.Status = state
+        dueAt := job.dueAt
+        response.EstimatedCompletion = &dueAt
+        s.setRetryAfter( w, dueAt )
+    default:
+        s.log( r ).Info( "Passsword id not found!" )
+        writeError( w, http.StatusNotFound, ErrorNotFound )
+        return
+    }
+
+    s.writeEncoded( w, r, http.StatusOK, response )
+}
+
+// Entry in the GET /hash?ids= bulk response
+type BulkEntry struct {
+    Status string `json:"status"`
+    Hash string `json:"hash,omitempty"`
+}
+
+var (
+    // Most ids accepted by a bulk GET
+    bulkMaxIds = 1000
+)
+
+/********************************************************************
+hashStatus()
+    Returns the state and, once done, the hash of a password id.
+    Ids that were never submitted report "not_found".
+********************************************************************/
+func ( s *Server ) hashStatus( id int64 ) ( BulkEntry, error ) {
+    record, job, state, gone, err := s.lookupHash( id )
+    switch {
+    case err != nil:
+        return BulkEntry{}, err
+    case gone != "":
+        return BulkEntry{ Status: gone }, nil
+    case record != nil:
+        return BulkEntry{ Status: StatusDone, Hash: record.Hash }, nil
+    case job != nil:
+        return BulkEntry{ Status: state }, nil
+    }
+    return BulkEntry{ Status: "not_found" }, nil
+}
+
+/********************************************************************
+handleHashBulkGet()
+    Handles GET requests on /hash?ids=1,2,3, returning a JSON object
+    mapping each id to its status and, once done, its hash.
+********************************************************************/
+func ( s *Server ) handleHashBulkGet( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /hash GET" )
+
+    // Check shutdown
+    if s.shutDown {
+        s.log( r ).Info( "Server has been shut down!" )
+        writeError( w, http.StatusNotAcceptable, ErrorShuttingDown )
+        return
+    }
+
+    // Lock the shutdown mutex to ensure the server doesn't
+    // shut down while processing this request
+    s.shutdownMutex.RLock()
+    defer s.shutdownMutex.RUnlock()
+
+    ids, err := parseIds( r.URL.Query().Get( "ids" ) )
+    if err == nil && len( ids ) > bulkMaxIds {
+        err = fmt.Errorf( "too many ids, at most %d", bulkMaxIds )
+    }
+    if err != nil {
+        s.log( r ).Info( "Invalid ids", "error", err )
+        writeFieldErrors( w, invalidField( "ids", err.Error() ) )
+        return
+    }
+
+    entries := make(map[int64]BulkEntry, len( ids ))
+    for _, id := range ids {
+        if entries[ id ], err = s.hashStatus( id ); err != nil {
+            s.writeStoreError( w, r, err )
+            return
+        }
+    }
+
+    s.writeEncoded( w, r, http.StatusOK, entries )
+}
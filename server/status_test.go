@@ -0,0 +1,168 @@
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+)
+
+// newTestServer returns a server hashing after delay, and its handler
+func newTestServer( t *testing.T, delay time.Duration, options ...Option ) ( *Server, http.Handler ) {
+    t.Helper()
+    s, err := New( append( []Option{ WithDelay( delay ) }, options... )... )
+    if err != nil {
+        t.Fatalf( "New: %v", err )
+    }
+    t.Cleanup( func() { s.Shutdown( context.Background() ) } )
+    return s, s.Handler()
+}
+
+// postPassword submits a password to POST /v1/hash and returns its id
+func postPassword( t *testing.T, handler http.Handler, password string ) int64 {
+    t.Helper()
+    request := httptest.NewRequest( http.MethodPost, "/v1/hash", strings.NewReader( url.Values{ "password": { password } }.Encode() ) )
+    request.Header.Set( "Content-Type", "application/x-www-form-urlencoded" )
+    response := httptest.NewRecorder()
+    handler.ServeHTTP( response, request )
+    if response.Code != http.StatusAccepted && response.Code != http.StatusOK {
+        t.Fatalf( "POST /v1/hash: %d %s", response.Code, response.Body )
+    }
+    var body HashResponse
+    if err := json.Unmarshal( response.Body.Bytes(), &body ); err != nil {
+        t.Fatalf( "POST /v1/hash body %q: %v", response.Body, err )
+    }
+    return body.Id
+}
+
+// getHash requests GET /v1/hash/{id}
+func getHash( handler http.Handler, id int64 ) *httptest.ResponseRecorder {
+    response := httptest.NewRecorder()
+    handler.ServeHTTP( response, httptest.NewRequest( http.MethodGet, "/v1/hash/" + strconv.FormatInt( id, 10 ), nil ) )
+    return response
+}
+
+// A GET right after the POST sees the pending job, never a 404, and
+// the hash once it is done
+func TestGetAfterPostReadsOwnWrite( t *testing.T ) {
+    _, handler := newTestServer( t, 200 * time.Millisecond )
+
+    id := postPassword( t, handler, "angryMonkey" )
+    response := getHash( handler, id )
+    if response.Code != http.StatusAccepted {
+        t.Fatalf( "GET right after POST: got %d %q, want 202", response.Code, response.Body )
+    }
+    if body := strings.TrimSpace( response.Body.String() ); body != http.StatusText( http.StatusAccepted ) {
+        t.Errorf( "pending body: got %q, want %q", body, http.StatusText( http.StatusAccepted ) )
+    }
+    if response.Header().Get( "Retry-After" ) == "" {
+        t.Error( "pending response without Retry-After" )
+    }
+
+    deadline := time.Now().Add( 5 * time.Second )
+    for response.Code == http.StatusAccepted && time.Now().Before( deadline ) {
+        time.Sleep( 20 * time.Millisecond )
+        response = getHash( handler, id )
+    }
+    if response.Code != http.StatusOK {
+        t.Fatalf( "GET once hashed: got %d %q, want 200", response.Code, response.Body )
+    }
+    if body := strings.TrimSpace( response.Body.String() ); body != hashPassword( "angryMonkey" ) {
+        t.Errorf( "hash: got %q, want %q", body, hashPassword( "angryMonkey" ) )
+    }
+}
+
+// Store whose writes always fail
+type failingStore struct {
+    Store
+}
+
+func ( failingStore ) Put( record *Record ) error {
+    return errors.New( "disk full" )
+}
+
+// A job whose record the store keeps refusing ends up failed instead of
+// holding its worker forever
+func TestStoreFailureFailsJob( t *testing.T ) {
+    retryInterval, maxAttempts := storeRetryInterval, storeMaxAttempts
+    storeRetryInterval, storeMaxAttempts = time.Millisecond, 2
+    t.Cleanup( func() { storeRetryInterval, storeMaxAttempts = retryInterval, maxAttempts } )
+
+    _, handler := newTestServer( t, 0, WithStore( failingStore{ NewMemoryStore() } ) )
+
+    id := postPassword( t, handler, "angryMonkey" )
+    response := getHash( handler, id )
+    deadline := time.Now().Add( 5 * time.Second )
+    for response.Code == http.StatusAccepted && time.Now().Before( deadline ) {
+        time.Sleep( 10 * time.Millisecond )
+        response = getHash( handler, id )
+    }
+    if response.Code != http.StatusInternalServerError || !strings.Contains( response.Body.String(), ErrorFailed ) {
+        t.Fatalf( "GET of a failed job: got %d %q, want 500 %s", response.Code, response.Body, ErrorFailed )
+    }
+
+    response = httptest.NewRecorder()
+    handler.ServeHTTP( response, httptest.NewRequest( http.MethodGet, "/v1/hash/" + strconv.FormatInt( id, 10 ) + "/status", nil ) )
+    var status StatusResponse
+    if err := json.Unmarshal( response.Body.Bytes(), &status ); err != nil || status.Status != StatusFailed {
+        t.Errorf( "status of a failed job: got %q (%v), want %q", response.Body, err, StatusFailed )
+    }
+}
+
+// Store whose writes wait until released
+type blockingStore struct {
+    Store
+    release chan struct{}
+}
+
+func ( b blockingStore ) Put( record *Record ) error {
+    <-b.release
+    return b.Store.Put( record )
+}
+
+// A slow store write doesn't hold mapMutex, so lookups of other ids and
+// the status of the job being stored answer meanwhile
+func TestSlowStoreWriteDoesNotBlockLookups( t *testing.T ) {
+    store := blockingStore{ NewMemoryStore(), make(chan struct{}) }
+    s, handler := newTestServer( t, 0, WithStore( store ) )
+
+    id := postPassword( t, handler, "angryMonkey" )
+    deadline := time.Now().Add( 2 * time.Second )
+    for {
+        s.mapMutex.Lock()
+        state := s.pendingJobs[ id ].state
+        s.mapMutex.Unlock()
+        if state == StatusProcessing {
+            break
+        }
+        if time.Now().After( deadline ) {
+            t.Fatal( "the job was never processed" )
+        }
+        time.Sleep( 10 * time.Millisecond )
+    }
+
+    looked := make(chan *httptest.ResponseRecorder, 1)
+    go func() {
+        response := httptest.NewRecorder()
+        handler.ServeHTTP( response, httptest.NewRequest( http.MethodGet, "/v1/hash/" + strconv.FormatInt( id, 10 ) + "/status", nil ) )
+        looked <- response
+    }()
+    select {
+    case response := <-looked:
+        var status StatusResponse
+        if err := json.Unmarshal( response.Body.Bytes(), &status ); err != nil || status.Status != StatusProcessing {
+            t.Errorf( "status while storing: got %q (%v), want %q", response.Body, err, StatusProcessing )
+        }
+    case <-time.After( 2 * time.Second ):
+        t.Fatal( "the status lookup waited for the store write" )
+    }
+
+    close( store.release )
+    waitHashed( t, handler, id, 2 * time.Second )
+}
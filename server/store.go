@@ -0,0 +1,253 @@
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "sort"
+    "sync"
+    "time"
+)
+
+// How long to wait before storing a hashed record again after the
+// store failed, and how many times it is tried before its job fails
+var (
+    storeRetryInterval = time.Second
+    storeMaxAttempts = 5
+)
+
+var (
+    // Returned by Store.Get() for ids without a record
+    ErrRecordNotFound = errors.New( "record not found" )
+
+    // Returned to GraphQL, gRPC and WebSocket clients when the store
+    // fails, the cause is only logged
+    errStoreUnavailable = errors.New( "store unavailable" )
+)
+
+// Persistence of hashed password records, kept in memory unless
+// WithStore() sets another backend. Implementations must be safe for
+// concurrent use
+type Store interface {
+    // Stores a record, replacing any with the same id
+    Put( record *Record ) error
+
+    // Returns the record of an id, or ErrRecordNotFound
+    Get( id int64 ) ( *Record, error )
+
+    // Removes the record of an id, if there is one
+    Delete( id int64 ) error
+
+    // Returns every record, in id order
+    List() ( []*Record, error )
+
+    // Returns how many records are stored
+    Count() ( int, error )
+}
+
+// Optionally implemented by a Store persisting the last allocated id,
+// so ids aren't handed out again after a restart
+type SequenceStore interface {
+    // Returns the last allocated id, 0 if none was
+    LastId() ( int64, error )
+
+    // Records the last allocated id
+    SetLastId( id int64 ) error
+}
+
+// Optionally implemented by a Store whose Count() is too costly for
+// every /readyz probe, to check it can be reached instead
+type PingStore interface {
+    Ping() error
+}
+
+// Record as encoded by the stores persisting it, with its provenance
+// and the checksum of the rest
+type storedRecord struct {
+    *Record
+    Provenance *Provenance `json:"provenance,omitempty"`
+    Digest string `json:"digest,omitempty"`
+    Checksum string `json:"checksum,omitempty"`
+}
+
+/********************************************************************
+encodeRecord()
+    Encodes a record as JSON for a persistent store, along with its
+    provenance, deduplication digest and checksum.
+********************************************************************/
+func encodeRecord( record *Record ) ( []byte, error ) {
+    checksum, err := recordChecksum( record )
+    if err != nil {
+        return nil, err
+    }
+    return json.Marshal( storedRecord{ Record: record, Provenance: record.provenance, Digest: record.digest, Checksum: checksum } )
+}
+
+/********************************************************************
+decodeRecord()
+    Decodes a record encoded by encodeRecord(), keeping its checksum
+    for verifyRecord().
+********************************************************************/
+func decodeRecord( data []byte ) ( *Record, error ) {
+    stored := storedRecord{ Record: &Record{} }
+    if err := json.Unmarshal( data, &stored ); err != nil {
+        return nil, err
+    }
+    stored.Record.provenance = stored.Provenance
+    stored.Record.digest = stored.Digest
+    stored.Record.checksum = stored.Checksum
+    return stored.Record, nil
+}
+
+// Default store, a map by id lost on restart
+type memoryStore struct {
+    mutex sync.RWMutex
+    records map[int64]*Record
+}
+
+/********************************************************************
+NewMemoryStore()
+    Creates an empty in-memory store, the default of New().
+********************************************************************/
+func NewMemoryStore() Store {
+    return &memoryStore{ records: make(map[int64]*Record) }
+}
+
+func ( m *memoryStore ) Put( record *Record ) error {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    m.records[ record.Id ] = record
+    return nil
+}
+
+func ( m *memoryStore ) Get( id int64 ) ( *Record, error ) {
+    m.mutex.RLock()
+    defer m.mutex.RUnlock()
+    record := m.records[ id ]
+    if record == nil {
+        return nil, ErrRecordNotFound
+    }
+    return record, nil
+}
+
+func ( m *memoryStore ) Delete( id int64 ) error {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    delete( m.records, id )
+    return nil
+}
+
+func ( m *memoryStore ) List() ( []*Record, error ) {
+    m.mutex.RLock()
+    records := make([]*Record, 0, len( m.records ))
+    for _, record := range m.records {
+        records = append( records, record )
+    }
+    m.mutex.RUnlock()
+
+    sort.Slice( records, func( i, j int ) bool { return records[ i ].Id < records[ j ].Id } )
+    return records, nil
+}
+
+func ( m *memoryStore ) Count() ( int, error ) {
+    m.mutex.RLock()
+    defer m.mutex.RUnlock()
+    return len( m.records ), nil
+}
+
+/********************************************************************
+loadStore()
+    Restores what the server derives from the records of a persistent
+    store: the last allocated id, so ids aren't handed out again, when
+    records expire, by their ttl or RetentionPeriod, or were soft
+    deleted, and in deduplication mode the index of the stored
+    hashes. With MaxRecords the records past it are evicted, the
+    earliest ids first.
+********************************************************************/
+func ( s *Server ) loadStore() error {
+    if sequence, ok := s.store.( SequenceStore ); ok {
+        lastId, err := sequence.LastId()
+        if err != nil {
+            return err
+        }
+        if lastId > s.lastId {
+            s.lastId = lastId
+        }
+    }
+
+    records, err := s.store.List()
+    if err != nil {
+        return err
+    }
+    now := s.clock.Now()
+    for _, record := range records {
+        if _, err := s.openDurable( record ); err != nil {
+            return err
+        }
+        if record.Id > s.lastId {
+            s.lastId = record.Id
+        }
+        if expiry := s.expiryOf( record ); expiry != nil {
+            s.expiresAt[ record.Id ] = *expiry
+        }
+        if record.DeletedAt != nil {
+            s.deletedAt[ record.Id ] = *record.DeletedAt
+        } else if !s.recordExpired( record, now ) {
+            s.rememberDigest( record )
+        }
+        if err := s.trackRecord( record.Id ); err != nil {
+            return err
+        }
+    }
+
+    if len( records ) > 0 {
+        s.logger.Info( "Restored records!", "records", len( records ), "last_id", s.lastId )
+        s.verifyRecords( records )
+    }
+    return nil
+}
+
+/********************************************************************
+getRecord()
+    Returns the record of an id from the store, nil without an error
+    if it has none, and an error wrapping errRecordCorrupted if it
+    doesn't match its checksum, unless IntegrityPolicy is flag.
+********************************************************************/
+func ( s *Server ) getRecord( id int64 ) ( *Record, error ) {
+    record, err := s.store.Get( id )
+    if errors.Is( err, ErrRecordNotFound ) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    if err := s.checkRecord( record ); err != nil {
+        return nil, err
+    }
+    return record, nil
+}
+
+/********************************************************************
+writeStoreError()
+    Logs a failure of the store and responds 503 Service Unavailable,
+    as it is usually temporary.
+********************************************************************/
+func ( s *Server ) writeStoreError( w http.ResponseWriter, r *http.Request, err error ) {
+    s.logErrorTo( s.log( r ), "Store failed: %v", err )
+    if errors.Is( err, errRecordCorrupted ) {
+        writeError( w, http.StatusInternalServerError, ErrorRecordCorrupted )
+        return
+    }
+    writeError( w, http.StatusServiceUnavailable, ErrorStoreUnavailable )
+}
+
+/********************************************************************
+storeFailed()
+    Logs a failure of the store with the logger of the request a
+    context belongs to, returning the error to give the client.
+********************************************************************/
+func ( s *Server ) storeFailed( ctx context.Context, err error ) error {
+    s.logErrorTo( s.contextLogger( ctx ), "Store failed: %v", err )
+    return errStoreUnavailable
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+    "fmt"
+    "net/http"
+    "strings"
+    "text/template"
+    "time"
+)
+
+// Names of the text/plain response templates
+const (
+    templateHashPost = "hash_post"
+    templateHashGet = "hash_get"
+)
+
+// Built-in text/plain responses, matching the original API
+const defaultTemplates = `{{define "hash_post"}}{{.Id}}{{end}}` +
+    `{{define "hash_get"}}{{.Hash}}{{end}}`
+
+// Data available to the text/plain response templates
+type templateData struct {
+    Id int64
+    Location string
+    Hash string
+    EstimatedCompletion time.Time
+}
+
+/********************************************************************
+templateFuncs()
+    Returns the functions available to templates, on top of the
+    text/template builtins.
+********************************************************************/
+func ( s *Server ) templateFuncs() template.FuncMap {
+    return template.FuncMap{
+        "rfc3339": func( t time.Time ) string { return t.Format( time.RFC3339 ) },
+        "unix": func( t time.Time ) int64 { return t.Unix() },
+        "seconds": func( t time.Time ) int64 { return int64( s.until( t ).Seconds() + 0.5 ) },
+    }
+}
+
+/********************************************************************
+defaultResponseTemplates()
+    Returns the built-in text/plain response templates.
+********************************************************************/
+func ( s *Server ) defaultResponseTemplates() *template.Template {
+    return template.Must( template.New( "responses" ).Funcs( s.templateFuncs() ).Parse( defaultTemplates ) )
+}
+
+/********************************************************************
+loadResponseTemplates()
+    Loads operator supplied text/plain response templates from a file
+    of {{define "hash_post"}}...{{end}} / {{define "hash_get"}} blocks.
+    Templates not defined in the file keep their built-in defaults.
+********************************************************************/
+func ( s *Server ) loadResponseTemplates( filename string ) error {
+    templates := s.defaultResponseTemplates()
+    if _, err := templates.ParseFiles( filename ); err != nil {
+        return fmt.Errorf( "loading response templates: %w", err )
+    }
+
+    s.responseTemplates = templates
+    return nil
+}
+
+/********************************************************************
+wantsText()
+    Returns true if the client asked for text/plain rather than one
+    of the encodings.
+********************************************************************/
+func wantsText( r *http.Request ) bool {
+    return strings.Contains( r.Header.Get( "Accept" ), "text/plain" ) && acceptedEncoding( r ) == nil
+}
+
+/********************************************************************
+writeTemplate()
+    Renders the named text/plain response template.
+********************************************************************/
+func ( s *Server ) writeTemplate( w http.ResponseWriter, status int, name string, data templateData ) {
+    var body strings.Builder
+    if err := s.responseTemplates.ExecuteTemplate( &body, name, data ); err != nil {
+        s.logError( "Unable to render response template: %v", err )
+        writeError( w, http.StatusInternalServerError, ErrorInternal )
+        return
+    }
+
+    w.Header().Set( "Content-Type", "text/plain; charset=utf-8" )
+    w.WriteHeader( status )
+    fmt.Fprint( w, body.String() )
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+    "container/list"
+    "sync"
+    "sync/atomic"
+)
+
+// Reads of the record cache, reported in /stats
+type CacheStat struct {
+    Hits int64 `json:"hits"`
+    Misses int64 `json:"misses"`
+    HitRatio float64 `json:"hit_ratio"`
+    Evictions int64 `json:"evictions"`
+    Records int `json:"records"`
+    Capacity int `json:"capacity"`
+}
+
+// Store keeping the records read or written most recently in memory in
+// front of a persistent one, set up by New() with Config.CacheRecords.
+// Writes go through to the persistent store, which stays the source
+// of truth, before the cache is updated, and listings always read it
+type cachedStore struct {
+    store Store
+    capacity int
+
+    // Records by recency, most recent first, guarded by mutex
+    mutex sync.Mutex
+    order *list.List
+    elements map[int64]*list.Element
+
+    // Bumped by every write, so a miss doesn't cache what a write
+    // replaced while it was read
+    generation uint64
+
+    hits atomic.Int64
+    misses atomic.Int64
+    evictions atomic.Int64
+}
+
+/********************************************************************
+newCachedStore()
+    Wraps a store with a least recently used cache of up to capacity
+    records.
+********************************************************************/
+func newCachedStore( store Store, capacity int ) *cachedStore {
+    return &cachedStore{ store: store, capacity: capacity, order: list.New(), elements: make(map[int64]*list.Element) }
+}
+
+/********************************************************************
+cacheRecord()
+    Keeps a record as the most recent, evicting the least recent ones
+    past the capacity. Must be called with mutex held.
+********************************************************************/
+func ( c *cachedStore ) cacheRecord( record *Record ) {
+    if element := c.elements[ record.Id ]; element != nil {
+        element.Value = record
+        c.order.MoveToFront( element )
+        return
+    }
+    c.elements[ record.Id ] = c.order.PushFront( record )
+    for c.order.Len() > c.capacity {
+        oldest := c.order.Back()
+        c.order.Remove( oldest )
+        delete( c.elements, oldest.Value.( *Record ).Id )
+        c.evictions.Add( 1 )
+    }
+}
+
+/********************************************************************
+forget()
+    Drops the record of an id from the cache. Must be called with
+    mutex held.
+********************************************************************/
+func ( c *cachedStore ) forget( id int64 ) {
+    if element := c.elements[ id ]; element != nil {
+        c.order.Remove( element )
+        delete( c.elements, id )
+    }
+}
+
+func ( c *cachedStore ) Put( record *Record ) error {
+    c.mutex.Lock()
+    c.generation++
+    c.forget( record.Id )
+    c.mutex.Unlock()
+
+    if err := c.store.Put( record ); err != nil {
+        return err
+    }
+
+    c.mutex.Lock()
+    c.generation++
+    c.cacheRecord( record )
+    c.mutex.Unlock()
+    return nil
+}
+
+func ( c *cachedStore ) Get( id int64 ) ( *Record, error ) {
+    c.mutex.Lock()
+    if element := c.elements[ id ]; element != nil {
+        c.order.MoveToFront( element )
+        c.mutex.Unlock()
+        c.hits.Add( 1 )
+        return element.Value.( *Record ), nil
+    }
+    generation := c.generation
+    c.mutex.Unlock()
+    c.misses.Add( 1 )
+
+    record, err := c.store.Get( id )
+    if err != nil {
+        return nil, err
+    }
+    c.mutex.Lock()
+    if c.generation == generation {
+        c.cacheRecord( record )
+    }
+    c.mutex.Unlock()
+    return record, nil
+}
+
+func ( c *cachedStore ) Delete( id int64 ) error {
+    c.mutex.Lock()
+    c.generation++
+    c.forget( id )
+    c.mutex.Unlock()
+    return c.store.Delete( id )
+}
+
+func ( c *cachedStore ) List() ( []*Record, error ) {
+    return c.store.List()
+}
+
+func ( c *cachedStore ) Count() ( int, error ) {
+    return c.store.Count()
+}
+
+func ( c *cachedStore ) LastId() ( int64, error ) {
+    if sequence, ok := c.store.( SequenceStore ); ok {
+        return sequence.LastId()
+    }
+    return 0, nil
+}
+
+func ( c *cachedStore ) SetLastId( id int64 ) error {
+    if sequence, ok := c.store.( SequenceStore ); ok {
+        return sequence.SetLastId( id )
+    }
+    return nil
+}
+
+func ( c *cachedStore ) Ping() error {
+    if pinger, ok := c.store.( PingStore ); ok {
+        return pinger.Ping()
+    }
+    _, err := c.store.Count()
+    return err
+}
+
+/********************************************************************
+stats()
+    Returns the reads of the cache since it was created or reset.
+********************************************************************/
+func ( c *cachedStore ) stats() *CacheStat {
+    stat := &CacheStat{ Hits: c.hits.Load(), Misses: c.misses.Load(), Evictions: c.evictions.Load(), Capacity: c.capacity }
+    if reads := stat.Hits + stat.Misses; reads > 0 {
+        stat.HitRatio = float64( stat.Hits ) / float64( reads )
+    }
+    c.mutex.Lock()
+    stat.Records = c.order.Len()
+    c.mutex.Unlock()
+    return stat
+}
+
+/********************************************************************
+resetStats()
+    Zeroes the hit, miss and eviction counts.
+********************************************************************/
+func ( c *cachedStore ) resetStats() {
+    c.hits.Store( 0 )
+    c.misses.Store( 0 )
+    c.evictions.Store( 0 )
+}
+
+/********************************************************************
+durableStore()
+    Returns the persistent store under the record cache and the
+    encryption, the one whose files compaction rewrites, the new one
+    during a migration.
+********************************************************************/
+func ( s *Server ) durableStore() Store {
+    store := s.store
+    if cached, ok := store.( *cachedStore ); ok {
+        store = cached.store
+    }
+    if encrypted, ok := store.( *encryptedStore ); ok {
+        store = encrypted.store
+    }
+    if migration, ok := store.( *migratingStore ); ok {
+        store = migration.to
+    }
+    return store
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+    "context"
+    "crypto/rand"
+    "net/http"
+
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/propagation"
+    semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// Instrumentation name of the server's spans
+const tracerName = "jumpcloud_password_hash/server"
+
+// Attribute holding the password id of a job span
+const jobIdKey = attribute.Key( "hash.id" )
+
+/********************************************************************
+WithTracerProvider()
+    Sets the OpenTelemetry tracer provider spans are created with,
+    the global one by default, which doesn't record anything unless
+    the program sets it up.
+********************************************************************/
+func WithTracerProvider( provider trace.TracerProvider ) Option {
+    return func( s *Server ) {
+        s.tracer = provider.Tracer( tracerName )
+    }
+}
+
+/********************************************************************
+WithPropagator()
+    Sets how trace context is read from request headers and written
+    to webhook callbacks, W3C traceparent and tracestate by default.
+********************************************************************/
+func WithPropagator( propagator propagation.TextMapPropagator ) Option {
+    return func( s *Server ) {
+        s.propagator = propagator
+    }
+}
+
+/********************************************************************
+withTracing()
+    Middleware creating a server span for every request, continuing
+    the trace of the request headers. The span is named after the
+    route the request matches and ends once the response is written.
+    The trace id is echoed in the X-Trace-Id header.
+********************************************************************/
+func ( s *Server ) withTracing( next http.HandlerFunc ) http.HandlerFunc {
+    return func( w http.ResponseWriter, r *http.Request ) {
+        _, pattern := s.mux.Handler( r )
+        name := r.Method + " " + unmatchedEndpoint
+        if pattern != "" {
+            name = pattern
+        }
+
+        ctx := s.propagator.Extract( r.Context(), propagation.HeaderCarrier( r.Header ) )
+        if !trace.SpanContextFromContext( ctx ).IsValid() {
+            // Start a trace even without a tracing backend, so the
+            // logs, response and webhooks of the request can still be
+            // correlated by its trace id
+            ctx = trace.ContextWithRemoteSpanContext( ctx, newSpanContext() )
+        }
+        ctx, span := s.tracer.Start( ctx, name,
+            trace.WithSpanKind( trace.SpanKindServer ),
+            trace.WithAttributes(
+                semconv.HTTPRequestMethodKey.String( r.Method ),
+                semconv.URLPath( r.URL.Path ),
+                semconv.UserAgentOriginal( r.UserAgent() ),
+            ),
+        )
+        defer span.End()
+        if pattern != "" {
+            span.SetAttributes( semconv.HTTPRoute( pattern[ len( r.Method ) + 1: ] ) )
+        }
+
+        w.Header().Set( "X-Trace-Id", span.SpanContext().TraceID().String() )
+
+        recorder := &statusRecorder{ ResponseWriter: w }
+        next( recorder, r.WithContext( ctx ) )
+
+        if recorder.status == 0 {
+            recorder.status = http.StatusOK
+        }
+        span.SetAttributes( semconv.HTTPResponseStatusCode( recorder.status ) )
+        if recorder.status >= http.StatusInternalServerError {
+            span.SetStatus( codes.Error, http.StatusText( recorder.status ) )
+        }
+    }
+}
+
+/********************************************************************
+newSpanContext()
+    Returns a sampled span context with random trace and span ids.
+********************************************************************/
+func newSpanContext() trace.SpanContext {
+    var traceId trace.TraceID
+    var spanId trace.SpanID
+    rand.Read( traceId[ : ] )
+    rand.Read( spanId[ : ] )
+    return trace.NewSpanContext( trace.SpanContextConfig{
+        TraceID: traceId,
+        SpanID: spanId,
+        TraceFlags: trace.FlagsSampled,
+    } )
+}
+
+/********************************************************************
+startJobSpan()
+    Starts the span of a queued job, covering its delay and hashing.
+    The job runs after the request has been answered, so its span
+    starts a trace of its own, linked to the span of the request.
+********************************************************************/
+func ( s *Server ) startJobSpan( ctx context.Context, job *hashJob ) {
+    job.traceContext = trace.SpanContextFromContext( ctx )
+    _, job.span = s.tracer.Start( context.Background(), "hash password",
+        trace.WithLinks( trace.LinkFromContext( ctx ) ),
+        trace.WithAttributes( jobIdKey.Int64( job.id ) ),
+    )
+}
+
@@ -0,0 +1,107 @@
+package server
+
+import (
+    "context"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+)
+
+// Key source reading a hex encoded master key from a field of a
+// HashiCorp Vault secret, created by NewVaultKeySource()
+type VaultKeySource struct {
+    client *http.Client
+    address string
+    token string
+    path string
+    field string
+}
+
+/********************************************************************
+NewVaultKeySource()
+    Creates a key source reading a field of the secret at a path of a
+    Vault server, e.g. "secret/data/hashsvc" for the KV version 2
+    engine mounted at "secret", authenticated with a token.
+********************************************************************/
+func NewVaultKeySource( address string, token string, path string, field string ) *VaultKeySource {
+    return &VaultKeySource{
+        client: &http.Client{},
+        address: strings.TrimSuffix( address, "/" ),
+        token: token,
+        path: strings.Trim( path, "/" ),
+        field: field,
+    }
+}
+
+/********************************************************************
+FetchKey()
+    Renews the token, if it is renewable, so refreshing the key keeps
+    it alive, then reads the key from the secret.
+********************************************************************/
+func ( v *VaultKeySource ) FetchKey( ctx context.Context ) ( []byte, error ) {
+    var token struct {
+        Data struct {
+            Renewable bool `json:"renewable"`
+        } `json:"data"`
+    }
+    if err := v.call( ctx, http.MethodGet, "auth/token/lookup-self", &token ); err != nil {
+        return nil, err
+    }
+    if token.Data.Renewable {
+        if err := v.call( ctx, http.MethodPost, "auth/token/renew-self", nil ); err != nil {
+            return nil, err
+        }
+    }
+
+    // KV version 2 nests the fields of the secret under data
+    var secret struct {
+        Data map[string]json.RawMessage `json:"data"`
+    }
+    if err := v.call( ctx, http.MethodGet, v.path, &secret ); err != nil {
+        return nil, err
+    }
+    fields := secret.Data
+    if nested, ok := fields[ "data" ]; ok && fields[ "metadata" ] != nil {
+        fields = nil
+        if err := json.Unmarshal( nested, &fields ); err != nil {
+            return nil, err
+        }
+    }
+    var value string
+    if err := json.Unmarshal( fields[ v.field ], &value ); err != nil || value == "" {
+        return nil, fmt.Errorf( "vault secret %s has no field %q", v.path, v.field )
+    }
+    return hex.DecodeString( value )
+}
+
+/********************************************************************
+call()
+    Calls the Vault HTTP API, decoding the response into result
+    unless it is nil.
+********************************************************************/
+func ( v *VaultKeySource ) call( ctx context.Context, method string, path string, result any ) error {
+    request, err := http.NewRequestWithContext( ctx, method, v.address + "/v1/" + path, nil )
+    if err != nil {
+        return err
+    }
+    request.Header.Set( "X-Vault-Token", v.token )
+    response, err := v.client.Do( request )
+    if err != nil {
+        return err
+    }
+    defer response.Body.Close()
+
+    if response.StatusCode / 100 != 2 {
+        var failure struct {
+            Errors []string `json:"errors"`
+        }
+        json.NewDecoder( response.Body ).Decode( &failure )
+        return fmt.Errorf( "vault %s %s: %s %s", method, path, response.Status, strings.Join( failure.Errors, ", " ) )
+    }
+    if result == nil {
+        return nil
+    }
+    return json.NewDecoder( response.Body ).Decode( result )
+}
@@ -0,0 +1,351 @@
+package server
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "sort"
+    "time"
+)
+
+// Operations of the write-ahead log. A record soft deleted or undeleted
+// is logged as a completion of the record as it is stored, and one
+// purged with a purged marker. Compaction replaces the completions of
+// records expired or evicted since with a marker, and keeps the last
+// id with a sequence entry if no other has it
+const (
+    walSubmit = "submit"
+    walComplete = "complete"
+    walExpired = "expired"
+    walEvicted = "evicted"
+    walPurged = "purged"
+    walSequence = "sequence"
+)
+
+// Entry of the write-ahead log. A submission carries the hash rather
+// than the password, computed as the job is queued, so no password is
+// ever written to disk, and in deduplication mode its SHA512 digest. A
+// completion carries the stored record. With Config.EncryptionKey the
+// hash, the digest and the record are sealed
+type walEntry struct {
+    Op string `json:"op"`
+    Id int64 `json:"id"`
+
+    Hash string `json:"hash,omitempty"`
+    Sealed bool `json:"sealed,omitempty"`
+    Algorithm string `json:"algorithm,omitempty"`
+    Labels map[string]string `json:"labels,omitempty"`
+    SubmittedAt *time.Time `json:"submitted_at,omitempty"`
+    CompleteBy *time.Time `json:"complete_by,omitempty"`
+    Ttl time.Duration `json:"ttl,omitempty"`
+    Provenance *Provenance `json:"provenance,omitempty"`
+    CallbackURL string `json:"callback_url,omitempty"`
+    Digest string `json:"digest,omitempty"`
+
+    Record json.RawMessage `json:"record,omitempty"`
+}
+
+/********************************************************************
+loadWAL()
+    Replays Config.WALFile, if it exists, and opens it for appending.
+    Completed records are put back in the store, expired ones counted
+    as expired, and submissions without a completion are queued again
+    to finish their delay, which runs from when they were submitted.
+    A torn last line, from a crash while it was written, is cut off.
+********************************************************************/
+func ( s *Server ) loadWAL() error {
+    file, err := os.Open( s.config.WALFile )
+    if err != nil && !errors.Is( err, fs.ErrNotExist ) {
+        return err
+    }
+
+    pending := make(map[int64]walEntry)
+    completed := 0
+    if err == nil {
+        scanner := bufio.NewScanner( file )
+        scanner.Buffer( nil, 1024 * 1024 )
+        var torn error
+        var size int64
+        for line := 1; scanner.Scan(); line++ {
+            if torn != nil {
+                file.Close()
+                return torn
+            }
+            if len( scanner.Bytes() ) == 0 {
+                size++
+                continue
+            }
+            var entry walEntry
+            if err := json.Unmarshal( scanner.Bytes(), &entry ); err != nil {
+                torn = fmt.Errorf( "line %d: %w", line, err )
+                continue
+            }
+            size += int64( len( scanner.Bytes() ) ) + 1
+            if entry.Id > s.lastId {
+                s.lastId = entry.Id
+            }
+
+            switch entry.Op {
+            case walSubmit:
+                if entry.Sealed {
+                    if err := s.openSubmission( &entry ); err != nil {
+                        file.Close()
+                        return fmt.Errorf( "line %d: %w", line, err )
+                    }
+                }
+                pending[ entry.Id ] = entry
+            case walComplete:
+                delete( pending, entry.Id )
+                if err := s.replayRecord( entry.Record ); err != nil {
+                    file.Close()
+                    return fmt.Errorf( "line %d: %w", line, err )
+                }
+                completed++
+            case walExpired:
+                delete( pending, entry.Id )
+                s.expiredIds.add( entry.Id )
+            case walEvicted:
+                delete( pending, entry.Id )
+                s.evictedIds.add( entry.Id )
+            case walPurged:
+                delete( pending, entry.Id )
+                if err := s.forgetPurged( entry.Id ); err != nil {
+                    file.Close()
+                    return fmt.Errorf( "line %d: %w", line, err )
+                }
+            }
+        }
+        file.Close()
+        if err := scanner.Err(); err != nil {
+            return err
+        }
+        if torn != nil {
+            s.logger.Warn( "Cutting off torn write-ahead log entry", "error", torn )
+            if err := os.Truncate( s.config.WALFile, size ); err != nil {
+                return err
+            }
+        }
+    }
+
+    if sequence, ok := s.store.( SequenceStore ); ok && completed + len( pending ) > 0 {
+        if err := sequence.SetLastId( s.lastId ); err != nil {
+            return err
+        }
+    }
+
+    s.walFile, err = os.OpenFile( s.config.WALFile, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0600 )
+    if err != nil {
+        return err
+    }
+
+    // Queue the unfinished submissions again, in id order, now that
+    // their completions can be logged
+    ids := make([]int64, 0, len( pending ))
+    for id := range pending {
+        ids = append( ids, id )
+    }
+    sort.Slice( ids, func( i, j int ) bool { return ids[ i ] < ids[ j ] } )
+    for _, id := range ids {
+        entry := pending[ id ]
+        job := &hashJob{
+            id: id,
+            hash: entry.Hash,
+            algorithm: entry.Algorithm,
+            labels: entry.Labels,
+            ttl: entry.Ttl,
+            provenance: entry.Provenance,
+            callbackURL: entry.CallbackURL,
+            digest: entry.Digest,
+        }
+        if entry.SubmittedAt != nil {
+            job.startTime = *entry.SubmittedAt
+        }
+        if entry.CompleteBy != nil {
+            job.completeBy = *entry.CompleteBy
+        }
+        if _, taken := s.digestIds[ job.digest ]; s.config.Deduplicate && job.digest != "" && !taken {
+            s.digestIds[ job.digest ] = id
+        }
+        s.scheduleJob( context.Background(), job )
+    }
+
+    if completed + len( pending ) > 0 {
+        s.logger.Info( "Replayed write-ahead log!", "completed", completed, "resumed", len( pending ), "last_id", s.lastId )
+    }
+    return nil
+}
+
+/********************************************************************
+replayRecord()
+    Puts a record saved before a restart, in the write-ahead log or a
+    snapshot, back in the store, or
+    counts it as expired if its ttl has elapsed since.
+********************************************************************/
+func ( s *Server ) replayRecord( data json.RawMessage ) error {
+    record, err := s.decodeDurable( data )
+    if err != nil {
+        return err
+    }
+    if err := verifyRecord( record ); err != nil {
+        return err
+    }
+    return s.restoreRecord( record )
+}
+
+/********************************************************************
+restoreRecord()
+    Stores a record hashed earlier, or by another server, or counts
+    it as expired if its ttl or the retention period has elapsed. A
+    soft deleted record is purged once its grace period is over, as
+    if it was stored then.
+********************************************************************/
+func ( s *Server ) restoreRecord( record *Record ) error {
+    if s.recordExpired( record, s.clock.Now() ) {
+        s.expiredIds.add( record.Id )
+        delete( s.expiresAt, record.Id )
+        s.untrackRecord( record.Id )
+        return s.store.Delete( record.Id )
+    }
+
+    if err := s.store.Put( record ); err != nil {
+        return err
+    }
+    if expiry := s.expiryOf( record ); expiry != nil {
+        s.expiresAt[ record.Id ] = *expiry
+    }
+    if record.DeletedAt != nil {
+        s.deletedAt[ record.Id ] = *record.DeletedAt
+    } else {
+        delete( s.deletedAt, record.Id )
+        s.rememberDigest( record )
+    }
+    s.evictedIds.remove( record.Id )
+    s.purgedIds.remove( record.Id )
+    return s.trackRecord( record.Id )
+}
+
+/********************************************************************
+logSubmission()
+    Hashes a job's password up front and appends its submission to
+    the write-ahead log, so it is finished after a crash. Returns an
+    error if the submission couldn't be encrypted or made durable.
+********************************************************************/
+func ( s *Server ) logSubmission( job *hashJob ) error {
+    job.hash = s.hasher.Hash( string( job.password ) )
+    job.algorithm = s.hasher.Algorithm()
+
+    entry := walEntry{
+        Op: walSubmit,
+        Id: job.id,
+        Hash: job.hash,
+        Algorithm: job.algorithm,
+        Labels: job.labels,
+        SubmittedAt: &job.startTime,
+        Ttl: job.ttl,
+        Provenance: job.provenance,
+        CallbackURL: job.callbackURL,
+        Digest: job.digest,
+    }
+    if !job.completeBy.IsZero() {
+        entry.CompleteBy = &job.completeBy
+    }
+    if s.recordCipher != nil {
+        sealed, err := s.recordCipher.seal( job.id, []byte( job.hash ) )
+        if err != nil {
+            return fmt.Errorf( "encrypting submission %d for the write-ahead log: %w", job.id, err )
+        }
+        entry.Hash, entry.Sealed = sealed, true
+        if job.digest != "" {
+            if entry.Digest, err = s.recordCipher.seal( job.id, []byte( job.digest ) ); err != nil {
+                return fmt.Errorf( "encrypting submission %d for the write-ahead log: %w", job.id, err )
+            }
+        }
+    }
+    return s.appendWAL( entry )
+}
+
+/********************************************************************
+openSubmission()
+    Decrypts the hash and digest of a submission sealed by
+    logSubmission().
+********************************************************************/
+func ( s *Server ) openSubmission( entry *walEntry ) error {
+    if s.recordCipher == nil {
+        return fmt.Errorf( "submission %d is encrypted, the encryption key is needed to read it", entry.Id )
+    }
+    hash, err := s.recordCipher.open( entry.Id, entry.Hash )
+    if err != nil {
+        return err
+    }
+    entry.Hash, entry.Sealed = string( hash ), false
+    if entry.Digest != "" {
+        digest, err := s.recordCipher.open( entry.Id, entry.Digest )
+        if err != nil {
+            return err
+        }
+        entry.Digest = string( digest )
+    }
+    return nil
+}
+
+/********************************************************************
+logCompletion()
+    Appends a stored record to the write-ahead log, completing its
+    submission. Failing to is logged as an error, the submission is
+    then finished again on replay.
+********************************************************************/
+func ( s *Server ) logCompletion( record *Record ) {
+    data, err := s.encodeDurable( record )
+    if err != nil {
+        s.logError( "Unable to encode record %d for the write-ahead log: %v", record.Id, err )
+        return
+    }
+    if err := s.appendWAL( walEntry{ Op: walComplete, Id: record.Id, Record: data } ); err != nil {
+        s.logError( "%v", err )
+    }
+}
+
+/********************************************************************
+appendWAL()
+    Appends an entry to the write-ahead log and syncs it before
+    returning, or returns the error failing to. Entries are dropped
+    once the log has been closed on shutdown.
+********************************************************************/
+func ( s *Server ) appendWAL( entry walEntry ) error {
+    s.walMutex.Lock()
+    defer s.walMutex.Unlock()
+
+    if s.walFile == nil {
+        return nil
+    }
+    data, err := json.Marshal( entry )
+    if err != nil {
+        return fmt.Errorf( "encoding write-ahead log entry %s %d: %w", entry.Op, entry.Id, err )
+    }
+    if _, err := s.walFile.Write( append( data, '\n' ) ); err != nil {
+        return fmt.Errorf( "writing write-ahead log entry %s %d: %w", entry.Op, entry.Id, err )
+    }
+    if err := s.walFile.Sync(); err != nil {
+        return fmt.Errorf( "syncing write-ahead log entry %s %d: %w", entry.Op, entry.Id, err )
+    }
+    return nil
+}
+
+/********************************************************************
+closeWAL()
+    Closes Config.WALFile on shutdown. Jobs still pending are replayed
+    on the next start.
+********************************************************************/
+func ( s *Server ) closeWAL() {
+    s.walMutex.Lock()
+    defer s.walMutex.Unlock()
+
+    if s.walFile != nil {
+        s.walFile.Close()
+        s.walFile = nil
+    }
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// A submission the write-ahead log fails to take is refused with 503
+// rather than queued, as a crash would lose it
+func TestWALFailureRefusesSubmission( t *testing.T ) {
+    config := DefaultConfig()
+    config.WALFile = filepath.Join( t.TempDir(), "wal.log" )
+    s, handler := newTestServer( t, 0, WithConfig( config ) )
+
+    // Writes to a closed file fail, like those to a full disk
+    s.walMutex.Lock()
+    s.walFile.Close()
+    s.walMutex.Unlock()
+
+    request := httptest.NewRequest( http.MethodPost, "/v1/hash", strings.NewReader( url.Values{ "password": { "angryMonkey" } }.Encode() ) )
+    request.Header.Set( "Content-Type", "application/x-www-form-urlencoded" )
+    request.Header.Set( "Idempotency-Key", "retry-me" )
+    response := httptest.NewRecorder()
+    handler.ServeHTTP( response, request )
+
+    if response.Code != http.StatusServiceUnavailable {
+        t.Errorf( "POST /v1/hash: got %d %q, want 503", response.Code, response.Body )
+    }
+    s.mapMutex.Lock()
+    pending := len( s.pendingJobs )
+    s.mapMutex.Unlock()
+    if pending != 0 {
+        t.Errorf( "got %d pending jobs, want none", pending )
+    }
+    s.idempotencyMutex.Lock()
+    _, kept := s.idempotencyKeys[ "retry-me" ]
+    s.idempotencyMutex.Unlock()
+    if kept {
+        t.Error( "the Idempotency-Key still replays the refused submission" )
+    }
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+var (
+    // Watch info
+    watchDefaultTimeout = 30 * time.Second
+    watchMaxTimeout = 5 * time.Minute
+)
+
+/********************************************************************
+notifyCompleted()
+    Wakes up every goroutine waiting on pwdCompleted by closing the
+    channel and replacing it with a fresh one.
+    Must be called with pwdMutexMap held.
+********************************************************************/
+func ( s *Server ) notifyCompleted() {
+    close( s.completed )
+    s.completed = make(chan struct{})
+}
+
+/********************************************************************
+handleHashWatch()
+    Handles GET requests on /hash/watch?ids=1,2,3 as a long-poll, of
+    up to bulkMaxIds ids. Responds as soon as any of the listed ids
+    has been hashed with the completed records as JSON. If none
+    complete within the timeout (optional "timeout" parameter, e.g.
+    10s) it responds with 204 No Content so the client can poll
+    again.
+********************************************************************/
+func ( s *Server ) handleHashWatch( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /hash/watch" )
+
+    // Check shutdown
+    if s.shutDown {
+        s.log( r ).Info( "Server has been shut down!" )
+        writeError( w, http.StatusNotAcceptable, ErrorShuttingDown )
+        return
+    }
+
+    ids, err := parseIds( r.URL.Query().Get( "ids" ) )
+    if err != nil {
+        s.log( r ).Info( "Invalid ids", "error", err )
+        writeFieldErrors( w, invalidField( "ids", err.Error() ) )
+        return
+    }
+    if len( ids ) > bulkMaxIds {
+        s.log( r ).Info( "Too many ids to watch", "ids", len( ids ), "max", bulkMaxIds )
+        writeError( w, http.StatusBadRequest, ErrorInvalidRequest )
+        return
+    }
+
+    timeout := watchDefaultTimeout
+    if value := r.URL.Query().Get( "timeout" ); value != "" {
+        timeout, err = time.ParseDuration( value )
+        if err != nil || timeout <= 0 || timeout > watchMaxTimeout {
+            s.log( r ).Info( "Invalid watch timeout!" )
+            writeFieldErrors( w, invalidField( "timeout", fmt.Sprintf( "invalid timeout %q, must be between 0 and %s", value, watchMaxTimeout ) ) )
+            return
+        }
+    }
+
+    // Don't hold the shutdown mutex while waiting, otherwise a watch
+    // would block shutdown for up to the whole timeout
+    timer := time.NewTimer( timeout )
+    defer timer.Stop()
+
+    // Ids still pending, only those that stopped being since the last
+    // wake up are read from the store, without holding mapMutex
+    pending := make(map[int64]bool, len( ids ))
+    for _, id := range ids {
+        pending[ id ] = true
+    }
+    for {
+        s.mapMutex.Lock()
+        settled := []int64{}
+        for id := range pending {
+            if s.pendingJobs[ id ] == nil {
+                settled = append( settled, id )
+                delete( pending, id )
+            }
+        }
+        completed := s.completed
+        s.mapMutex.Unlock()
+
+        records := []*Record{}
+        for _, id := range settled {
+            record, err := s.getRecord( id )
+            if err != nil {
+                s.writeStoreError( w, r, err )
+                return
+            }
+            if record != nil {
+                records = append( records, record )
+            }
+        }
+
+        if len( records ) > 0 {
+            sort.Slice( records, func( i, j int ) bool { return records[ i ].Id < records[ j ].Id } )
+            s.writeEncoded( w, r, http.StatusOK, records )
+            return
+        }
+
+        select {
+        case <-completed:
+        case <-timer.C:
+            w.WriteHeader( http.StatusNoContent )
+            return
+        case <-s.shutdownStarted:
+            w.WriteHeader( http.StatusNoContent )
+            return
+        case <-r.Context().Done():
+            return
+        }
+    }
+}
+
+/********************************************************************
+waitForHash()
+    Blocks until the password with the given id is no longer pending
+    or the wait elapses. Returns false if the request was cancelled.
+********************************************************************/
+func ( s *Server ) waitForHash( ctx context.Context, id int64, wait time.Duration ) bool {
+    timer := time.NewTimer( wait )
+    defer timer.Stop()
+
+    for {
+        s.mapMutex.Lock()
+        pending := s.pendingJobs[ id ] != nil
+        completed := s.completed
+        s.mapMutex.Unlock()
+
+        if !pending {
+            return true
+        }
+
+        select {
+        case <-completed:
+        case <-timer.C:
+            return true
+        case <-s.shutdownStarted:
+            return true
+        case <-ctx.Done():
+            return false
+        }
+    }
+}
+
+/********************************************************************
+parseIds()
+    Parses a comma separated list of password ids.
+********************************************************************/
+func parseIds( value string ) ( []int64, error ) {
+    if value == "" {
+        return nil, fmt.Errorf( "missing ids" )
+    }
+
+    ids := []int64{}
+    for _, part := range strings.Split( value, "," ) {
+        id, err := strconv.ParseInt( strings.TrimSpace( part ), 10, 64 )
+        if err != nil {
+            return nil, fmt.Errorf( "invalid id %q", part )
+        }
+        ids = append( ids, id )
+    }
+
+    return ids, nil
+}
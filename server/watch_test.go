@@ -0,0 +1,35 @@
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+)
+
+// A watch answers the record of the id that completed, and refuses more
+// than bulkMaxIds ids
+func TestHashWatch( t *testing.T ) {
+    _, handler := newTestServer( t, 100 * time.Millisecond )
+
+    id := postPassword( t, handler, "angryMonkey" )
+    response := httptest.NewRecorder()
+    handler.ServeHTTP( response, httptest.NewRequest( http.MethodGet, "/v1/hash/watch?timeout=5s&ids=" + strconv.FormatInt( id, 10 ) + "," + strconv.FormatInt( id + 1, 10 ), nil ) )
+    var records []Record
+    if err := json.Unmarshal( response.Body.Bytes(), &records ); response.Code != http.StatusOK || err != nil {
+        t.Fatalf( "watch: got %d %q, want 200 with the record", response.Code, response.Body )
+    }
+    if len( records ) != 1 || records[ 0 ].Id != id || records[ 0 ].Hash != hashPassword( "angryMonkey" ) {
+        t.Errorf( "watch: got %+v, want the record of %d", records, id )
+    }
+
+    ids := strings.TrimSuffix( strings.Repeat( "1,", bulkMaxIds + 1 ), "," )
+    response = httptest.NewRecorder()
+    handler.ServeHTTP( response, httptest.NewRequest( http.MethodGet, "/v1/hash/watch?ids=" + ids, nil ) )
+    if response.Code != http.StatusBadRequest {
+        t.Errorf( "watching %d ids: got %d, want 400", bulkMaxIds + 1, response.Code )
+    }
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net"
+    "net/http"
+    "net/netip"
+    "net/url"
+    "syscall"
+    "time"
+
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// Webhook payload POSTed to a job's callback_url
+type WebhookPayload struct {
+    Id int64 `json:"id"`
+    Hash string `json:"hash"`
+    CompletedAt time.Time `json:"completed_at"`
+}
+
+// Webhook delivery counters, reported in /stats
+type WebhookStat struct {
+    Delivered int64 `json:"delivered"`
+    Retries int64 `json:"retries"`
+    DeadLettered int64 `json:"dead_lettered"`
+}
+
+// Callback that could not be delivered after all retries
+type DeadLetter struct {
+    Id int64 `json:"id"`
+    CallbackURL string `json:"callback_url"`
+    Attempts int `json:"attempts"`
+    LastError string `json:"last_error"`
+    FailedAt time.Time `json:"failed_at"`
+    RequestId string `json:"request_id,omitempty"`
+}
+
+var (
+    // Webhook info
+    webhookTimeout = 10 * time.Second
+    webhookMaxAttempts = 5
+    webhookInitialBackoff = 1 * time.Second
+    webhookMaxDeadLetters = 1000
+
+    // Carrier-grade NAT range, private though netip doesn't say so
+    webhookSharedRange = netip.MustParsePrefix( "100.64.0.0/10" )
+)
+
+/********************************************************************
+newWebhookClient()
+    Returns the client delivering the webhooks. Unless allowPrivate,
+    it refuses to connect to loopback, private, link-local (like the
+    169.254.169.254 cloud metadata service), unspecified and
+    multicast addresses, checked on the address dialed so a host name
+    resolving to one, even after the callback_url was accepted, is
+    refused too. Proxies aren't used, as they would dial instead.
+********************************************************************/
+func newWebhookClient( allowPrivate bool ) *http.Client {
+    dialer := &net.Dialer{ Timeout: webhookTimeout }
+    if !allowPrivate {
+        dialer.Control = func( network string, address string, conn syscall.RawConn ) error {
+            return checkWebhookAddress( address )
+        }
+    }
+    transport := http.DefaultTransport.( *http.Transport ).Clone()
+    transport.Proxy = nil
+    transport.DialContext = dialer.DialContext
+    return &http.Client{ Timeout: webhookTimeout, Transport: transport }
+}
+
+/********************************************************************
+checkWebhookAddress()
+    Returns an error if a dialed host:port is an address webhooks
+    must not reach.
+********************************************************************/
+func checkWebhookAddress( address string ) error {
+    addrPort, err := netip.ParseAddrPort( address )
+    if err != nil {
+        return fmt.Errorf( "webhook address %q: %w", address, err )
+    }
+    addr := addrPort.Addr().Unmap()
+    if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+        addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() || webhookSharedRange.Contains( addr ) {
+        return fmt.Errorf( "webhook address %s is not public", addr )
+    }
+    return nil
+}
+
+/********************************************************************
+parseCallbackURL()
+    Validates the optional "callback_url" form field, which must be
+    an absolute http or https URL.
+********************************************************************/
+func parseCallbackURL( value string ) ( string, error ) {
+    if value == "" {
+        return "", nil
+    }
+
+    callbackURL, err := url.Parse( value )
+    if err != nil || ( callbackURL.Scheme != "http" && callbackURL.Scheme != "https" ) || callbackURL.Host == "" {
+        return "", fmt.Errorf( "invalid callback_url %q", value )
+    }
+
+    return callbackURL.String(), nil
+}
+
+/********************************************************************
+deliverWebhook()
+    POSTs the completed record to its callback URL, retrying with
+    exponential backoff on the server's clock. Callbacks that still
+    fail after webhookMaxAttempts are added to the dead-letter list.
+    A shutdown abandons the delivery, cancelling the attempt in
+    progress, and Shutdown() waits for it to return. Callbacks carry
+    the trace context and request id of the request submitting the
+    password.
+********************************************************************/
+func ( s *Server ) deliverWebhook( job *hashJob, record *Record ) {
+    defer s.webhookGroup.Done()
+
+    ctx, cancel := context.WithCancel( trace.ContextWithRemoteSpanContext( context.Background(), job.traceContext ) )
+    defer cancel()
+    go func() {
+        select {
+        case <-s.shutdownStarted:
+            cancel()
+        case <-ctx.Done():
+        }
+    }()
+
+    requestId := job.provenance.RequestId
+    body, _ := json.Marshal( WebhookPayload{ Id: record.Id, Hash: record.Hash, CompletedAt: record.CompletedAt } )
+
+    backoff := webhookInitialBackoff
+    var lastErr error
+    for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+        if attempt > 1 {
+            if !s.sleep( backoff, ctx.Done() ) {
+                s.logErrorTo( job.logger, "Webhook for id %d abandoned after %d attempts, shutting down: %v", record.Id, attempt - 1, lastErr )
+                return
+            }
+            backoff *= 2
+            s.webhookMutex.Lock()
+            s.webhookStats.Retries++
+            s.webhookMutex.Unlock()
+        }
+
+        lastErr = s.postWebhook( ctx, job.callbackURL, body, requestId )
+        if lastErr == nil {
+            s.webhookMutex.Lock()
+            s.webhookStats.Delivered++
+            s.webhookMutex.Unlock()
+            return
+        }
+        if ctx.Err() != nil {
+            s.logErrorTo( job.logger, "Webhook for id %d abandoned after %d attempts, shutting down: %v", record.Id, attempt, lastErr )
+            return
+        }
+        s.logErrorTo( job.logger, "Webhook for id %d failed, attempt %d: %v", record.Id, attempt, lastErr )
+    }
+
+    s.webhookMutex.Lock()
+    defer s.webhookMutex.Unlock()
+    s.webhookStats.DeadLettered++
+    s.webhookDeadLetters = append( s.webhookDeadLetters, DeadLetter{
+        Id: record.Id,
+        CallbackURL: job.callbackURL,
+        Attempts: webhookMaxAttempts,
+        LastError: lastErr.Error(),
+        FailedAt: s.clock.Now(),
+        RequestId: requestId,
+    } )
+    if len( s.webhookDeadLetters ) > webhookMaxDeadLetters {
+        s.webhookDeadLetters = s.webhookDeadLetters[ len( s.webhookDeadLetters ) - webhookMaxDeadLetters: ]
+    }
+}
+
+/********************************************************************
+postWebhook()
+    Makes a single webhook delivery attempt, any non-2xx response
+    counts as a failure. The body is signed with a detached JWS in
+    the X-Webhook-Signature header, verifiable against the JWKS, and
+    X-Request-ID is the id of the submitting request.
+********************************************************************/
+func ( s *Server ) postWebhook( ctx context.Context, callbackURL string, body []byte, requestId string ) error {
+    request, err := http.NewRequestWithContext( ctx, http.MethodPost, callbackURL, bytes.NewReader( body ) )
+    if err != nil {
+        return err
+    }
+    s.propagator.Inject( ctx, propagation.HeaderCarrier( request.Header ) )
+    request.Header.Set( "Content-Type", "application/json" )
+    request.Header.Set( "X-Request-ID", requestId )
+    request.Header.Set( "X-Webhook-Signature", s.signDetached( body ) )
+
+    resp, err := s.webhookClient.Do( request )
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf( "callback returned %s", resp.Status )
+    }
+    return nil
+}
+
+/********************************************************************
+webhookStatsSnapshot()
+    Returns the webhook counters, or nil if no webhooks were sent.
+********************************************************************/
+func ( s *Server ) webhookStatsSnapshot() *WebhookStat {
+    s.webhookMutex.Lock()
+    defer s.webhookMutex.Unlock()
+
+    if s.webhookStats == ( WebhookStat{} ) {
+        return nil
+    }
+    stats := s.webhookStats
+    return &stats
+}
+
+/********************************************************************
+handleDeadLetters()
+    Handles GET requests on /admin/webhooks/dead-letters, listing the
+    callbacks that could not be delivered.
+********************************************************************/
+func ( s *Server ) handleDeadLetters( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /admin/webhooks/dead-letters" )
+
+    s.webhookMutex.Lock()
+    deadLetters := append( []DeadLetter{}, s.webhookDeadLetters... )
+    s.webhookMutex.Unlock()
+
+    s.writeEncoded( w, r, http.StatusOK, deadLetters )
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// Addresses webhooks must not reach are refused whatever the URL names
+func TestCheckWebhookAddress( t *testing.T ) {
+    for address, allowed := range map[string]bool{
+        "127.0.0.1:80": false,
+        "[::1]:80": false,
+        "10.1.2.3:443": false,
+        "172.16.0.1:443": false,
+        "192.168.1.1:443": false,
+        "169.254.169.254:80": false,
+        "[fe80::1]:80": false,
+        "[fd00::1]:80": false,
+        "[::ffff:127.0.0.1]:80": false,
+        "100.64.0.1:80": false,
+        "0.0.0.0:80": false,
+        "224.0.0.1:80": false,
+        "93.184.216.34:443": true,
+        "[2606:2800:220:1:248:1893:25c8:1946]:443": true,
+    } {
+        if err := checkWebhookAddress( address ); ( err == nil ) != allowed {
+            t.Errorf( "%s: got %v, want allowed %t", address, err, allowed )
+        }
+    }
+}
+
+// The delivery client refuses a loopback callback unless private
+// addresses are allowed
+func TestWebhookClientRefusesLoopback( t *testing.T ) {
+    callback := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {} ) )
+    defer callback.Close()
+
+    for allowPrivate, wantErr := range map[bool]bool{ false: true, true: false } {
+        request, _ := http.NewRequestWithContext( context.Background(), http.MethodPost, callback.URL, strings.NewReader( "{}" ) )
+        resp, err := newWebhookClient( allowPrivate ).Do( request )
+        if err == nil {
+            resp.Body.Close()
+        }
+        if ( err != nil ) != wantErr {
+            t.Errorf( "allowPrivate %t: got %v, want error %t", allowPrivate, err, wantErr )
+        }
+    }
+}
+
+// callbackServer answers webhook callbacks with the statuses in turn,
+// the last one from then on, counting the attempts
+func callbackServer( t *testing.T, attempts *atomic.Int64, statuses ...int ) *httptest.Server {
+    t.Helper()
+    callback := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        attempt := int( attempts.Add( 1 ) )
+        w.WriteHeader( statuses[ min( attempt, len( statuses ) ) - 1 ] )
+    } ) )
+    t.Cleanup( callback.Close )
+    return callback
+}
+
+// postWithCallback submits a password to POST /v1/hash with a callback_url
+func postWithCallback( t *testing.T, handler http.Handler, callbackURL string ) {
+    t.Helper()
+    body := url.Values{ "password": { "angryMonkey" }, "callback_url": { callbackURL } }.Encode()
+    request := httptest.NewRequest( http.MethodPost, "/v1/hash", strings.NewReader( body ) )
+    request.Header.Set( "Content-Type", "application/x-www-form-urlencoded" )
+    response := httptest.NewRecorder()
+    handler.ServeHTTP( response, request )
+    if response.Code != http.StatusAccepted {
+        t.Fatalf( "POST /v1/hash: %d %s", response.Code, response.Body )
+    }
+}
+
+// waitAttempts polls until the callback had the number of attempts
+func waitAttempts( t *testing.T, attempts *atomic.Int64, want int64 ) {
+    t.Helper()
+    deadline := time.Now().Add( 2 * time.Second )
+    for attempts.Load() < want {
+        if time.Now().After( deadline ) {
+            t.Fatalf( "got %d webhook attempts, want %d", attempts.Load(), want )
+        }
+        time.Sleep( 10 * time.Millisecond )
+    }
+}
+
+// Webhook retries back off on the server's clock
+func TestWebhookRetryFollowsClock( t *testing.T ) {
+    defer func( backoff time.Duration ) { webhookInitialBackoff = backoff }( webhookInitialBackoff )
+    webhookInitialBackoff = time.Hour
+
+    var attempts atomic.Int64
+    callback := callbackServer( t, &attempts, http.StatusInternalServerError, http.StatusOK )
+    clock := &steppedClock{}
+    config := DefaultConfig()
+    config.WebhookAllowPrivate = true
+    s, handler := newTestServer( t, 0, WithConfig( config ), WithClock( clock ) )
+
+    postWithCallback( t, handler, callback.URL )
+    waitAttempts( t, &attempts, 1 )
+    clock.step( 2 * time.Hour )
+    waitAttempts( t, &attempts, 2 )
+
+    deadline := time.Now().Add( 2 * time.Second )
+    for stats := s.webhookStatsSnapshot(); stats == nil || stats.Delivered != 1; stats = s.webhookStatsSnapshot() {
+        if time.Now().After( deadline ) {
+            t.Fatalf( "webhook stats: got %+v, want 1 delivered", stats )
+        }
+        time.Sleep( 10 * time.Millisecond )
+    }
+}
+
+// A shutdown abandons a webhook waiting to be retried rather than
+// waiting out its backoff, and no attempt is made after it
+func TestShutdownAbandonsWebhookRetries( t *testing.T ) {
+    defer func( backoff time.Duration ) { webhookInitialBackoff = backoff }( webhookInitialBackoff )
+    webhookInitialBackoff = time.Hour
+
+    var attempts atomic.Int64
+    callback := callbackServer( t, &attempts, http.StatusInternalServerError )
+    config := DefaultConfig()
+    config.WebhookAllowPrivate = true
+    s, handler := newTestServer( t, 0, WithConfig( config ) )
+
+    postWithCallback( t, handler, callback.URL )
+    waitAttempts( t, &attempts, 1 )
+
+    shutDown := make(chan error, 1)
+    go func() { shutDown <- s.Shutdown( context.Background() ) }()
+    select {
+    case err := <-shutDown:
+        if err != nil {
+            t.Errorf( "Shutdown: %v", err )
+        }
+    case <-time.After( 2 * time.Second ):
+        t.Fatal( "Shutdown waited for the webhook backoff" )
+    }
+    if got := attempts.Load(); got != 1 {
+        t.Errorf( "got %d webhook attempts, want 1", got )
+    }
+}
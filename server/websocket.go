@@ -0,0 +1,234 @@
+package server
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "sync/atomic"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// WebSocket message types
+const (
+    wsSubmit = "submit"
+    wsAccepted = "accepted"
+    wsCompleted = "completed"
+    wsError = "error"
+)
+
+// Message sent by a WebSocket client
+type wsRequest struct {
+    Type string `json:"type"`
+    Ref string `json:"ref,omitempty"`
+    Password string `json:"password"`
+    Labels map[string]string `json:"labels,omitempty"`
+    Ttl string `json:"ttl,omitempty"`
+}
+
+// Message sent to a WebSocket client
+type wsResponse struct {
+    Type string `json:"type"`
+    Ref string `json:"ref,omitempty"`
+    Id int64 `json:"id,omitempty"`
+    EstimatedCompletion *time.Time `json:"estimated_completion,omitempty"`
+    Deduplicated bool `json:"deduplicated,omitempty"`
+    Hash string `json:"hash,omitempty"`
+    CompletedAt *time.Time `json:"completed_at,omitempty"`
+    Error string `json:"error,omitempty"`
+    Warning string `json:"warning,omitempty"`
+}
+
+var (
+    // WebSocket info
+    wsUpgrader = websocket.Upgrader{}
+    wsMaxOutstanding int64 = 100
+    wsWriteTimeout = 10 * time.Second
+    wsPingInterval = 30 * time.Second
+    wsMaxMessageSize int64 = 64 * 1024
+)
+
+/********************************************************************
+handleWebSocket()
+    Handles WebSocket connections on /ws. Clients send
+    {"type":"submit","password":"...","ref":"..."} messages and get
+    an "accepted" message with the id straight away, then a
+    "completed" message with the hash once it is ready.
+    For backpressure each connection may have at most
+    wsMaxOutstanding passwords waiting to be hashed, further
+    submissions get an "error" message until some complete. Nearing
+    the limit, "accepted" messages carry a "warning".
+********************************************************************/
+func ( s *Server ) handleWebSocket( w http.ResponseWriter, r *http.Request ) {
+    s.log( r ).Debug( "Endpoint: /ws" )
+
+    // Check shutdown
+    if s.shutDown {
+        s.log( r ).Info( "Server has been shut down!" )
+        writeError( w, http.StatusNotAcceptable, ErrorShuttingDown )
+        return
+    }
+
+    conn, err := wsUpgrader.Upgrade( w, r, nil )
+    if err != nil {
+        s.logError( "WebSocket upgrade failed: %v", err )
+        return
+    }
+    defer conn.Close()
+    conn.SetReadLimit( wsMaxMessageSize )
+
+    ctx, cancel := context.WithCancel( r.Context() )
+    defer cancel()
+
+    // Every outstanding job can queue at most an accepted and a completed
+    // message, so with the outstanding limit the send buffer never fills
+    send := make(chan wsResponse, 2 * wsMaxOutstanding + 16)
+    go s.wsWriter( ctx, cancel, conn, send )
+
+    var outstanding int64
+    for {
+        var request wsRequest
+        if err := conn.ReadJSON( &request ); err != nil {
+            return
+        }
+
+        response := wsResponse{ Type: wsError, Ref: request.Ref }
+        switch {
+        case request.Type != wsSubmit:
+            response.Error = fmt.Sprintf( "unknown message type %q", request.Type )
+        case s.shutDown:
+            response.Error = "server is shutting down"
+        case request.Password == "" && !s.config.AllowEmptyPassword:
+            response.Error = "missing password"
+        case atomic.LoadInt64( &outstanding ) >= wsMaxOutstanding:
+            response.Error = "too many outstanding passwords, wait for some to complete"
+        default:
+            response = s.wsSubmitPassword( ctx, r, request, send, &outstanding )
+        }
+        if !wsSend( ctx, send, response ) {
+            return
+        }
+    }
+}
+
+/********************************************************************
+wsSend()
+    Queues a message for the WebSocket writer. Blocks while the send
+    buffer is full, so a slow client stops its own reads. Returns
+    false if the connection has gone away.
+********************************************************************/
+func wsSend( ctx context.Context, send chan wsResponse, response wsResponse ) bool {
+    select {
+    case send <- response:
+        return true
+    case <-ctx.Done():
+        return false
+    }
+}
+
+/********************************************************************
+wsSubmitPassword()
+    Queues a password submitted over a WebSocket and starts a go
+    routine that sends the "completed" message once it is hashed.
+********************************************************************/
+func ( s *Server ) wsSubmitPassword( ctx context.Context, r *http.Request, request wsRequest, send chan wsResponse, outstanding *int64 ) wsResponse {
+    ttl, err := parseTtl( request.Ttl )
+    if err != nil {
+        return wsResponse{ Type: wsError, Ref: request.Ref, Error: err.Error() }
+    }
+    for key := range request.Labels {
+        if key == "" {
+            return wsResponse{ Type: wsError, Ref: request.Ref, Error: "invalid label, empty key" }
+        }
+    }
+    if s.config.MaxPendingJobs > 0 && s.pendingJobCount() >= s.config.MaxPendingJobs {
+        return wsResponse{ Type: wsError, Ref: request.Ref, Error: "too many pending passwords" }
+    }
+
+    startTime := s.clock.Now()
+    id, deduplicated, err := s.allocateId( request.Password )
+    if err != nil {
+        return wsResponse{ Type: wsError, Ref: request.Ref, Error: s.storeFailed( r.Context(), err ).Error() }
+    }
+    if !deduplicated {
+        err := s.queueJob( r.Context(), &hashJob{
+            id: id,
+            password: secret( request.Password ),
+            labels: request.Labels,
+            startTime: startTime,
+            ttl: ttl,
+            provenance: newProvenance( r, startTime ),
+        } )
+        if err != nil {
+            return wsResponse{ Type: wsError, Ref: request.Ref, Error: s.storeFailed( r.Context(), err ).Error() }
+        }
+    }
+
+    used := atomic.AddInt64( outstanding, 1 )
+    go func() {
+        defer atomic.AddInt64( outstanding, -1 )
+
+        for ctx.Err() == nil {
+            s.waitForHash( ctx, id, watchMaxTimeout )
+            record, job, _, gone, err := s.lookupHash( id )
+            switch {
+            case err != nil:
+                wsSend( ctx, send, wsResponse{ Type: wsError, Ref: request.Ref, Id: id, Error: s.storeFailed( ctx, err ).Error() } )
+                return
+            case record != nil && gone == "":
+                completedAt := record.CompletedAt
+                wsSend( ctx, send, wsResponse{ Type: wsCompleted, Ref: request.Ref, Id: id, Hash: record.Hash, CompletedAt: &completedAt } )
+                return
+            case gone == StatusFailed:
+                wsSend( ctx, send, wsResponse{ Type: wsError, Ref: request.Ref, Id: id, Error: "password could not be stored" } )
+                return
+            case job == nil:
+                wsSend( ctx, send, wsResponse{ Type: wsError, Ref: request.Ref, Id: id, Error: "password is no longer available" } )
+                return
+            }
+        }
+    }()
+
+    response := wsResponse{ Type: wsAccepted, Ref: request.Ref, Id: id, Deduplicated: deduplicated }
+    response.Warning = s.checkSoftLimit( "websocket outstanding passwords", used, wsMaxOutstanding )
+    if dueAt := s.estimatedCompletion( id ); !dueAt.IsZero() {
+        response.EstimatedCompletion = &dueAt
+    }
+    return response
+}
+
+/********************************************************************
+s.wsWriter()
+    Writes queued messages and keep alive pings to a WebSocket. Stops,
+    closing the connection, on a write error, when the connection's
+    context ends or when the server shuts down.
+********************************************************************/
+func ( s *Server ) wsWriter( ctx context.Context, cancel context.CancelFunc, conn *websocket.Conn, send chan wsResponse ) {
+    defer cancel()
+    defer conn.Close()
+
+    ping := time.NewTicker( wsPingInterval )
+    defer ping.Stop()
+
+    for {
+        var err error
+        select {
+        case response := <-send:
+            conn.SetWriteDeadline( s.clock.Now().Add( wsWriteTimeout ) )
+            err = conn.WriteJSON( response )
+        case <-ping.C:
+            err = conn.WriteControl( websocket.PingMessage, nil, s.clock.Now().Add( wsWriteTimeout ) )
+        case <-s.shutdownStarted:
+            conn.WriteControl( websocket.CloseMessage,
+                websocket.FormatCloseMessage( websocket.CloseGoingAway, "server shutting down" ),
+                s.clock.Now().Add( wsWriteTimeout ) )
+            return
+        case <-ctx.Done():
+            return
+        }
+        if err != nil {
+            return
+        }
+    }
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// Submissions over /ws are refused once MaxPendingJobs passwords wait
+// to be hashed, like POST /hash ones
+func TestWebSocketPendingLimit( t *testing.T ) {
+    config := DefaultConfig()
+    config.MaxPendingJobs = 1
+    _, handler := newTestServer( t, time.Hour, WithConfig( config ) )
+    httpServer := httptest.NewServer( handler )
+    defer httpServer.Close()
+
+    conn, _, err := websocket.DefaultDialer.Dial( "ws" + strings.TrimPrefix( httpServer.URL, "http" ) + "/ws", nil )
+    if err != nil {
+        t.Fatalf( "Dial: %v", err )
+    }
+    defer conn.Close()
+
+    for i, want := range []string{ wsAccepted, wsError } {
+        if err := conn.WriteJSON( wsRequest{ Type: "submit", Password: "angryMonkey" + strings.Repeat( "!", i ) } ); err != nil {
+            t.Fatalf( "WriteJSON: %v", err )
+        }
+        var response wsResponse
+        if err := conn.ReadJSON( &response ); err != nil {
+            t.Fatalf( "ReadJSON: %v", err )
+        }
+        if response.Type != want {
+            t.Errorf( "submission %d: got %+v, want %s", i + 1, response, want )
+        }
+    }
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+    "time"
+)
+
+// Windows of recent hashes reported by /stats, shortest first
+var statWindows = []struct {
+    name string
+    length time.Duration
+}{
+    { "1m", time.Minute },
+    { "5m", 5 * time.Minute },
+    { "1h", time.Hour },
+}
+
+// Seconds kept by a sliding window, the longest statWindows length
+const windowSeconds = 3600
+
+// Hashes completed within a window of recent time
+type WindowStat struct {
+    Count int64 `json:"count"`
+    Average int64 `json:"average"`
+    PerSecond float64 `json:"per_second"`
+}
+
+// Hashes completed in one second
+type windowBucket struct {
+    second int64
+    count int64
+    totalTime int64
+}
+
+// Per second counts of the last windowSeconds, as a ring of buckets
+// indexed by Unix time. A bucket left from an earlier lap of the
+// ring is stale and counts as empty. Guarded by mapMutex
+type slidingWindow struct {
+    buckets [ windowSeconds ]windowBucket
+}
+
+/********************************************************************
+add()
+    Records a hash completed at now, which took latency microseconds.
+********************************************************************/
+func ( sw *slidingWindow ) add( now time.Time, latency int64 ) {
+    second := now.Unix()
+    bucket := &sw.buckets[ second % windowSeconds ]
+    if bucket.second != second {
+        *bucket = windowBucket{ second: second }
+    }
+    bucket.count++
+    bucket.totalTime += latency
+}
+
+/********************************************************************
+sum()
+    Returns the number of hashes and their total time in the window
+    of the given length up to now.
+********************************************************************/
+func ( sw *slidingWindow ) sum( now time.Time, length time.Duration ) ( count int64, totalTime int64 ) {
+    last := now.Unix()
+    first := last - int64( length / time.Second ) + 1
+    for _, bucket := range sw.buckets {
+        if bucket.second >= first && bucket.second <= last {
+            count += bucket.count
+            totalTime += bucket.totalTime
+        }
+    }
+    return count, totalTime
+}
+
+/********************************************************************
+windowStats()
+    Returns the stats of every window in statWindows. Throughput of
+    a window longer than the stats have been kept, since the server
+    started or they were reset, is over that time. Must be called
+    with mapMutex held.
+********************************************************************/
+func ( s *Server ) windowStats() map[string]WindowStat {
+    now := s.clock.Now()
+    kept := s.since( s.statsSince )
+
+    stats := make(map[string]WindowStat, len( statWindows ))
+    for _, window := range statWindows {
+        count, totalTime := s.recentHashes.sum( now, window.length )
+        stat := WindowStat{ Count: count }
+        if count > 0 {
+            stat.Average = totalTime / count
+            stat.PerSecond = float64( count ) / min( window.length, kept ).Seconds()
+        }
+        stats[ window.name ] = stat
+    }
+    return stats
+}
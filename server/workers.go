@@ -0,0 +1,187 @@
+package server
+
+import (
+    "container/heap"
+    "runtime"
+    "time"
+
+    "go.opentelemetry.io/otel/codes"
+)
+
+// How often the dispatcher reads a Clock other than the system's while
+// waiting for a job, so a clock moved forward makes jobs due
+var dispatchPollInterval = 100 * time.Millisecond
+
+// Queued jobs by when they are due, earliest first, a container/heap
+type jobQueue []*hashJob
+
+func ( q jobQueue ) Len() int { return len( q ) }
+func ( q jobQueue ) Less( i, j int ) bool { return q[ i ].dueAt.Before( q[ j ].dueAt ) }
+func ( q jobQueue ) Swap( i, j int ) { q[ i ], q[ j ] = q[ j ], q[ i ] }
+func ( q *jobQueue ) Push( job interface{} ) { *q = append( *q, job.( *hashJob ) ) }
+
+func ( q *jobQueue ) Pop() interface{} {
+    old := *q
+    job := old[ len( old ) - 1 ]
+    old[ len( old ) - 1 ] = nil
+    *q = old[ :len( old ) - 1 ]
+    return job
+}
+
+/********************************************************************
+workerCount()
+    Returns Config.Workers, the number of CPUs when 0.
+********************************************************************/
+func ( s *Server ) workerCount() int {
+    if s.config.Workers <= 0 {
+        return runtime.NumCPU()
+    }
+    return s.config.Workers
+}
+
+/********************************************************************
+startWorkers()
+    Starts the pool of workers hashing the jobs, and the dispatcher
+    handing them each job once its delay has elapsed. A burst of
+    submissions only grows the queue, never the number of go
+    routines. stopWorkers() stops them.
+********************************************************************/
+func ( s *Server ) startWorkers() {
+    s.workers = s.workerCount()
+    s.dueJobs = make(chan *hashJob)
+    s.jobQueued = make(chan struct{}, 1)
+    s.workersStopped = make(chan struct{})
+    s.workerGroup.Add( s.workers + 1 )
+    for i := 0; i < s.workers; i++ {
+        go s.hashWorker()
+    }
+    go s.dispatchJobs()
+}
+
+/********************************************************************
+stopWorkers()
+    Stops the dispatcher and the workers, waiting for those hashing a
+    job to store it. Jobs still queued stay pending, a write-ahead
+    log queues them again on restart.
+********************************************************************/
+func ( s *Server ) stopWorkers() {
+    close( s.workersStopped )
+    s.workerGroup.Wait()
+}
+
+/********************************************************************
+enqueueJob()
+    Adds a scheduled job to the queue of the dispatcher, without
+    waiting for it.
+********************************************************************/
+func ( s *Server ) enqueueJob( job *hashJob ) {
+    s.jobQueueMutex.Lock()
+    heap.Push( &s.jobQueue, job )
+    s.jobQueueMutex.Unlock()
+
+    select {
+    case s.jobQueued <- struct{}{}:
+    default:
+    }
+}
+
+/********************************************************************
+dispatchJobs()
+    Hands the queued jobs to the workers as they become due on the
+    server's clock, or once their delay has elapsed on the system's,
+    earliest first, holding them while processing is paused. Once
+    every worker is busy due jobs wait in the queue, still reported
+    as queued.
+********************************************************************/
+func ( s *Server ) dispatchJobs() {
+    defer s.workerGroup.Done()
+    for {
+        s.jobQueueMutex.Lock()
+        if len( s.jobQueue ) == 0 {
+            s.jobQueueMutex.Unlock()
+            select {
+            case <-s.jobQueued:
+            case <-s.workersStopped:
+                return
+            }
+            continue
+        }
+        delay := s.jobDelay( s.jobQueue[ 0 ] )
+        if realDelay := time.Until( s.jobQueue[ 0 ].realDueAt ); realDelay < delay {
+            delay = realDelay
+        }
+        if delay > 0 {
+            s.jobQueueMutex.Unlock()
+
+            // Wait for the earliest job, or for an earlier one queued
+            // meanwhile, e.g. with a complete_by deadline. Only the
+            // system clock moves with the timer
+            if _, system := s.clock.( systemClock ); !system && delay > dispatchPollInterval {
+                delay = dispatchPollInterval
+            }
+            timer := time.NewTimer( delay )
+            select {
+            case <-timer.C:
+            case <-s.jobQueued:
+                timer.Stop()
+            case <-s.workersStopped:
+                timer.Stop()
+                return
+            }
+            continue
+        }
+        job := heap.Pop( &s.jobQueue ).( *hashJob )
+        s.jobQueueMutex.Unlock()
+
+        if !s.waitWhilePaused( s.workersStopped ) {
+            return
+        }
+        job.span.AddEvent( "delay elapsed" )
+        select {
+        case s.dueJobs <- job:
+        case <-s.workersStopped:
+            return
+        }
+    }
+}
+
+/********************************************************************
+hashWorker()
+    Hashes and stores the jobs dispatched to it, one at a time, until
+    the workers are stopped.
+********************************************************************/
+func ( s *Server ) hashWorker() {
+    defer s.workerGroup.Done()
+    for {
+        select {
+        case job := <-s.dueJobs:
+            s.busyWorkers.Add( 1 )
+            s.hashAndStore( job )
+            s.busyWorkers.Add( -1 )
+        case <-s.workersStopped:
+            return
+        }
+    }
+}
+
+/********************************************************************
+failJob()
+    Gives up on a job whose record the store failed to take after
+    storeMaxAttempts, so it stops holding a worker, its status is
+    failed from then on. Its submission stays in the write-ahead log,
+    so a restart queues it again.
+********************************************************************/
+func ( s *Server ) failJob( job *hashJob, err error ) {
+    s.logErrorTo( job.logger, "Unable to store hash %d after %d attempts, giving up: %v", job.id, storeMaxAttempts, err )
+
+    s.mapMutex.Lock()
+    delete( s.pendingJobs, job.id )
+    s.failedIds.add( job.id )
+    s.forgetDigest( job.id, job.digest )
+    s.notifyCompleted()
+    s.mapMutex.Unlock()
+
+    job.span.RecordError( err )
+    job.span.SetStatus( codes.Error, "store failed" )
+    job.span.End()
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+    "net/http"
+    "sync"
+    "testing"
+    "time"
+)
+
+// Clock whose wall time the test steps, keeping the monotonic reading
+// of the system clock like an NTP step would
+type steppedClock struct {
+    mutex sync.Mutex
+    offset time.Duration
+}
+
+func ( c *steppedClock ) Now() time.Time {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    return time.Now().Add( c.offset )
+}
+
+func ( c *steppedClock ) step( by time.Duration ) {
+    c.mutex.Lock()
+    c.offset += by
+    c.mutex.Unlock()
+}
+
+// waitHashed polls GET /v1/hash/{id} until it stops answering 202
+func waitHashed( t *testing.T, handler http.Handler, id int64, timeout time.Duration ) {
+    t.Helper()
+    deadline := time.Now().Add( timeout )
+    for {
+        response := getHash( handler, id )
+        if response.Code == http.StatusOK {
+            return
+        }
+        if response.Code != http.StatusAccepted || time.Now().After( deadline ) {
+            t.Fatalf( "GET /v1/hash/%d: got %d %q, want 200 within %s", id, response.Code, response.Body, timeout )
+        }
+        time.Sleep( 10 * time.Millisecond )
+    }
+}
+
+// Stepping the clock backwards neither holds a queued job nor makes its
+// latency negative
+func TestClockStepBackwards( t *testing.T ) {
+    clock := &steppedClock{}
+    s, handler := newTestServer( t, 200 * time.Millisecond, WithClock( clock ) )
+
+    id := postPassword( t, handler, "angryMonkey" )
+    clock.step( -time.Hour )
+    waitHashed( t, handler, id, 2 * time.Second )
+
+    record, err := s.store.Get( id )
+    if err != nil {
+        t.Fatalf( "Get( %d ): %v", id, err )
+    }
+    if record.LatencyUs < 0 {
+        t.Errorf( "latency: got %dus, want >= 0", record.LatencyUs )
+    }
+    if stats, _ := s.collectStats(); stats.Total != 1 || stats.Average < 0 {
+        t.Errorf( "stats: got total %d, average %d, want 1 and >= 0", stats.Total, stats.Average )
+    }
+}
+
+// Stepping the clock forwards past the delay makes a queued job due
+func TestClockStepForwards( t *testing.T ) {
+    clock := &steppedClock{}
+    s, handler := newTestServer( t, time.Hour, WithClock( clock ) )
+
+    // Step once the dispatcher waits for the job, it only sees the
+    // step by reading the clock again
+    id := postPassword( t, handler, "angryMonkey" )
+    time.Sleep( 50 * time.Millisecond )
+    clock.step( 2 * time.Hour )
+    waitHashed( t, handler, id, 2 * time.Second )
+
+    record, err := s.store.Get( id )
+    if err != nil {
+        t.Fatalf( "Get( %d ): %v", id, err )
+    }
+    if record.LatencyUs < 0 {
+        t.Errorf( "latency: got %dus, want >= 0", record.LatencyUs )
+    }
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	server "jumpcloud_password_hash/server"
+)
+
+// storeOptions are the -store flags.
+type storeOptions struct {
+	kind string
+	path string // File of the bolt store
+	dynamoTable string
+	dynamoRegion string // AWS_REGION when empty
+	dynamoEndpoint string // e.g. DynamoDB Local, AWS when empty
+}
+
+// archiveOptions are the -archive flags.
+type archiveOptions struct {
+	bucket string
+	prefix string
+	region string // AWS_REGION when empty
+	endpoint string // e.g. MinIO, AWS when empty
+}
+
+// keySourceOptions are the flags fetching the master encryption key
+// from a secret manager.
+type keySourceOptions struct {
+	kmsCiphertext string // Base64 CiphertextBlob
+	kmsRegion string // AWS_REGION when empty
+	kmsEndpoint string // e.g. LocalStack, AWS when empty
+	vaultPath string
+	vaultField string
+	vaultAddress string
+	vaultToken string
+}
+
+// newKeySource returns the KMS or Vault source of the master key, nil
+// when the key isn't fetched from either.
+func newKeySource( ctx context.Context, options keySourceOptions ) ( server.KeySource, error ) {
+	switch {
+	case options.kmsCiphertext != "" && options.vaultPath != "":
+		return nil, fmt.Errorf( "-encryption-key-kms and -encryption-key-vault are exclusive" )
+	case options.kmsCiphertext != "":
+		ciphertext, err := base64.StdEncoding.DecodeString( options.kmsCiphertext )
+		if err != nil {
+			return nil, fmt.Errorf( "invalid -encryption-key-kms: %w", err )
+		}
+		return server.NewKMSKeySource( ctx, ciphertext, options.kmsRegion, options.kmsEndpoint )
+	case options.vaultPath != "":
+		if options.vaultAddress == "" || options.vaultToken == "" {
+			return nil, fmt.Errorf( "-encryption-key-vault needs -vault-addr and -vault-token" )
+		}
+		return server.NewVaultKeySource( options.vaultAddress, options.vaultToken, options.vaultPath, options.vaultField ), nil
+	}
+	return nil, nil
+}
+
+// newArchive returns the S3 archive of the records, nil without a
+// bucket.
+func newArchive( ctx context.Context, options archiveOptions ) ( server.Archive, error ) {
+	if options.bucket == "" {
+		return nil, nil
+	}
+	return server.NewS3Archive( ctx, options.bucket, options.prefix, options.region, options.endpoint )
+}
+
+// newMigrationSource opens the store migrated from, bolt:<file> or
+// dynamodb:<table>, in the region and at the endpoint of -store.
+func newMigrationSource( ctx context.Context, value string, options storeOptions ) ( server.Store, io.Closer, error ) {
+	kind, target, _ := strings.Cut( value, ":" )
+	source := storeOptions{ kind: kind, dynamoRegion: options.dynamoRegion, dynamoEndpoint: options.dynamoEndpoint }
+	switch {
+	case target == "":
+	case kind == "bolt":
+		source.path = target
+	case kind == "dynamodb":
+		source.dynamoTable = target
+	}
+	if target == "" || source.path == "" && source.dynamoTable == "" {
+		return nil, nil, fmt.Errorf( "invalid -migrate-from %q, expected bolt:<file> or dynamodb:<table>", value )
+	}
+	if kind == options.kind && source.path == options.path && source.dynamoTable == options.dynamoTable {
+		return nil, nil, fmt.Errorf( "-migrate-from is the -store migrated to" )
+	}
+	return newStore( ctx, source )
+}
+
+// newStore opens the store of the hashed passwords: nil for "memory",
+// the server's default, the bbolt file for "bolt", or the DynamoDB
+// table for "dynamodb". Stores holding resources are returned along
+// with their closer.
+func newStore( ctx context.Context, options storeOptions ) ( server.Store, io.Closer, error ) {
+	switch options.kind {
+	case "memory":
+		return nil, nil, nil
+	case "bolt":
+		if options.path == "" {
+			return nil, nil, fmt.Errorf( "-store bolt needs -store-path" )
+		}
+		store, err := server.NewBoltStore( options.path )
+		if err != nil {
+			return nil, nil, err
+		}
+		return store, store, nil
+	case "dynamodb":
+		if options.dynamoTable == "" {
+			return nil, nil, fmt.Errorf( "-store dynamodb needs -dynamodb-table" )
+		}
+		store, err := server.NewDynamoDBStore( ctx, options.dynamoTable, options.dynamoRegion, options.dynamoEndpoint )
+		if err != nil {
+			return nil, nil, err
+		}
+		return store, nil, nil
+	}
+	return nil, nil, fmt.Errorf( "invalid store %q, expected memory, bolt or dynamodb", options.kind )
+}
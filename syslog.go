@@ -0,0 +1,130 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"strings"
+)
+
+// Syslog facilities by name
+var syslogFacilities = map[string]syslog.Priority{
+	"kern": syslog.LOG_KERN,
+	"user": syslog.LOG_USER,
+	"mail": syslog.LOG_MAIL,
+	"daemon": syslog.LOG_DAEMON,
+	"auth": syslog.LOG_AUTH,
+	"syslog": syslog.LOG_SYSLOG,
+	"lpr": syslog.LOG_LPR,
+	"news": syslog.LOG_NEWS,
+	"uucp": syslog.LOG_UUCP,
+	"cron": syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp": syslog.LOG_FTP,
+	"local0": syslog.LOG_LOCAL0,
+	"local1": syslog.LOG_LOCAL1,
+	"local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4,
+	"local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6,
+	"local7": syslog.LOG_LOCAL7,
+}
+
+// newSyslogLogger creates the logger of the server sending lines of at
+// least the level to a syslog daemon, the local one for "local" or a
+// remote one at "udp://host:port" or "tcp://host:port". Lines have the
+// severity of their level, and are formatted like newLogger() does
+// without the time and level, which syslog records itself.
+func newSyslogLogger( address string, facility string, tag string, level string, format string ) ( *slog.Logger, error ) {
+	priority, ok := syslogFacilities[ facility ]
+	if !ok {
+		return nil, fmt.Errorf( "invalid syslog facility %q", facility )
+	}
+	options, err := logOptions( level )
+	if err != nil {
+		return nil, err
+	}
+	options.ReplaceAttr = func( groups []string, attr slog.Attr ) slog.Attr {
+		if len( groups ) == 0 && ( attr.Key == slog.TimeKey || attr.Key == slog.LevelKey ) {
+			return slog.Attr{}
+		}
+		return attr
+	}
+
+	var writer *syslog.Writer
+	if address == "local" {
+		writer, err = syslog.New( priority | syslog.LOG_INFO, tag )
+	} else {
+		network, addr, found := strings.Cut( address, "://" )
+		if !found || network != "udp" && network != "tcp" {
+			return nil, fmt.Errorf( "invalid syslog address %q, expected local, udp://host:port or tcp://host:port", address )
+		}
+		writer, err = syslog.Dial( network, addr, priority | syslog.LOG_INFO, tag )
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var handler syslogHandler
+	for i, write := range []func( string ) error{ writer.Debug, writer.Info, writer.Warning, writer.Err } {
+		if handler.handlers[ i ], err = newLogHandler( syslogWriter( write ), format, options ); err != nil {
+			return nil, err
+		}
+	}
+	return slog.New( handler ), nil
+}
+
+// syslogWriter writes each line at the severity of the function.
+type syslogWriter func( string ) error
+
+func ( write syslogWriter ) Write( line []byte ) ( int, error ) {
+	if err := write( strings.TrimSuffix( string( line ), "\n" ) ); err != nil {
+		return 0, err
+	}
+	return len( line ), nil
+}
+
+// syslogHandler hands each record to the handler writing at the
+// severity of its level: debug, info, warning or error.
+type syslogHandler struct {
+	handlers [ 4 ]slog.Handler
+}
+
+// severity returns the index in handlers of the level's severity.
+func severity( level slog.Level ) int {
+	switch {
+	case level < slog.LevelInfo:
+		return 0
+	case level < slog.LevelWarn:
+		return 1
+	case level < slog.LevelError:
+		return 2
+	}
+	return 3
+}
+
+func ( h syslogHandler ) Enabled( ctx context.Context, level slog.Level ) bool {
+	return h.handlers[ severity( level ) ].Enabled( ctx, level )
+}
+
+func ( h syslogHandler ) Handle( ctx context.Context, record slog.Record ) error {
+	return h.handlers[ severity( record.Level ) ].Handle( ctx, record )
+}
+
+func ( h syslogHandler ) WithAttrs( attrs []slog.Attr ) slog.Handler {
+	for i, handler := range h.handlers {
+		h.handlers[ i ] = handler.WithAttrs( attrs )
+	}
+	return h
+}
+
+func ( h syslogHandler ) WithGroup( name string ) slog.Handler {
+	for i, handler := range h.handlers {
+		h.handlers[ i ] = handler.WithGroup( name )
+	}
+	return h
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// newSyslogLogger fails, there is no syslog on this platform.
+func newSyslogLogger( address string, facility string, tag string, level string, format string ) ( *slog.Logger, error ) {
+	return nil, fmt.Errorf( "syslog is not supported on this platform" )
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"os"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// setupTracing exports spans over OTLP when an OTLP endpoint is set in
+// the standard OTEL_EXPORTER_OTLP_* environment variables, which also
+// configure the exporter. OTEL_EXPORTER_OTLP_PROTOCOL picks "grpc" or
+// the default "http/protobuf". The returned function flushes the spans
+// still buffered, and is nil when tracing is off.
+func setupTracing( ctx context.Context ) ( func( context.Context ) error, error ) {
+	if os.Getenv( "OTEL_SDK_DISABLED" ) == "true" || os.Getenv( "OTEL_TRACES_EXPORTER" ) == "none" ||
+		os.Getenv( "OTEL_EXPORTER_OTLP_ENDPOINT" ) == "" && os.Getenv( "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT" ) == "" {
+		return nil, nil
+	}
+
+	protocol := os.Getenv( "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL" )
+	if protocol == "" {
+		protocol = os.Getenv( "OTEL_EXPORTER_OTLP_PROTOCOL" )
+	}
+	var exporter sdktrace.SpanExporter
+	var err error
+	if protocol == "grpc" {
+		exporter, err = otlptracegrpc.New( ctx )
+	} else {
+		exporter, err = otlptracehttp.New( ctx )
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New( ctx,
+		resource.WithAttributes( semconv.ServiceName( "hashsvc" ) ),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider( sdktrace.WithBatcher( exporter ), sdktrace.WithResource( res ) )
+	otel.SetTracerProvider( provider )
+	otel.SetTextMapPropagator( propagation.NewCompositeTextMapPropagator( propagation.TraceContext{}, propagation.Baggage{} ) )
+	return provider.Shutdown, nil
+}